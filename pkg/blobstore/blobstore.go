@@ -0,0 +1,41 @@
+// Package blobstore keeps binary evidence (delivery photos, signatures) out of
+// Postgres. The filesystem driver is for tests and local runs; production
+// uses any S3-compatible object storage.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type Store interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes a blob; a missing one is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+type Config struct {
+	Driver    string
+	Dir       string
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// New picks the driver named in the config.
+func New(cfg Config) (Store, error) {
+	switch cfg.Driver {
+	case "", "fs":
+		return NewFSStore(cfg.Dir)
+	case "s3":
+		return NewS3Store(cfg.Endpoint, cfg.Region, cfg.Bucket, cfg.AccessKey, cfg.SecretKey)
+	default:
+		return nil, fmt.Errorf("unknown blob store driver %q", cfg.Driver)
+	}
+}
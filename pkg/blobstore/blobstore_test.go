@@ -0,0 +1,96 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFSStore_RoundTrip(t *testing.T) {
+	s, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := s.Put(ctx, "proofs/t1/b1/p1/signature", "image/png", []byte("sig")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "proofs/t1/b1/p1/signature")
+	if err != nil || string(got) != "sig" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := s.Get(ctx, "proofs/t1/b1/p1/photo"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.Put(ctx, "../escape", "", []byte("x")); err == nil {
+		t.Fatalf("expected traversal key to be rejected")
+	}
+	if err := s.Delete(ctx, "proofs/t1/b1/p1/signature"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "proofs/t1/b1/p1/signature"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted blob to be gone, got %v", err)
+	}
+	if err := s.Delete(ctx, "proofs/t1/b1/p1/signature"); err != nil {
+		t.Fatalf("deleting a missing blob: %v", err)
+	}
+}
+
+func TestS3Store_SignsAndRoundTrips(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AK/") || r.Header.Get("x-amz-content-sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := NewS3Store(srv.URL, "", "proofs-bucket", "AK", "SK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := s.Put(ctx, "proofs/t1/b1/p1/photo", "image/jpeg", []byte("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects["/proofs-bucket/proofs/t1/b1/p1/photo"]; !ok {
+		t.Fatalf("expected path-style key, got %v", objects)
+	}
+	got, err := s.Get(ctx, "proofs/t1/b1/p1/photo")
+	if err != nil || string(got) != "jpeg" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := s.Get(ctx, "proofs/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.Delete(ctx, "proofs/t1/b1/p1/photo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects["/proofs-bucket/proofs/t1/b1/p1/photo"]; ok {
+		t.Fatalf("expected object to be deleted")
+	}
+}
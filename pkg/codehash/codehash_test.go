@@ -0,0 +1,21 @@
+package codehash
+
+import "testing"
+
+func TestHash_StableAcrossServices(t *testing.T) {
+	// hashes stored before the helper was shared must stay valid
+	if h := Hash([]byte("s1"), "o1", "123456"); h != "23333a3a9966919e7d1b6acb0d7898ddd859c80f806f33cb9a435f0db38484ac" {
+		t.Fatalf("unexpected hash %q", h)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	secret := []byte("s1")
+	hash := Hash(secret, "o1", "123456")
+	if !Matches(secret, "o1", "123456", hash) {
+		t.Fatalf("expected the issued code to match")
+	}
+	if Matches(secret, "o1", "123457", hash) || Matches(secret, "o2", "123456", hash) || Matches([]byte("s2"), "o1", "123456", hash) {
+		t.Fatalf("wrong code, subject or secret must not match")
+	}
+}
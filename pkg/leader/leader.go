@@ -0,0 +1,196 @@
+// Package leader elects one replica of a service to run a singleton job.
+// It is shared by the services that run background jobs, each of which
+// reports leadership through its own metrics.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Elector runs a singleton job on exactly one replica. Leadership is a
+// session-level Postgres advisory lock held on a dedicated connection, and
+// the lease row in leader_leases is renewed on every tick so other replicas
+// can see who leads. When the leader dies its session ends, Postgres drops
+// the lock and the next replica to campaign takes over.
+type Elector struct {
+	db       *pgxpool.Pool
+	name     string
+	id       string
+	interval time.Duration
+	onChange func(job string, isLeader bool)
+	leader   atomic.Bool
+}
+
+// Lease describes the current holder of a job as recorded in leader_leases.
+type Lease struct {
+	Job       string    `json:"job"`
+	Holder    string    `json:"holder"`
+	IsSelf    bool      `json:"is_self"`
+	RenewedAt time.Time `json:"renewed_at,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+func EnsureSchema(db *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS leader_leases (
+			name TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			acquired_at TIMESTAMPTZ NOT NULL,
+			renewed_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)
+	`)
+	return err
+}
+
+// InstanceID identifies this process in leader_leases.
+func InstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return host + "-" + uuid.NewString()[:8]
+}
+
+// NewElector creates an elector for the job name. onChange, if set, is
+// called whenever this instance gains or loses leadership; services use it
+// to export their leader gauge.
+func NewElector(db *pgxpool.Pool, name, instanceID string, interval time.Duration, onChange func(job string, isLeader bool)) *Elector {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Elector{db: db, name: name, id: instanceID, interval: interval, onChange: onChange}
+}
+
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns for leadership until ctx is done. While this instance leads,
+// job runs with a context that is cancelled as soon as the lease is lost.
+func (e *Elector) Run(ctx context.Context, job func(ctx context.Context)) {
+	e.report(false)
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		e.campaign(ctx, job)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (e *Elector) campaign(ctx context.Context, job func(ctx context.Context)) {
+	conn, err := e.db.Acquire(ctx)
+	if err != nil {
+		return
+	}
+	var ok bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, e.name).Scan(&ok); err != nil || !ok {
+		conn.Release()
+		return
+	}
+	defer func() {
+		// Closing the session releases the advisory lock even if the
+		// connection is already broken.
+		_ = conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+	if err := e.renew(ctx, conn); err != nil {
+		slog.Warn("leader lease write failed", "job", e.name, "error", err)
+		return
+	}
+
+	e.setLeader(true)
+	slog.Info("acquired leadership", "job", e.name, "holder", e.id)
+	jobCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		job(jobCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		e.setLeader(false)
+		e.resign()
+		slog.Info("released leadership", "job", e.name, "holder", e.id)
+	}()
+
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := e.renew(ctx, conn); err != nil {
+				slog.Warn("leader lease renewal failed", "job", e.name, "error", err)
+				return
+			}
+		}
+	}
+}
+
+func (e *Elector) renew(ctx context.Context, conn *pgxpool.Conn) error {
+	ttl := fmt.Sprintf("%d milliseconds", (3 * e.interval).Milliseconds())
+	_, err := conn.Exec(ctx, `
+		INSERT INTO leader_leases (name, holder, acquired_at, renewed_at, expires_at)
+		VALUES ($1, $2, NOW(), NOW(), NOW() + $3::interval)
+		ON CONFLICT (name) DO UPDATE
+		SET holder = EXCLUDED.holder,
+			acquired_at = CASE WHEN leader_leases.holder = EXCLUDED.holder THEN leader_leases.acquired_at ELSE EXCLUDED.acquired_at END,
+			renewed_at = EXCLUDED.renewed_at,
+			expires_at = EXCLUDED.expires_at
+	`, e.name, e.id, ttl)
+	return err
+}
+
+func (e *Elector) resign() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, _ = e.db.Exec(ctx, `
+		UPDATE leader_leases SET expires_at = NOW() WHERE name=$1 AND holder=$2
+	`, e.name, e.id)
+}
+
+func (e *Elector) setLeader(v bool) {
+	e.leader.Store(v)
+	e.report(v)
+}
+
+func (e *Elector) report(v bool) {
+	if e.onChange != nil {
+		e.onChange(e.name, v)
+	}
+}
+
+// Current returns the live lease for the job. Holder is empty when nobody
+// holds an unexpired lease.
+func (e *Elector) Current(ctx context.Context) (Lease, error) {
+	l := Lease{Job: e.name}
+	err := e.db.QueryRow(ctx, `
+		SELECT holder, renewed_at, expires_at
+		FROM leader_leases
+		WHERE name=$1 AND expires_at > NOW()
+	`, e.name).Scan(&l.Holder, &l.RenewedAt, &l.ExpiresAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return l, err
+	}
+	l.IsSelf = l.Holder == e.id && e.IsLeader()
+	return l, nil
+}
@@ -0,0 +1,46 @@
+// Package refsnapshot reads the full snapshot reference-service serves to
+// its consumers on startup. Each consumer decodes only the part it keeps,
+// so the snapshot type is the consumer's own.
+package refsnapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client reads the reference snapshot into a T. The token is a JWT with the
+// service role issued for this consumer.
+type Client[T any] struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func NewClient[T any](baseURL, token string, timeout time.Duration) *Client[T] {
+	return &Client[T]{baseURL: strings.TrimRight(baseURL, "/"), token: token, http: &http.Client{Timeout: timeout}}
+}
+
+func (c *Client[T]) Snapshot(ctx context.Context) (*T, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/snapshot", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reference snapshot: unexpected status %d", resp.StatusCode)
+	}
+	var snap T
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("reference snapshot: %w", err)
+	}
+	return &snap, nil
+}
@@ -0,0 +1,51 @@
+package refsnapshot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type snapshot struct {
+	Seq          int64 `json:"seq"`
+	PickupPoints []struct {
+		ID string `json:"id"`
+	} `json:"pickup_points"`
+}
+
+func TestClient_Snapshot(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/snapshot" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"seq":7,"pickup_points":[{"id":"p1","name":"ignored"}],"carriers":[]}`))
+	}))
+	defer srv.Close()
+
+	snap, err := NewClient[snapshot](srv.URL+"/", "tok", time.Second).Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer tok" {
+		t.Fatalf("unexpected authorization %q", auth)
+	}
+	if snap.Seq != 7 || len(snap.PickupPoints) != 1 || snap.PickupPoints[0].ID != "p1" {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+}
+
+func TestClient_SnapshotStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	if _, err := NewClient[snapshot](srv.URL, "tok", time.Second).Snapshot(context.Background()); err == nil {
+		t.Fatalf("expected an error for a non-200 answer")
+	}
+}
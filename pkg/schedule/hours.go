@@ -0,0 +1,87 @@
+package schedule
+
+import (
+	"errors"
+	"time"
+)
+
+// Hours is the calendar of a pickup point. OpeningHours and Holidays say
+// when it is open, ReceivingWindows when it takes inbound deliveries. A
+// pickup point without opening hours is always open, one without receiving
+// windows receives whenever it is open.
+type Hours struct {
+	OpeningHours     []Rule      `json:"opening_hours"`
+	Holidays         []Exception `json:"holidays"`
+	ReceivingWindows []Rule      `json:"receiving_windows"`
+}
+
+// receivingStep and receivingHorizon bound the search for the next
+// receiving slot: rules have minute precision, holidays rarely last a week.
+const (
+	receivingStep    = 5 * time.Minute
+	receivingHorizon = 14 * 24 * time.Hour
+)
+
+func (h Hours) Validate() error {
+	for _, r := range h.OpeningHours {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, r := range h.ReceivingWindows {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, e := range h.Holidays {
+		if err := e.Validate(); err != nil {
+			return err
+		}
+	}
+	// holidays are left out: a long closure must not make the weekly
+	// calendar look broken
+	if len(h.ReceivingWindows) > 0 {
+		weekly := Hours{OpeningHours: h.OpeningHours, ReceivingWindows: h.ReceivingWindows}
+		if _, ok := weekly.NextReceiving(time.Now()); !ok {
+			return errors.New("receiving windows never fall into opening hours")
+		}
+	}
+	return nil
+}
+
+// OpenAt reports whether the pickup point is open at t. Holidays of kind off
+// close it, kind on opens it outside the usual hours.
+func (h Hours) OpenAt(t time.Time) bool {
+	return Schedule{Rules: h.OpeningHours, Exceptions: h.Holidays}.OnShift(t)
+}
+
+// ReceivesAt reports whether a delivery arriving at t is accepted.
+func (h Hours) ReceivesAt(t time.Time) bool {
+	if !h.OpenAt(t) {
+		return false
+	}
+	if len(h.ReceivingWindows) == 0 {
+		return true
+	}
+	for _, r := range h.ReceivingWindows {
+		if r.Covers(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextReceiving returns the first moment from t on when a delivery is
+// accepted. ok is false when there is none within two weeks.
+func (h Hours) NextReceiving(t time.Time) (time.Time, bool) {
+	if h.ReceivesAt(t) {
+		return t, true
+	}
+	next := t.Truncate(receivingStep).Add(receivingStep)
+	for end := t.Add(receivingHorizon); !next.After(end); next = next.Add(receivingStep) {
+		if h.ReceivesAt(next) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,62 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoursReceivesAt(t *testing.T) {
+	h := Hours{
+		OpeningHours:     []Rule{{Weekday: time.Monday, Start: "09:00", End: "21:00", Timezone: "UTC"}},
+		ReceivingWindows: []Rule{{Weekday: time.Monday, Start: "10:00", End: "12:00", Timezone: "UTC"}},
+	}
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		at       time.Duration
+		open     bool
+		receives bool
+	}{
+		{8 * time.Hour, false, false},
+		{9*time.Hour + 30*time.Minute, true, false},
+		{11 * time.Hour, true, true},
+		{15 * time.Hour, true, false},
+	}
+	for _, c := range cases {
+		at := monday.Add(c.at)
+		if h.OpenAt(at) != c.open || h.ReceivesAt(at) != c.receives {
+			t.Fatalf("%s: open=%v receives=%v", at, h.OpenAt(at), h.ReceivesAt(at))
+		}
+	}
+
+	holiday := Exception{Kind: KindOff, StartsAt: monday, EndsAt: monday.Add(24 * time.Hour)}
+	h.Holidays = []Exception{holiday}
+	if h.ReceivesAt(monday.Add(11 * time.Hour)) {
+		t.Fatalf("holiday must close receiving")
+	}
+}
+
+func TestHoursWithoutRulesAlwaysReceives(t *testing.T) {
+	if !(Hours{}).ReceivesAt(time.Now()) {
+		t.Fatalf("pickup point without hours must receive")
+	}
+}
+
+func TestHoursNextReceiving(t *testing.T) {
+	h := Hours{ReceivingWindows: []Rule{{Weekday: time.Monday, Start: "10:00", End: "12:00", Timezone: "UTC"}}}
+	sunday := time.Date(2023, 12, 31, 22, 3, 0, 0, time.UTC)
+	next, ok := h.NextReceiving(sunday)
+	if !ok || !next.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected next receiving %s %v", next, ok)
+	}
+
+	never := Hours{
+		OpeningHours:     []Rule{{Weekday: time.Monday, Start: "09:00", End: "10:00", Timezone: "UTC"}},
+		ReceivingWindows: []Rule{{Weekday: time.Tuesday, Start: "10:00", End: "12:00", Timezone: "UTC"}},
+	}
+	if _, ok := never.NextReceiving(sunday); ok {
+		t.Fatalf("expected no receiving slot")
+	}
+	if never.Validate() == nil {
+		t.Fatalf("expected validation error")
+	}
+}
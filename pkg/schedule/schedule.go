@@ -0,0 +1,152 @@
+// Package schedule evaluates weekly working hours and their exceptions. The
+// reference service stores schedules in this form; routing, batching and
+// tracking read them back to check who is available when.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultTimezone is used for rules that do not name one.
+const DefaultTimezone = "Europe/Minsk"
+
+// Exception kinds: off removes availability (day off, break, sick leave),
+// on adds a one-off shift outside the weekly rules.
+const (
+	KindOff = "off"
+	KindOn  = "on"
+)
+
+// Rule is a weekly recurring shift. End <= Start means the shift crosses
+// midnight and ends on the following day.
+type Rule struct {
+	Weekday  time.Weekday `json:"weekday"`
+	Start    string       `json:"start"`
+	End      string       `json:"end"`
+	Timezone string       `json:"timezone,omitempty"`
+}
+
+type Exception struct {
+	ID       string    `json:"id,omitempty"`
+	Kind     string    `json:"kind"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// Schedule is the full availability calendar of one carrier. A carrier
+// without rules is not restricted by shifts, only by its off exceptions.
+type Schedule struct {
+	CarrierID  string      `json:"carrier_id"`
+	Rules      []Rule      `json:"rules"`
+	Exceptions []Exception `json:"exceptions"`
+}
+
+// ParseClock converts "HH:MM" into minutes since midnight.
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// FormatClock is the inverse of ParseClock.
+func FormatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+func (r Rule) Validate() error {
+	if r.Weekday < time.Sunday || r.Weekday > time.Saturday {
+		return errors.New("weekday must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	start, err := ParseClock(r.Start)
+	if err != nil {
+		return err
+	}
+	end, err := ParseClock(r.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return errors.New("shift start and end must differ")
+	}
+	if r.Timezone != "" {
+		if _, err := time.LoadLocation(r.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", r.Timezone)
+		}
+	}
+	return nil
+}
+
+func (e Exception) Validate() error {
+	if e.Kind != KindOff && e.Kind != KindOn {
+		return errors.New("kind must be off or on")
+	}
+	if e.StartsAt.IsZero() || e.EndsAt.IsZero() || !e.EndsAt.After(e.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+func (r Rule) location() *time.Location {
+	tz := r.Timezone
+	if tz == "" {
+		tz = DefaultTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Covers reports whether t falls into the rule's shift.
+func (r Rule) Covers(t time.Time) bool {
+	start, err := ParseClock(r.Start)
+	if err != nil {
+		return false
+	}
+	end, err := ParseClock(r.End)
+	if err != nil {
+		return false
+	}
+	local := t.In(r.location())
+	m := local.Hour()*60 + local.Minute()
+	wd := local.Weekday()
+	if start < end {
+		return wd == r.Weekday && m >= start && m < end
+	}
+	next := (r.Weekday + 1) % 7
+	return (wd == r.Weekday && m >= start) || (wd == next && m < end)
+}
+
+func (e Exception) Covers(t time.Time) bool {
+	return !t.Before(e.StartsAt) && t.Before(e.EndsAt)
+}
+
+// OnShift reports whether the carrier is available at t. Off exceptions win
+// over on exceptions, which win over the weekly rules.
+func (s Schedule) OnShift(t time.Time) bool {
+	on := false
+	for _, e := range s.Exceptions {
+		if !e.Covers(t) {
+			continue
+		}
+		if e.Kind == KindOff {
+			return false
+		}
+		on = true
+	}
+	if on || len(s.Rules) == 0 {
+		return true
+	}
+	for _, r := range s.Rules {
+		if r.Covers(t) {
+			return true
+		}
+	}
+	return false
+}
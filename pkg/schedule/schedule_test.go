@@ -0,0 +1,98 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func at(t *testing.T, s string) time.Time {
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+	v, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRuleCovers_DayShift(t *testing.T) {
+	// 2024-01-01 is a Monday.
+	r := Rule{Weekday: time.Monday, Start: "08:00", End: "20:00"}
+	if !r.Covers(at(t, "2024-01-01 08:00")) || !r.Covers(at(t, "2024-01-01 19:59")) {
+		t.Fatalf("expected shift to cover working hours")
+	}
+	if r.Covers(at(t, "2024-01-01 20:00")) || r.Covers(at(t, "2024-01-02 10:00")) {
+		t.Fatalf("expected shift to end at 20:00 on Monday")
+	}
+}
+
+func TestRuleCovers_Overnight(t *testing.T) {
+	r := Rule{Weekday: time.Friday, Start: "22:00", End: "06:00"}
+	if !r.Covers(at(t, "2024-01-05 23:30")) || !r.Covers(at(t, "2024-01-06 05:59")) {
+		t.Fatalf("expected overnight shift to span midnight")
+	}
+	if r.Covers(at(t, "2024-01-06 06:00")) || r.Covers(at(t, "2024-01-05 05:00")) {
+		t.Fatalf("unexpected coverage outside overnight shift")
+	}
+}
+
+func TestRuleCovers_UsesRuleTimezone(t *testing.T) {
+	r := Rule{Weekday: time.Monday, Start: "08:00", End: "09:00", Timezone: "UTC"}
+	// 08:30 UTC is 11:30 in Minsk.
+	if !r.Covers(time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC)) {
+		t.Fatalf("expected rule to be evaluated in UTC")
+	}
+}
+
+func TestScheduleOnShift_ExceptionsOverrideRules(t *testing.T) {
+	s := Schedule{
+		Rules: []Rule{{Weekday: time.Monday, Start: "08:00", End: "20:00"}},
+		Exceptions: []Exception{
+			{Kind: KindOff, StartsAt: at(t, "2024-01-01 12:00"), EndsAt: at(t, "2024-01-01 13:00")},
+			{Kind: KindOn, StartsAt: at(t, "2024-01-02 10:00"), EndsAt: at(t, "2024-01-02 14:00")},
+		},
+	}
+	if !s.OnShift(at(t, "2024-01-01 11:00")) {
+		t.Fatalf("expected on shift before the break")
+	}
+	if s.OnShift(at(t, "2024-01-01 12:30")) {
+		t.Fatalf("expected break to remove availability")
+	}
+	if !s.OnShift(at(t, "2024-01-02 11:00")) {
+		t.Fatalf("expected extra shift to add availability")
+	}
+	if s.OnShift(at(t, "2024-01-02 15:00")) {
+		t.Fatalf("expected off shift on Tuesday afternoon")
+	}
+}
+
+func TestScheduleOnShift_NoRulesIsUnrestricted(t *testing.T) {
+	s := Schedule{Exceptions: []Exception{{Kind: KindOff, StartsAt: at(t, "2024-01-01 00:00"), EndsAt: at(t, "2024-01-02 00:00")}}}
+	if !s.OnShift(at(t, "2024-01-03 03:00")) {
+		t.Fatalf("expected carrier without rules to be available")
+	}
+	if s.OnShift(at(t, "2024-01-01 10:00")) {
+		t.Fatalf("expected day off to apply without rules")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Rule{Weekday: 7, Start: "08:00", End: "09:00"}).Validate(); err == nil {
+		t.Fatalf("expected weekday error")
+	}
+	if err := (Rule{Weekday: 1, Start: "8am", End: "09:00"}).Validate(); err == nil {
+		t.Fatalf("expected time format error")
+	}
+	if err := (Rule{Weekday: 1, Start: "08:00", End: "08:00"}).Validate(); err == nil {
+		t.Fatalf("expected empty shift error")
+	}
+	if err := (Exception{Kind: "holiday"}).Validate(); err == nil {
+		t.Fatalf("expected kind error")
+	}
+	now := time.Now()
+	if err := (Exception{Kind: KindOff, StartsAt: now, EndsAt: now}).Validate(); err == nil {
+		t.Fatalf("expected range error")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"os"
@@ -15,10 +16,12 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"bel-parcel/pkg/leader"
 	"bel-parcel/services/batching-service/internal/batching"
 	"bel-parcel/services/batching-service/internal/config"
 	"bel-parcel/services/batching-service/internal/infra/db"
 	"bel-parcel/services/batching-service/internal/infra/kafka"
+	"bel-parcel/services/batching-service/internal/infra/reference"
 	"bel-parcel/services/batching-service/internal/metrics"
 	"bel-parcel/services/batching-service/internal/outbox"
 )
@@ -52,6 +55,10 @@ func main() {
 		slog.Error("failed to ensure outbox schema", "error", err)
 		os.Exit(1)
 	}
+	if err := leader.EnsureSchema(batchDB); err != nil {
+		slog.Error("failed to ensure leader schema", "error", err)
+		os.Exit(1)
+	}
 
 	producer, err := kafka.NewProducer(cfg.Kafka.Brokers, cfg.Kafka.Timeout)
 	if err != nil {
@@ -70,6 +77,10 @@ func main() {
 
 	go outbox.StartPublisher(cctx, batchDB, producer)
 
+	// The projection is filled from the reference snapshot before consuming;
+	// events older than the stored rows are then ignored by their seq.
+	bootstrapReference(cctx, svc, reference.NewClient(cfg.Reference.URL, cfg.Reference.Token, cfg.Reference.Timeout))
+
 	consumer.Start(cctx, func(topic string, key, value []byte) error {
 		if err := svc.HandleEvent(cctx, topic, key, value); err != nil {
 			svc.PublishDLQ(cctx, topic, key, value, err)
@@ -78,18 +89,19 @@ func main() {
 		return nil
 	})
 
-	go func() {
+	elector := leader.NewElector(batchDB, "batching-flush-expired", leader.InstanceID(), cfg.Leader.RenewInterval, metrics.SetLeader)
+	go elector.Run(cctx, func(ctx context.Context) {
 		t := time.NewTicker(cfg.Batching.FlushInterval)
 		defer t.Stop()
 		for {
 			select {
-			case <-cctx.Done():
+			case <-ctx.Done():
 				return
 			case <-t.C:
-				svc.FlushExpired(context.Background())
+				svc.FlushExpired(ctx)
 			}
 		}
-	}()
+	})
 
 	mux := http.NewServeMux()
 	metrics.Init(mux)
@@ -104,8 +116,16 @@ func main() {
 			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 			return
 		}
+		lease, err := elector.Current(r.Context())
+		if err != nil {
+			slog.Warn("leader lookup failed", "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "OK",
+			"leader": lease,
+		})
 	})
 
 	srv := &http.Server{
@@ -133,6 +153,30 @@ func main() {
 	slog.Info("server stopped")
 }
 
+// bootstrapReference loads the reference snapshot with a few retries. If the
+// reference service stays unavailable the service starts on the projection it
+// already has and catches up from the event stream.
+func bootstrapReference(ctx context.Context, svc *batching.Service, client *reference.Client) {
+	backoff := time.Second
+	for attempt := 1; attempt <= 5; attempt++ {
+		snap, err := client.Snapshot(ctx)
+		if err == nil {
+			err = svc.ApplyReferenceSnapshot(ctx, snap)
+		}
+		if err == nil {
+			return
+		}
+		slog.Warn("reference snapshot bootstrap failed", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	slog.Warn("starting without reference snapshot")
+}
+
 func setupLogger() {
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
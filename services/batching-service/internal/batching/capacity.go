@@ -0,0 +1,85 @@
+package batching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"bel-parcel/pkg/schedule"
+	"bel-parcel/services/batching-service/internal/metrics"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A PVP holds the parcels of every batch formed for it until they are picked
+// up or leave with a return batch. pvp_parcels tracks that load so a group
+// is not flushed into a full PVP, and the PVP hours keep batches from being
+// dispatched while it is closed.
+
+// pvpCalendar returns the capacity (0 means no limit) and hours of a PVP.
+// A PVP missing from the projection is treated as unrestricted.
+func pvpCalendar(ctx context.Context, tx pgx.Tx, pvpID string) (int, schedule.Hours, error) {
+	var capacity int
+	var hours schedule.Hours
+	err := tx.QueryRow(ctx, `SELECT capacity, hours FROM ref_pickup_points WHERE pvp_id=$1`, pvpID).Scan(&capacity, &hours)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, schedule.Hours{}, nil
+	}
+	return capacity, hours, err
+}
+
+// freeCapacity returns how many more parcels the PVP can take, or -1 when it
+// has no limit.
+func freeCapacity(ctx context.Context, tx pgx.Tx, pvpID string, capacity int) (int, error) {
+	if capacity <= 0 {
+		return -1, nil
+	}
+	var load int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM pvp_parcels WHERE pvp_id=$1`, pvpID).Scan(&load); err != nil {
+		return 0, err
+	}
+	return max(capacity-load, 0), nil
+}
+
+// holdGroup leaves a group in place until the next flush attempt. The check
+// runs on every expired flush tick, so it only logs at debug level.
+func holdGroup(warehouseID, pvpID, reason string) {
+	metrics.BatchGroupsHeld.WithLabelValues(reason).Inc()
+	slog.Debug("batch group held", "warehouse_id", warehouseID, "pvp_id", pvpID, "reason", reason)
+}
+
+func addPVPParcelsTx(ctx context.Context, tx pgx.Tx, orderDest map[string]string) error {
+	for oid, pvpID := range orderDest {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO pvp_parcels (order_id, pvp_id, added_at) VALUES ($1, $2, NOW())
+			ON CONFLICT (order_id) DO UPDATE SET pvp_id=EXCLUDED.pvp_id, added_at=EXCLUDED.added_at
+		`, oid, pvpID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removePVPParcelsTx(ctx context.Context, tx pgx.Tx, orderIDs []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM pvp_parcels WHERE order_id = ANY($1)`, orderIDs)
+	return err
+}
+
+// handleOrderPickedUp frees the space of a parcel the customer collected.
+func (s *Service) handleOrderPickedUp(ctx context.Context, value []byte) error {
+	var envelope struct {
+		Data struct {
+			OrderID string `json:"order_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	if envelope.Data.OrderID == "" {
+		return nil
+	}
+	// Deleting is idempotent, so redelivered events need no dedup record.
+	_, err := s.db.Exec(ctx, `DELETE FROM pvp_parcels WHERE order_id=$1`, envelope.Data.OrderID)
+	return err
+}
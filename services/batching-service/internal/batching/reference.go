@@ -0,0 +1,74 @@
+package batching
+
+import (
+	"context"
+	"log/slog"
+
+	"bel-parcel/services/batching-service/internal/infra/reference"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Reference rows keep the change number (seq) of the record they were built
+// from. A row is only overwritten by a newer or equal seq, so the snapshot and
+// the event stream can be applied in any order and replayed safely.
+
+func upsertRefWarehouse(ctx context.Context, tx pgx.Tx, id, name string, lat, lng float64, seq int64) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO ref_warehouses (warehouse_id, name, latitude, longitude, source_seq, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (warehouse_id) DO UPDATE
+		SET name=EXCLUDED.name, latitude=EXCLUDED.latitude, longitude=EXCLUDED.longitude,
+			source_seq=EXCLUDED.source_seq, updated_at=NOW()
+		WHERE ref_warehouses.source_seq <= EXCLUDED.source_seq
+	`, id, name, lat, lng, seq)
+	return err
+}
+
+func upsertRefPickupPoint(ctx context.Context, tx pgx.Tx, p reference.PickupPoint) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO ref_pickup_points (pvp_id, name, latitude, longitude, is_hub, capacity, hours, source_seq, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		ON CONFLICT (pvp_id) DO UPDATE
+		SET name=EXCLUDED.name, latitude=EXCLUDED.latitude, longitude=EXCLUDED.longitude, is_hub=EXCLUDED.is_hub,
+			capacity=EXCLUDED.capacity, hours=EXCLUDED.hours, source_seq=EXCLUDED.source_seq, updated_at=NOW()
+		WHERE ref_pickup_points.source_seq <= EXCLUDED.source_seq
+	`, p.ID, p.Name, p.Latitude, p.Longitude, p.IsHub, p.Capacity, p.Hours, p.Seq)
+	return err
+}
+
+// ApplyReferenceSnapshot fills the reference projection from a
+// reference-service snapshot. Records without coordinates cannot be used
+// for routing and are skipped.
+func (s *Service) ApplyReferenceSnapshot(ctx context.Context, snap *reference.Snapshot) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	skipped := 0
+	for _, w := range snap.Warehouses {
+		if w.Latitude == 0 && w.Longitude == 0 {
+			skipped++
+			continue
+		}
+		if err := upsertRefWarehouse(ctx, tx, w.ID, w.Name, w.Latitude, w.Longitude, w.Seq); err != nil {
+			return err
+		}
+	}
+	for _, p := range snap.PickupPoints {
+		if p.Latitude == 0 && p.Longitude == 0 {
+			skipped++
+			continue
+		}
+		if err := upsertRefPickupPoint(ctx, tx, p); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("reference snapshot applied", "seq", snap.Seq,
+		"warehouses", len(snap.Warehouses), "pickup_points", len(snap.PickupPoints), "skipped", skipped)
+	return nil
+}
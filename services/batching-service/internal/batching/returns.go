@@ -0,0 +1,250 @@
+package batching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/batching-service/internal/metrics"
+	"bel-parcel/services/batching-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// handleReturnCreated collects orders whose storage period expired at a PVP
+// into per PVP/warehouse return groups, the reverse of batch_group_items.
+func (s *Service) handleReturnCreated(ctx context.Context, value []byte) error {
+	var envelope struct {
+		EventID   string          `json:"event_id"`
+		EventType string          `json:"event_type"`
+		Data      json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	if envelope.EventType != "orders.return_created" {
+		return nil
+	}
+	var data struct {
+		ReturnID    string `json:"return_id"`
+		OrderID     string `json:"order_id"`
+		PVPID       string `json:"pvp_id"`
+		WarehouseID string `json:"warehouse_id"`
+	}
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		return err
+	}
+	if data.OrderID == "" || data.PVPID == "" || data.WarehouseID == "" {
+		slog.Warn("return without origin or warehouse skipped", "order_id", data.OrderID, "return_id", data.ReturnID)
+		return nil
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	var id string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, occurred_at)
+		VALUES ($1, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, envelope.EventID).Scan(&id); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if id == "" {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO return_group_items(pvp_id, warehouse_id, order_id, return_id, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (pvp_id, warehouse_id, order_id) DO NOTHING
+	`, data.PVPID, data.WarehouseID, data.OrderID, data.ReturnID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	var cnt int
+	if err := s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM return_group_items WHERE pvp_id=$1 AND warehouse_id=$2
+	`, data.PVPID, data.WarehouseID).Scan(&cnt); err != nil {
+		return err
+	}
+	if cnt >= s.maxSize {
+		if err := s.flushReturnGroupDB(ctx, data.PVPID, data.WarehouseID); err != nil {
+			slog.Error("return size flush failed", "pvp_id", data.PVPID, "warehouse_id", data.WarehouseID, "error", err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) flushExpiredReturnsDB(ctx context.Context) error {
+	interval := fmt.Sprintf("%d seconds", int64(s.flushInterval.Seconds()))
+	rows, err := s.db.Query(ctx, `
+		SELECT pvp_id, warehouse_id
+		FROM return_group_items
+		GROUP BY pvp_id, warehouse_id
+		HAVING COUNT(*) >= $1 OR MAX(updated_at) <= NOW() - $2::interval
+	`, s.maxSize, interval)
+	if err != nil {
+		return err
+	}
+	type groupKey struct{ pvpID, warehouseID string }
+	var keys []groupKey
+	for rows.Next() {
+		var k groupKey
+		if err := rows.Scan(&k.pvpID, &k.warehouseID); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	var firstErr error
+	for _, k := range keys {
+		if err := s.flushReturnGroupDB(ctx, k.pvpID, k.warehouseID); err != nil {
+			slog.Error("expired return flush failed", "pvp_id", k.pvpID, "warehouse_id", k.warehouseID, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// returnGroupLockKey differs from groupLockKey so a return group never
+// contends with the forward group of the same warehouse and PVP.
+func returnGroupLockKey(pvpID, warehouseID string) string {
+	return "batching:return:" + pvpID + ":" + warehouseID
+}
+
+// flushReturnGroupDB forms a return batch from the PVP straight to the
+// seller's warehouse. It follows flushGroupDB: one transaction under a
+// per-group advisory lock, deleting only the rows it read.
+func (s *Service) flushReturnGroupDB(ctx context.Context, pvpID, warehouseID string) error {
+	now := time.Now().UTC()
+	start := time.Now()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, returnGroupLockKey(pvpID, warehouseID)); err != nil {
+		return err
+	}
+
+	var orderIDs []string
+	rows, err := tx.Query(ctx, `
+		SELECT order_id FROM return_group_items
+		WHERE pvp_id=$1 AND warehouse_id=$2
+		ORDER BY updated_at
+		FOR UPDATE
+	`, pvpID, warehouseID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var oid string
+		if err := rows.Scan(&oid); err != nil {
+			rows.Close()
+			return err
+		}
+		orderIDs = append(orderIDs, oid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(orderIDs) == 0 {
+		return nil
+	}
+
+	var originLat, originLng float64
+	if err := tx.QueryRow(ctx, "SELECT latitude, longitude FROM ref_pickup_points WHERE pvp_id=$1", pvpID).Scan(&originLat, &originLng); err != nil {
+		return fmt.Errorf("pvp %s not found: %w", pvpID, err)
+	}
+	var destLat, destLng float64
+	if err := tx.QueryRow(ctx, "SELECT latitude, longitude FROM ref_warehouses WHERE warehouse_id=$1", warehouseID).Scan(&destLat, &destLng); err != nil {
+		return fmt.Errorf("warehouse %s not found in ref_warehouses: %w", warehouseID, err)
+	}
+
+	batchID := uuid.NewString()
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     "batches.formed",
+		"occurred_at":    now,
+		"correlation_id": pvpID + "/" + warehouseID,
+		"data": map[string]interface{}{
+			"batch_id":           batchID,
+			"origin_type":        "pvp",
+			"origin_id":          pvpID,
+			"origin_lat":         originLat,
+			"origin_lng":         originLng,
+			"destination_type":   "warehouse",
+			"destination_id":     warehouseID,
+			"destination_lat":    destLat,
+			"destination_lng":    destLng,
+			"is_hub_destination": false,
+			"order_ids":          orderIDs,
+			"formed_at":          now,
+		},
+	}
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM return_group_items
+		WHERE pvp_id=$1 AND warehouse_id=$2 AND order_id = ANY($3)
+	`, pvpID, warehouseID, orderIDs); err != nil {
+		return err
+	}
+	if err := removePVPParcelsTx(ctx, tx, orderIDs); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO batches (id, origin_id, origin_type, seller_warehouse_id, pickup_point_id, origin_lat, origin_lng, destination_lat, destination_lng, is_hub_destination, destination_type, formed_at)
+		VALUES ($1, $2, 'pvp', $3, $2, $4, $5, $6, $7, false, 'warehouse', $8)
+	`, batchID, pvpID, warehouseID, originLat, originLng, destLat, destLng, now); err != nil {
+		return err
+	}
+	for _, oid := range orderIDs {
+		if _, err := tx.Exec(ctx, `INSERT INTO batch_orders (batch_id, order_id) VALUES ($1, $2)`, batchID, oid); err != nil {
+			return err
+		}
+	}
+
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	if err := outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     "batches.formed",
+		CorrelationID: pvpID + "/" + warehouseID + "/" + batchID,
+		Topic:         s.outTopic,
+		PartitionKey:  batchID,
+		Payload:       payload,
+		OccurredAt:    now,
+	}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	metrics.BatchFlushDuration.Observe(time.Since(start).Seconds())
+	return nil
+}
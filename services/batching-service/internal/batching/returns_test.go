@@ -0,0 +1,67 @@
+package batching
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func returnCreated(orderID, pvpID, warehouseID string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     "orders.return_created",
+		"occurred_at":    time.Now().UTC(),
+		"correlation_id": orderID,
+		"data": map[string]interface{}{
+			"return_id":    uuid.NewString(),
+			"order_id":     orderID,
+			"pvp_id":       pvpID,
+			"warehouse_id": warehouseID,
+		},
+	})
+	return b
+}
+
+func TestReturns_FlushFormsBatchTowardsWarehouse(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	run := uuid.NewString()[:8]
+	warehouseID := "wh-" + run
+	pvpID := "pvp-" + run
+	if _, err := db.Exec(ctx, `INSERT INTO ref_warehouses (warehouse_id, name, latitude, longitude) VALUES ($1, 'wh', 53.9, 27.56)`, warehouseID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO ref_pickup_points (pvp_id, name, latitude, longitude, is_hub) VALUES ($1, 'pvp', 53.91, 27.57, false)`, pvpID); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(db, nil, "batches.formed", 2, time.Hour)
+	for i := 0; i < 2; i++ {
+		if err := svc.HandleEvent(ctx, "orders.returns", nil, returnCreated(uuid.NewString(), pvpID, warehouseID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var batches int
+	var originType, destType string
+	if err := db.QueryRow(ctx, `
+		SELECT COUNT(*), MAX(origin_type), MAX(destination_type) FROM batches WHERE origin_id=$1
+	`, pvpID).Scan(&batches, &originType, &destType); err != nil {
+		t.Fatal(err)
+	}
+	if batches != 1 || originType != "pvp" || destType != "warehouse" {
+		t.Fatalf("expected one pvp->warehouse batch, got %d %s->%s", batches, originType, destType)
+	}
+	var left int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM return_group_items WHERE pvp_id=$1`, pvpID).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Fatalf("expected the return group to be emptied, %d left", left)
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math"
-	"sync"
 	"time"
 
+	"bel-parcel/pkg/schedule"
 	"bel-parcel/services/batching-service/internal/infra/kafka"
+	"bel-parcel/services/batching-service/internal/infra/reference"
 	"bel-parcel/services/batching-service/internal/metrics"
 	"bel-parcel/services/batching-service/internal/outbox"
 
@@ -25,18 +27,6 @@ type Service struct {
 	dlqTopic      string
 	maxSize       int
 	flushInterval time.Duration
-	mu            sync.Mutex
-	groups        map[string]*group
-}
-
-type group struct {
-	warehouseID string
-	orderIDs    []string
-	updatedAt   time.Time
-	contacts    map[string]struct {
-		Phone string
-		Email string
-	}
 }
 
 func EnsureSchema(db *pgxpool.Pool) error {
@@ -57,6 +47,7 @@ func EnsureSchema(db *pgxpool.Pool) error {
 			order_id TEXT NOT NULL,
 			customer_phone TEXT,
 			customer_email TEXT,
+			customer_locale TEXT,
 			updated_at TIMESTAMPTZ NOT NULL,
 			PRIMARY KEY (warehouse_id, pvp_id, order_id)
 		)
@@ -67,7 +58,8 @@ func EnsureSchema(db *pgxpool.Pool) error {
 	if _, err := db.Exec(ctx, `
 		ALTER TABLE IF EXISTS batch_group_items
 		ADD COLUMN IF NOT EXISTS customer_phone TEXT,
-		ADD COLUMN IF NOT EXISTS customer_email TEXT
+		ADD COLUMN IF NOT EXISTS customer_email TEXT,
+		ADD COLUMN IF NOT EXISTS customer_locale TEXT
 	`); err != nil {
 		return err
 	}
@@ -111,6 +103,47 @@ func EnsureSchema(db *pgxpool.Pool) error {
 	`); err != nil {
 		return err
 	}
+	// Lineage of batches split after a disrupted trip
+	if _, err := db.Exec(ctx, `
+		ALTER TABLE batches ADD COLUMN IF NOT EXISTS parent_batch_id TEXT;
+		ALTER TABLE batch_orders ADD COLUMN IF NOT EXISTS split_from_batch_id TEXT;
+	`); err != nil {
+		return err
+	}
+	// Returns of uncollected orders, grouped per PVP and seller warehouse
+	if _, err := db.Exec(ctx, `
+		ALTER TABLE batches ADD COLUMN IF NOT EXISTS destination_type TEXT DEFAULT 'pvp';
+		CREATE TABLE IF NOT EXISTS return_group_items (
+			pvp_id TEXT NOT NULL,
+			warehouse_id TEXT NOT NULL,
+			order_id TEXT NOT NULL,
+			return_id TEXT,
+			updated_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (pvp_id, warehouse_id, order_id)
+		);
+	`); err != nil {
+		return err
+	}
+	// Change number of the reference record, see ApplyReferenceSnapshot
+	if _, err := db.Exec(ctx, `
+		ALTER TABLE ref_warehouses ADD COLUMN IF NOT EXISTS source_seq BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE ref_pickup_points ADD COLUMN IF NOT EXISTS source_seq BIGINT NOT NULL DEFAULT 0;
+	`); err != nil {
+		return err
+	}
+	// PVP capacity and hours, and the parcels each PVP is holding
+	if _, err := db.Exec(ctx, `
+		ALTER TABLE ref_pickup_points ADD COLUMN IF NOT EXISTS capacity INT NOT NULL DEFAULT 0;
+		ALTER TABLE ref_pickup_points ADD COLUMN IF NOT EXISTS hours JSONB NOT NULL DEFAULT '{}';
+		CREATE TABLE IF NOT EXISTS pvp_parcels (
+			order_id TEXT PRIMARY KEY,
+			pvp_id TEXT NOT NULL,
+			added_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_pvp_parcels_pvp ON pvp_parcels(pvp_id);
+	`); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -122,7 +155,6 @@ func NewService(db *pgxpool.Pool, producer *kafka.Producer, outTopic string, max
 		outTopic:      outTopic,
 		maxSize:       maxSize,
 		flushInterval: flushInterval,
-		groups:        make(map[string]*group),
 	}
 }
 
@@ -138,6 +170,18 @@ func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []by
 	if topic == "events.batch_delivered_to_pvp" {
 		return s.handleBatchDelivered(ctx, value)
 	}
+	if topic == "batches.split" {
+		return s.handleBatchSplit(ctx, value)
+	}
+	if topic == "commands.batch.split" {
+		return s.handleSplitCommand(ctx, value)
+	}
+	if topic == "orders.returns" {
+		return s.handleReturnCreated(ctx, value)
+	}
+	if topic == "events.order_picked_up" {
+		return s.handleOrderPickedUp(ctx, value)
+	}
 	if topic != "orders.created" {
 		return nil
 	}
@@ -169,7 +213,7 @@ func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []by
 	if err := json.Unmarshal(envelope.Data, &data); err != nil {
 		return err
 	}
-	s.addToGroup(ctx, data.SellerWarehouseID, data.PickupPointID, data.OrderID, data.CustomerPhone, data.CustomerEmail)
+	s.addToGroup(ctx, data.SellerWarehouseID, data.PickupPointID)
 	return nil
 }
 
@@ -203,6 +247,7 @@ func (s *Service) handleReferenceUpdate(ctx context.Context, value []byte) error
 
 	var data struct {
 		UpdateType string `json:"update_type"`
+		Seq        int64  `json:"seq"`
 		Warehouse  *struct {
 			ID        string  `json:"warehouse_id"`
 			Name      string  `json:"name"`
@@ -215,6 +260,8 @@ func (s *Service) handleReferenceUpdate(ctx context.Context, value []byte) error
 			Latitude  float64 `json:"latitude"`
 			Longitude float64 `json:"longitude"`
 			IsHub     bool    `json:"is_hub"`
+			Capacity  int     `json:"capacity"`
+			schedule.Hours
 		} `json:"pickup_point"`
 	}
 	if err := json.Unmarshal(envelope.Data, &data); err != nil {
@@ -224,23 +271,16 @@ func (s *Service) handleReferenceUpdate(ctx context.Context, value []byte) error
 	switch data.UpdateType {
 	case "warehouse":
 		if w := data.Warehouse; w != nil {
-			if _, err := tx.Exec(ctx, `
-				INSERT INTO ref_warehouses (warehouse_id, name, latitude, longitude, updated_at)
-				VALUES ($1, $2, $3, $4, NOW())
-				ON CONFLICT (warehouse_id) DO UPDATE
-				SET name=EXCLUDED.name, latitude=EXCLUDED.latitude, longitude=EXCLUDED.longitude, updated_at=NOW()
-			`, w.ID, w.Name, w.Latitude, w.Longitude); err != nil {
+			if err := upsertRefWarehouse(ctx, tx, w.ID, w.Name, w.Latitude, w.Longitude, data.Seq); err != nil {
 				return err
 			}
 		}
 	case "pickup_point":
 		if p := data.PickupPoint; p != nil {
-			if _, err := tx.Exec(ctx, `
-				INSERT INTO ref_pickup_points (pvp_id, name, latitude, longitude, is_hub, updated_at)
-				VALUES ($1, $2, $3, $4, $5, NOW())
-				ON CONFLICT (pvp_id) DO UPDATE
-				SET name=EXCLUDED.name, latitude=EXCLUDED.latitude, longitude=EXCLUDED.longitude, is_hub=EXCLUDED.is_hub, updated_at=NOW()
-			`, p.ID, p.Name, p.Latitude, p.Longitude, p.IsHub); err != nil {
+			if err := upsertRefPickupPoint(ctx, tx, reference.PickupPoint{
+				ID: p.ID, Name: p.Name, Latitude: p.Latitude, Longitude: p.Longitude, IsHub: p.IsHub,
+				Capacity: p.Capacity, Hours: p.Hours, Seq: data.Seq,
+			}); err != nil {
 				return err
 			}
 		}
@@ -415,32 +455,18 @@ func (s *Service) PublishDLQ(ctx context.Context, originalTopic string, key []by
 	_ = s.producer.Publish(ctx, s.dlqTopic, string(key), envelope)
 }
 
-func (s *Service) addToGroup(ctx context.Context, warehouseID, pvpID, orderID, phone, email string) {
-	k := warehouseID + ":" + pvpID
-	now := time.Now().UTC()
-	s.mu.Lock()
-	g, ok := s.groups[k]
-	if !ok {
-		g = &group{warehouseID: warehouseID, orderIDs: make([]string, 0, s.maxSize), updatedAt: now, contacts: make(map[string]struct {
-			Phone string
-			Email string
-		})}
-		s.groups[k] = g
-		metrics.BatchActiveGroups.Inc()
-	}
-	g.orderIDs = append(g.orderIDs, orderID)
+// addToGroup runs after the item is persisted in batch_group_items. Group
+// membership lives only in Postgres so any replica may trigger the flush.
+func (s *Service) addToGroup(ctx context.Context, warehouseID, pvpID string) {
 	metrics.BatchOrdersAdded.WithLabelValues(warehouseID).Inc()
-	g.contacts[orderID] = struct {
-		Phone string
-		Email string
-	}{Phone: phone, Email: email}
-	g.updatedAt = now
-	s.mu.Unlock()
-	_ = s.tryFlushBySizeDB(ctx, warehouseID, pvpID)
+	if err := s.tryFlushBySizeDB(ctx, warehouseID, pvpID); err != nil {
+		slog.Error("size flush failed", "warehouse_id", warehouseID, "pvp_id", pvpID, "error", err)
+	}
 }
 
 func (s *Service) FlushExpired(ctx context.Context) {
 	_ = s.flushExpiredDB(ctx)
+	_ = s.flushExpiredReturnsDB(ctx)
 }
 
 func (s *Service) flushGroup(ctx context.Context, key string) error {
@@ -468,6 +494,7 @@ func (s *Service) addItemTx(ctx context.Context, eventID string, raw json.RawMes
 		CreatedAt         time.Time `json:"created_at"`
 		CustomerPhone     string    `json:"customer_phone"`
 		CustomerEmail     string    `json:"customer_email"`
+		CustomerLocale    string    `json:"customer_locale"`
 	}
 	if err := json.Unmarshal(raw, &data); err != nil {
 		return false, err
@@ -492,11 +519,11 @@ func (s *Service) addItemTx(ctx context.Context, eventID string, raw json.RawMes
 		return false, nil
 	}
 	if _, err := tx.Exec(ctx, `
-		INSERT INTO batch_group_items(warehouse_id, pvp_id, order_id, customer_phone, customer_email, updated_at)
-		VALUES ($1, $2, $3, $4, $5, NOW())
+		INSERT INTO batch_group_items(warehouse_id, pvp_id, order_id, customer_phone, customer_email, customer_locale, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NOW())
 		ON CONFLICT (warehouse_id, pvp_id, order_id)
-		DO UPDATE SET customer_phone=EXCLUDED.customer_phone, customer_email=EXCLUDED.customer_email, updated_at=EXCLUDED.updated_at
-	`, data.SellerWarehouseID, data.PickupPointID, data.OrderID, data.CustomerPhone, data.CustomerEmail); err != nil {
+		DO UPDATE SET customer_phone=EXCLUDED.customer_phone, customer_email=EXCLUDED.customer_email, customer_locale=EXCLUDED.customer_locale, updated_at=EXCLUDED.updated_at
+	`, data.SellerWarehouseID, data.PickupPointID, data.OrderID, data.CustomerPhone, data.CustomerEmail, data.CustomerLocale); err != nil {
 		return false, err
 	}
 	if err := tx.Commit(ctx); err != nil {
@@ -533,40 +560,98 @@ func (s *Service) flushExpiredDB(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	type groupKey struct{ warehouseID, pvpID string }
+	var keys []groupKey
 	for rows.Next() {
-		var wid, pid string
-		if err := rows.Scan(&wid, &pid); err != nil {
+		var k groupKey
+		if err := rows.Scan(&k.warehouseID, &k.pvpID); err != nil {
+			rows.Close()
 			return err
 		}
-		if err := s.flushGroupDB(ctx, wid, pid); err != nil {
-			return err
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	var firstErr error
+	for _, k := range keys {
+		if err := s.flushGroupDB(ctx, k.warehouseID, k.pvpID); err != nil {
+			slog.Error("expired flush failed", "warehouse_id", k.warehouseID, "pvp_id", k.pvpID, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
 		}
 	}
-	return rows.Err()
+	return firstErr
 }
 
+// groupLockKey is the advisory lock key that serialises flushes of one
+// warehouse/PVP group across replicas.
+func groupLockKey(warehouseID, pvpID string) string {
+	return "batching:group:" + warehouseID + ":" + pvpID
+}
+
+// flushGroupDB turns the items of a warehouse/PVP group into a batch. The
+// whole flush runs in one transaction holding a per-group advisory lock, and
+// only the rows read under that lock are deleted, so concurrent replicas
+// cannot emit the same orders twice: the loser finds the group empty and
+// returns without publishing anything.
 func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) error {
 	now := time.Now().UTC()
 	start := time.Now()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, groupLockKey(warehouseID, pvpID)); err != nil {
+		return err
+	}
+
+	// A full PVP gets nothing; otherwise only the oldest orders that still
+	// fit are taken and the rest wait for the next flush.
+	capacity, pvpHours, err := pvpCalendar(ctx, tx, pvpID)
+	if err != nil {
+		return err
+	}
+	free, err := freeCapacity(ctx, tx, pvpID, capacity)
+	if err != nil {
+		return err
+	}
+	if free == 0 {
+		holdGroup(warehouseID, pvpID, "full")
+		return nil
+	}
+	var limit any
+	if free > 0 {
+		limit = free
+	}
+
 	var orderIDs []string
 	var orderContacts []map[string]string
 	pvpSet := make(map[string]struct{})
 	orderDestMap := make(map[string]string)
 
-	rows, err := s.db.Query(ctx, `
-		SELECT order_id, pvp_id, COALESCE(customer_phone,''), COALESCE(customer_email,'')
+	rows, err := tx.Query(ctx, `
+		SELECT order_id, pvp_id, COALESCE(customer_phone,''), COALESCE(customer_email,''), COALESCE(customer_locale,'')
 		FROM batch_group_items
 		WHERE warehouse_id=$1 AND pvp_id=$2
 		ORDER BY updated_at
-	`, warehouseID, pvpID)
+		LIMIT $3
+		FOR UPDATE
+	`, warehouseID, pvpID, limit)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 	for rows.Next() {
-		var oid, pid, phone, email string
-		if err := rows.Scan(&oid, &pid, &phone, &email); err != nil {
+		var oid, pid, phone, email, locale string
+		if err := rows.Scan(&oid, &pid, &phone, &email, &locale); err != nil {
+			rows.Close()
 			return err
 		}
 		orderIDs = append(orderIDs, oid)
@@ -576,12 +661,15 @@ func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) e
 			"order_id":       oid,
 			"customer_phone": phone,
 			"customer_email": email,
+			"locale":         locale,
 		})
 	}
+	rows.Close()
 	if err := rows.Err(); err != nil {
 		return err
 	}
 	if len(orderIDs) == 0 {
+		// Already flushed by another replica.
 		return nil
 	}
 
@@ -590,7 +678,7 @@ func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) e
 	var isHubDest bool
 
 	var originLat, originLng float64
-	if err := s.db.QueryRow(ctx, "SELECT latitude, longitude FROM ref_warehouses WHERE warehouse_id=$1", warehouseID).Scan(&originLat, &originLng); err != nil {
+	if err := tx.QueryRow(ctx, "SELECT latitude, longitude FROM ref_warehouses WHERE warehouse_id=$1", warehouseID).Scan(&originLat, &originLng); err != nil {
 		return fmt.Errorf("warehouse %s not found in ref_warehouses: %w", warehouseID, err)
 	}
 
@@ -603,7 +691,7 @@ func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) e
 
 		var pvpLat, pvpLng float64
 		var pvpIsHub bool
-		if err := s.db.QueryRow(ctx, "SELECT latitude, longitude, is_hub FROM ref_pickup_points WHERE pvp_id=$1", singlePVPID).Scan(&pvpLat, &pvpLng, &pvpIsHub); err != nil {
+		if err := tx.QueryRow(ctx, "SELECT latitude, longitude, is_hub FROM ref_pickup_points WHERE pvp_id=$1", singlePVPID).Scan(&pvpLat, &pvpLng, &pvpIsHub); err != nil {
 			return fmt.Errorf("pvp %s not found: %w", singlePVPID, err)
 		}
 
@@ -619,11 +707,10 @@ func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) e
 	}
 
 	if !useSinglePVP {
-		rows, err := s.db.Query(ctx, "SELECT pvp_id, latitude, longitude FROM ref_pickup_points WHERE is_hub=true")
+		rows, err := tx.Query(ctx, "SELECT pvp_id, latitude, longitude FROM ref_pickup_points WHERE is_hub=true")
 		if err != nil {
 			return err
 		}
-		defer rows.Close()
 
 		minDist := math.MaxFloat64
 		foundHub := false
@@ -645,12 +732,24 @@ func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) e
 				foundHub = true
 			}
 		}
+		rows.Close()
 
 		if !foundHub {
 			return fmt.Errorf("no hubs found in ref_pickup_points")
 		}
 	}
 
+	destHours := pvpHours
+	if destID != pvpID {
+		if _, destHours, err = pvpCalendar(ctx, tx, destID); err != nil {
+			return err
+		}
+	}
+	if !destHours.OpenAt(now) {
+		holdGroup(warehouseID, pvpID, "closed")
+		return nil
+	}
+
 	batchID := uuid.NewString()
 	envelope := map[string]interface{}{
 		"event_id":       uuid.NewString(),
@@ -674,18 +773,10 @@ func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) e
 		},
 	}
 
-	tx, err := s.db.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = tx.Rollback(ctx)
-	}()
-
 	if _, err := tx.Exec(ctx, `
 		DELETE FROM batch_group_items
-		WHERE warehouse_id=$1 AND pvp_id=$2
-	`, warehouseID, pvpID); err != nil {
+		WHERE warehouse_id=$1 AND pvp_id=$2 AND order_id = ANY($3)
+	`, warehouseID, pvpID, orderIDs); err != nil {
 		return err
 	}
 
@@ -703,6 +794,9 @@ func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) e
 			return err
 		}
 	}
+	if err := addPVPParcelsTx(ctx, tx, orderDestMap); err != nil {
+		return err
+	}
 
 	payload, err := json.Marshal(envelope)
 	if err != nil {
@@ -724,12 +818,6 @@ func (s *Service) flushGroupDB(ctx context.Context, warehouseID, pvpID string) e
 		return err
 	}
 	metrics.BatchFlushDuration.Observe(time.Since(start).Seconds())
-	s.mu.Lock()
-	if g, ok := s.groups[warehouseID+":"+pvpID]; ok {
-		g.orderIDs = g.orderIDs[:0]
-	}
-	s.mu.Unlock()
-	metrics.BatchActiveGroups.Dec()
 	return nil
 }
 
@@ -0,0 +1,326 @@
+package batching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"bel-parcel/services/batching-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// handleBatchSplit applies a split decided by routing-service during a
+// transshipment: the moved orders leave the parent batch for a child batch
+// that keeps the parent's origin and destination. No batches.formed is
+// published because routing has already placed the child on a trip.
+func (s *Service) handleBatchSplit(ctx context.Context, value []byte) error {
+	var envelope struct {
+		EventID string          `json:"event_id"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	var data struct {
+		ParentBatchID string    `json:"parent_batch_id"`
+		BatchID       string    `json:"batch_id"`
+		OrderIDs      []string  `json:"order_ids"`
+		SplitAt       time.Time `json:"split_at"`
+	}
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		return err
+	}
+	if data.ParentBatchID == "" || data.BatchID == "" {
+		return nil
+	}
+	if data.SplitAt.IsZero() {
+		data.SplitAt = time.Now().UTC()
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, occurred_at)
+		VALUES ($1, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, envelope.EventID).Scan(&id); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if id == "" {
+		return nil
+	}
+
+	ct, err := tx.Exec(ctx, `
+		INSERT INTO batches (id, origin_id, origin_type, seller_warehouse_id, pickup_point_id, origin_lat, origin_lng, destination_lat, destination_lng, is_hub_destination, formed_at, parent_batch_id)
+		SELECT $2, origin_id, origin_type, seller_warehouse_id, pickup_point_id, origin_lat, origin_lng, destination_lat, destination_lng, is_hub_destination, $3, id
+		FROM batches WHERE id=$1
+		ON CONFLICT (id) DO NOTHING
+	`, data.ParentBatchID, data.BatchID, data.SplitAt)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		slog.Warn("split of unknown batch", "parent_batch_id", data.ParentBatchID, "batch_id", data.BatchID)
+		return tx.Commit(ctx)
+	}
+	moved, err := moveBatchOrdersTx(ctx, tx, data.ParentBatchID, data.BatchID, data.OrderIDs)
+	if err != nil {
+		return err
+	}
+	if int(moved) != len(data.OrderIDs) {
+		slog.Warn("split moved fewer orders than requested", "parent_batch_id", data.ParentBatchID, "batch_id", data.BatchID,
+			"requested", len(data.OrderIDs), "moved", moved)
+	}
+	return tx.Commit(ctx)
+}
+
+// moveBatchOrdersTx re-links orders to a child batch. The child rows keep the
+// parent id in split_from_batch_id so the lineage survives the move.
+func moveBatchOrdersTx(ctx context.Context, tx pgx.Tx, parentID, childID string, orderIDs []string) (int64, error) {
+	ct, err := tx.Exec(ctx, `
+		INSERT INTO batch_orders (batch_id, order_id, destination_pvp_id, split_from_batch_id)
+		SELECT $2, order_id, destination_pvp_id, batch_id
+		FROM batch_orders WHERE batch_id=$1 AND order_id = ANY($3)
+		ON CONFLICT (batch_id, order_id) DO NOTHING
+	`, parentID, childID, orderIDs)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM batch_orders WHERE batch_id=$1 AND order_id = ANY($2)
+	`, parentID, orderIDs); err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+// Split modes requested by routing-service.
+const (
+	SplitByDestination = "destination"
+	SplitByCapacity    = "capacity"
+)
+
+type batchOrder struct {
+	OrderID       string
+	DestinationID string
+}
+
+type splitGroup struct {
+	DestinationID string
+	OrderIDs      []string
+}
+
+// planSplit divides the orders of a batch into sub-batches. By destination
+// every final pickup point gets its own sub-batch; in both modes a sub-batch
+// holds at most maxOrders orders.
+func planSplit(orders []batchOrder, mode string, maxOrders int) []splitGroup {
+	byDest := make(map[string][]string)
+	for _, o := range orders {
+		key := ""
+		if mode == SplitByDestination {
+			key = o.DestinationID
+		}
+		byDest[key] = append(byDest[key], o.OrderID)
+	}
+	dests := make([]string, 0, len(byDest))
+	for d := range byDest {
+		dests = append(dests, d)
+	}
+	sort.Strings(dests)
+
+	var groups []splitGroup
+	for _, d := range dests {
+		ids := byDest[d]
+		sort.Strings(ids)
+		size := len(ids)
+		if maxOrders > 0 && maxOrders < size {
+			size = maxOrders
+		}
+		for len(ids) > 0 {
+			n := size
+			if n > len(ids) {
+				n = len(ids)
+			}
+			groups = append(groups, splitGroup{DestinationID: d, OrderIDs: ids[:n]})
+			ids = ids[n:]
+		}
+	}
+	return groups
+}
+
+// handleSplitCommand splits a batch whose trip was disrupted and that no
+// single replacement vehicle can take. Every sub-batch is published as
+// batches.formed with its parent id, so routing-service plans a trip for it
+// and order-service re-links the orders.
+func (s *Service) handleSplitCommand(ctx context.Context, value []byte) error {
+	var envelope struct {
+		EventID string          `json:"event_id"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	var data struct {
+		BatchID   string `json:"batch_id"`
+		TripID    string `json:"trip_id"`
+		Mode      string `json:"mode"`
+		MaxOrders int    `json:"max_orders"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		return err
+	}
+	if data.BatchID == "" {
+		return nil
+	}
+	if data.Mode != SplitByDestination && data.Mode != SplitByCapacity {
+		slog.Warn("unknown split mode", "batch_id", data.BatchID, "mode", data.Mode)
+		return nil
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, occurred_at)
+		VALUES ($1, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, envelope.EventID).Scan(&id); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if id == "" {
+		return nil
+	}
+
+	var originID, originType string
+	var destID *string
+	var originLat, originLng, destLat, destLng float64
+	var isHubDest bool
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(origin_id, ''), COALESCE(origin_type, 'warehouse'), pickup_point_id,
+		       COALESCE(origin_lat, 0), COALESCE(origin_lng, 0), COALESCE(destination_lat, 0), COALESCE(destination_lng, 0),
+		       COALESCE(is_hub_destination, false)
+		FROM batches WHERE id=$1
+	`, data.BatchID).Scan(&originID, &originType, &destID, &originLat, &originLng, &destLat, &destLng, &isHubDest); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			slog.Warn("split of unknown batch", "batch_id", data.BatchID)
+			return tx.Commit(ctx)
+		}
+		return err
+	}
+	parentDest := ""
+	if destID != nil {
+		parentDest = *destID
+	}
+
+	rows, err := tx.Query(ctx, `SELECT order_id, COALESCE(destination_pvp_id, '') FROM batch_orders WHERE batch_id=$1`, data.BatchID)
+	if err != nil {
+		return err
+	}
+	var orders []batchOrder
+	for rows.Next() {
+		var o batchOrder
+		if err := rows.Scan(&o.OrderID, &o.DestinationID); err != nil {
+			rows.Close()
+			return err
+		}
+		if o.DestinationID == "" {
+			o.DestinationID = parentDest
+		}
+		orders = append(orders, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	groups := planSplit(orders, data.Mode, data.MaxOrders)
+	for _, g := range groups {
+		dest := parentDest
+		lat, lng, hub := destLat, destLng, isHubDest
+		if data.Mode == SplitByDestination && g.DestinationID != "" && g.DestinationID != parentDest {
+			var pLat, pLng float64
+			var pHub bool
+			err := tx.QueryRow(ctx, "SELECT latitude, longitude, is_hub FROM ref_pickup_points WHERE pvp_id=$1", g.DestinationID).Scan(&pLat, &pLng, &pHub)
+			if err == nil {
+				dest, lat, lng, hub = g.DestinationID, pLat, pLng, pHub
+			} else if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			} else {
+				// Unknown pickup point: the sub-batch keeps the parent's route.
+				slog.Warn("split destination not found in ref_pickup_points", "batch_id", data.BatchID, "pvp_id", g.DestinationID)
+			}
+		}
+
+		childID := uuid.NewString()
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO batches (id, origin_id, origin_type, seller_warehouse_id, pickup_point_id, origin_lat, origin_lng, destination_lat, destination_lng, is_hub_destination, formed_at, parent_batch_id)
+			SELECT $2, origin_id, origin_type, seller_warehouse_id, $3, origin_lat, origin_lng, $4, $5, $6, $7, id
+			FROM batches WHERE id=$1
+		`, data.BatchID, childID, dest, lat, lng, hub, now); err != nil {
+			return err
+		}
+		if _, err := moveBatchOrdersTx(ctx, tx, data.BatchID, childID, g.OrderIDs); err != nil {
+			return err
+		}
+
+		envelope := map[string]interface{}{
+			"event_id":       uuid.NewString(),
+			"event_type":     "batches.formed",
+			"occurred_at":    now,
+			"correlation_id": data.BatchID + "/" + childID,
+			"data": map[string]interface{}{
+				"batch_id":           childID,
+				"parent_batch_id":    data.BatchID,
+				"origin_type":        originType,
+				"origin_id":          originID,
+				"origin_lat":         originLat,
+				"origin_lng":         originLng,
+				"destination_type":   "pvp",
+				"destination_id":     dest,
+				"destination_lat":    lat,
+				"destination_lng":    lng,
+				"is_hub_destination": hub,
+				"order_ids":          g.OrderIDs,
+				"formed_at":          now,
+			},
+		}
+		payload, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		if err := outbox.EnqueueTx(ctx, tx, outbox.Event{
+			ID:            uuid.NewString(),
+			EventType:     "batches.formed",
+			CorrelationID: data.BatchID + "/" + childID,
+			Topic:         s.outTopic,
+			PartitionKey:  childID,
+			Payload:       payload,
+			OccurredAt:    now,
+		}); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("Batch split", "batch_id", data.BatchID, "trip_id", data.TripID, "mode", data.Mode, "sub_batches", len(groups), "reason", data.Reason)
+	return nil
+}
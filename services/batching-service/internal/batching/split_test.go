@@ -0,0 +1,148 @@
+package batching
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBatchSplit_MovesOrdersToChildBatch(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "batches.formed", 5, time.Hour)
+
+	run := uuid.NewString()[:8]
+	parent, child := "parent-"+run, "child-"+run
+	if _, err := db.Exec(ctx, `
+		INSERT INTO batches (id, origin_id, origin_type, pickup_point_id, origin_lat, origin_lng, destination_lat, destination_lng, is_hub_destination, formed_at)
+		VALUES ($1, 'wh-1', 'warehouse', 'pvp-1', 53.9, 27.56, 53.7, 27.3, false, NOW())
+	`, parent); err != nil {
+		t.Fatal(err)
+	}
+	orders := []string{"o1-" + run, "o2-" + run, "o3-" + run}
+	for _, o := range orders {
+		if _, err := db.Exec(ctx, `INSERT INTO batch_orders (batch_id, order_id, destination_pvp_id) VALUES ($1, $2, 'pvp-1')`, parent, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event, _ := json.Marshal(map[string]interface{}{
+		"event_id":   uuid.NewString(),
+		"event_type": "batches.split",
+		"data": map[string]interface{}{
+			"parent_batch_id": parent,
+			"batch_id":        child,
+			"order_ids":       orders[:2],
+		},
+	})
+	for i := 0; i < 2; i++ {
+		if err := svc.HandleEvent(ctx, "batches.split", nil, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var onChild, onParent int
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM batch_orders WHERE batch_id=$1`, child).Scan(&onChild)
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM batch_orders WHERE batch_id=$1`, parent).Scan(&onParent)
+	if onChild != 2 || onParent != 1 {
+		t.Fatalf("expected 2 orders on child and 1 on parent, got %d and %d", onChild, onParent)
+	}
+	var pvp string
+	if err := db.QueryRow(ctx, `SELECT pickup_point_id FROM batches WHERE id=$1`, child).Scan(&pvp); err != nil {
+		t.Fatal(err)
+	}
+	if pvp != "pvp-1" {
+		t.Fatalf("child batch must keep the parent destination, got %s", pvp)
+	}
+}
+
+func TestPlanSplit_ByDestinationRespectsCapacity(t *testing.T) {
+	orders := []batchOrder{
+		{OrderID: "o1", DestinationID: "pvp-b"},
+		{OrderID: "o2", DestinationID: "pvp-a"},
+		{OrderID: "o3", DestinationID: "pvp-b"},
+		{OrderID: "o4", DestinationID: "pvp-b"},
+	}
+	groups := planSplit(orders, SplitByDestination, 2)
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 sub-batches, got %d: %+v", len(groups), groups)
+	}
+	if groups[0].DestinationID != "pvp-a" || len(groups[0].OrderIDs) != 1 {
+		t.Fatalf("unexpected first group %+v", groups[0])
+	}
+	if groups[1].DestinationID != "pvp-b" || len(groups[1].OrderIDs) != 2 || len(groups[2].OrderIDs) != 1 {
+		t.Fatalf("pvp-b orders must be chunked by capacity, got %+v", groups[1:])
+	}
+}
+
+func TestPlanSplit_ByCapacityIgnoresDestination(t *testing.T) {
+	orders := []batchOrder{
+		{OrderID: "o1", DestinationID: "pvp-a"},
+		{OrderID: "o2", DestinationID: "pvp-b"},
+		{OrderID: "o3", DestinationID: "pvp-c"},
+	}
+	groups := planSplit(orders, SplitByCapacity, 2)
+	if len(groups) != 2 || len(groups[0].OrderIDs) != 2 || len(groups[1].OrderIDs) != 1 {
+		t.Fatalf("unexpected capacity split %+v", groups)
+	}
+}
+
+func TestSplitCommand_FormsSubBatchesWithLineage(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "batches.formed", 5, time.Hour)
+
+	run := uuid.NewString()[:8]
+	parent := "hub-batch-" + run
+	pvpA, pvpB := "pvp-a-"+run, "pvp-b-"+run
+	if _, err := db.Exec(ctx, `
+		INSERT INTO ref_pickup_points (pvp_id, name, latitude, longitude, is_hub) VALUES ($1, 'A', 53.1, 27.1, false), ($2, 'B', 53.2, 27.2, false)
+	`, pvpA, pvpB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `
+		INSERT INTO batches (id, origin_id, origin_type, pickup_point_id, origin_lat, origin_lng, destination_lat, destination_lng, is_hub_destination, formed_at)
+		VALUES ($1, 'wh-1', 'warehouse', 'hub-1', 53.9, 27.56, 53.5, 27.5, true, NOW())
+	`, parent); err != nil {
+		t.Fatal(err)
+	}
+	for i, dest := range []string{pvpA, pvpB, pvpB} {
+		if _, err := db.Exec(ctx, `INSERT INTO batch_orders (batch_id, order_id, destination_pvp_id) VALUES ($1, $2, $3)`,
+			parent, fmt.Sprintf("o%d-%s", i, run), dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event, _ := json.Marshal(map[string]interface{}{
+		"event_id":   uuid.NewString(),
+		"event_type": "commands.batch.split",
+		"data": map[string]interface{}{
+			"batch_id":   parent,
+			"trip_id":    "trip-" + run,
+			"mode":       SplitByDestination,
+			"max_orders": 10,
+		},
+	})
+	for i := 0; i < 2; i++ {
+		if err := svc.HandleEvent(ctx, "commands.batch.split", nil, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var children, lineage, onParent int
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM batches WHERE parent_batch_id=$1`, parent).Scan(&children)
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM batch_orders WHERE split_from_batch_id=$1`, parent).Scan(&lineage)
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM batch_orders WHERE batch_id=$1`, parent).Scan(&onParent)
+	if children != 2 || lineage != 3 || onParent != 0 {
+		t.Fatalf("expected 2 sub-batches holding 3 orders, got %d sub-batches, %d orders, %d left on parent", children, lineage, onParent)
+	}
+	var formed int
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM outbox_events WHERE event_type='batches.formed' AND correlation_id LIKE $1`, parent+"/%").Scan(&formed)
+	if formed != 2 {
+		t.Fatalf("expected batches.formed per sub-batch, got %d", formed)
+	}
+}
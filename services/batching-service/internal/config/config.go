@@ -26,6 +26,14 @@ type Config struct {
 		MaxSize       int
 		FlushInterval time.Duration
 	}
+	Leader struct {
+		RenewInterval time.Duration
+	}
+	Reference struct {
+		URL     string
+		Token   string
+		Timeout time.Duration
+	}
 	OTLP struct {
 		Endpoint string
 	}
@@ -39,11 +47,15 @@ func Load() (*Config, error) {
 	v.SetDefault("kafka.brokers", []string{"redpanda:9092"})
 	v.SetDefault("kafka.timeout", 5*time.Second)
 	v.SetDefault("kafka.groupid", "batching-service")
-	v.SetDefault("kafka.consumetopics", []string{"orders.created", "events.reference_updated", "events.batch_delivered_to_pvp"})
+	v.SetDefault("kafka.consumetopics", []string{"orders.created", "events.reference_updated", "events.batch_delivered_to_pvp", "batches.split", "commands.batch.split", "orders.returns", "events.order_picked_up"})
 	v.SetDefault("kafka.producetopic", "batches.formed")
 	v.SetDefault("kafka.dlqtopic", "dlq.batching")
 	v.SetDefault("batching.maxsize", 10)
 	v.SetDefault("batching.flushinterval", 1*time.Minute)
+	v.SetDefault("leader.renewinterval", 5*time.Second)
+	v.SetDefault("reference.url", "http://localhost:8084")
+	v.SetDefault("reference.token", "")
+	v.SetDefault("reference.timeout", 10*time.Second)
 	v.SetDefault("otlp.endpoint", "")
 
 	v.SetConfigName("config")
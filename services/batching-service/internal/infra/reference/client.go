@@ -0,0 +1,42 @@
+package reference
+
+import (
+	"time"
+
+	"bel-parcel/pkg/refsnapshot"
+	"bel-parcel/pkg/schedule"
+)
+
+// Snapshot is the part of the reference-service snapshot batching keeps.
+type Snapshot struct {
+	Seq          int64         `json:"seq"`
+	PickupPoints []PickupPoint `json:"pickup_points"`
+	Warehouses   []Warehouse   `json:"warehouses"`
+}
+
+type PickupPoint struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	IsHub     bool    `json:"is_hub"`
+	// Capacity in parcels, 0 means no limit.
+	Capacity int `json:"capacity"`
+	schedule.Hours
+	Seq int64 `json:"seq"`
+}
+
+type Warehouse struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Seq       int64   `json:"seq"`
+}
+
+// Client reads the reference snapshot into Snapshot.
+type Client = refsnapshot.Client[Snapshot]
+
+func NewClient(baseURL, token string, timeout time.Duration) *Client {
+	return refsnapshot.NewClient[Snapshot](baseURL, token, timeout)
+}
@@ -0,0 +1,35 @@
+package reference
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/snapshot" || r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"seq":7,"pickup_points":[{"id":"pvp-1","latitude":53.9,"longitude":27.5,"is_hub":true,"capacity":300,"opening_hours":[{"weekday":1,"start":"09:00","end":"21:00"}],"seq":7}],"warehouses":[{"id":"wh-1","seq":3}]}`))
+	}))
+	defer srv.Close()
+
+	snap, err := NewClient(srv.URL+"/", "tok", time.Second).Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if snap.Seq != 7 || len(snap.PickupPoints) != 1 || !snap.PickupPoints[0].IsHub || snap.Warehouses[0].Seq != 3 {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+	if p := snap.PickupPoints[0]; p.Capacity != 300 || len(p.OpeningHours) != 1 || p.OpeningHours[0].End != "21:00" {
+		t.Fatalf("unexpected pickup point hours %+v", p)
+	}
+
+	if _, err := NewClient(srv.URL, "bad", time.Second).Snapshot(context.Background()); err == nil {
+		t.Fatalf("expected error for rejected token")
+	}
+}
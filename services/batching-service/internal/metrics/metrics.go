@@ -40,7 +40,7 @@ var (
 	BatchActiveGroups = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "batch_active_groups",
-			Help: "Number of active warehouse+pvp groups in memory",
+			Help: "Number of open warehouse+pvp groups in batch_group_items",
 		},
 	)
 	BatchOrdersAdded = prometheus.NewCounterVec(
@@ -50,13 +50,35 @@ var (
 		},
 		[]string{"warehouse_id"},
 	)
+	BatchGroupsHeld = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "batch_groups_held_total",
+			Help: "Count of flush attempts deferred because the PVP is closed or full, labeled by reason",
+		},
+		[]string{"reason"},
+	)
+	batchLeaderIsLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "batch_leader_is_leader",
+			Help: "Whether this batching-service instance leads a singleton job (1) or not (0)",
+		},
+		[]string{"job"},
+	)
 )
 
 func Init(mux *http.ServeMux) {
-	prometheus.MustRegister(batchErrors, batchDLQSize, batchOutboxQueueSize, BatchFlushDuration, BatchActiveGroups, BatchOrdersAdded)
+	prometheus.MustRegister(batchErrors, batchDLQSize, batchOutboxQueueSize, BatchFlushDuration, BatchActiveGroups, BatchOrdersAdded, BatchGroupsHeld, batchLeaderIsLeader)
 	mux.Handle("/metrics", promhttp.Handler())
 }
 
+func SetLeader(job string, leader bool) {
+	v := 0.0
+	if leader {
+		v = 1
+	}
+	batchLeaderIsLeader.WithLabelValues(job).Set(v)
+}
+
 func IncBatchError(topic string) {
 	batchErrors.WithLabelValues(topic).Inc()
 }
@@ -93,4 +115,22 @@ func StartDLQGauge(ctx context.Context, db *pgxpool.Pool) {
 			}
 		}
 	}()
+	go func() {
+		t := time.NewTicker(10 * time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				var cnt int
+				_ = db.QueryRow(context.Background(), `
+					SELECT COUNT(*) FROM (
+						SELECT 1 FROM batch_group_items GROUP BY warehouse_id, pvp_id
+					) g
+				`).Scan(&cnt)
+				BatchActiveGroups.Set(float64(cnt))
+			}
+		}
+	}()
 }
@@ -0,0 +1,4 @@
+-- Rollback for 003_batch_split.up.sql
+
+ALTER TABLE batch_orders DROP COLUMN IF EXISTS split_from_batch_id;
+ALTER TABLE batches DROP COLUMN IF EXISTS parent_batch_id;
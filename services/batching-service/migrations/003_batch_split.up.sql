@@ -0,0 +1,3 @@
+-- Происхождение партий, разделённых после срыва рейса
+ALTER TABLE batches ADD COLUMN IF NOT EXISTS parent_batch_id TEXT;
+ALTER TABLE batch_orders ADD COLUMN IF NOT EXISTS split_from_batch_id TEXT;
@@ -0,0 +1,4 @@
+-- Rollback for 004_returns.up.sql
+
+DROP TABLE IF EXISTS return_group_items;
+ALTER TABLE batches DROP COLUMN IF EXISTS destination_type;
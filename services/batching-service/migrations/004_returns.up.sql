@@ -0,0 +1,12 @@
+-- Тип назначения партии (ПВЗ или склад продавца для возвратов)
+ALTER TABLE batches ADD COLUMN IF NOT EXISTS destination_type TEXT DEFAULT 'pvp';
+
+-- Невостребованные заказы, ожидающие возвратной партии
+CREATE TABLE IF NOT EXISTS return_group_items (
+    pvp_id TEXT NOT NULL,
+    warehouse_id TEXT NOT NULL,
+    order_id TEXT NOT NULL,
+    return_id TEXT,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (pvp_id, warehouse_id, order_id)
+);
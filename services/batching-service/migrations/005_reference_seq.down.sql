@@ -0,0 +1,4 @@
+-- Rollback for 005_reference_seq.up.sql
+
+ALTER TABLE ref_pickup_points DROP COLUMN IF EXISTS source_seq;
+ALTER TABLE ref_warehouses DROP COLUMN IF EXISTS source_seq;
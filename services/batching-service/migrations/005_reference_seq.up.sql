@@ -0,0 +1,3 @@
+-- Номер изменения записи справочника, из которой построена строка проекции
+ALTER TABLE ref_warehouses ADD COLUMN IF NOT EXISTS source_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ref_pickup_points ADD COLUMN IF NOT EXISTS source_seq BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Rollback for 006_pvp_capacity.up.sql
+
+DROP TABLE IF EXISTS pvp_parcels;
+ALTER TABLE ref_pickup_points DROP COLUMN IF EXISTS hours;
+ALTER TABLE ref_pickup_points DROP COLUMN IF EXISTS capacity;
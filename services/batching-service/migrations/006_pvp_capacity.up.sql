@@ -0,0 +1,11 @@
+-- Вместимость ПВЗ (0 — без ограничения) и часы работы из справочника
+ALTER TABLE ref_pickup_points ADD COLUMN IF NOT EXISTS capacity INT NOT NULL DEFAULT 0;
+ALTER TABLE ref_pickup_points ADD COLUMN IF NOT EXISTS hours JSONB NOT NULL DEFAULT '{}';
+
+-- Посылки, которые находятся в ПВЗ или уже отправлены в него партией
+CREATE TABLE IF NOT EXISTS pvp_parcels (
+    order_id TEXT PRIMARY KEY,
+    pvp_id TEXT NOT NULL,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_pvp_parcels_pvp ON pvp_parcels(pvp_id);
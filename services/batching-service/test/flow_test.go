@@ -388,6 +388,7 @@ func initSchema(t *testing.T, db *sql.DB) {
 			order_id TEXT NOT NULL,
 			customer_phone TEXT,
 			customer_email TEXT,
+			customer_locale TEXT,
 			updated_at TIMESTAMPTZ NOT NULL,
 			PRIMARY KEY (warehouse_id, pvp_id, order_id)
 		)`,
@@ -15,13 +15,18 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"bel-parcel/pkg/blobstore"
 	"bel-parcel/services/mobile-gateway/internal/auth"
 	"bel-parcel/services/mobile-gateway/internal/config"
+	"bel-parcel/services/mobile-gateway/internal/handover"
 	httpserver "bel-parcel/services/mobile-gateway/internal/http"
 	"bel-parcel/services/mobile-gateway/internal/infra/db"
 	"bel-parcel/services/mobile-gateway/internal/infra/kafka"
 	"bel-parcel/services/mobile-gateway/internal/metrics"
+	"bel-parcel/services/mobile-gateway/internal/offers"
 	"bel-parcel/services/mobile-gateway/internal/outbox"
+	"bel-parcel/services/mobile-gateway/internal/pickups"
+	"bel-parcel/services/mobile-gateway/internal/trips"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -43,6 +48,14 @@ func main() {
 		slog.Error("empty AUTH_ISSUER or AUTH_AUDIENCE, refusing to start")
 		os.Exit(1)
 	}
+	if cfg.Handover.CodeSecret == "" {
+		slog.Error("empty HANDOVER_CODESECRET, refusing to start")
+		os.Exit(1)
+	}
+	if cfg.Pickup.CodeSecret == "" {
+		slog.Error("empty PICKUP_CODESECRET, refusing to start")
+		os.Exit(1)
+	}
 	setupLogger()
 	shutdown := setupOTLP(cfg)
 	defer shutdown()
@@ -74,6 +87,22 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if err := offers.EnsureSchema(pool); err != nil {
+		slog.Error("failed to ensure carrier_offers schema", "error", err)
+		os.Exit(1)
+	}
+	if err := pickups.EnsureSchema(pool); err != nil {
+		slog.Error("failed to ensure pickup_codes schema", "error", err)
+		os.Exit(1)
+	}
+	if err := trips.EnsureSchema(pool); err != nil {
+		slog.Error("failed to ensure carrier_trips schema", "error", err)
+		os.Exit(1)
+	}
+	if err := handover.EnsureSchema(pool); err != nil {
+		slog.Error("failed to ensure handover_codes schema", "error", err)
+		os.Exit(1)
+	}
 	producer, err := kafka.NewProducer(cfg.Kafka.Brokers, cfg.Kafka.Timeout)
 	if err != nil {
 		slog.Error("failed to create Kafka producer", "error", err)
@@ -82,22 +111,55 @@ func main() {
 	defer producer.Close()
 	validator := auth.NewValidator(cfg.Auth.HS256Secret, cfg.Auth.Issuer, cfg.Auth.Audience)
 	topics := map[string]string{
-		"batch_picked_up":               cfg.Kafka.TopicPickedUp,
-		"events.batch_picked_up":        cfg.Kafka.TopicPickedUp,
-		"batch_delivered_to_pvp":        cfg.Kafka.TopicDeliveredToPVP,
-		"events.batch_delivered_to_pvp": cfg.Kafka.TopicDeliveredToPVP,
-		"batch_received_by_pvp":         cfg.Kafka.TopicReceivedByPVP,
-		"events.batch_received_by_pvp":  cfg.Kafka.TopicReceivedByPVP,
-		"carrier.location":              cfg.Kafka.TopicCarrierLocation,
-		"events.carrier_location":       cfg.Kafka.TopicCarrierLocation,
-	}
-	s := httpserver.NewServer(pool, validator, topics)
+		"batch_picked_up":                  cfg.Kafka.TopicPickedUp,
+		"events.batch_picked_up":           cfg.Kafka.TopicPickedUp,
+		"batch_delivered_to_pvp":           cfg.Kafka.TopicDeliveredToPVP,
+		"events.batch_delivered_to_pvp":    cfg.Kafka.TopicDeliveredToPVP,
+		"batch_received_by_pvp":            cfg.Kafka.TopicReceivedByPVP,
+		"events.batch_received_by_pvp":     cfg.Kafka.TopicReceivedByPVP,
+		"carrier.location":                 cfg.Kafka.TopicCarrierLocation,
+		"events.carrier_location":          cfg.Kafka.TopicCarrierLocation,
+		"events.trip_offer_accepted":       cfg.Kafka.TopicOfferAccepted,
+		"events.trip_incident_reported":    cfg.Kafka.TopicIncidents,
+		"commands.transshipment":           cfg.Kafka.TopicTransshipments,
+		"events.delivery_proof_recorded":   cfg.Kafka.TopicDeliveryProofs,
+		"events.order_picked_up":           cfg.Kafka.TopicOrderPickedUp,
+		"events.customer_return_requested": cfg.Kafka.TopicCustomerReturns,
+	}
+	blobs, err := blobstore.New(blobstore.Config{
+		Driver:    cfg.Blob.Driver,
+		Dir:       cfg.Blob.Dir,
+		Endpoint:  cfg.Blob.Endpoint,
+		Region:    cfg.Blob.Region,
+		Bucket:    cfg.Blob.Bucket,
+		AccessKey: cfg.Blob.AccessKey,
+		SecretKey: cfg.Blob.SecretKey,
+	})
+	if err != nil {
+		slog.Error("failed to create blob store", "error", err)
+		os.Exit(1)
+	}
+	s := httpserver.NewServer(pool, validator, topics).WithBlobStore(blobs).WithHandoverSecret(cfg.Handover.CodeSecret).WithLegacyDeliveryEvents(cfg.Handover.LegacyEvents).WithPickupCodeSecret(cfg.Pickup.CodeSecret)
 	mux := s.Routes()
 	metrics.Register()
 	mux.Handle("GET /metrics", promhttp.Handler())
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go outbox.StartPublisher(ctx, pool, producer)
+	consumer := kafka.NewConsumer(cfg.Kafka.Brokers, cfg.Kafka.GroupID, []string{cfg.Kafka.TopicOffers, cfg.Kafka.TopicPickupCodes, cfg.Kafka.TopicTripsAssigned}, cfg.Kafka.Timeout)
+	defer consumer.Close()
+	projector := offers.NewProjector(pool)
+	pickupProjector := pickups.NewProjector(pool)
+	tripProjector := trips.NewProjector(pool)
+	consumer.Start(ctx, func(topic string, key, value []byte) error {
+		switch topic {
+		case cfg.Kafka.TopicPickupCodes:
+			return pickupProjector.HandleEvent(ctx, topic, key, value)
+		case cfg.Kafka.TopicTripsAssigned:
+			return tripProjector.HandleEvent(ctx, topic, key, value)
+		}
+		return projector.HandleEvent(ctx, topic, key, value)
+	})
 	srv := &http.Server{
 		Addr:         ":" + cfg.Server.Port,
 		Handler:      mux,
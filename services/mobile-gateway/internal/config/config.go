@@ -21,6 +21,25 @@ type Config struct {
 		TopicDeliveredToPVP string
 		TopicReceivedByPVP  string
 		TopicCarrierLocation string
+		TopicOfferAccepted   string
+		TopicOffers          string
+		TopicIncidents       string
+		TopicTransshipments  string
+		TopicDeliveryProofs  string
+		TopicPickupCodes     string
+		TopicOrderPickedUp   string
+		TopicCustomerReturns string
+		TopicTripsAssigned   string
+		GroupID              string
+	}
+	Blob struct {
+		Driver    string
+		Dir       string
+		Endpoint  string
+		Region    string
+		Bucket    string
+		AccessKey string
+		SecretKey string
 	}
 	OTLP struct {
 		Endpoint string
@@ -30,6 +49,15 @@ type Config struct {
 		Issuer      string
 		Audience    string
 	}
+	Handover struct {
+		CodeSecret string
+		// LegacyEvents keeps the deprecated delivered_to_pvp path of
+		// POST /events open while older apps are still in use.
+		LegacyEvents bool
+	}
+	Pickup struct {
+		CodeSecret string
+	}
 }
 
 func Load() (*Config, error) {
@@ -42,10 +70,30 @@ func Load() (*Config, error) {
 	v.SetDefault("kafka.topicdeliveredtopvp", "events.batch_delivered_to_pvp")
 	v.SetDefault("kafka.topicreceivedbypvp", "events.batch_received_by_pvp")
 	v.SetDefault("kafka.topiccarrierlocation", "events.carrier_location")
+	v.SetDefault("kafka.topicofferaccepted", "events.trip_offer_accepted")
+	v.SetDefault("kafka.topicoffers", "trips.offers")
+	v.SetDefault("kafka.topicincidents", "events.trip_incident_reported")
+	v.SetDefault("kafka.topictransshipments", "commands.transshipment")
+	v.SetDefault("kafka.topicdeliveryproofs", "events.delivery_proof_recorded")
+	v.SetDefault("kafka.topicpickupcodes", "orders.pickup_codes")
+	v.SetDefault("kafka.topicorderpickedup", "events.order_picked_up")
+	v.SetDefault("kafka.topiccustomerreturns", "events.customer_return_requested")
+	v.SetDefault("kafka.topictripsassigned", "trips.assigned")
+	v.SetDefault("kafka.groupid", "mobile-gateway")
+	v.SetDefault("blob.driver", "fs")
+	v.SetDefault("blob.dir", "./data/blobs")
+	v.SetDefault("blob.endpoint", "")
+	v.SetDefault("blob.region", "")
+	v.SetDefault("blob.bucket", "")
+	v.SetDefault("blob.accesskey", "")
+	v.SetDefault("blob.secretkey", "")
 	v.SetDefault("otlp.endpoint", "")
 	v.SetDefault("auth.hs256secret", "")
 	v.SetDefault("auth.issuer", "")
 	v.SetDefault("auth.audience", "")
+	v.SetDefault("handover.codesecret", "")
+	v.SetDefault("handover.legacyevents", true)
+	v.SetDefault("pickup.codesecret", "")
 	v.SetConfigName("config")
 	v.SetConfigType("yaml")
 	v.AddConfigPath(".")
@@ -0,0 +1,53 @@
+// Package handover issues the confirmation codes a PVP worker reads out to
+// the carrier when a batch is handed over. Only a codehash of each code,
+// bound to its batch and keyed with a secret from config, is stored.
+package handover
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// A code is valid for CodeTTL and stops working after MaxFailedAttempts
+// wrong entries; the PVP worker then issues a new one.
+const (
+	CodeTTL           = 30 * time.Minute
+	MaxFailedAttempts = 5
+)
+
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func EnsureSchema(db Execer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS handover_codes (
+			batch_id TEXT PRIMARY KEY,
+			pvp_id TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			issued_by TEXT NOT NULL,
+			issued_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			failed_attempts INT NOT NULL DEFAULT 0,
+			used_at TIMESTAMPTZ,
+			used_by TEXT
+		)
+	`)
+	return err
+}
+
+// NewCode returns a random six-digit code.
+func NewCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,20 @@
+package handover
+
+import "testing"
+
+func TestNewCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := NewCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 6 {
+			t.Fatalf("expected six digits, got %q", code)
+		}
+		for _, c := range code {
+			if c < '0' || c > '9' {
+				t.Fatalf("expected digits only, got %q", code)
+			}
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bel-parcel/pkg/blobstore"
+	"bel-parcel/pkg/codehash"
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/handover"
+	"bel-parcel/services/mobile-gateway/internal/metrics"
+	"bel-parcel/services/mobile-gateway/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	deliveryProofEvent = "events.delivery_proof_recorded"
+	maxProofUpload     = 10 << 20
+)
+
+var (
+	errBadImage            = errors.New("file must be a PNG or JPEG image")
+	errMissingSignature    = errors.New("signature is required")
+	errNoHandoverCode      = errors.New("no handover code issued for this batch at this pvp")
+	errHandoverCodeUsed    = errors.New("batch already delivered")
+	errHandoverCodeExpired = errors.New("handover code expired, ask the pvp for a new one")
+	errHandoverCodeLocked  = errors.New("too many failed attempts, ask the pvp for a new code")
+	errWrongHandoverCode   = errors.New("invalid confirmation code")
+)
+
+func (s *Server) WithBlobStore(store blobstore.Store) *Server {
+	s.blobs = store
+	return s
+}
+
+// WithHandoverSecret sets the key handover codes are hashed with. Without it
+// deliveries are refused.
+func (s *Server) WithHandoverSecret(secret string) *Server {
+	s.handoverSecret = []byte(secret)
+	return s
+}
+
+// WithLegacyDeliveryEvents keeps accepting batch_delivered_to_pvp on
+// POST /events, without a confirmation code, for apps that predate
+// POST /deliveries. Once disabled the old path answers 410 Gone.
+func (s *Server) WithLegacyDeliveryEvents(enabled bool) *Server {
+	s.retireLegacyDeliveries = !enabled
+	return s
+}
+
+type proofFile struct {
+	key         string
+	contentType string
+}
+
+// handleIssueHandoverCode lets the PVP worker receiving a batch issue the
+// confirmation code they read out to the carrier. Issuing again replaces an
+// unused code and clears its failed attempts; a spent one stays spent.
+func (s *Server) handleIssueHandoverCode(w http.ResponseWriter, r *http.Request) {
+	const path = "/deliveries/codes"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	if len(s.handoverSecret) == 0 {
+		http.Error(w, "handover codes not configured", http.StatusServiceUnavailable)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "503").Inc()
+		return
+	}
+	var body struct {
+		BatchID string `json:"batch_id"`
+		PVPID   string `json:"pvp_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.BatchID == "" || body.PVPID == "" {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	code, err := handover.NewCode()
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(handover.CodeTTL)
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(), `
+		INSERT INTO handover_codes (batch_id, pvp_id, code_hash, issued_by, issued_at, expires_at, failed_attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, 0)
+		ON CONFLICT (batch_id) DO UPDATE SET
+			pvp_id = EXCLUDED.pvp_id,
+			code_hash = EXCLUDED.code_hash,
+			issued_by = EXCLUDED.issued_by,
+			issued_at = EXCLUDED.issued_at,
+			expires_at = EXCLUDED.expires_at,
+			failed_attempts = 0
+		WHERE handover_codes.used_at IS NULL
+	`, body.BatchID, body.PVPID, codehash.Hash(s.handoverSecret, body.BatchID, code), user.ID, now, expiresAt)
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "batch already delivered", http.StatusConflict)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "409").Inc()
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "expires_at": expiresAt})
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "201").Inc()
+}
+
+// handleDeliverToPVP records a PVP handover together with its evidence: the
+// confirmation code the PVP worker issued for the batch, a signature image
+// and an optional photo. The files go to the blob store first; the code is
+// then checked and spent, and the delivery claim and the proof record are
+// queued, in one transaction. Files of a handover that is not recorded are
+// deleted again.
+func (s *Server) handleDeliverToPVP(w http.ResponseWriter, r *http.Request) {
+	const path = "/deliveries"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	if s.blobs == nil {
+		http.Error(w, "proof storage not configured", http.StatusServiceUnavailable)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "503").Inc()
+		return
+	}
+	if len(s.handoverSecret) == 0 {
+		http.Error(w, "handover codes not configured", http.StatusServiceUnavailable)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "503").Inc()
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxProofUpload)
+	if err := r.ParseMultipartForm(maxProofUpload); err != nil {
+		http.Error(w, "invalid multipart form", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	tripID := r.FormValue("trip_id")
+	batchID := r.FormValue("batch_id")
+	pvpID := r.FormValue("pvp_id")
+	code := r.FormValue("confirmation_code")
+	isHub, _ := strconv.ParseBool(r.FormValue("is_hub"))
+	if tripID == "" || batchID == "" || pvpID == "" {
+		http.Error(w, "missing fields", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	if !validConfirmationCode(code) {
+		http.Error(w, "confirmation_code must be 4 to 8 digits", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	topic, ok := s.resolveTopic("events.batch_delivered_to_pvp")
+	proofTopic, proofOK := s.resolveTopic(deliveryProofEvent)
+	if !ok || !proofOK {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+
+	proofID := uuid.NewString()
+	prefix := "proofs/" + tripID + "/" + batchID + "/" + proofID + "/"
+	var uploaded []string
+	recorded := false
+	defer func() {
+		if !recorded {
+			s.deleteProofFiles(context.WithoutCancel(r.Context()), uploaded)
+		}
+	}()
+	signature, err := s.storeProofFile(r.Context(), r, "signature", prefix+"signature")
+	if signature != nil {
+		uploaded = append(uploaded, signature.key)
+	}
+	if err == nil && signature == nil {
+		err = errMissingSignature
+	}
+	var photo *proofFile
+	if err == nil {
+		photo, err = s.storeProofFile(r.Context(), r, "photo", prefix+"photo")
+		if photo != nil {
+			uploaded = append(uploaded, photo.key)
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, errBadImage) && !errors.Is(err, errMissingSignature) {
+			http.Error(w, "internal", http.StatusInternalServerError)
+			metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+
+	now := time.Now().UTC()
+	delivered := map[string]interface{}{
+		"trip_id":      tripID,
+		"batch_id":     batchID,
+		"carrier_id":   user.ID,
+		"pvp_id":       pvpID,
+		"is_hub":       isHub,
+		"delivered_at": now,
+		"proof_id":     proofID,
+	}
+	proof := map[string]interface{}{
+		"proof_id":               proofID,
+		"trip_id":                tripID,
+		"batch_id":               batchID,
+		"pvp_id":                 pvpID,
+		"carrier_id":             user.ID,
+		"signature_key":          signature.key,
+		"signature_content_type": signature.contentType,
+		"delivered_at":           now,
+	}
+	if photo != nil {
+		proof["photo_key"] = photo.key
+		proof["photo_content_type"] = photo.contentType
+	}
+	err = s.recordDelivery(r.Context(), now, user.ID, pvpID, code, topic, proofTopic, proofID, batchID, delivered, proof)
+	switch {
+	case err == nil:
+	case errors.Is(err, errHandoverCodeUsed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "409").Inc()
+		return
+	case errors.Is(err, errHandoverCodeLocked):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "429").Inc()
+		return
+	case errors.Is(err, errNoHandoverCode), errors.Is(err, errHandoverCodeExpired), errors.Is(err, errWrongHandoverCode):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "403").Inc()
+		return
+	default:
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	recorded = true
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"proof_id": proofID})
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "202").Inc()
+}
+
+// storeProofFile uploads one form file. It returns nil when the field is
+// absent; anything that is not a PNG or JPEG is refused by its content.
+func (s *Server) storeProofFile(ctx context.Context, r *http.Request, field, key string) (*proofFile, error) {
+	f, _, err := r.FormFile(field)
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	contentType := http.DetectContentType(data)
+	if contentType != "image/png" && contentType != "image/jpeg" {
+		return nil, errBadImage
+	}
+	if err := s.blobs.Put(ctx, key, contentType, data); err != nil {
+		return nil, err
+	}
+	return &proofFile{key: key, contentType: contentType}, nil
+}
+
+// deleteProofFiles removes the uploads of a handover that was not recorded.
+// A file left behind is only logged; nothing refers to it.
+func (s *Server) deleteProofFiles(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			slog.Warn("failed to delete unrecorded proof file", "key", key, "error", err)
+		}
+	}
+}
+
+// recordDelivery spends the batch's handover code and queues the delivery
+// and proof events. The proof names the PVP worker who issued the code, never
+// the code itself. A wrong code is counted and committed on its own, so the
+// attempt sticks even though nothing is queued.
+func (s *Server) recordDelivery(ctx context.Context, now time.Time, carrierID, pvpID, code, topic, proofTopic, proofID, batchID string, delivered, proof map[string]interface{}) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	var codePVP, codeHash, issuedBy string
+	var expiresAt time.Time
+	var failed int
+	var usedAt *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT pvp_id, code_hash, issued_by, expires_at, failed_attempts, used_at
+		FROM handover_codes WHERE batch_id=$1 FOR UPDATE
+	`, batchID).Scan(&codePVP, &codeHash, &issuedBy, &expiresAt, &failed, &usedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errNoHandoverCode
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case usedAt != nil:
+		return errHandoverCodeUsed
+	case codePVP != pvpID:
+		return errNoHandoverCode
+	case failed >= handover.MaxFailedAttempts:
+		return errHandoverCodeLocked
+	case !expiresAt.After(now):
+		return errHandoverCodeExpired
+	}
+	if !codehash.Matches(s.handoverSecret, batchID, code, codeHash) {
+		if _, err := tx.Exec(ctx, `
+			UPDATE handover_codes SET failed_attempts = failed_attempts + 1 WHERE batch_id=$1
+		`, batchID); err != nil {
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		if failed+1 >= handover.MaxFailedAttempts {
+			return errHandoverCodeLocked
+		}
+		return errWrongHandoverCode
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE handover_codes SET used_at=$2, used_by=$3 WHERE batch_id=$1
+	`, batchID, now, carrierID); err != nil {
+		return err
+	}
+	proof["confirmed_by"] = issuedBy
+	for _, e := range []struct {
+		eventType string
+		topic     string
+		key       string
+		data      map[string]interface{}
+	}{
+		{"events.batch_delivered_to_pvp", topic, proofID, delivered},
+		{deliveryProofEvent, proofTopic, batchID, proof},
+	} {
+		eventID := uuid.NewString()
+		raw, err := json.Marshal(e.data)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(map[string]interface{}{
+			"event_id":       eventID,
+			"event_type":     e.eventType,
+			"occurred_at":    now,
+			"correlation_id": proofID,
+			"data":           json.RawMessage(raw),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO http_events_log(id, event_type, event_id, payload, received_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, uuid.NewString(), e.eventType, eventID, raw, now); err != nil {
+			return err
+		}
+		if err := outbox.EnqueueTx(ctx, tx, outbox.Event{
+			ID:            uuid.NewString(),
+			EventType:     e.eventType,
+			EventID:       eventID,
+			CorrelationID: proofID,
+			Topic:         e.topic,
+			PartitionKey:  e.key,
+			Payload:       payload,
+			OccurredAt:    now,
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func validConfirmationCode(code string) bool {
+	if len(code) < 4 || len(code) > 8 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/metrics"
+	"bel-parcel/services/mobile-gateway/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const incidentReportedEvent = "events.trip_incident_reported"
+
+var incidentTypes = map[string]bool{"breakdown": true, "accident": true, "delay": true}
+
+// handleReportIncident forwards a carrier's breakdown, accident or delay
+// report to routing-service, which decides whether the trip needs a
+// replacement carrier.
+func (s *Server) handleReportIncident(w http.ResponseWriter, r *http.Request) {
+	const path = "/incidents"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	var body struct {
+		TripID      string  `json:"trip_id"`
+		Type        string  `json:"type"`
+		Latitude    float64 `json:"latitude"`
+		Longitude   float64 `json:"longitude"`
+		Description string  `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.TripID == "" {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	if !incidentTypes[body.Type] {
+		http.Error(w, "type must be breakdown, accident or delay", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	if body.Latitude < -90 || body.Latitude > 90 || body.Longitude < -180 || body.Longitude > 180 {
+		http.Error(w, "invalid coordinates", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	topic, ok := s.resolveTopic(incidentReportedEvent)
+	if !ok {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	now := time.Now().UTC()
+	incidentID := uuid.NewString()
+	eventID := uuid.NewString()
+	raw, err := json.Marshal(map[string]interface{}{
+		"incident_id": incidentID,
+		"trip_id":     body.TripID,
+		"carrier_id":  user.ID,
+		"type":        body.Type,
+		"latitude":    body.Latitude,
+		"longitude":   body.Longitude,
+		"description": body.Description,
+		"reported_at": now,
+	})
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	// Only the carrier driving the trip may report on it. A trip whose
+	// assignment has not reached the gateway yet is left to routing-service,
+	// which checks the same thing.
+	var tripCarrier string
+	if err := tx.QueryRow(r.Context(), `SELECT carrier_id FROM carrier_trips WHERE trip_id=$1`, body.TripID).Scan(&tripCarrier); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if tripCarrier != "" && tripCarrier != user.ID {
+		http.Error(w, "trip is assigned to another carrier", http.StatusForbidden)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "403").Inc()
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+		INSERT INTO http_events_log(id, event_type, event_id, payload, received_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.NewString(), incidentReportedEvent, eventID, raw, now); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event_id":       eventID,
+		"event_type":     incidentReportedEvent,
+		"occurred_at":    now,
+		"correlation_id": incidentID,
+		"data":           json.RawMessage(raw),
+	})
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if err := outbox.EnqueueTx(r.Context(), tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     incidentReportedEvent,
+		EventID:       eventID,
+		CorrelationID: incidentID,
+		Topic:         topic,
+		PartitionKey:  body.TripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	}); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"incident_id": incidentID})
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "202").Inc()
+}
@@ -0,0 +1,181 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/metrics"
+	"bel-parcel/services/mobile-gateway/internal/offers"
+	"bel-parcel/services/mobile-gateway/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const offerAcceptedEvent = "events.trip_offer_accepted"
+
+type offerView struct {
+	OfferID        string          `json:"offer_id"`
+	TripID         string          `json:"trip_id"`
+	BatchID        string          `json:"batch_id"`
+	Rank           int             `json:"rank"`
+	DistanceMeters int             `json:"distance_meters"`
+	Status         string          `json:"status"`
+	Details        json.RawMessage `json:"details"`
+	OfferedAt      time.Time       `json:"offered_at"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+}
+
+func (s *Server) handleListOffers(w http.ResponseWriter, r *http.Request) {
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues("/offers", "401").Inc()
+		return
+	}
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues("/offers", "500").Inc()
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	rows, err := tx.Query(r.Context(), `
+		SELECT offer_id, trip_id, batch_id, rank, distance_meters, status, payload, offered_at, expires_at
+		FROM carrier_offers
+		WHERE carrier_id=$1 AND status IN ('pending', 'accept_requested') AND expires_at > NOW()
+		ORDER BY offered_at DESC
+	`, user.ID)
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues("/offers", "500").Inc()
+		return
+	}
+	list := []offerView{}
+	for rows.Next() {
+		var o offerView
+		if err := rows.Scan(&o.OfferID, &o.TripID, &o.BatchID, &o.Rank, &o.DistanceMeters, &o.Status, &o.Details, &o.OfferedAt, &o.ExpiresAt); err != nil {
+			rows.Close()
+			http.Error(w, "internal", http.StatusInternalServerError)
+			metrics.HTTPRequestsTotal.WithLabelValues("/offers", "500").Inc()
+			return
+		}
+		list = append(list, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues("/offers", "500").Inc()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"offers": list})
+	metrics.HTTPRequestsTotal.WithLabelValues("/offers", "200").Inc()
+}
+
+// handleAcceptOffer flips the local offer to accept_requested and forwards the
+// acceptance to routing-service, which makes the final decision. The local
+// compare-and-set turns repeated taps into a single event.
+func (s *Server) handleAcceptOffer(w http.ResponseWriter, r *http.Request) {
+	const path = "/offers/accept"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	offerID := r.PathValue("id")
+	if offerID == "" {
+		http.Error(w, "missing offer id", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	topic, ok := s.resolveTopic(offerAcceptedEvent)
+	if !ok {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	now := time.Now().UTC()
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	var tripID string
+	if err := tx.QueryRow(r.Context(), `
+		UPDATE carrier_offers SET status=$3, updated_at=$4
+		WHERE offer_id=$1 AND carrier_id=$2 AND status=$5 AND expires_at > $4
+		RETURNING trip_id
+	`, offerID, user.ID, offers.StatusAcceptRequested, now, offers.StatusPending).Scan(&tripID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "offer is not available", http.StatusConflict)
+			metrics.HTTPRequestsTotal.WithLabelValues(path, "409").Inc()
+			return
+		}
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	eventID := uuid.NewString()
+	data := map[string]interface{}{
+		"offer_id":    offerID,
+		"trip_id":     tripID,
+		"carrier_id":  user.ID,
+		"accepted_at": now,
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+		INSERT INTO http_events_log(id, event_type, event_id, payload, received_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.NewString(), offerAcceptedEvent, eventID, raw, now); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event_id":       eventID,
+		"event_type":     offerAcceptedEvent,
+		"occurred_at":    now,
+		"correlation_id": offerID,
+		"data":           json.RawMessage(raw),
+	})
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if err := outbox.EnqueueTx(r.Context(), tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     offerAcceptedEvent,
+		EventID:       eventID,
+		CorrelationID: offerID,
+		Topic:         topic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	}); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("accepted"))
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "202").Inc()
+}
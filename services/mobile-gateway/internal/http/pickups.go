@@ -0,0 +1,199 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bel-parcel/pkg/codehash"
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/metrics"
+	"bel-parcel/services/mobile-gateway/internal/outbox"
+	"bel-parcel/services/mobile-gateway/internal/pickups"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const orderPickedUpEvent = "events.order_picked_up"
+
+// WithPickupCodeSecret sets the key pickup codes are hashed with; it is shared
+// with order-service, which issues them. Without it pickups are refused.
+func (s *Server) WithPickupCodeSecret(secret string) *Server {
+	s.pickupSecret = []byte(secret)
+	return s
+}
+
+// handleCustomerPickup checks the code the customer shows at the PVP against
+// the projection of issued codes. Wrong codes count towards a lock that grows
+// with every further miss; the right one is spent and order-service moves the
+// order to DELIVERED_TO_CUSTOMER.
+func (s *Server) handleCustomerPickup(w http.ResponseWriter, r *http.Request) {
+	const path = "/pickups"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	if len(s.pickupSecret) == 0 {
+		http.Error(w, "pickup codes not configured", http.StatusServiceUnavailable)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "503").Inc()
+		return
+	}
+	var body struct {
+		OrderID string `json:"order_id"`
+		PVPID   string `json:"pvp_id"`
+		Code    string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.OrderID == "" || body.PVPID == "" || body.Code == "" {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	topic, ok := s.resolveTopic(orderPickedUpEvent)
+	if !ok {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	now := time.Now().UTC()
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	var pvpID, codeHash, status string
+	var failed int
+	var lockedUntil *time.Time
+	err = tx.QueryRow(r.Context(), `
+		SELECT pvp_id, code_hash, status, failed_attempts, locked_until
+		FROM pickup_codes WHERE order_id=$1 FOR UPDATE
+	`, body.OrderID).Scan(&pvpID, &codeHash, &status, &failed, &lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "no pickup code for this order", http.StatusNotFound)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "404").Inc()
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if status == pickups.StatusUsed {
+		http.Error(w, "order already picked up", http.StatusConflict)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "409").Inc()
+		return
+	}
+	if status == pickups.StatusRevoked {
+		http.Error(w, "storage period expired, order is being returned", http.StatusGone)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "410").Inc()
+		return
+	}
+	if pvpID != "" && pvpID != body.PVPID {
+		http.Error(w, "order is held at another pvp", http.StatusConflict)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "409").Inc()
+		return
+	}
+	if lockedUntil != nil && lockedUntil.After(now) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(lockedUntil.Sub(now).Seconds())+1))
+		http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "429").Inc()
+		return
+	}
+	if !codehash.Matches(s.pickupSecret, body.OrderID, body.Code, codeHash) {
+		failed++
+		var lock *time.Time
+		lockFor := pickups.LockFor(failed)
+		if lockFor > 0 {
+			until := now.Add(lockFor)
+			lock = &until
+		}
+		if _, err := tx.Exec(r.Context(), `
+			UPDATE pickup_codes SET failed_attempts=$2, locked_until=$3, updated_at=$4 WHERE order_id=$1
+		`, body.OrderID, failed, lock, now); err != nil {
+			http.Error(w, "internal", http.StatusInternalServerError)
+			metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+			return
+		}
+		if err := tx.Commit(r.Context()); err != nil {
+			http.Error(w, "internal", http.StatusInternalServerError)
+			metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+			return
+		}
+		if lock != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(lockFor.Seconds())))
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			metrics.HTTPRequestsTotal.WithLabelValues(path, "429").Inc()
+			return
+		}
+		http.Error(w, "invalid code", http.StatusForbidden)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "403").Inc()
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+		UPDATE pickup_codes SET status=$2, used_at=$3, used_by=$4, failed_attempts=0, updated_at=$3 WHERE order_id=$1
+	`, body.OrderID, pickups.StatusUsed, now, user.ID); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	eventID := uuid.NewString()
+	raw, err := json.Marshal(map[string]interface{}{
+		"order_id":      body.OrderID,
+		"pvp_id":        body.PVPID,
+		"pvp_worker_id": user.ID,
+		"picked_up_at":  now,
+	})
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+		INSERT INTO http_events_log(id, event_type, event_id, payload, received_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.NewString(), orderPickedUpEvent, eventID, raw, now); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event_id":       eventID,
+		"event_type":     orderPickedUpEvent,
+		"occurred_at":    now,
+		"correlation_id": body.OrderID,
+		"data":           json.RawMessage(raw),
+	})
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if err := outbox.EnqueueTx(r.Context(), tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     orderPickedUpEvent,
+		EventID:       eventID,
+		CorrelationID: body.OrderID,
+		Topic:         topic,
+		PartitionKey:  body.OrderID,
+		Payload:       payload,
+		OccurredAt:    now,
+	}); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("picked up"))
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "200").Inc()
+}
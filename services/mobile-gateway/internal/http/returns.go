@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/metrics"
+	"bel-parcel/services/mobile-gateway/internal/outbox"
+	"bel-parcel/services/mobile-gateway/internal/pickups"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const customerReturnEvent = "events.customer_return_requested"
+
+var returnReasons = map[string]bool{"defective": true, "wrong_item": true, "not_as_described": true, "changed_mind": true}
+
+// handleCustomerReturn lets a PVP worker open a return for an order the
+// customer brings back. Only orders whose pickup code was spent, i.e. that
+// were handed over, can be returned; order-service creates the return order.
+func (s *Server) handleCustomerReturn(w http.ResponseWriter, r *http.Request) {
+	const path = "/returns"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	var body struct {
+		OrderID string `json:"order_id"`
+		PVPID   string `json:"pvp_id"`
+		Reason  string `json:"reason"`
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.OrderID == "" || body.PVPID == "" {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	if !returnReasons[body.Reason] {
+		http.Error(w, "invalid reason", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	topic, ok := s.resolveTopic(customerReturnEvent)
+	if !ok {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	now := time.Now().UTC()
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	var status string
+	err = tx.QueryRow(r.Context(), `
+		SELECT status FROM pickup_codes WHERE order_id=$1 FOR UPDATE
+	`, body.OrderID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "404").Inc()
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if status != pickups.StatusUsed {
+		http.Error(w, "order was not handed over to the customer", http.StatusConflict)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "409").Inc()
+		return
+	}
+	tag, err := tx.Exec(r.Context(), `
+		UPDATE pickup_codes SET status=$2, updated_at=$3 WHERE order_id=$1 AND status=$4
+	`, body.OrderID, pickups.StatusReturned, now, pickups.StatusUsed)
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "return already requested", http.StatusConflict)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "409").Inc()
+		return
+	}
+	returnID := uuid.NewString()
+	eventID := uuid.NewString()
+	raw, err := json.Marshal(map[string]interface{}{
+		"return_id":     returnID,
+		"order_id":      body.OrderID,
+		"pvp_id":        body.PVPID,
+		"reason":        body.Reason,
+		"comment":       body.Comment,
+		"pvp_worker_id": user.ID,
+		"requested_at":  now,
+	})
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+		INSERT INTO http_events_log(id, event_type, event_id, payload, received_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.NewString(), customerReturnEvent, eventID, raw, now); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event_id":       eventID,
+		"event_type":     customerReturnEvent,
+		"occurred_at":    now,
+		"correlation_id": body.OrderID,
+		"data":           json.RawMessage(raw),
+	})
+	if err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if err := outbox.EnqueueTx(r.Context(), tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     customerReturnEvent,
+		EventID:       eventID,
+		CorrelationID: body.OrderID,
+		Topic:         topic,
+		PartitionKey:  body.OrderID,
+		Payload:       payload,
+		OccurredAt:    now,
+	}); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"return_id": returnID, "status": "requested"})
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "202").Inc()
+}
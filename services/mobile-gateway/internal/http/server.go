@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"bel-parcel/pkg/blobstore"
 	"bel-parcel/services/mobile-gateway/internal/auth"
 	"bel-parcel/services/mobile-gateway/internal/metrics"
 	"bel-parcel/services/mobile-gateway/internal/outbox"
@@ -18,6 +19,11 @@ type Server struct {
 	db        DB
 	validator *auth.Validator
 	topics    map[string]string
+	blobs     blobstore.Store
+
+	handoverSecret         []byte
+	pickupSecret           []byte
+	retireLegacyDeliveries bool
 }
 
 type DB interface {
@@ -37,6 +43,36 @@ func (s *Server) Routes() *http.ServeMux {
 	mux.HandleFunc("POST /location", auth.RequireRoles(s.validator, []string{"carrier"}, func(w http.ResponseWriter, r *http.Request) {
 		s.handleCarrierLocation(w, r)
 	}))
+	mux.HandleFunc("GET /offers", auth.RequireRoles(s.validator, []string{"carrier"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleListOffers(w, r)
+	}))
+	mux.HandleFunc("POST /offers/{id}/accept", auth.RequireRoles(s.validator, []string{"carrier"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleAcceptOffer(w, r)
+	}))
+	mux.HandleFunc("POST /transshipments", auth.RequireRoles(s.validator, []string{"carrier"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleStartTransshipment(w, r)
+	}))
+	mux.HandleFunc("POST /transshipments/{id}/scans", auth.RequireRoles(s.validator, []string{"carrier"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleScanBox(w, r)
+	}))
+	mux.HandleFunc("POST /transshipments/{id}/complete", auth.RequireRoles(s.validator, []string{"carrier"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleCompleteTransshipment(w, r)
+	}))
+	mux.HandleFunc("POST /incidents", auth.RequireRoles(s.validator, []string{"carrier"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleReportIncident(w, r)
+	}))
+	mux.HandleFunc("POST /deliveries", auth.RequireRoles(s.validator, []string{"carrier"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleDeliverToPVP(w, r)
+	}))
+	mux.HandleFunc("POST /deliveries/codes", auth.RequireRoles(s.validator, []string{"pvp_worker"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleIssueHandoverCode(w, r)
+	}))
+	mux.HandleFunc("POST /pickups", auth.RequireRoles(s.validator, []string{"pvp_worker"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleCustomerPickup(w, r)
+	}))
+	mux.HandleFunc("POST /returns", auth.RequireRoles(s.validator, []string{"pvp_worker"}, func(w http.ResponseWriter, r *http.Request) {
+		s.handleCustomerReturn(w, r)
+	}))
 	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
 		if err := s.db.Ping(r.Context()); err != nil {
 			http.Error(w, "unhealthy", http.StatusServiceUnavailable)
@@ -140,6 +176,16 @@ func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	case "events.batch_delivered_to_pvp", "batch_delivered_to_pvp":
+		// Deprecated: a handover is recorded with the code the PVP issued for
+		// the batch, which POST /deliveries checks. Apps released before that
+		// still report here until the path is retired in config.
+		if s.retireLegacyDeliveries {
+			http.Error(w, "use POST /deliveries with the pvp confirmation code", http.StatusGone)
+			metrics.HTTPRequestsTotal.WithLabelValues("/events", "410").Inc()
+			return
+		}
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</deliveries>; rel="successor-version"`)
 		var d struct {
 			TripID      string    `json:"trip_id"`
 			BatchID     string    `json:"batch_id"`
@@ -0,0 +1,302 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bel-parcel/pkg/blobstore"
+	"bel-parcel/pkg/codehash"
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/handover"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+const handoverSecret = "handover-secret"
+
+type handoverRow struct {
+	pvpID     string
+	hash      string
+	issuedBy  string
+	expiresAt time.Time
+	failed    int
+	usedAt    *time.Time
+	err       error
+}
+
+func (r handoverRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*string) = r.pvpID
+	*dest[1].(*string) = r.hash
+	*dest[2].(*string) = r.issuedBy
+	*dest[3].(*time.Time) = r.expiresAt
+	*dest[4].(*int) = r.failed
+	*dest[5].(**time.Time) = r.usedAt
+	return nil
+}
+
+type handoverTx struct {
+	offerTx
+	code     handoverRow
+	failOn   string
+	payloads [][]byte
+}
+
+func (t *handoverTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row { return t.code }
+func (t *handoverTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	t.execs = append(t.execs, sql)
+	if t.failOn != "" && strings.Contains(sql, t.failOn) {
+		return pgconn.CommandTag{}, errors.New("boom")
+	}
+	if strings.Contains(sql, "outbox_events") {
+		t.payloads = append(t.payloads, args[6].([]byte))
+	}
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+// issuedHandover is the row POST /deliveries/codes leaves for batch-1.
+func issuedHandover(code string) *handoverTx {
+	return &handoverTx{code: handoverRow{
+		pvpID:     "pvp-1",
+		hash:      codehash.Hash([]byte(handoverSecret), "batch-1", code),
+		issuedBy:  "worker-1",
+		expiresAt: time.Now().Add(handover.CodeTTL),
+	}}
+}
+
+func deliveryServer(t *testing.T, tx pgx.Tx) (*Server, *blobstore.FSStore, string) {
+	dir := t.TempDir()
+	store, err := blobstore.NewFSStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(&locMockDB{tx: tx}, auth.NewValidator("secret", "bp", "mobile"), map[string]string{
+		"events.batch_delivered_to_pvp":  "events.batch_delivered_to_pvp",
+		"events.delivery_proof_recorded": "events.delivery_proof_recorded",
+	}).WithBlobStore(store).WithHandoverSecret(handoverSecret)
+	return s, store, dir
+}
+
+// storedFiles lists what the handler left in the blob store.
+func storedFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func deliveryRequest(t *testing.T, fields map[string]string, files map[string][]byte) *http.Request {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		_ = mw.WriteField(k, v)
+	}
+	for name, data := range files {
+		fw, _ := mw.CreateFormFile(name, name+".png")
+		fw.Write(data)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/deliveries", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	return req
+}
+
+var deliveryFields = map[string]string{
+	"trip_id":           "trip-1",
+	"batch_id":          "batch-1",
+	"pvp_id":            "pvp-1",
+	"confirmation_code": "4821",
+}
+
+func TestDeliverToPVP_StoresProof(t *testing.T) {
+	tx := issuedHandover("4821")
+	s, store, _ := deliveryServer(t, tx)
+	rr := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rr, deliveryRequest(t, deliveryFields, map[string][]byte{"signature": pngHeader}))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp["proof_id"] == "" {
+		t.Fatalf("expected proof_id, got %s", rr.Body.String())
+	}
+	data, err := store.Get(context.Background(), "proofs/trip-1/batch-1/"+resp["proof_id"]+"/signature")
+	if err != nil || !bytes.Equal(data, pngHeader) {
+		t.Fatalf("signature not stored: %v", err)
+	}
+	if !tx.committed {
+		t.Fatalf("expected commit")
+	}
+	var outboxInserts int
+	var spent bool
+	for _, q := range tx.execs {
+		if strings.Contains(q, "outbox_events") {
+			outboxInserts++
+		}
+		if strings.Contains(q, "UPDATE handover_codes SET used_at") {
+			spent = true
+		}
+	}
+	if !spent {
+		t.Fatalf("expected the handover code to be spent, got %v", tx.execs)
+	}
+	if outboxInserts != 2 {
+		t.Fatalf("expected delivery and proof events, got %d outbox inserts", outboxInserts)
+	}
+	for _, payload := range tx.payloads {
+		if bytes.Contains(payload, []byte("confirmation_code")) {
+			t.Fatalf("the confirmation code must not leave the gateway: %s", payload)
+		}
+	}
+	if !bytes.Contains(tx.payloads[1], []byte(`"confirmed_by":"worker-1"`)) {
+		t.Fatalf("expected the proof to name who issued the code: %s", tx.payloads[1])
+	}
+}
+
+func TestDeliverToPVP_Rejects(t *testing.T) {
+	badCode := map[string]string{}
+	for k, v := range deliveryFields {
+		badCode[k] = v
+	}
+	badCode["confirmation_code"] = "12a4"
+	cases := map[string]struct {
+		fields map[string]string
+		files  map[string][]byte
+	}{
+		"missing signature": {deliveryFields, map[string][]byte{"photo": pngHeader}},
+		"not an image":      {deliveryFields, map[string][]byte{"signature": []byte("hello")}},
+		"bad code":          {badCode, map[string][]byte{"signature": pngHeader}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tx := issuedHandover("4821")
+			s, _, dir := deliveryServer(t, tx)
+			rr := httptest.NewRecorder()
+			s.Routes().ServeHTTP(rr, deliveryRequest(t, c.fields, c.files))
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", rr.Code)
+			}
+			if tx.committed {
+				t.Fatalf("must not commit a rejected delivery")
+			}
+			if files := storedFiles(t, dir); len(files) != 0 {
+				t.Fatalf("expected no stored files, got %v", files)
+			}
+		})
+	}
+}
+
+func TestDeliverToPVP_ChecksHandoverCode(t *testing.T) {
+	used := time.Now().Add(-time.Minute)
+	cases := map[string]struct {
+		tx     *handoverTx
+		status int
+	}{
+		"wrong code":     {issuedHandover("1111"), http.StatusForbidden},
+		"not issued":     {&handoverTx{code: handoverRow{err: pgx.ErrNoRows}}, http.StatusForbidden},
+		"other pvp":      {&handoverTx{code: handoverRow{pvpID: "pvp-2", hash: codehash.Hash([]byte(handoverSecret), "batch-1", "4821"), expiresAt: time.Now().Add(time.Minute)}}, http.StatusForbidden},
+		"expired":        {&handoverTx{code: handoverRow{pvpID: "pvp-1", hash: codehash.Hash([]byte(handoverSecret), "batch-1", "4821"), expiresAt: time.Now().Add(-time.Minute)}}, http.StatusForbidden},
+		"already used":   {&handoverTx{code: handoverRow{pvpID: "pvp-1", hash: codehash.Hash([]byte(handoverSecret), "batch-1", "4821"), expiresAt: time.Now().Add(time.Minute), usedAt: &used}}, http.StatusConflict},
+		"too many tries": {&handoverTx{code: handoverRow{pvpID: "pvp-1", hash: codehash.Hash([]byte(handoverSecret), "batch-1", "4821"), expiresAt: time.Now().Add(time.Minute), failed: handover.MaxFailedAttempts}}, http.StatusTooManyRequests},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, _, dir := deliveryServer(t, c.tx)
+			rr := httptest.NewRecorder()
+			s.Routes().ServeHTTP(rr, deliveryRequest(t, deliveryFields, map[string][]byte{"signature": pngHeader, "photo": pngHeader}))
+			if rr.Code != c.status {
+				t.Fatalf("expected %d, got %d %s", c.status, rr.Code, rr.Body.String())
+			}
+			for _, q := range c.tx.execs {
+				if strings.Contains(q, "outbox_events") {
+					t.Fatalf("must not queue an unconfirmed delivery")
+				}
+			}
+			if files := storedFiles(t, dir); len(files) != 0 {
+				t.Fatalf("expected the uploads to be deleted, got %v", files)
+			}
+		})
+	}
+}
+
+func TestDeliverToPVP_WrongCodeCountsAttempt(t *testing.T) {
+	tx := issuedHandover("1111")
+	s, _, _ := deliveryServer(t, tx)
+	rr := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rr, deliveryRequest(t, deliveryFields, map[string][]byte{"signature": pngHeader}))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rr.Code)
+	}
+	if !tx.committed || len(tx.execs) != 1 || !strings.Contains(tx.execs[0], "failed_attempts = failed_attempts + 1") {
+		t.Fatalf("expected the failed attempt to be committed, got %v", tx.execs)
+	}
+}
+
+func TestDeliverToPVP_EnqueueFailureDeletesUploads(t *testing.T) {
+	tx := issuedHandover("4821")
+	tx.failOn = "outbox_events"
+	s, _, dir := deliveryServer(t, tx)
+	rr := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rr, deliveryRequest(t, deliveryFields, map[string][]byte{"signature": pngHeader}))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+	if tx.committed {
+		t.Fatalf("must not commit a failed delivery")
+	}
+	if files := storedFiles(t, dir); len(files) != 0 {
+		t.Fatalf("expected the uploads to be deleted, got %v", files)
+	}
+}
+
+func TestIssueHandoverCode(t *testing.T) {
+	tx := &handoverTx{}
+	s, _, _ := deliveryServer(t, tx)
+	req := httptest.NewRequest(http.MethodPost, "/deliveries/codes", strings.NewReader(`{"batch_id":"batch-1","pvp_id":"pvp-1"}`))
+	req.Header.Set("Authorization", "Bearer "+jwtRole(t, "pvp_worker"))
+	rr := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || len(resp.Code) != 6 {
+		t.Fatalf("expected a six-digit code, got %s", rr.Body.String())
+	}
+	if !tx.committed || len(tx.execs) != 1 || !strings.Contains(tx.execs[0], "INSERT INTO handover_codes") {
+		t.Fatalf("expected the code to be stored, got %v", tx.execs)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/deliveries/codes", strings.NewReader(`{"batch_id":"batch-1","pvp_id":"pvp-1"}`))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	rr = httptest.NewRecorder()
+	s.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("carriers must not issue codes, got %d", rr.Code)
+	}
+}
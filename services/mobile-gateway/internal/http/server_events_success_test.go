@@ -104,6 +104,26 @@ func TestEvents_DeliveredToPVP_Accepted(t *testing.T) {
 	if rr.Code != http.StatusAccepted {
 		t.Fatalf("expected 202, got %d", rr.Code)
 	}
+	if rr.Header().Get("Deprecation") != "true" {
+		t.Fatalf("expected the legacy path marked deprecated")
+	}
+}
+
+func TestEvents_DeliveredToPVP_GoneOnceRetired(t *testing.T) {
+	tx := &mgTx{}
+	db := &mgMockDB{tx: tx}
+	s := NewServer(db, auth.NewValidator("secret", "bp", "mobile"), map[string]string{
+		"events.batch_delivered_to_pvp": "events.batch_delivered_to_pvp",
+	}).WithLegacyDeliveryEvents(false)
+	mux := s.Routes()
+	body := `{"event_id":"e2","event_type":"events.batch_delivered_to_pvp","data":{"trip_id":"t1","batch_id":"b1","carrier_id":"c1","pvp_id":"p1","is_hub":false,"delivered_at":"` + time.Now().UTC().Format(time.RFC3339) + `"}}`
+	req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+jwtFor(t))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusGone {
+		t.Fatalf("expected 410, got %d", rr.Code)
+	}
 }
 
 func TestEvents_ReceivedByPVP_Accepted(t *testing.T) {
@@ -0,0 +1,85 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bel-parcel/services/mobile-gateway/internal/auth"
+)
+
+func incidentServer(tx *offerTx) *Server {
+	return NewServer(&locMockDB{tx: tx}, auth.NewValidator("secret", "bp", "mobile"), map[string]string{
+		"events.trip_incident_reported": "events.trip_incident_reported",
+	})
+}
+
+func TestReportIncident_Accepted(t *testing.T) {
+	tx := &offerTx{}
+	body := `{"trip_id":"trip-1","type":"breakdown","latitude":53.9,"longitude":27.56,"description":"engine"}`
+	req := httptest.NewRequest(http.MethodPost, "/incidents", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	rr := httptest.NewRecorder()
+	incidentServer(tx).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp["incident_id"] == "" {
+		t.Fatalf("expected incident_id in response, got %s", rr.Body.String())
+	}
+	if !tx.committed {
+		t.Fatalf("expected commit")
+	}
+	var outboxInsert bool
+	for _, q := range tx.execs {
+		if strings.Contains(q, "outbox_events") {
+			outboxInsert = true
+		}
+	}
+	if !outboxInsert {
+		t.Fatalf("expected outbox insert, got %v", tx.execs)
+	}
+}
+
+func TestReportIncident_InvalidType(t *testing.T) {
+	tx := &offerTx{}
+	req := httptest.NewRequest(http.MethodPost, "/incidents", strings.NewReader(`{"trip_id":"trip-1","type":"flat_tyre"}`))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	rr := httptest.NewRecorder()
+	incidentServer(tx).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+	if tx.committed {
+		t.Fatalf("must not commit invalid report")
+	}
+}
+
+func TestReportIncident_InvalidCoordinates(t *testing.T) {
+	tx := &offerTx{}
+	req := httptest.NewRequest(http.MethodPost, "/incidents", strings.NewReader(`{"trip_id":"trip-1","type":"delay","latitude":123}`))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	rr := httptest.NewRecorder()
+	incidentServer(tx).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestReportIncident_OtherCarriersTrip(t *testing.T) {
+	tx := &offerTx{row: offerRow{tripID: "c2"}}
+	body := `{"trip_id":"trip-1","type":"breakdown","latitude":53.9,"longitude":27.56}`
+	req := httptest.NewRequest(http.MethodPost, "/incidents", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	rr := httptest.NewRecorder()
+	incidentServer(tx).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d %s", rr.Code, rr.Body.String())
+	}
+	if tx.committed || len(tx.execs) != 0 {
+		t.Fatalf("must not record a report on another carrier's trip")
+	}
+}
@@ -0,0 +1,141 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bel-parcel/pkg/codehash"
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/pickups"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type pickupRow struct {
+	pvpID       string
+	hash        string
+	status      string
+	failed      int
+	lockedUntil *time.Time
+}
+
+func (r pickupRow) Scan(dest ...any) error {
+	*dest[0].(*string) = r.pvpID
+	*dest[1].(*string) = r.hash
+	*dest[2].(*string) = r.status
+	*dest[3].(*int) = r.failed
+	*dest[4].(**time.Time) = r.lockedUntil
+	return nil
+}
+
+type pickupTx struct {
+	offerTx
+	code pickupRow
+}
+
+func (t *pickupTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row { return t.code }
+
+func pickupRequest(t *testing.T, tx *pickupTx, code string) *httptest.ResponseRecorder {
+	s := NewServer(&locMockDB{tx: tx}, auth.NewValidator("secret", "bp", "mobile"), map[string]string{
+		"events.order_picked_up": "events.order_picked_up",
+	}).WithPickupCodeSecret("pickup-secret")
+	req := httptest.NewRequest(http.MethodPost, "/pickups", strings.NewReader(`{"order_id":"o1","pvp_id":"p1","code":"`+code+`"}`))
+	req.Header.Set("Authorization", "Bearer "+jwtRole(t, "pvp_worker"))
+	rr := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func issuedCode(failed int) *pickupTx {
+	return &pickupTx{code: pickupRow{pvpID: "p1", hash: codehash.Hash([]byte("pickup-secret"), "o1", "123456"), status: pickups.StatusIssued, failed: failed}}
+}
+
+func TestCustomerPickup_CorrectCode(t *testing.T) {
+	tx := issuedCode(0)
+	rr := pickupRequest(t, tx, "123456")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	if !tx.committed {
+		t.Fatalf("expected commit")
+	}
+	var outboxInsert bool
+	for _, q := range tx.execs {
+		if strings.Contains(q, "outbox_events") {
+			outboxInsert = true
+		}
+	}
+	if !outboxInsert {
+		t.Fatalf("expected pickup event in the outbox, got %v", tx.execs)
+	}
+}
+
+func TestCustomerPickup_WrongCodeCountsAttempt(t *testing.T) {
+	tx := issuedCode(0)
+	rr := pickupRequest(t, tx, "000000")
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rr.Code)
+	}
+	if !tx.committed || len(tx.execs) != 1 || !strings.Contains(tx.execs[0], "failed_attempts") {
+		t.Fatalf("expected the failed attempt to be recorded, got %v", tx.execs)
+	}
+}
+
+func TestCustomerPickup_LocksAfterTooManyFailures(t *testing.T) {
+	rr := pickupRequest(t, issuedCode(pickups.MaxFailedAttempts-1), "000000")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d", rr.Code)
+	}
+
+	until := time.Now().Add(time.Minute)
+	tx := issuedCode(0)
+	tx.code.lockedUntil = &until
+	rr = pickupRequest(t, tx, "123456")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected locked code to be refused, got %d", rr.Code)
+	}
+	if tx.committed {
+		t.Fatalf("must not spend a locked code")
+	}
+}
+
+func TestCustomerPickup_LockGrowsAfterExpiry(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	tx := issuedCode(pickups.MaxFailedAttempts)
+	tx.code.lockedUntil = &expired
+	rr := pickupRequest(t, tx, "000000")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a miss after the lock to lock again, got %d", rr.Code)
+	}
+	if got, want := rr.Header().Get("Retry-After"), strconv.Itoa(int((2 * pickups.LockDuration).Seconds())); got != want {
+		t.Fatalf("expected Retry-After %s, got %s", want, got)
+	}
+	if !tx.committed {
+		t.Fatalf("expected the failed attempt to be recorded")
+	}
+}
+
+func TestCustomerPickup_UsedCode(t *testing.T) {
+	tx := issuedCode(0)
+	tx.code.status = pickups.StatusUsed
+	if rr := pickupRequest(t, tx, "123456"); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rr.Code)
+	}
+}
+
+func TestCustomerPickup_RevokedCode(t *testing.T) {
+	tx := issuedCode(0)
+	tx.code.status = pickups.StatusRevoked
+	rr := pickupRequest(t, tx, "123456")
+	if rr.Code != http.StatusGone {
+		t.Fatalf("expected 410, got %d", rr.Code)
+	}
+	if tx.committed {
+		t.Fatalf("must not spend a revoked code")
+	}
+}
@@ -0,0 +1,81 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/pickups"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type statusRow string
+
+func (r statusRow) Scan(dest ...any) error {
+	*dest[0].(*string) = string(r)
+	return nil
+}
+
+type returnTx struct {
+	offerTx
+	status statusRow
+}
+
+func (t *returnTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row { return t.status }
+func (t *returnTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	t.execs = append(t.execs, sql)
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func returnRequest(t *testing.T, tx *returnTx, body string) *httptest.ResponseRecorder {
+	s := NewServer(&locMockDB{tx: tx}, auth.NewValidator("secret", "bp", "mobile"), map[string]string{
+		"events.customer_return_requested": "events.customer_return_requested",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/returns", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+jwtRole(t, "pvp_worker"))
+	rr := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCustomerReturn_HandedOverOrder(t *testing.T) {
+	tx := &returnTx{status: statusRow(pickups.StatusUsed)}
+	rr := returnRequest(t, tx, `{"order_id":"o1","pvp_id":"p1","reason":"defective"}`)
+	if rr.Code != http.StatusAccepted || !strings.Contains(rr.Body.String(), "return_id") {
+		t.Fatalf("expected 202 with return id, got %d %s", rr.Code, rr.Body.String())
+	}
+	if !tx.committed {
+		t.Fatalf("expected commit")
+	}
+	var outboxInsert bool
+	for _, q := range tx.execs {
+		if strings.Contains(q, "outbox_events") {
+			outboxInsert = true
+		}
+	}
+	if !outboxInsert {
+		t.Fatalf("expected return event in the outbox, got %v", tx.execs)
+	}
+}
+
+func TestCustomerReturn_NotHandedOver(t *testing.T) {
+	tx := &returnTx{status: statusRow(pickups.StatusIssued)}
+	if rr := returnRequest(t, tx, `{"order_id":"o1","pvp_id":"p1","reason":"defective"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rr.Code)
+	}
+	if tx.committed {
+		t.Fatalf("must not commit")
+	}
+}
+
+func TestCustomerReturn_InvalidReason(t *testing.T) {
+	tx := &returnTx{status: statusRow(pickups.StatusUsed)}
+	if rr := returnRequest(t, tx, `{"order_id":"o1","pvp_id":"p1","reason":"bored"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
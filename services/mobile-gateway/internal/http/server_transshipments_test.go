@@ -0,0 +1,81 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bel-parcel/services/mobile-gateway/internal/auth"
+)
+
+func transshipmentServer(tx *offerTx) *Server {
+	return NewServer(&locMockDB{tx: tx}, auth.NewValidator("secret", "bp", "mobile"), map[string]string{
+		"commands.transshipment": "commands.transshipment",
+	})
+}
+
+func TestStartTransshipment_Accepted(t *testing.T) {
+	tx := &offerTx{}
+	body := `{"source_trip_id":"trip-1","target_carrier_id":"c2","box_ids":["o1","o2"],"reason":"overloaded"}`
+	req := httptest.NewRequest(http.MethodPost, "/transshipments", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	rr := httptest.NewRecorder()
+	transshipmentServer(tx).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp["transshipment_id"] == "" {
+		t.Fatalf("expected transshipment_id, got %s", rr.Body.String())
+	}
+	if !tx.committed {
+		t.Fatal("expected commit")
+	}
+}
+
+func TestStartTransshipment_SameCarrierRejected(t *testing.T) {
+	tx := &offerTx{}
+	req := httptest.NewRequest(http.MethodPost, "/transshipments", strings.NewReader(`{"source_trip_id":"trip-1","target_carrier_id":"c1"}`))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	rr := httptest.NewRecorder()
+	transshipmentServer(tx).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestScanBox_InvalidDirection(t *testing.T) {
+	tx := &offerTx{}
+	req := httptest.NewRequest(http.MethodPost, "/transshipments/ts-1/scans", strings.NewReader(`{"box_id":"o1","direction":"sideways"}`))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c1"))
+	rr := httptest.NewRecorder()
+	transshipmentServer(tx).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestScanBox_Accepted(t *testing.T) {
+	tx := &offerTx{}
+	req := httptest.NewRequest(http.MethodPost, "/transshipments/ts-1/scans", strings.NewReader(`{"box_id":"o1","direction":"in"}`))
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c2"))
+	rr := httptest.NewRecorder()
+	transshipmentServer(tx).Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted || !tx.committed {
+		t.Fatalf("expected 202 and commit, got %d", rr.Code)
+	}
+}
+
+func TestCompleteTransshipment_TopicNotConfigured(t *testing.T) {
+	tx := &offerTx{}
+	s := NewServer(&locMockDB{tx: tx}, auth.NewValidator("secret", "bp", "mobile"), map[string]string{})
+	req := httptest.NewRequest(http.MethodPost, "/transshipments/ts-1/complete", nil)
+	req.Header.Set("Authorization", "Bearer "+jwtCarrierID(t, "c2"))
+	rr := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError || tx.committed {
+		t.Fatalf("expected 500 without commit, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"bel-parcel/services/mobile-gateway/internal/auth"
+	"bel-parcel/services/mobile-gateway/internal/metrics"
+	"bel-parcel/services/mobile-gateway/internal/outbox"
+
+	"github.com/google/uuid"
+)
+
+// All messages of one transfer share a topic and are keyed by its id, so
+// routing-service sees the scans before the completion.
+const (
+	transshipmentCommandTopic  = "commands.transshipment"
+	transshipmentInitiateEvent = "commands.transshipment.initiate"
+	transshipmentScanEvent     = "commands.transshipment.scan"
+	transshipmentCompleteEvent = "commands.transshipment.complete"
+)
+
+var errUnknownTopic = errors.New("topic not configured")
+
+// publish logs the request and queues the event in one transaction, the same
+// way the other carrier endpoints do.
+func (s *Server) publish(ctx context.Context, topicKey, eventType, correlationID, partitionKey string, data map[string]interface{}) error {
+	topic, ok := s.resolveTopic(topicKey)
+	if !ok {
+		return errUnknownTopic
+	}
+	now := time.Now().UTC()
+	eventID := uuid.NewString()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event_id":       eventID,
+		"event_type":     eventType,
+		"occurred_at":    now,
+		"correlation_id": correlationID,
+		"data":           json.RawMessage(raw),
+	})
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO http_events_log(id, event_type, event_id, payload, received_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.NewString(), eventType, eventID, raw, now); err != nil {
+		return err
+	}
+	if err := outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     eventType,
+		EventID:       eventID,
+		CorrelationID: correlationID,
+		Topic:         topic,
+		PartitionKey:  partitionKey,
+		Payload:       payload,
+		OccurredAt:    now,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// handleStartTransshipment lets the carrier of a broken or overloaded vehicle
+// hand boxes to another carrier. An empty box list moves the whole trip.
+// routing-service checks the trip, the boxes and the target's capacity.
+func (s *Server) handleStartTransshipment(w http.ResponseWriter, r *http.Request) {
+	const path = "/transshipments"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	var body struct {
+		SourceTripID    string   `json:"source_trip_id"`
+		TargetCarrierID string   `json:"target_carrier_id"`
+		BoxIDs          []string `json:"box_ids"`
+		Reason          string   `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.SourceTripID == "" || body.TargetCarrierID == "" {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	if body.TargetCarrierID == user.ID {
+		http.Error(w, "target carrier must differ from the source carrier", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	id := uuid.NewString()
+	if err := s.publish(r.Context(), transshipmentCommandTopic, transshipmentInitiateEvent, id, id, map[string]interface{}{
+		"transshipment_id":  id,
+		"source_trip_id":    body.SourceTripID,
+		"target_carrier_id": body.TargetCarrierID,
+		"box_ids":           body.BoxIDs,
+		"reason":            body.Reason,
+		"initiated_by":      user.ID,
+	}); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"transshipment_id": id})
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "202").Inc()
+}
+
+// handleScanBox forwards a barcode scan: "out" when the box leaves the source
+// vehicle, "in" when it is loaded into the target vehicle.
+func (s *Server) handleScanBox(w http.ResponseWriter, r *http.Request) {
+	const path = "/transshipments/scans"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	id := r.PathValue("id")
+	var body struct {
+		BoxID     string    `json:"box_id"`
+		Direction string    `json:"direction"`
+		ScannedAt time.Time `json:"scanned_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || id == "" || body.BoxID == "" {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	if body.Direction != "in" && body.Direction != "out" {
+		http.Error(w, "direction must be in or out", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	if body.ScannedAt.IsZero() {
+		body.ScannedAt = time.Now().UTC()
+	}
+	if err := s.publish(r.Context(), transshipmentCommandTopic, transshipmentScanEvent, id+"/"+body.BoxID+"/"+body.Direction, id, map[string]interface{}{
+		"transshipment_id": id,
+		"box_id":           body.BoxID,
+		"direction":        body.Direction,
+		"carrier_id":       user.ID,
+		"scanned_at":       body.ScannedAt,
+	}); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("accepted"))
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "202").Inc()
+}
+
+// handleCompleteTransshipment closes the scanning; routing-service reconciles
+// the scans and re-links the moved boxes.
+func (s *Server) handleCompleteTransshipment(w http.ResponseWriter, r *http.Request) {
+	const path = "/transshipments/complete"
+	user := auth.FromContext(r)
+	if user == nil || user.ID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "401").Inc()
+		return
+	}
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing transshipment id", http.StatusBadRequest)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "400").Inc()
+		return
+	}
+	if err := s.publish(r.Context(), transshipmentCommandTopic, transshipmentCompleteEvent, id, id, map[string]interface{}{
+		"transshipment_id": id,
+		"completed_by":     user.ID,
+	}); err != nil {
+		http.Error(w, "internal", http.StatusInternalServerError)
+		metrics.HTTPRequestsTotal.WithLabelValues(path, "500").Inc()
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("accepted"))
+	metrics.HTTPRequestsTotal.WithLabelValues(path, "202").Inc()
+}
@@ -0,0 +1,110 @@
+package offers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Offer statuses of the local projection. accept_requested means the carrier
+// has pressed "accept" and the gateway is waiting for routing to decide.
+const (
+	StatusPending         = "pending"
+	StatusAcceptRequested = "accept_requested"
+	StatusAccepted        = "accepted"
+	StatusRejected        = "rejected"
+	StatusWithdrawn       = "withdrawn"
+)
+
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func EnsureSchema(db Execer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS carrier_offers (
+			offer_id TEXT PRIMARY KEY,
+			trip_id TEXT NOT NULL,
+			batch_id TEXT NOT NULL,
+			carrier_id TEXT NOT NULL,
+			rank INT NOT NULL DEFAULT 0,
+			distance_meters INT NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			reason TEXT,
+			payload JSONB NOT NULL,
+			offered_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_carrier_offers_carrier ON carrier_offers(carrier_id, status);
+	`)
+	return err
+}
+
+// Projector keeps carrier_offers in sync with the offer events published by
+// routing-service. Events are keyed by carrier, so per-carrier order holds.
+type Projector struct {
+	db Execer
+}
+
+func NewProjector(db Execer) *Projector {
+	return &Projector{db: db}
+}
+
+func (p *Projector) HandleEvent(ctx context.Context, topic string, key, value []byte) error {
+	var envelope struct {
+		EventID    string          `json:"event_id"`
+		EventType  string          `json:"event_type"`
+		OccurredAt time.Time       `json:"occurred_at"`
+		Data       json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	var data struct {
+		OfferID        string    `json:"offer_id"`
+		TripID         string    `json:"trip_id"`
+		BatchID        string    `json:"batch_id"`
+		CarrierID      string    `json:"carrier_id"`
+		Rank           int       `json:"rank"`
+		DistanceMeters int       `json:"distance_meters"`
+		Reason         string    `json:"reason"`
+		OfferedAt      time.Time `json:"offered_at"`
+		ExpiresAt      time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		return err
+	}
+	if data.OfferID == "" {
+		return nil
+	}
+	switch envelope.EventType {
+	case "trips.offer_created":
+		_, err := p.db.Exec(ctx, `
+			INSERT INTO carrier_offers (offer_id, trip_id, batch_id, carrier_id, rank, distance_meters, status, payload, offered_at, expires_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+			ON CONFLICT (offer_id) DO NOTHING
+		`, data.OfferID, data.TripID, data.BatchID, data.CarrierID, data.Rank, data.DistanceMeters, StatusPending, []byte(envelope.Data), data.OfferedAt, data.ExpiresAt)
+		return err
+	case "trips.offer_accepted":
+		return p.setStatus(ctx, data.OfferID, StatusAccepted, "")
+	case "trips.offer_rejected":
+		return p.setStatus(ctx, data.OfferID, StatusRejected, data.Reason)
+	case "trips.offer_withdrawn":
+		return p.setStatus(ctx, data.OfferID, StatusWithdrawn, data.Reason)
+	default:
+		return nil
+	}
+}
+
+func (p *Projector) setStatus(ctx context.Context, offerID, status, reason string) error {
+	_, err := p.db.Exec(ctx, `
+		UPDATE carrier_offers SET status=$2, reason=NULLIF($3, ''), updated_at=NOW()
+		WHERE offer_id=$1 AND status IN ('pending', 'accept_requested')
+	`, offerID, status, reason)
+	return err
+}
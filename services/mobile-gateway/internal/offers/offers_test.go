@@ -0,0 +1,80 @@
+package offers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type recExec struct {
+	sqls []string
+	args [][]any
+}
+
+func (r *recExec) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	r.sqls = append(r.sqls, sql)
+	r.args = append(r.args, args)
+	return pgconn.CommandTag{}, nil
+}
+
+func TestEnsureSchemaCreatesTable(t *testing.T) {
+	db := &recExec{}
+	if err := EnsureSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sqls) != 1 || !strings.Contains(db.sqls[0], "carrier_offers") {
+		t.Fatalf("unexpected statements: %v", db.sqls)
+	}
+}
+
+func TestProjector_OfferCreatedInsertsPending(t *testing.T) {
+	db := &recExec{}
+	p := NewProjector(db)
+	msg := `{"event_id":"e1","event_type":"trips.offer_created","data":{"offer_id":"o1","trip_id":"t1","batch_id":"b1","carrier_id":"c1","rank":2,"distance_meters":800,"offered_at":"2024-01-01T00:00:00Z","expires_at":"2024-01-01T00:02:00Z"}}`
+	if err := p.HandleEvent(context.Background(), "trips.offers", nil, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sqls) != 1 || !strings.Contains(db.sqls[0], "INSERT INTO carrier_offers") {
+		t.Fatalf("expected insert, got %v", db.sqls)
+	}
+	if db.args[0][0] != "o1" || db.args[0][6] != StatusPending {
+		t.Fatalf("unexpected args: %v", db.args[0])
+	}
+}
+
+func TestProjector_OutcomesUpdateStatus(t *testing.T) {
+	cases := map[string]string{
+		"trips.offer_accepted":  StatusAccepted,
+		"trips.offer_rejected":  StatusRejected,
+		"trips.offer_withdrawn": StatusWithdrawn,
+	}
+	for eventType, status := range cases {
+		db := &recExec{}
+		msg := `{"event_id":"e1","event_type":"` + eventType + `","data":{"offer_id":"o1","trip_id":"t1","carrier_id":"c1","reason":"taken"}}`
+		if err := NewProjector(db).HandleEvent(context.Background(), "trips.offers", nil, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.sqls) != 1 || !strings.Contains(db.sqls[0], "UPDATE carrier_offers") {
+			t.Fatalf("%s: expected update, got %v", eventType, db.sqls)
+		}
+		if db.args[0][1] != status {
+			t.Fatalf("%s: expected status %s, got %v", eventType, status, db.args[0][1])
+		}
+	}
+}
+
+func TestProjector_IgnoresUnknownAndInvalid(t *testing.T) {
+	db := &recExec{}
+	p := NewProjector(db)
+	if err := p.HandleEvent(context.Background(), "trips.offers", nil, []byte(`{"event_type":"trips.other","data":{"offer_id":"o1"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.HandleEvent(context.Background(), "trips.offers", nil, []byte(`not json`)); err == nil {
+		t.Fatalf("expected error for invalid json")
+	}
+	if len(db.sqls) != 0 {
+		t.Fatalf("expected no writes, got %v", db.sqls)
+	}
+}
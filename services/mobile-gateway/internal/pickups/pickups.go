@@ -0,0 +1,140 @@
+package pickups
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Code statuses of the local projection.
+const (
+	StatusIssued  = "issued"
+	StatusUsed    = "used"
+	StatusRevoked = "revoked"
+	// StatusReturned marks a handed-over order the customer brought back.
+	StatusReturned = "returned"
+)
+
+// A code is locked for LockDuration after MaxFailedAttempts wrong entries in
+// a row, which keeps a six-digit code out of reach of guessing. The count is
+// kept across locks: every further wrong entry locks the code again for
+// twice as long, up to MaxLockDuration.
+const (
+	MaxFailedAttempts = 5
+	LockDuration      = 15 * time.Minute
+	MaxLockDuration   = 24 * time.Hour
+)
+
+// LockFor returns how long a code is locked after its failed-th wrong entry
+// in a row, or zero while it stays open.
+func LockFor(failed int) time.Duration {
+	if failed < MaxFailedAttempts {
+		return 0
+	}
+	d := LockDuration
+	for i := MaxFailedAttempts; i < failed && d < MaxLockDuration; i++ {
+		d *= 2
+	}
+	return min(d, MaxLockDuration)
+}
+
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func EnsureSchema(db Execer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS pickup_codes (
+			order_id TEXT PRIMARY KEY,
+			pvp_id TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			status TEXT NOT NULL,
+			failed_attempts INT NOT NULL DEFAULT 0,
+			locked_until TIMESTAMPTZ,
+			issued_at TIMESTAMPTZ NOT NULL,
+			used_at TIMESTAMPTZ,
+			used_by TEXT,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	return err
+}
+
+// Projector keeps pickup_codes in sync with the codes order-service issues.
+// A re-issued code replaces the old one and clears the failed attempts; a
+// code revoked when the storage period expires can no longer be used.
+type Projector struct {
+	db Execer
+}
+
+func NewProjector(db Execer) *Projector {
+	return &Projector{db: db}
+}
+
+func (p *Projector) HandleEvent(ctx context.Context, topic string, key, value []byte) error {
+	var envelope struct {
+		EventType string          `json:"event_type"`
+		Data      json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	switch envelope.EventType {
+	case "orders.pickup_code_issued":
+		return p.issued(ctx, envelope.Data)
+	case "orders.pickup_code_revoked":
+		return p.revoked(ctx, envelope.Data)
+	default:
+		return nil
+	}
+}
+
+func (p *Projector) issued(ctx context.Context, raw json.RawMessage) error {
+	var data struct {
+		OrderID  string    `json:"order_id"`
+		PVPID    string    `json:"pvp_id"`
+		CodeHash string    `json:"code_hash"`
+		IssuedAt time.Time `json:"issued_at"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	if data.OrderID == "" || data.CodeHash == "" {
+		return nil
+	}
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO pickup_codes (order_id, pvp_id, code_hash, status, issued_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (order_id) DO UPDATE SET
+			pvp_id = EXCLUDED.pvp_id,
+			code_hash = EXCLUDED.code_hash,
+			status = EXCLUDED.status,
+			failed_attempts = 0,
+			locked_until = NULL,
+			issued_at = EXCLUDED.issued_at,
+			updated_at = NOW()
+		WHERE pickup_codes.status <> 'used' AND pickup_codes.issued_at < EXCLUDED.issued_at
+	`, data.OrderID, data.PVPID, data.CodeHash, StatusIssued, data.IssuedAt)
+	return err
+}
+
+func (p *Projector) revoked(ctx context.Context, raw json.RawMessage) error {
+	var data struct {
+		OrderID string `json:"order_id"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	if data.OrderID == "" {
+		return nil
+	}
+	_, err := p.db.Exec(ctx, `
+		UPDATE pickup_codes SET status = $2, updated_at = NOW()
+		WHERE order_id = $1 AND status = 'issued'
+	`, data.OrderID, StatusRevoked)
+	return err
+}
@@ -0,0 +1,72 @@
+package pickups
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type recExec struct {
+	sqls []string
+	args [][]any
+}
+
+func (r *recExec) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	r.sqls = append(r.sqls, sql)
+	r.args = append(r.args, args)
+	return pgconn.CommandTag{}, nil
+}
+
+func TestLockFor(t *testing.T) {
+	cases := map[int]time.Duration{
+		MaxFailedAttempts - 1:  0,
+		MaxFailedAttempts:      LockDuration,
+		MaxFailedAttempts + 1:  2 * LockDuration,
+		MaxFailedAttempts + 2:  4 * LockDuration,
+		MaxFailedAttempts + 20: MaxLockDuration,
+	}
+	for failed, want := range cases {
+		if got := LockFor(failed); got != want {
+			t.Fatalf("LockFor(%d) = %v, want %v", failed, got, want)
+		}
+	}
+}
+
+func TestProjector_CodeIssuedUpserts(t *testing.T) {
+	db := &recExec{}
+	msg := `{"event_id":"e1","event_type":"orders.pickup_code_issued","data":{"order_id":"o1","pvp_id":"p1","code_hash":"abc","issued_at":"2024-01-01T00:00:00Z"}}`
+	if err := NewProjector(db).HandleEvent(context.Background(), "orders.pickup_codes", nil, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sqls) != 1 || !strings.Contains(db.sqls[0], "INSERT INTO pickup_codes") {
+		t.Fatalf("expected upsert, got %v", db.sqls)
+	}
+	if db.args[0][0] != "o1" || db.args[0][3] != StatusIssued {
+		t.Fatalf("unexpected args: %v", db.args[0])
+	}
+}
+
+func TestProjector_IgnoresOtherEvents(t *testing.T) {
+	db := &recExec{}
+	msg := `{"event_id":"e1","event_type":"notifications.pickup_code","data":{"order_id":"o1"}}`
+	if err := NewProjector(db).HandleEvent(context.Background(), "orders.pickup_codes", nil, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sqls) != 0 {
+		t.Fatalf("expected no writes, got %v", db.sqls)
+	}
+}
+
+func TestProjector_CodeRevoked(t *testing.T) {
+	db := &recExec{}
+	msg := `{"event_id":"e2","event_type":"orders.pickup_code_revoked","data":{"order_id":"o1","pvp_id":"p1"}}`
+	if err := NewProjector(db).HandleEvent(context.Background(), "orders.pickup_codes", nil, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sqls) != 1 || !strings.Contains(db.sqls[0], "UPDATE pickup_codes") || db.args[0][1] != StatusRevoked {
+		t.Fatalf("expected revocation, got %v %v", db.sqls, db.args)
+	}
+}
@@ -0,0 +1,77 @@
+package trips
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func EnsureSchema(db Execer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS carrier_trips (
+			trip_id TEXT PRIMARY KEY,
+			carrier_id TEXT NOT NULL,
+			assigned_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	return err
+}
+
+// Projector keeps carrier_trips, the carrier each trip is assigned to, in
+// sync with routing-service's trips.assigned events, so the gateway can tell
+// whether a carrier reporting on a trip is the one driving it. A later
+// assignment of the same trip replaces the earlier one.
+type Projector struct {
+	db Execer
+}
+
+func NewProjector(db Execer) *Projector {
+	return &Projector{db: db}
+}
+
+func (p *Projector) HandleEvent(ctx context.Context, topic string, key, value []byte) error {
+	var envelope struct {
+		EventType  string          `json:"event_type"`
+		OccurredAt time.Time       `json:"occurred_at"`
+		Data       json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	if envelope.EventType != "trips.assigned" {
+		return nil
+	}
+	var data struct {
+		TripID     string    `json:"trip_id"`
+		CarrierID  string    `json:"carrier_id"`
+		AssignedAt time.Time `json:"assigned_at"`
+	}
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		return err
+	}
+	if data.TripID == "" || data.CarrierID == "" {
+		return nil
+	}
+	if data.AssignedAt.IsZero() {
+		data.AssignedAt = envelope.OccurredAt
+	}
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO carrier_trips (trip_id, carrier_id, assigned_at, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (trip_id) DO UPDATE SET
+			carrier_id = EXCLUDED.carrier_id,
+			assigned_at = EXCLUDED.assigned_at,
+			updated_at = NOW()
+		WHERE carrier_trips.assigned_at <= EXCLUDED.assigned_at
+	`, data.TripID, data.CarrierID, data.AssignedAt)
+	return err
+}
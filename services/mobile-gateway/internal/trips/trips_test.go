@@ -0,0 +1,49 @@
+package trips
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type recExec struct {
+	sqls []string
+	args [][]any
+}
+
+func (r *recExec) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	r.sqls = append(r.sqls, sql)
+	r.args = append(r.args, args)
+	return pgconn.CommandTag{}, nil
+}
+
+func TestProjector_AssignedUpserts(t *testing.T) {
+	db := &recExec{}
+	msg := `{"event_id":"e1","event_type":"trips.assigned","data":{"trip_id":"t1","batch_id":"b1","carrier_id":"c1","assigned_at":"2024-01-01T00:00:00Z"}}`
+	if err := NewProjector(db).HandleEvent(context.Background(), "trips.assigned", nil, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.sqls) != 1 || !strings.Contains(db.sqls[0], "INSERT INTO carrier_trips") {
+		t.Fatalf("expected upsert, got %v", db.sqls)
+	}
+	if db.args[0][0] != "t1" || db.args[0][1] != "c1" {
+		t.Fatalf("unexpected args: %v", db.args[0])
+	}
+}
+
+func TestProjector_IgnoresOtherEvents(t *testing.T) {
+	db := &recExec{}
+	for _, msg := range []string{
+		`{"event_id":"e1","event_type":"trips.confirmed","data":{"trip_id":"t1","carrier_id":"c1"}}`,
+		`{"event_id":"e2","event_type":"trips.assigned","data":{"trip_id":"t1"}}`,
+	} {
+		if err := NewProjector(db).HandleEvent(context.Background(), "trips.assigned", nil, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.sqls) != 0 {
+		t.Fatalf("expected no writes, got %v", db.sqls)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"bel-parcel/services/notification-service/internal/config"
+	"bel-parcel/services/notification-service/internal/infra/db"
+	ikafka "bel-parcel/services/notification-service/internal/infra/kafka"
+	"bel-parcel/services/notification-service/internal/metrics"
+	"bel-parcel/services/notification-service/internal/notify"
+)
+
+func main() {
+	if os.Getenv("BP_CMD_TEST") == "1" {
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	setupLogger()
+	shutdownTracer := setupOTLP(cfg)
+	defer shutdownTracer()
+
+	pool, err := db.NewPgxPool(cfg.DB.DSN)
+	if err != nil {
+		slog.Error("failed to connect to DB", "error", err)
+		os.Exit(1)
+	}
+	defer pool.Close()
+	if err := notify.EnsureSchema(pool); err != nil {
+		slog.Error("failed to ensure notification schema", "error", err)
+		os.Exit(1)
+	}
+
+	loc, err := time.LoadLocation(cfg.Notify.TimeZone)
+	if err != nil {
+		slog.Error("invalid time zone", "time_zone", cfg.Notify.TimeZone, "error", err)
+		os.Exit(1)
+	}
+	templates, err := notify.NewTemplates(cfg.Notify.DefaultLocale)
+	if err != nil {
+		slog.Error("failed to load templates", "error", err)
+		os.Exit(1)
+	}
+	channels := map[string]notify.Channel{}
+	if cfg.SMTP.Host != "" {
+		channels[notify.ChannelEmail] = notify.NewSMTPSender(notify.SMTPConfig{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+			Timeout:  cfg.SMTP.Timeout,
+		})
+	} else {
+		slog.Warn("SMTP host not set, email notifications disabled")
+	}
+	if cfg.SMS.URL != "" {
+		channels[notify.ChannelSMS] = notify.NewHTTPSMSGateway(notify.SMSConfig{
+			URL:     cfg.SMS.URL,
+			APIKey:  cfg.SMS.APIKey,
+			Sender:  cfg.SMS.Sender,
+			Timeout: cfg.SMS.Timeout,
+		})
+	} else {
+		slog.Warn("SMS gateway URL not set, SMS notifications disabled")
+	}
+	svc := notify.NewService(pool, channels, templates, notify.Options{
+		Location:    loc,
+		Quiet:       notify.QuietHours{Start: cfg.Notify.QuietHoursStart, End: cfg.Notify.QuietHoursEnd},
+		MaxAttempts: cfg.Notify.MaxAttempts,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	consumer := ikafka.NewConsumer(cfg.Kafka.Brokers, cfg.Kafka.GroupID, cfg.Kafka.ConsumeTopics, cfg.Kafka.Timeout)
+	defer consumer.Close()
+	consumer.Start(ctx, func(topic string, key, value []byte) error {
+		if err := svc.HandleEvent(ctx, topic, key, value); err != nil {
+			slog.Error("event handling failed", "topic", topic, "error", err)
+			return err
+		}
+		return nil
+	})
+	// Sends everything due, deferred and retried notifications included;
+	// replicas share the work through SKIP LOCKED.
+	go svc.RunDispatcher(ctx, cfg.Notify.DispatchInterval)
+
+	mux := http.NewServeMux()
+	metrics.Init(mux)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := pool.Ping(r.Context()); err != nil {
+			slog.Error("readiness check failed", "error", err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("GET /notifications", func(w http.ResponseWriter, r *http.Request) {
+		list, err := svc.DeliveryLog(r.Context(), r.URL.Query().Get("order_id"))
+		if err != nil {
+			slog.Error("failed to read delivery log", "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	})
+
+	srv := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: mux,
+	}
+
+	go func() {
+		slog.Info("starting HTTP server", "port", cfg.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server failed", "error", err)
+		}
+	}()
+
+	// Graceful shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	<-sigCh
+
+	slog.Info("shutting down gracefully...")
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server shutdown failed", "error", err)
+	}
+
+	slog.Info("server stopped")
+}
+
+func setupLogger() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	})))
+}
+
+func setupOTLP(cfg *config.Config) func() {
+	if cfg.OTLP.Endpoint == "" {
+		slog.Warn("OTLP endpoint not set, tracing disabled")
+		return func() {}
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(cfg.OTLP.Endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		slog.Error("failed to create OTLP exporter", "error", err)
+		return func() {}
+	}
+
+	res, _ := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("notification-service"),
+		),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(provider)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = provider.Shutdown(ctx)
+	}
+}
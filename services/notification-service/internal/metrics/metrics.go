@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	NotificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notifications_total",
+			Help: "Customer notifications by channel and final status",
+		},
+		[]string{"channel", "status"},
+	)
+)
+
+func Init(mux *http.ServeMux) {
+	prometheus.MustRegister(NotificationsTotal)
+	mux.Handle("/metrics", promhttp.Handler())
+}
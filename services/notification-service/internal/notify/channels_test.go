@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPSMSGateway_Send(t *testing.T) {
+	var got map[string]string
+	var authHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got["to"] == "+375000000000" {
+			http.Error(w, "unknown subscriber", http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	g := NewHTTPSMSGateway(SMSConfig{URL: srv.URL, APIKey: "k", Sender: "BelParcel", Timeout: time.Second})
+	if err := g.Send(context.Background(), Message{To: "+375291112233", Body: "Код: 123456"}); err != nil {
+		t.Fatal(err)
+	}
+	if authHeader != "Bearer k" || got["sender"] != "BelParcel" || got["text"] != "Код: 123456" {
+		t.Fatalf("unexpected request %v %q", got, authHeader)
+	}
+	err := g.Send(context.Background(), Message{To: "+375000000000", Body: "x"})
+	if err == nil || !strings.Contains(err.Error(), "422") {
+		t.Fatalf("expected gateway error, got %v", err)
+	}
+}
+
+// fakeSMTP accepts one message and hands its DATA section to the test.
+func fakeSMTP(t *testing.T) (string, int, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	data := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				reply("354 go ahead")
+				var b strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					b.WriteString(l)
+				}
+				data <- b.String()
+				reply("250 queued")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, data
+}
+
+func TestSMTPSender_Send(t *testing.T) {
+	host, port, data := fakeSMTP(t)
+	s := NewSMTPSender(SMTPConfig{Host: host, Port: port, From: "no-reply@bel-parcel.by"})
+	if err := s.Send(context.Background(), Message{To: "a@example.com", Subject: "Заказ o1", Body: "Код: 123456"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-data:
+		if !strings.Contains(msg, "To: a@example.com") || !strings.Contains(msg, "charset=UTF-8") || !strings.Contains(msg, "Код: 123456") {
+			t.Fatalf("unexpected message %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no message received")
+	}
+	if err := s.Send(context.Background(), Message{To: "a@example.com\r\nBcc: x@example.com"}); err == nil {
+		t.Fatalf("expected header injection to be rejected")
+	}
+}
+
+func TestSMTPSender_TimesOutOnSilentRelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Never greets.
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	s := NewSMTPSender(SMTPConfig{Host: addr.IP.String(), Port: addr.Port, From: "no-reply@bel-parcel.by", Timeout: 100 * time.Millisecond})
+	start := time.Now()
+	if err := s.Send(context.Background(), Message{To: "a@example.com", Body: "x"}); err == nil {
+		t.Fatalf("expected a timeout")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("send was not bounded by the timeout")
+	}
+}
@@ -0,0 +1,34 @@
+package notify
+
+import "time"
+
+// QuietHours is a daily window in local time during which nothing is sent.
+// Start and End are hours of the day; the window may wrap midnight, and
+// Start == End disables it.
+type QuietHours struct {
+	Start int
+	End   int
+}
+
+func (q QuietHours) contains(hour int) bool {
+	if q.Start == q.End {
+		return false
+	}
+	if q.Start < q.End {
+		return hour >= q.Start && hour < q.End
+	}
+	return hour >= q.Start || hour < q.End
+}
+
+// NextSendTime returns now, or the end of the quiet window now falls into.
+func (q QuietHours) NextSendTime(now time.Time, loc *time.Location) time.Time {
+	local := now.In(loc)
+	if !q.contains(local.Hour()) {
+		return now
+	}
+	end := time.Date(local.Year(), local.Month(), local.Day(), q.End, 0, 0, 0, loc)
+	if !end.After(local) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end.UTC()
+}
@@ -0,0 +1,29 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHours_NextSendTime(t *testing.T) {
+	loc := time.FixedZone("MSK", 3*3600)
+	q := QuietHours{Start: 22, End: 8}
+	cases := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 3, 1, 12, 0, 0, 0, loc), time.Date(2026, 3, 1, 12, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 1, 23, 30, 0, 0, loc), time.Date(2026, 3, 2, 8, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 2, 3, 0, 0, 0, loc), time.Date(2026, 3, 2, 8, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 2, 8, 0, 0, 0, loc), time.Date(2026, 3, 2, 8, 0, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		if got := q.NextSendTime(c.now.UTC(), loc); !got.Equal(c.want) {
+			t.Fatalf("now %s: expected %s, got %s", c.now, c.want, got)
+		}
+	}
+	now := time.Date(2026, 3, 1, 23, 30, 0, 0, loc)
+	if got := (QuietHours{}).NextSendTime(now, loc); !got.Equal(now) {
+		t.Fatalf("disabled quiet hours must not defer, got %s", got)
+	}
+}
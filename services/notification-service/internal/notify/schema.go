@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func EnsureSchema(db Execer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS processed_events (
+			event_id TEXT PRIMARY KEY,
+			occurred_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS order_contacts (
+			order_id TEXT PRIMARY KEY,
+			customer_phone TEXT,
+			customer_email TEXT,
+			locale TEXT,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS batch_orders (
+			batch_id TEXT NOT NULL,
+			order_id TEXT NOT NULL,
+			PRIMARY KEY (batch_id, order_id)
+		);
+		CREATE TABLE IF NOT EXISTS trip_batches (
+			trip_id TEXT NOT NULL,
+			batch_id TEXT NOT NULL,
+			PRIMARY KEY (trip_id, batch_id)
+		);
+		CREATE TABLE IF NOT EXISTS notification_log (
+			id UUID PRIMARY KEY,
+			dedup_key TEXT NOT NULL,
+			order_id TEXT NOT NULL,
+			channel TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			locale TEXT NOT NULL,
+			template TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			body TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			send_after TIMESTAMPTZ NOT NULL,
+			sent_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (dedup_key, channel)
+		);
+		DROP INDEX IF EXISTS idx_notification_log_due;
+		CREATE INDEX IF NOT EXISTS idx_notification_log_due ON notification_log(send_after) WHERE status IN ('pending', 'sending');
+		CREATE INDEX IF NOT EXISTS idx_notification_log_order ON notification_log(order_id);
+	`)
+	return err
+}
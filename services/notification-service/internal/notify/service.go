@@ -0,0 +1,524 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/notification-service/internal/metrics"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const dispatchBatch = 50
+
+// claimLease is how long a claimed notification belongs to the dispatcher
+// that claimed it. One that dies mid-send leaves it to be claimed again once
+// the lease is over.
+const claimLease = 5 * time.Minute
+
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type Options struct {
+	Location    *time.Location
+	Quiet       QuietHours
+	MaxAttempts int
+}
+
+// Service turns order events into customer notifications. Every message is
+// first written to notification_log, keyed by a de-duplication key per
+// channel, and sent by Dispatch once it is due. Event handling never waits
+// for a provider; it only wakes the dispatcher.
+type Service struct {
+	db          DB
+	channels    map[string]Channel
+	templates   *Templates
+	loc         *time.Location
+	quiet       QuietHours
+	maxAttempts int
+	now         func() time.Time
+	wake        chan struct{}
+}
+
+func NewService(db DB, channels map[string]Channel, templates *Templates, opts Options) *Service {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	return &Service{
+		db:          db,
+		channels:    channels,
+		templates:   templates,
+		loc:         loc,
+		quiet:       opts.Quiet,
+		maxAttempts: maxAttempts,
+		now:         func() time.Time { return time.Now().UTC() },
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+type contact struct {
+	OrderID       string `json:"order_id"`
+	CustomerPhone string `json:"customer_phone"`
+	CustomerEmail string `json:"customer_email"`
+	Locale        string `json:"locale"`
+}
+
+// notification is one message about one order, before it is fanned out to
+// the customer's channels.
+type notification struct {
+	orderID  string
+	template string
+	dedupKey string
+	data     map[string]string
+}
+
+// HandleEvent records what the event means for notifications. The processed
+// mark commits with everything the event writes, so a failed event is
+// redelivered whole; the dispatcher is woken only after the commit.
+func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []byte) error {
+	var envelope struct {
+		EventID   string          `json:"event_id"`
+		EventType string          `json:"event_type"`
+		Data      json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if ok, err := markProcessedTx(ctx, tx, envelope.EventID); err != nil || !ok {
+		return err
+	}
+	due, err := s.handleEventTx(ctx, tx, envelope.EventType, envelope.Data)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	if due {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// handleEventTx reports whether it queued a notification that is already
+// due.
+func (s *Service) handleEventTx(ctx context.Context, tx pgx.Tx, eventType string, raw json.RawMessage) (bool, error) {
+	switch eventType {
+	case "batches.formed":
+		return false, s.handleBatchFormed(ctx, tx, raw)
+	case "trips.assigned":
+		var data struct {
+			TripID  string `json:"trip_id"`
+			BatchID string `json:"batch_id"`
+		}
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return false, err
+		}
+		if data.TripID == "" || data.BatchID == "" {
+			return false, nil
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2) ON CONFLICT DO NOTHING
+		`, data.TripID, data.BatchID)
+		return false, err
+	case "orders.status_updated":
+		var data struct {
+			OrderID   string `json:"order_id"`
+			NewStatus string `json:"new_status"`
+		}
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return false, err
+		}
+		tmpl := "status." + data.NewStatus
+		if !s.templates.Has(tmpl) {
+			return false, nil
+		}
+		return s.notify(ctx, tx, notification{
+			orderID:  data.OrderID,
+			template: tmpl,
+			dedupKey: data.OrderID + "/" + tmpl,
+			data:     map[string]string{"OrderID": data.OrderID},
+		})
+	case "events.trip_eta_updated":
+		return s.handleETAUpdate(ctx, tx, raw)
+	case "notifications.pickup_code", "notifications.storage_expiry_reminder":
+		var data struct {
+			contact
+			PickupCode       string     `json:"pickup_code"`
+			StorageExpiresAt *time.Time `json:"storage_expires_at"`
+			IssuedAt         time.Time  `json:"issued_at"`
+		}
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return false, err
+		}
+		if err := storeContact(ctx, tx, data.contact); err != nil {
+			return false, err
+		}
+		n := notification{
+			orderID: data.OrderID,
+			data:    map[string]string{"OrderID": data.OrderID},
+		}
+		if data.StorageExpiresAt != nil {
+			n.data["StorageExpiresAt"] = s.formatTime(*data.StorageExpiresAt)
+		}
+		if eventType == "notifications.pickup_code" {
+			n.template = TemplatePickupCode
+			n.dedupKey = data.OrderID + "/" + TemplatePickupCode + "/" + data.IssuedAt.UTC().Format(time.RFC3339)
+			n.data["PickupCode"] = data.PickupCode
+		} else {
+			n.template = TemplateStorageReminder
+			n.dedupKey = data.OrderID + "/" + TemplateStorageReminder + "/" + n.data["StorageExpiresAt"]
+		}
+		return s.notify(ctx, tx, n)
+	default:
+		return false, nil
+	}
+}
+
+func markProcessedTx(ctx context.Context, tx pgx.Tx, eventID string) (bool, error) {
+	var id string
+	err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, occurred_at) VALUES ($1, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, eventID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return id != "", nil
+}
+
+// handleBatchFormed keeps the customer contacts batching passes along and
+// which orders travel in the batch, for ETA updates of its trip. Return
+// batches are skipped: their customers are not waiting for a delivery.
+func (s *Service) handleBatchFormed(ctx context.Context, tx pgx.Tx, raw json.RawMessage) error {
+	var data struct {
+		BatchID         string    `json:"batch_id"`
+		DestinationType string    `json:"destination_type"`
+		OrderIDs        []string  `json:"order_ids"`
+		OrderContacts   []contact `json:"order_contacts"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	if data.DestinationType == "warehouse" {
+		return nil
+	}
+	for _, c := range data.OrderContacts {
+		if err := storeContact(ctx, tx, c); err != nil {
+			return err
+		}
+	}
+	for _, oid := range data.OrderIDs {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO batch_orders (batch_id, order_id) VALUES ($1, $2) ON CONFLICT DO NOTHING
+		`, data.BatchID, oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func storeContact(ctx context.Context, tx pgx.Tx, c contact) error {
+	if c.OrderID == "" || (c.CustomerPhone == "" && c.CustomerEmail == "") {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO order_contacts (order_id, customer_phone, customer_email, locale, updated_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NOW())
+		ON CONFLICT (order_id) DO UPDATE SET
+			customer_phone = COALESCE(EXCLUDED.customer_phone, order_contacts.customer_phone),
+			customer_email = COALESCE(EXCLUDED.customer_email, order_contacts.customer_email),
+			locale = COALESCE(EXCLUDED.locale, order_contacts.locale),
+			updated_at = NOW()
+	`, c.OrderID, c.CustomerPhone, c.CustomerEmail, c.Locale)
+	return err
+}
+
+// handleETAUpdate notifies every order of the trip's batches. The ETA is
+// rounded to 15 minutes for de-duplication, so small corrections are not
+// sent again.
+func (s *Service) handleETAUpdate(ctx context.Context, tx pgx.Tx, raw json.RawMessage) (bool, error) {
+	var data struct {
+		TripID           string    `json:"trip_id"`
+		EstimatedArrival time.Time `json:"estimated_arrival"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return false, err
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT DISTINCT bo.order_id FROM trip_batches tb JOIN batch_orders bo ON bo.batch_id = tb.batch_id
+		WHERE tb.trip_id = $1
+	`, data.TripID)
+	if err != nil {
+		return false, err
+	}
+	var orderIDs []string
+	for rows.Next() {
+		var oid string
+		if err := rows.Scan(&oid); err != nil {
+			rows.Close()
+			return false, err
+		}
+		orderIDs = append(orderIDs, oid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	eta := data.EstimatedArrival.Round(15 * time.Minute)
+	due := false
+	for _, oid := range orderIDs {
+		queued, err := s.notify(ctx, tx, notification{
+			orderID:  oid,
+			template: TemplateETA,
+			dedupKey: oid + "/" + TemplateETA + "/" + eta.UTC().Format(time.RFC3339),
+			data:     map[string]string{"OrderID": oid, "ETA": s.formatTime(eta)},
+		})
+		if err != nil {
+			return false, err
+		}
+		due = due || queued
+	}
+	return due, nil
+}
+
+func (s *Service) formatTime(t time.Time) string {
+	return t.In(s.loc).Format("02.01.2006 15:04")
+}
+
+// notify renders the notification for every channel the customer can be
+// reached on and queues it, deferred past quiet hours. It reports whether
+// anything was queued to be sent right away.
+func (s *Service) notify(ctx context.Context, tx pgx.Tx, n notification) (bool, error) {
+	if n.orderID == "" {
+		return false, nil
+	}
+	var phone, email, locale string
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(customer_phone, ''), COALESCE(customer_email, ''), COALESCE(locale, '')
+		FROM order_contacts WHERE order_id = $1
+	`, n.orderID).Scan(&phone, &email, &locale)
+	if errors.Is(err, pgx.ErrNoRows) {
+		slog.Debug("no contacts for order, notification skipped", "order_id", n.orderID, "template", n.template)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	now := s.now()
+	sendAfter := s.quiet.NextSendTime(now, s.loc)
+	queued := false
+	for channel, recipient := range map[string]string{ChannelSMS: phone, ChannelEmail: email} {
+		if recipient == "" {
+			continue
+		}
+		if _, ok := s.channels[channel]; !ok {
+			continue
+		}
+		usedLocale, subject, body, err := s.templates.Render(n.template, locale, n.data)
+		if err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO notification_log (id, dedup_key, order_id, channel, recipient, locale, template, subject, body, status, send_after, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'pending', $10, $11)
+			ON CONFLICT (dedup_key, channel) DO NOTHING
+		`, uuid.NewString(), n.dedupKey, n.orderID, channel, recipient, usedLocale, n.template, subject, body, sendAfter, now); err != nil {
+			return false, err
+		}
+		queued = true
+	}
+	return queued && !sendAfter.After(now), nil
+}
+
+// RunDispatcher sends due notifications every interval, and right away when
+// HandleEvent queues one that is already due. It returns when ctx is done.
+func (s *Service) RunDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		if err := s.Dispatch(ctx); err != nil {
+			slog.Error("notification dispatch failed", "error", err)
+		}
+	}
+}
+
+type dueNotification struct {
+	id, channel string
+	msg         Message
+	attempts    int
+}
+
+// Dispatch sends due notifications. They are claimed and the claim committed
+// before anything is sent, so no transaction or row lock is held while a
+// provider answers. Failed sends are retried with a growing delay until
+// MaxAttempts, then marked failed.
+func (s *Service) Dispatch(ctx context.Context) error {
+	batch, err := s.claimDue(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, d := range batch {
+		if err := s.send(ctx, d); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// claimDue marks up to dispatchBatch due notifications as being sent by this
+// dispatcher. The attempt is counted here, so a notification that keeps
+// crashing its dispatcher still runs out of attempts.
+func (s *Service) claimDue(ctx context.Context) ([]dueNotification, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	now := s.now()
+	rows, err := tx.Query(ctx, `
+		SELECT id::text, channel, recipient, subject, body, attempts
+		FROM notification_log
+		WHERE status IN ('pending', 'sending') AND send_after <= $1
+		ORDER BY send_after
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, now, dispatchBatch)
+	if err != nil {
+		return nil, err
+	}
+	var batch []dueNotification
+	var ids []string
+	for rows.Next() {
+		var d dueNotification
+		if err := rows.Scan(&d.id, &d.channel, &d.msg.To, &d.msg.Subject, &d.msg.Body, &d.attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.attempts++
+		batch = append(batch, d)
+		ids = append(ids, d.id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(batch) == 0 {
+		return nil, nil
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE notification_log SET status='sending', attempts=attempts+1, send_after=$2 WHERE id = ANY($1::uuid[])
+	`, ids, now.Add(claimLease)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// send delivers one claimed notification and records the outcome, unless
+// the claim was lost to another dispatcher in the meantime.
+func (s *Service) send(ctx context.Context, d dueNotification) error {
+	ch, ok := s.channels[d.channel]
+	var sendErr error
+	if !ok {
+		sendErr = errors.New("channel not configured")
+	} else {
+		sendErr = ch.Send(ctx, d.msg)
+	}
+	now := s.now()
+	if sendErr == nil {
+		metrics.NotificationsTotal.WithLabelValues(d.channel, "sent").Inc()
+		_, err := s.db.Exec(ctx, `
+			UPDATE notification_log SET status='sent', sent_at=$3, last_error=NULL WHERE id=$1 AND status='sending' AND attempts=$2
+		`, d.id, d.attempts, now)
+		return err
+	}
+	slog.Warn("notification send failed", "id", d.id, "channel", d.channel, "attempt", d.attempts, "error", sendErr)
+	status := "pending"
+	if d.attempts >= s.maxAttempts {
+		status = "failed"
+		metrics.NotificationsTotal.WithLabelValues(d.channel, "failed").Inc()
+	}
+	// A retry that would fall into quiet hours waits for them to end, like
+	// a new notification
+	retryAt := s.quiet.NextSendTime(now.Add(time.Duration(d.attempts)*time.Minute), s.loc)
+	_, err := s.db.Exec(ctx, `
+		UPDATE notification_log SET status=$3, last_error=$4, send_after=$5 WHERE id=$1 AND status='sending' AND attempts=$2
+	`, d.id, d.attempts, status, sendErr.Error(), retryAt)
+	return err
+}
+
+// LogEntry is a delivery log row as shown to operators; the body is left out
+// because it may carry a pickup code.
+type LogEntry struct {
+	ID        string     `json:"id"`
+	OrderID   string     `json:"order_id"`
+	Channel   string     `json:"channel"`
+	Recipient string     `json:"recipient"`
+	Locale    string     `json:"locale"`
+	Template  string     `json:"template"`
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	SendAfter time.Time  `json:"send_after"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (s *Service) DeliveryLog(ctx context.Context, orderID string) ([]LogEntry, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id::text, order_id, channel, recipient, locale, template, status, attempts, COALESCE(last_error, ''), send_after, sent_at, created_at
+		FROM notification_log
+		WHERE $1 = '' OR order_id = $1
+		ORDER BY created_at DESC
+		LIMIT 200
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []LogEntry{}
+	for rows.Next() {
+		var e LogEntry
+		if err := rows.Scan(&e.ID, &e.OrderID, &e.Channel, &e.Recipient, &e.Locale, &e.Template, &e.Status, &e.Attempts, &e.LastError, &e.SendAfter, &e.SentAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,287 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type fakeRow struct{ vals []any }
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.vals == nil {
+		return pgx.ErrNoRows
+	}
+	for i := range dest {
+		*dest[i].(*string) = r.vals[i].(string)
+	}
+	return nil
+}
+
+// fakeDB answers the processed_events insert and the contacts lookup and
+// records every Exec. Without a tx of its own it begins eventTx over
+// itself.
+type fakeDB struct {
+	contacts  []any
+	execs     []string
+	args      [][]any
+	failOn    string
+	tx        pgx.Tx
+	committed bool
+}
+
+func (d *fakeDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	if d.tx == nil {
+		return &eventTx{db: d}, nil
+	}
+	return d.tx, nil
+}
+func (d *fakeDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	d.execs = append(d.execs, sql)
+	d.args = append(d.args, args)
+	if d.failOn != "" && strings.Contains(sql, d.failOn) {
+		return pgconn.CommandTag{}, errors.New("boom")
+	}
+	return pgconn.CommandTag{}, nil
+}
+func (d *fakeDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, context.Canceled
+}
+func (d *fakeDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	if strings.Contains(sql, "processed_events") {
+		return fakeRow{vals: []any{args[0]}}
+	}
+	return fakeRow{vals: d.contacts}
+}
+
+type eventTx struct {
+	pgx.Tx
+	db *fakeDB
+}
+
+func (tx *eventTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return tx.db.Exec(ctx, sql, args...)
+}
+func (tx *eventTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return tx.db.Query(ctx, sql, args...)
+}
+func (tx *eventTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return tx.db.QueryRow(ctx, sql, args...)
+}
+func (tx *eventTx) Commit(ctx context.Context) error   { tx.db.committed = true; return nil }
+func (tx *eventTx) Rollback(ctx context.Context) error { return nil }
+
+func testService(t *testing.T, db *fakeDB, quiet QuietHours) *Service {
+	t.Helper()
+	tmpl, err := NewTemplates("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(db, map[string]Channel{ChannelSMS: nil, ChannelEmail: nil}, tmpl, Options{Quiet: quiet})
+	s.now = func() time.Time { return time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC) }
+	return s
+}
+
+func event(t *testing.T, eventType string, data map[string]interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(map[string]interface{}{"event_id": "e1", "event_type": eventType, "data": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func logInserts(db *fakeDB) [][]any {
+	var out [][]any
+	for i, q := range db.execs {
+		if strings.Contains(q, "INSERT INTO notification_log") {
+			out = append(out, db.args[i])
+		}
+	}
+	return out
+}
+
+func TestHandleEvent_StatusWithoutTemplateIgnored(t *testing.T) {
+	db := &fakeDB{contacts: []any{"+375291112233", "", "ru"}}
+	s := testService(t, db, QuietHours{Start: 22, End: 8})
+	msg := event(t, "orders.status_updated", map[string]interface{}{"order_id": "o1", "new_status": "DELIVERED_TO_PVP"})
+	if err := s.HandleEvent(context.Background(), "orders.status_updated", nil, msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(logInserts(db)) != 0 {
+		t.Fatalf("internal statuses must not notify, got %v", db.execs)
+	}
+}
+
+func TestHandleEvent_PickupCodeDeferredDuringQuietHours(t *testing.T) {
+	db := &fakeDB{contacts: []any{"+375291112233", "a@example.com", "be"}}
+	s := testService(t, db, QuietHours{Start: 22, End: 8})
+	msg := event(t, "notifications.pickup_code", map[string]interface{}{
+		"order_id":       "o1",
+		"customer_phone": "+375291112233",
+		"pickup_code":    "123456",
+		"issued_at":      "2026-03-01T23:00:00Z",
+	})
+	if err := s.HandleEvent(context.Background(), "notifications.customer", nil, msg); err != nil {
+		t.Fatal(err)
+	}
+	inserts := logInserts(db)
+	if len(inserts) != 2 {
+		t.Fatalf("expected SMS and email queued, got %d", len(inserts))
+	}
+	for _, args := range inserts {
+		if args[5] != "be" || !strings.Contains(args[8].(string), "123456") {
+			t.Fatalf("unexpected rendering %v", args)
+		}
+		if sendAfter := args[9].(time.Time); !sendAfter.Equal(time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)) {
+			t.Fatalf("expected deferral to the end of quiet hours, got %s", sendAfter)
+		}
+	}
+}
+
+func TestHandleEvent_NoContactsSkipped(t *testing.T) {
+	db := &fakeDB{}
+	s := testService(t, db, QuietHours{})
+	msg := event(t, "orders.status_updated", map[string]interface{}{"order_id": "o1", "new_status": "BATCHED"})
+	if err := s.HandleEvent(context.Background(), "orders.status_updated", nil, msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(logInserts(db)) != 0 {
+		t.Fatalf("expected nothing queued without contacts")
+	}
+}
+
+func TestHandleEvent_DueNotificationWakesDispatcher(t *testing.T) {
+	db := &fakeDB{contacts: []any{"+375291112233", "", "ru"}}
+	s := testService(t, db, QuietHours{})
+	msg := event(t, "orders.status_updated", map[string]interface{}{"order_id": "o1", "new_status": "BATCHED"})
+	if err := s.HandleEvent(context.Background(), "orders.status_updated", nil, msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(logInserts(db)) != 1 {
+		t.Fatalf("expected the SMS queued, got %v", db.execs)
+	}
+	if !db.committed || len(s.wake) != 1 {
+		t.Fatalf("expected the dispatcher woken after the commit instead of sending inline")
+	}
+}
+
+func TestHandleEvent_FailedInsertLeavesEventUnprocessed(t *testing.T) {
+	db := &fakeDB{contacts: []any{"+375291112233", "", "ru"}, failOn: "INSERT INTO notification_log"}
+	s := testService(t, db, QuietHours{})
+	msg := event(t, "orders.status_updated", map[string]interface{}{"order_id": "o1", "new_status": "BATCHED"})
+	if err := s.HandleEvent(context.Background(), "orders.status_updated", nil, msg); err == nil {
+		t.Fatalf("expected the failed insert to be returned")
+	}
+	if db.committed {
+		t.Fatalf("the processed mark must not commit without the notification")
+	}
+	if len(s.wake) != 0 {
+		t.Fatalf("nothing was queued, the dispatcher must not be woken")
+	}
+}
+
+type dueRows struct {
+	pgx.Rows
+	vals [][]any
+	i    int
+}
+
+func (r *dueRows) Next() bool { r.i++; return r.i <= len(r.vals) }
+func (r *dueRows) Scan(dest ...any) error {
+	for i, v := range r.vals[r.i-1] {
+		switch d := dest[i].(type) {
+		case *string:
+			*d = v.(string)
+		case *int:
+			*d = v.(int)
+		}
+	}
+	return nil
+}
+func (r *dueRows) Close()     {}
+func (r *dueRows) Err() error { return nil }
+
+// claimTx hands out the due rows and remembers whether the claim was
+// committed.
+type claimTx struct {
+	pgx.Tx
+	rows      [][]any
+	execs     []string
+	committed bool
+}
+
+func (tx *claimTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return &dueRows{vals: tx.rows}, nil
+}
+func (tx *claimTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	tx.execs = append(tx.execs, sql)
+	return pgconn.CommandTag{}, nil
+}
+func (tx *claimTx) Commit(ctx context.Context) error   { tx.committed = true; return nil }
+func (tx *claimTx) Rollback(ctx context.Context) error { return nil }
+
+type channelFunc func(ctx context.Context, msg Message) error
+
+func (f channelFunc) Send(ctx context.Context, msg Message) error { return f(ctx, msg) }
+
+func TestDispatch_SendsAfterClaimCommitted(t *testing.T) {
+	tx := &claimTx{rows: [][]any{{"n1", ChannelSMS, "+375291112233", "", "Заказ o1", 0}}}
+	db := &fakeDB{tx: tx}
+	tmpl, err := NewTemplates("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	sms := channelFunc(func(ctx context.Context, msg Message) error {
+		if !tx.committed {
+			t.Fatalf("sent while the claim transaction was open")
+		}
+		sent = append(sent, msg.To)
+		return nil
+	})
+	s := NewService(db, map[string]Channel{ChannelSMS: sms}, tmpl, Options{})
+	if err := s.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.execs) != 1 || !strings.Contains(tx.execs[0], "status='sending'") {
+		t.Fatalf("expected the row claimed, got %v", tx.execs)
+	}
+	if len(sent) != 1 || sent[0] != "+375291112233" {
+		t.Fatalf("unexpected sends %v", sent)
+	}
+	if len(db.execs) != 1 || !strings.Contains(db.execs[0], "status='sent'") || !strings.Contains(db.execs[0], "status='sending'") {
+		t.Fatalf("expected the claimed row marked sent, got %v", db.execs)
+	}
+	if db.args[0][0] != "n1" || db.args[0][1] != 1 {
+		t.Fatalf("expected the claim's attempt checked, got %v", db.args[0])
+	}
+}
+
+func TestDispatch_RetryDeferredPastQuietHours(t *testing.T) {
+	tx := &claimTx{rows: [][]any{{"n1", ChannelSMS, "+375291112233", "", "Заказ o1", 0}}}
+	db := &fakeDB{tx: tx}
+	tmpl, err := NewTemplates("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sms := channelFunc(func(ctx context.Context, msg Message) error { return errors.New("gateway down") })
+	s := NewService(db, map[string]Channel{ChannelSMS: sms}, tmpl, Options{Quiet: QuietHours{Start: 22, End: 8}})
+	// 21:59, the one-minute retry would land in quiet hours
+	s.now = func() time.Time { return time.Date(2026, 3, 1, 21, 59, 30, 0, time.UTC) }
+	if err := s.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.args) != 1 {
+		t.Fatalf("expected the failure recorded, got %v", db.execs)
+	}
+	if retryAt := db.args[0][4].(time.Time); !retryAt.Equal(time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the retry after quiet hours, got %s", retryAt)
+	}
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Supported locales. Customers without a known locale get the default one.
+var Locales = []string{"ru", "be", "en"}
+
+// Template keys. Status updates use "status." plus the order status.
+const (
+	TemplatePickupCode      = "pickup_code"
+	TemplateStorageReminder = "storage_reminder"
+	TemplateETA             = "eta"
+)
+
+type templateText struct {
+	Subject string
+	Body    string
+}
+
+var templateTexts = map[string]map[string]templateText{
+	"status.BATCHED": {
+		"ru": {"Заказ {{.OrderID}} передан в доставку", "Ваш заказ {{.OrderID}} передан в доставку. Мы сообщим, когда он прибудет в пункт выдачи."},
+		"be": {"Заказ {{.OrderID}} перададзены ў дастаўку", "Ваш заказ {{.OrderID}} перададзены ў дастаўку. Мы паведамім, калі ён прыбудзе ў пункт выдачы."},
+		"en": {"Order {{.OrderID}} is on its way", "Your order {{.OrderID}} has been handed over for delivery. We will let you know when it reaches the pickup point."},
+	},
+	"status.DELIVERED_TO_CUSTOMER": {
+		"ru": {"Заказ {{.OrderID}} выдан", "Заказ {{.OrderID}} выдан. Спасибо, что пользуетесь BelParcel!"},
+		"be": {"Заказ {{.OrderID}} выдадзены", "Заказ {{.OrderID}} выдадзены. Дзякуй, што карыстаецеся BelParcel!"},
+		"en": {"Order {{.OrderID}} collected", "Order {{.OrderID}} has been collected. Thank you for using BelParcel!"},
+	},
+	"status.RETURNING": {
+		"ru": {"Заказ {{.OrderID}} возвращается продавцу", "Заказ {{.OrderID}} возвращается продавцу."},
+		"be": {"Заказ {{.OrderID}} вяртаецца прадаўцу", "Заказ {{.OrderID}} вяртаецца прадаўцу."},
+		"en": {"Order {{.OrderID}} is being returned", "Order {{.OrderID}} is being returned to the seller."},
+	},
+	"status.RETURNED_TO_SELLER": {
+		"ru": {"Заказ {{.OrderID}} возвращён продавцу", "Заказ {{.OrderID}} возвращён продавцу."},
+		"be": {"Заказ {{.OrderID}} вернуты прадаўцу", "Заказ {{.OrderID}} вернуты прадаўцу."},
+		"en": {"Order {{.OrderID}} returned", "Order {{.OrderID}} has been returned to the seller."},
+	},
+	TemplateETA: {
+		"ru": {"Заказ {{.OrderID}}: новое время прибытия", "Ожидаемое время прибытия заказа {{.OrderID}} в пункт выдачи: {{.ETA}}."},
+		"be": {"Заказ {{.OrderID}}: новы час прыбыцця", "Чаканы час прыбыцця заказа {{.OrderID}} у пункт выдачы: {{.ETA}}."},
+		"en": {"Order {{.OrderID}}: new arrival time", "Order {{.OrderID}} is expected at the pickup point at {{.ETA}}."},
+	},
+	TemplatePickupCode: {
+		"ru": {"Заказ {{.OrderID}} ждёт вас", "Заказ {{.OrderID}} прибыл в пункт выдачи. Код получения: {{.PickupCode}}.{{if .StorageExpiresAt}} Срок хранения до {{.StorageExpiresAt}}.{{end}}"},
+		"be": {"Заказ {{.OrderID}} чакае вас", "Заказ {{.OrderID}} прыбыў у пункт выдачы. Код атрымання: {{.PickupCode}}.{{if .StorageExpiresAt}} Тэрмін захоўвання да {{.StorageExpiresAt}}.{{end}}"},
+		"en": {"Order {{.OrderID}} is ready for pickup", "Order {{.OrderID}} has arrived at the pickup point. Pickup code: {{.PickupCode}}.{{if .StorageExpiresAt}} Kept until {{.StorageExpiresAt}}.{{end}}"},
+	},
+	TemplateStorageReminder: {
+		"ru": {"Срок хранения заказа {{.OrderID}} заканчивается", "Заказ {{.OrderID}} хранится в пункте выдачи до {{.StorageExpiresAt}}. После этого он будет возвращён продавцу."},
+		"be": {"Тэрмін захоўвання заказа {{.OrderID}} сканчаецца", "Заказ {{.OrderID}} захоўваецца ў пункце выдачы да {{.StorageExpiresAt}}. Пасля гэтага ён будзе вернуты прадаўцу."},
+		"en": {"Order {{.OrderID}}: storage period ends soon", "Order {{.OrderID}} is kept at the pickup point until {{.StorageExpiresAt}}. After that it will be returned to the seller."},
+	},
+}
+
+type compiled struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// Templates renders the localized notification texts.
+type Templates struct {
+	defaultLocale string
+	byKey         map[string]map[string]compiled
+}
+
+func NewTemplates(defaultLocale string) (*Templates, error) {
+	if !supportedLocale(defaultLocale) {
+		return nil, fmt.Errorf("unsupported default locale %q", defaultLocale)
+	}
+	t := &Templates{defaultLocale: defaultLocale, byKey: map[string]map[string]compiled{}}
+	for key, locales := range templateTexts {
+		t.byKey[key] = map[string]compiled{}
+		for locale, text := range locales {
+			subject, err := template.New(key + "." + locale + ".subject").Parse(text.Subject)
+			if err != nil {
+				return nil, err
+			}
+			body, err := template.New(key + "." + locale + ".body").Parse(text.Body)
+			if err != nil {
+				return nil, err
+			}
+			t.byKey[key][locale] = compiled{subject: subject, body: body}
+		}
+	}
+	return t, nil
+}
+
+// Has reports whether customers are notified about this key at all.
+func (t *Templates) Has(key string) bool {
+	_, ok := t.byKey[key]
+	return ok
+}
+
+// Render returns subject and body in the given locale, falling back to the
+// default locale.
+func (t *Templates) Render(key, locale string, data map[string]string) (string, string, string, error) {
+	locales, ok := t.byKey[key]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown template %q", key)
+	}
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	c, ok := locales[locale]
+	if !ok {
+		locale = t.defaultLocale
+		c = locales[locale]
+	}
+	var subject, body bytes.Buffer
+	if err := c.subject.Execute(&subject, data); err != nil {
+		return "", "", "", err
+	}
+	if err := c.body.Execute(&body, data); err != nil {
+		return "", "", "", err
+	}
+	return locale, subject.String(), body.String(), nil
+}
+
+func supportedLocale(locale string) bool {
+	for _, l := range Locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplates_RenderAllLocales(t *testing.T) {
+	tmpl, err := NewTemplates("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{"OrderID": "o1", "PickupCode": "123456", "StorageExpiresAt": "01.02.2026 10:00", "ETA": "01.02.2026 12:00"}
+	for key, locales := range templateTexts {
+		for _, locale := range Locales {
+			if _, ok := locales[locale]; !ok {
+				t.Fatalf("template %s has no %s text", key, locale)
+			}
+			used, subject, body, err := tmpl.Render(key, locale, data)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", key, locale, err)
+			}
+			if used != locale || !strings.Contains(subject, "o1") || !strings.Contains(body, "o1") || strings.Contains(body, "<no value>") {
+				t.Fatalf("%s/%s rendered %q / %q", key, locale, subject, body)
+			}
+		}
+	}
+}
+
+func TestTemplates_UnknownLocaleFallsBack(t *testing.T) {
+	tmpl, err := NewTemplates("be")
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, _, body, err := tmpl.Render(TemplatePickupCode, "de", map[string]string{"OrderID": "o1", "PickupCode": "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != "be" || !strings.Contains(body, "Код атрымання: 123456.") || strings.Contains(body, "Тэрмін") {
+		t.Fatalf("unexpected fallback rendering %s %q", used, body)
+	}
+	if _, err := NewTemplates("de"); err == nil {
+		t.Fatalf("expected unsupported default locale to be rejected")
+	}
+}
@@ -0,0 +1,7 @@
+-- Rollback for 001_init.up.sql
+
+DROP TABLE IF EXISTS notification_log;
+DROP TABLE IF EXISTS trip_batches;
+DROP TABLE IF EXISTS batch_orders;
+DROP TABLE IF EXISTS order_contacts;
+DROP TABLE IF EXISTS processed_events;
@@ -0,0 +1,48 @@
+-- Обработанные события (идемпотентность)
+CREATE TABLE IF NOT EXISTS processed_events (
+    event_id TEXT PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL
+);
+
+-- Контакты и язык покупателя по заказу
+CREATE TABLE IF NOT EXISTS order_contacts (
+    order_id TEXT PRIMARY KEY,
+    customer_phone TEXT,
+    customer_email TEXT,
+    locale TEXT,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Состав партий и рейсов для уведомлений об ETA
+CREATE TABLE IF NOT EXISTS batch_orders (
+    batch_id TEXT NOT NULL,
+    order_id TEXT NOT NULL,
+    PRIMARY KEY (batch_id, order_id)
+);
+CREATE TABLE IF NOT EXISTS trip_batches (
+    trip_id TEXT NOT NULL,
+    batch_id TEXT NOT NULL,
+    PRIMARY KEY (trip_id, batch_id)
+);
+
+-- Журнал доставки уведомлений; ключ дедупликации уникален для канала
+CREATE TABLE IF NOT EXISTS notification_log (
+    id UUID PRIMARY KEY,
+    dedup_key TEXT NOT NULL,
+    order_id TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    locale TEXT NOT NULL,
+    template TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    send_after TIMESTAMPTZ NOT NULL,
+    sent_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (dedup_key, channel)
+);
+CREATE INDEX IF NOT EXISTS idx_notification_log_due ON notification_log(send_after) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_notification_log_order ON notification_log(order_id);
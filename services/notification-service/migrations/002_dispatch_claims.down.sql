@@ -0,0 +1,5 @@
+-- Rollback for 002_dispatch_claims.up.sql
+
+UPDATE notification_log SET status = 'pending' WHERE status = 'sending';
+DROP INDEX IF EXISTS idx_notification_log_due;
+CREATE INDEX IF NOT EXISTS idx_notification_log_due ON notification_log(send_after) WHERE status = 'pending';
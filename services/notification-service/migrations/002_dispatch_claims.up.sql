@@ -0,0 +1,4 @@
+-- Отправка идёт вне транзакции: строка сначала захватывается (status = 'sending',
+-- send_after — конец аренды), поэтому выборка к отправке покрывает оба статуса
+DROP INDEX IF EXISTS idx_notification_log_due;
+CREATE INDEX IF NOT EXISTS idx_notification_log_due ON notification_log(send_after) WHERE status IN ('pending', 'sending');
@@ -19,6 +19,7 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"bel-parcel/pkg/blobstore"
 	"bel-parcel/services/operator-api/internal/app"
 	"bel-parcel/services/operator-api/internal/auth"
 	"bel-parcel/services/operator-api/internal/clients"
@@ -98,6 +99,22 @@ func main() {
 				trip_id TEXT,
 				updated_at TIMESTAMPTZ DEFAULT NOW()
 			);
+			CREATE TABLE IF NOT EXISTS delivery_proofs (
+				id TEXT PRIMARY KEY,
+				trip_id TEXT NOT NULL,
+				batch_id TEXT NOT NULL,
+				pvp_id TEXT NOT NULL,
+				carrier_id TEXT NOT NULL,
+				confirmed_by TEXT,
+				signature_key TEXT NOT NULL,
+				signature_content_type TEXT NOT NULL,
+				photo_key TEXT,
+				photo_content_type TEXT,
+				delivered_at TIMESTAMPTZ NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_delivery_proofs_trip ON delivery_proofs(trip_id, batch_id);
+			ALTER TABLE delivery_proofs DROP COLUMN IF EXISTS confirmation_code;
+			ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS confirmed_by TEXT;
 		`); err != nil {
 			slog.Error("failed to ensure cache schema", "error", err)
 			os.Exit(1)
@@ -118,7 +135,20 @@ func main() {
 		cfg.Services.ReferenceURL,
 	)
 
-	svc := app.NewService(operatorDB, cls, producer, cfg.Kafka.CommandTopic)
+	blobs, err := blobstore.New(blobstore.Config{
+		Driver:    cfg.Blob.Driver,
+		Dir:       cfg.Blob.Dir,
+		Endpoint:  cfg.Blob.Endpoint,
+		Region:    cfg.Blob.Region,
+		Bucket:    cfg.Blob.Bucket,
+		AccessKey: cfg.Blob.AccessKey,
+		SecretKey: cfg.Blob.SecretKey,
+	})
+	if err != nil {
+		slog.Error("failed to create blob store", "error", err)
+		os.Exit(1)
+	}
+	svc := app.NewService(operatorDB, cls, producer, cfg.Kafka.CommandTopic).WithTransshipmentTopic(cfg.Kafka.TransshipmentTopic).WithTripStatusTopic(cfg.Kafka.TripStatusTopic).WithBlobStore(blobs)
 	validator := auth.NewValidator(cfg.Auth.HS256Secret, cfg.Auth.Issuer, cfg.Auth.Audience)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -226,6 +256,7 @@ func main() {
 		var body struct {
 			NewCarrierID string `json:"new_carrier_id"`
 			Reason       string `json:"reason"`
+			SplitBy      string `json:"split_by"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			writeJSONError(w, http.StatusBadRequest, "bad request")
@@ -235,18 +266,99 @@ func main() {
 			writeJSONError(w, http.StatusBadRequest, "bad request")
 			return
 		}
+		if !app.ValidSplitBy(body.SplitBy) {
+			writeJSONError(w, http.StatusBadRequest, "split_by must be destination or capacity")
+			return
+		}
 		u := auth.FromContext(r)
 		if u == nil {
 			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
 			return
 		}
-		if err := svc.ReassignTrip(r.Context(), id, body.NewCarrierID, body.Reason, u.ID); err != nil {
+		if err := svc.ReassignTrip(r.Context(), id, body.NewCarrierID, body.Reason, body.SplitBy, u.ID); err != nil {
 			slog.Error("reassign failed", "error", err, "trip_id", id)
 			writeJSONError(w, http.StatusInternalServerError, "internal")
 			return
 		}
 		w.WriteHeader(http.StatusAccepted)
 	})))
+	tripStatusHandler := func(command string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			id := r.PathValue("trip_id")
+			var body struct {
+				Reason string `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "bad request")
+				return
+			}
+			if body.Reason == "" && command != app.TripResumeCommand {
+				writeJSONError(w, http.StatusBadRequest, "reason is required")
+				return
+			}
+			u := auth.FromContext(r)
+			if u == nil {
+				writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if err := svc.ChangeTripStatus(r.Context(), command, id, body.Reason, u.ID); err != nil {
+				slog.Error("trip status command failed", "error", err, "trip_id", id, "command", command)
+				writeJSONError(w, http.StatusInternalServerError, "internal")
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}
+	mux.HandleFunc("POST /trips/{trip_id}/cancel", measure("/trips/{trip_id}/cancel", auth.RequireRoles(validator, []string{"moderator", "admin"}, tripStatusHandler(app.TripCancelCommand))))
+	mux.HandleFunc("POST /trips/{trip_id}/hold", measure("/trips/{trip_id}/hold", auth.RequireRoles(validator, []string{"moderator", "admin"}, tripStatusHandler(app.TripHoldCommand))))
+	mux.HandleFunc("POST /trips/{trip_id}/resume", measure("/trips/{trip_id}/resume", auth.RequireRoles(validator, []string{"moderator", "admin"}, tripStatusHandler(app.TripResumeCommand))))
+	mux.HandleFunc("GET /trips/{trip_id}/history", measure("/trips/{trip_id}/history", auth.RequireRoles(validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("trip_id")
+		history, err := svc.TripHistory(r.Context(), id)
+		if err != nil {
+			slog.Error("trip history failed", "error", err, "trip_id", id)
+			writeJSONError(w, http.StatusInternalServerError, "internal")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	})))
+	mux.HandleFunc("GET /delivery-proofs", measure("/delivery-proofs", auth.RequireRoles(validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		tripID := r.URL.Query().Get("trip_id")
+		batchID := r.URL.Query().Get("batch_id")
+		if tripID == "" && batchID == "" {
+			writeJSONError(w, http.StatusBadRequest, "trip_id or batch_id is required")
+			return
+		}
+		proofs, err := svc.DeliveryProofs(r.Context(), tripID, batchID)
+		if err != nil {
+			slog.Error("delivery proofs failed", "error", err, "trip_id", tripID, "batch_id", batchID)
+			writeJSONError(w, http.StatusInternalServerError, "internal")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proofs)
+	})))
+	mux.HandleFunc("GET /delivery-proofs/{proof_id}/{kind}", measure("/delivery-proofs/{proof_id}/{kind}", auth.RequireRoles(validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("proof_id")
+		kind := r.PathValue("kind")
+		if kind != "signature" && kind != "photo" {
+			writeJSONError(w, http.StatusBadRequest, "kind must be signature or photo")
+			return
+		}
+		data, contentType, err := svc.DeliveryProofFile(r.Context(), id, kind)
+		if errors.Is(err, app.ErrProofNotFound) {
+			writeJSONError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if err != nil {
+			slog.Error("delivery proof file failed", "error", err, "proof_id", id, "kind", kind)
+			writeJSONError(w, http.StatusInternalServerError, "internal")
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(data)
+	})))
 	mux.HandleFunc("GET /delays", measure("/delays", auth.RequireRoles(validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
 		h := 1.5
 		if v := r.URL.Query().Get("hours"); v != "" {
@@ -280,6 +392,98 @@ func main() {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(refs)
 	})))
+	mux.HandleFunc("GET /carriers/on-shift", measure("/carriers/on-shift", auth.RequireRoles(validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		var at *time.Time
+		if v := r.URL.Query().Get("at"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid at")
+				return
+			}
+			at = &t
+		}
+		carriers, err := svc.CarriersOnShift(r.Context(), at)
+		if err != nil {
+			slog.Error("carriers on shift failed", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(carriers)
+	})))
+	mux.HandleFunc("GET /incidents", measure("/incidents", auth.RequireRoles(validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		var bounds [2]*time.Time
+		for i, name := range []string{"from", "to"} {
+			v := r.URL.Query().Get(name)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid "+name)
+				return
+			}
+			bounds[i] = &t
+		}
+		rep, err := svc.IncidentReport(r.Context(), bounds[0], bounds[1])
+		if err != nil {
+			slog.Error("incident report failed", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	})))
+	mux.HandleFunc("POST /transshipments", measure("/transshipments", auth.RequireRoles(validator, []string{"moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		var body app.TransshipmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+		if body.SourceTripID == "" || body.TargetCarrierID == "" || body.Reason == "" {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+		u := auth.FromContext(r)
+		if u == nil {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		id, err := svc.StartTransshipment(r.Context(), body, u.ID)
+		if err != nil {
+			slog.Error("transshipment start failed", "error", err, "trip_id", body.SourceTripID)
+			writeJSONError(w, http.StatusInternalServerError, "internal")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"transshipment_id": id})
+	})))
+	mux.HandleFunc("GET /transshipments/{id}", measure("/transshipments/{id}", auth.RequireRoles(validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		t, err := svc.GetTransshipment(r.Context(), id)
+		if err != nil {
+			slog.Error("transshipment lookup failed", "error", err, "transshipment_id", id)
+			writeJSONError(w, http.StatusNotFound, "not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	})))
+	mux.HandleFunc("POST /transshipments/{id}/complete", measure("/transshipments/{id}/complete", auth.RequireRoles(validator, []string{"moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		u := auth.FromContext(r)
+		if u == nil {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if err := svc.CompleteTransshipment(r.Context(), id, u.ID); err != nil {
+			slog.Error("transshipment complete failed", "error", err, "transshipment_id", id)
+			writeJSONError(w, http.StatusInternalServerError, "internal")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})))
 	mux.HandleFunc("PUT /references/pvp/{id}", measure("/references/pvp/{id}", auth.RequireRoles(validator, []string{"admin", "moderator"}, func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		var body struct {
@@ -340,6 +544,30 @@ func main() {
 
 		w.WriteHeader(http.StatusAccepted)
 	})))
+	referenceHistory := func(idParam string, entityTypes ...string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			id := r.PathValue(idParam)
+			var beforeID int64
+			if v := r.URL.Query().Get("before_id"); v != "" {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || n <= 0 {
+					writeJSONError(w, http.StatusBadRequest, "invalid before_id")
+					return
+				}
+				beforeID = n
+			}
+			changes, err := svc.ReferenceHistory(r.Context(), entityTypes, id, beforeID)
+			if err != nil {
+				slog.Error("reference history failed", "error", err, "id", id)
+				writeJSONError(w, http.StatusInternalServerError, "internal")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(changes)
+		}
+	}
+	mux.HandleFunc("GET /references/pvp/{id}/history", measure("/references/pvp/{id}/history", auth.RequireRoles(validator, []string{"moderator", "admin"}, referenceHistory("id", "pickup_point"))))
+	mux.HandleFunc("GET /references/carriers/{carrier_id}/history", measure("/references/carriers/{carrier_id}/history", auth.RequireRoles(validator, []string{"moderator", "admin"}, referenceHistory("carrier_id", "carrier", "carrier_schedule"))))
 	mux.Handle("GET /metrics", promhttp.Handler())
 	srv := &http.Server{
 		Addr:         ":" + cfg.Server.Port,
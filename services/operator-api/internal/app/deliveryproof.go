@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"bel-parcel/pkg/blobstore"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrProofNotFound = errors.New("delivery proof not found")
+
+// DeliveryProof is the handover evidence a carrier recorded at a PVP. The
+// photo and signature themselves live in the blob store. The confirmation
+// code is never kept: mobile-gateway checked it and names the PVP worker who
+// issued it.
+type DeliveryProof struct {
+	ID                   string    `json:"proof_id"`
+	TripID               string    `json:"trip_id"`
+	BatchID              string    `json:"batch_id"`
+	PVPID                string    `json:"pvp_id"`
+	CarrierID            string    `json:"carrier_id"`
+	ConfirmedBy          string    `json:"confirmed_by,omitempty"`
+	SignatureKey         string    `json:"signature_key"`
+	SignatureContentType string    `json:"signature_content_type"`
+	PhotoKey             string    `json:"photo_key,omitempty"`
+	PhotoContentType     string    `json:"photo_content_type,omitempty"`
+	DeliveredAt          time.Time `json:"delivered_at"`
+}
+
+func (s *Service) WithBlobStore(store blobstore.Store) *Service {
+	s.blobs = store
+	return s
+}
+
+// file returns the blob key and content type of the signature or photo.
+func (p DeliveryProof) file(kind string) (string, string, bool) {
+	switch kind {
+	case "signature":
+		return p.SignatureKey, p.SignatureContentType, p.SignatureKey != ""
+	case "photo":
+		return p.PhotoKey, p.PhotoContentType, p.PhotoKey != ""
+	}
+	return "", "", false
+}
+
+const deliveryProofColumns = `id, trip_id, batch_id, pvp_id, carrier_id, COALESCE(confirmed_by, ''),
+	signature_key, signature_content_type, COALESCE(photo_key, ''), COALESCE(photo_content_type, ''), delivered_at`
+
+func scanDeliveryProof(row pgx.Row) (DeliveryProof, error) {
+	var p DeliveryProof
+	err := row.Scan(&p.ID, &p.TripID, &p.BatchID, &p.PVPID, &p.CarrierID, &p.ConfirmedBy,
+		&p.SignatureKey, &p.SignatureContentType, &p.PhotoKey, &p.PhotoContentType, &p.DeliveredAt)
+	return p, err
+}
+
+// DeliveryProofs lists the proofs recorded for a trip, optionally narrowed to
+// one batch, newest first.
+func (s *Service) DeliveryProofs(ctx context.Context, tripID, batchID string) ([]DeliveryProof, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+deliveryProofColumns+`
+		FROM delivery_proofs
+		WHERE ($1 = '' OR trip_id = $1) AND ($2 = '' OR batch_id = $2)
+		ORDER BY delivered_at DESC
+	`, tripID, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	proofs := []DeliveryProof{}
+	for rows.Next() {
+		p, err := scanDeliveryProof(rows)
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, p)
+	}
+	return proofs, rows.Err()
+}
+
+// DeliveryProofFile loads the signature or photo of a proof from the blob
+// store and returns it with its content type.
+func (s *Service) DeliveryProofFile(ctx context.Context, proofID, kind string) ([]byte, string, error) {
+	p, err := scanDeliveryProof(s.db.QueryRow(ctx, `SELECT `+deliveryProofColumns+` FROM delivery_proofs WHERE id=$1`, proofID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", ErrProofNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return s.loadProofFile(ctx, p, kind)
+}
+
+func (s *Service) loadProofFile(ctx context.Context, p DeliveryProof, kind string) ([]byte, string, error) {
+	key, contentType, ok := p.file(kind)
+	if !ok || s.blobs == nil {
+		return nil, "", ErrProofNotFound
+	}
+	data, err := s.blobs.Get(ctx, key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, "", ErrProofNotFound
+	}
+	return data, contentType, err
+}
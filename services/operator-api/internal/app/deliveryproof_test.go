@@ -0,0 +1,34 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"bel-parcel/pkg/blobstore"
+)
+
+func TestService_LoadProofFile(t *testing.T) {
+	store, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "proofs/t1/b1/p1/signature", "image/png", []byte("sig")); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(nil, nil, nil, "").WithBlobStore(store)
+	p := DeliveryProof{ID: "p1", SignatureKey: "proofs/t1/b1/p1/signature", SignatureContentType: "image/png"}
+
+	data, contentType, err := svc.loadProofFile(ctx, p, "signature")
+	if err != nil || string(data) != "sig" || contentType != "image/png" {
+		t.Fatalf("got %q %q %v", data, contentType, err)
+	}
+	if _, _, err := svc.loadProofFile(ctx, p, "photo"); !errors.Is(err, ErrProofNotFound) {
+		t.Fatalf("expected ErrProofNotFound for a proof without a photo, got %v", err)
+	}
+	p.SignatureKey = "proofs/t1/b1/p1/missing"
+	if _, _, err := svc.loadProofFile(ctx, p, "signature"); !errors.Is(err, ErrProofNotFound) {
+		t.Fatalf("expected ErrProofNotFound for a missing blob, got %v", err)
+	}
+}
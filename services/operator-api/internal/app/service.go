@@ -1,6 +1,7 @@
 package app
 
 import (
+	"bel-parcel/pkg/blobstore"
 	"bel-parcel/services/operator-api/internal/clients"
 	"bel-parcel/services/operator-api/internal/infra/kafka"
 	"bel-parcel/services/operator-api/internal/outbox"
@@ -34,22 +35,34 @@ type Reference struct {
 }
 
 type Service struct {
-	db           *pgxpool.Pool
-	clients      *clients.Clients
-	producer     *kafka.Producer
-	commandTopic string
-	cache        sync.Map
+	db                 *pgxpool.Pool
+	clients            *clients.Clients
+	producer           *kafka.Producer
+	commandTopic       string
+	transshipmentTopic string
+	tripStatusTopic    string
+	blobs              blobstore.Store
+	cache              sync.Map
 }
 
 func NewService(db *pgxpool.Pool, clients *clients.Clients, producer *kafka.Producer, commandTopic string) *Service {
 	return &Service{
-		db:           db,
-		clients:      clients,
-		producer:     producer,
-		commandTopic: commandTopic,
+		db:                 db,
+		clients:            clients,
+		producer:           producer,
+		commandTopic:       commandTopic,
+		transshipmentTopic: "commands.transshipment",
+		tripStatusTopic:    "commands.trip.status",
 	}
 }
 
+func (s *Service) WithTransshipmentTopic(topic string) *Service {
+	if topic != "" {
+		s.transshipmentTopic = topic
+	}
+	return s
+}
+
 type cacheEntry struct {
 	data      interface{}
 	expiresAt time.Time
@@ -171,7 +184,20 @@ func (s *Service) TripDetails(ctx context.Context, tripID string) (map[string]in
 	return res, nil
 }
 
-func (s *Service) ReassignTrip(ctx context.Context, tripID, newCarrierID, reason, operatorID string) error {
+// Split modes an operator may ask for when reassigning a trip whose batch
+// no single vehicle should carry. routing-service forwards the split to
+// batching-service; without a mode the batch moves whole unless it exceeds
+// the vehicle capacity.
+const (
+	SplitByDestination = "destination"
+	SplitByCapacity    = "capacity"
+)
+
+func ValidSplitBy(mode string) bool {
+	return mode == "" || mode == SplitByDestination || mode == SplitByCapacity
+}
+
+func (s *Service) ReassignTrip(ctx context.Context, tripID, newCarrierID, reason, splitBy, operatorID string) error {
 	now := time.Now().UTC()
 	envelope := map[string]interface{}{
 		"event_id":       uuid.NewString(),
@@ -179,11 +205,13 @@ func (s *Service) ReassignTrip(ctx context.Context, tripID, newCarrierID, reason
 		"occurred_at":    now,
 		"correlation_id": tripID,
 		"data": map[string]interface{}{
-			"trip_id":        tripID,
-			"new_carrier_id": newCarrierID,
-			"reason":         reason,
-			"requested_at":   now,
-			"operator_id":    operatorID,
+			"trip_id":          tripID,
+			"original_trip_id": tripID,
+			"new_carrier_id":   newCarrierID,
+			"reason":           reason,
+			"split_by":         splitBy,
+			"requested_at":     now,
+			"operator_id":      operatorID,
 		},
 	}
 	payload, _ := json.Marshal(envelope)
@@ -254,6 +282,131 @@ func (s *Service) SearchReferences(ctx context.Context, typ, q string) ([]Refere
 	return refs, nil
 }
 
+type OnShiftCarrier struct {
+	CarrierID string `json:"carrier_id"`
+	Name      string `json:"name"`
+	Scheduled bool   `json:"scheduled"`
+}
+
+// CarriersOnShift asks reference-service which active carriers are on shift
+// at the given moment, or now when at is nil.
+func (s *Service) CarriersOnShift(ctx context.Context, at *time.Time) ([]OnShiftCarrier, error) {
+	path := "/carriers/on-shift"
+	cacheKey := "on_shift:now"
+	if at != nil {
+		path += "?at=" + url.QueryEscape(at.UTC().Format(time.RFC3339))
+		cacheKey = "on_shift:" + at.UTC().Format(time.RFC3339)
+	}
+	if val, ok := s.getCache(cacheKey); ok {
+		return val.([]OnShiftCarrier), nil
+	}
+	body, err := s.clients.Reference.Get(ctx, path)
+	if err != nil {
+		slog.Error("failed to call reference-service", "path", path, "error", err)
+		return nil, fmt.Errorf("reference service unavailable: %w", err)
+	}
+	var carriers []OnShiftCarrier
+	if err := json.Unmarshal(body, &carriers); err != nil {
+		return nil, err
+	}
+	s.setCache(cacheKey, carriers)
+	return carriers, nil
+}
+
+// ReferenceChange is an entry of the reference-service audit log.
+type ReferenceChange struct {
+	ID         int64           `json:"id"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	OperatorID string          `json:"operator_id"`
+	Reason     string          `json:"reason"`
+	ChangedAt  time.Time       `json:"changed_at"`
+}
+
+// ReferenceHistory reads the change history of one reference record, newest
+// first. It is not cached: operators open it right after making a change.
+func (s *Service) ReferenceHistory(ctx context.Context, entityTypes []string, id string, beforeID int64) ([]ReferenceChange, error) {
+	q := url.Values{}
+	for _, t := range entityTypes {
+		q.Add("entity_type", t)
+	}
+	q.Set("entity_id", id)
+	if beforeID > 0 {
+		q.Set("before_id", strconv.FormatInt(beforeID, 10))
+	}
+	body, err := s.clients.Reference.Get(ctx, "/audit?"+q.Encode())
+	if err != nil {
+		slog.Error("failed to call reference-service", "path", "/audit", "error", err)
+		return nil, fmt.Errorf("reference service unavailable: %w", err)
+	}
+	var changes []ReferenceChange
+	if err := json.Unmarshal(body, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+type IncidentSummary struct {
+	Type                 string  `json:"type"`
+	Total                int     `json:"total"`
+	Resolved             int     `json:"resolved"`
+	Recovered            int     `json:"recovered"`
+	Open                 int     `json:"open"`
+	AvgResolutionSeconds float64 `json:"avg_resolution_seconds"`
+	MaxResolutionSeconds float64 `json:"max_resolution_seconds"`
+}
+
+type Incident struct {
+	IncidentID        string     `json:"incident_id"`
+	TripID            string     `json:"trip_id"`
+	CarrierID         string     `json:"carrier_id"`
+	Type              string     `json:"type"`
+	Status            string     `json:"status"`
+	Latitude          float64    `json:"latitude"`
+	Longitude         float64    `json:"longitude"`
+	Description       string     `json:"description"`
+	ReplacementTripID *string    `json:"replacement_trip_id"`
+	ReportedAt        time.Time  `json:"reported_at"`
+	ResolvedAt        *time.Time `json:"resolved_at"`
+	ResolutionSeconds *float64   `json:"resolution_seconds"`
+}
+
+type IncidentReport struct {
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Summary   []IncidentSummary `json:"summary"`
+	Incidents []Incident        `json:"incidents"`
+}
+
+// IncidentReport fetches trip incidents and their time-to-resolution from
+// routing-service. Nil bounds fall back to routing's default window.
+func (s *Service) IncidentReport(ctx context.Context, from, to *time.Time) (IncidentReport, error) {
+	q := url.Values{}
+	if from != nil {
+		q.Set("from", from.UTC().Format(time.RFC3339))
+	}
+	if to != nil {
+		q.Set("to", to.UTC().Format(time.RFC3339))
+	}
+	path := "/incidents"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	body, err := s.clients.Routing.Get(ctx, path)
+	if err != nil {
+		slog.Error("failed to call routing-service for incidents", "error", err)
+		return IncidentReport{}, fmt.Errorf("routing service unavailable: %w", err)
+	}
+	var rep IncidentReport
+	if err := json.Unmarshal(body, &rep); err != nil {
+		return IncidentReport{}, err
+	}
+	return rep, nil
+}
+
 func (s *Service) markPublished(ctx context.Context, eventType, correlationID string) (bool, error) {
 	var et string
 	err := s.db.QueryRow(ctx, `
@@ -412,6 +565,39 @@ func (s *Service) SyncCache(ctx context.Context, topic string, value []byte) err
 		`, trip.ID, trip.OriginWarehouseID, trip.PickupPointID, trip.CarrierID, trip.AssignedAt, trip.Status)
 		return err
 
+	case "trips.status_changed":
+		var change struct {
+			TripID    string  `json:"trip_id"`
+			CarrierID *string `json:"carrier_id"`
+			ToStatus  string  `json:"to_status"`
+		}
+		if err := json.Unmarshal(event.Data, &change); err != nil {
+			return err
+		}
+		_, err := s.db.Exec(ctx, `
+			INSERT INTO trips_cache (id, carrier_id, status, updated_at)
+			VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (id) DO UPDATE SET
+				carrier_id = COALESCE(EXCLUDED.carrier_id, trips_cache.carrier_id),
+				status = EXCLUDED.status,
+				updated_at = NOW()
+		`, change.TripID, change.CarrierID, change.ToStatus)
+		return err
+
+	case "events.delivery_proof_recorded":
+		var p DeliveryProof
+		if err := json.Unmarshal(event.Data, &p); err != nil {
+			return err
+		}
+		_, err := s.db.Exec(ctx, `
+			INSERT INTO delivery_proofs (id, trip_id, batch_id, pvp_id, carrier_id, confirmed_by,
+				signature_key, signature_content_type, photo_key, photo_content_type, delivered_at)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11)
+			ON CONFLICT (id) DO NOTHING
+		`, p.ID, p.TripID, p.BatchID, p.PVPID, p.CarrierID, p.ConfirmedBy,
+			p.SignatureKey, p.SignatureContentType, p.PhotoKey, p.PhotoContentType, p.DeliveredAt)
+		return err
+
 	case "batches.updated":
 		var batch struct {
 			ID     string `json:"id"`
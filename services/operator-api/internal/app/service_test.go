@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"bel-parcel/services/operator-api/internal/clients"
 
@@ -102,3 +103,104 @@ func TestService_Caching(t *testing.T) {
 	_, _ = svc.ListTrips(context.Background(), "", "", "", nil)
 	assert.Equal(t, 1, callCount)
 }
+
+func TestService_CarriersOnShift(t *testing.T) {
+	at := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/carriers/on-shift", r.URL.Path)
+		assert.Equal(t, "2024-01-01T10:00:00Z", r.URL.Query().Get("at"))
+		json.NewEncoder(w).Encode([]OnShiftCarrier{{CarrierID: "c1", Name: "Ivan", Scheduled: true}})
+	}))
+	defer server.Close()
+
+	cls := clients.NewClients(nil, server.URL, server.URL, server.URL, server.URL)
+	svc := NewService(nil, cls, nil, "topic")
+
+	carriers, err := svc.CarriersOnShift(context.Background(), &at)
+	assert.NoError(t, err)
+	assert.Len(t, carriers, 1)
+	assert.Equal(t, "c1", carriers[0].CarrierID)
+	assert.True(t, carriers[0].Scheduled)
+}
+
+func TestService_IncidentReport(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/incidents", r.URL.Path)
+		assert.Equal(t, "2024-01-01T00:00:00Z", r.URL.Query().Get("from"))
+		assert.Empty(t, r.URL.Query().Get("to"))
+		json.NewEncoder(w).Encode(IncidentReport{
+			Summary:   []IncidentSummary{{Type: "breakdown", Total: 1, Resolved: 1, Recovered: 1, AvgResolutionSeconds: 42}},
+			Incidents: []Incident{{IncidentID: "i1", TripID: "t1", Type: "breakdown", Status: "recovered"}},
+		})
+	}))
+	defer server.Close()
+
+	cls := clients.NewClients(nil, server.URL, server.URL, server.URL, server.URL)
+	svc := NewService(nil, cls, nil, "topic")
+
+	rep, err := svc.IncidentReport(context.Background(), &from, nil)
+	assert.NoError(t, err)
+	assert.Len(t, rep.Incidents, 1)
+	assert.Equal(t, 42.0, rep.Summary[0].AvgResolutionSeconds)
+}
+
+func TestService_GetTransshipment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/transshipments/ts1", r.URL.Path)
+		json.NewEncoder(w).Encode(Transshipment{
+			ID:             "ts1",
+			SourceTripID:   "t1",
+			Status:         "completed_with_discrepancies",
+			Expected:       3,
+			Reconciliation: Reconciliation{Moved: []string{"b1", "b2"}, Missing: []string{"b3"}, Extra: []string{}},
+		})
+	}))
+	defer server.Close()
+
+	cls := clients.NewClients(nil, server.URL, server.URL, server.URL, server.URL)
+	svc := NewService(nil, cls, nil, "topic")
+
+	ts, err := svc.GetTransshipment(context.Background(), "ts1")
+	assert.NoError(t, err)
+	assert.Equal(t, "completed_with_discrepancies", ts.Status)
+	assert.Equal(t, []string{"b3"}, ts.Reconciliation.Missing)
+}
+
+func TestService_TripHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/trips/t1/history", r.URL.Path)
+		json.NewEncoder(w).Encode([]TripStatusChange{
+			{FromStatus: "ASSIGNED", ToStatus: "ON_HOLD", Actor: "op-1", Reason: "road closed"},
+			{FromStatus: "ON_HOLD", ToStatus: "ASSIGNED", Actor: "op-1"},
+		})
+	}))
+	defer server.Close()
+
+	cls := clients.NewClients(nil, server.URL, server.URL, server.URL, server.URL)
+	svc := NewService(nil, cls, nil, "topic")
+
+	history, err := svc.TripHistory(context.Background(), "t1")
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "road closed", history[0].Reason)
+}
+
+func TestService_ReferenceHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/audit", r.URL.Path)
+		assert.Equal(t, []string{"carrier", "carrier_schedule"}, r.URL.Query()["entity_type"])
+		assert.Equal(t, "car-1", r.URL.Query().Get("entity_id"))
+		assert.Equal(t, "17", r.URL.Query().Get("before_id"))
+		json.NewEncoder(w).Encode([]ReferenceChange{{ID: 16, EntityType: "carrier", EntityID: "car-1", Action: "deactivated", OperatorID: "op-1", Reason: "fired"}})
+	}))
+	defer server.Close()
+
+	cls := clients.NewClients(nil, server.URL, server.URL, server.URL, server.URL)
+	svc := NewService(nil, cls, nil, "topic")
+
+	changes, err := svc.ReferenceHistory(context.Background(), []string{"carrier", "carrier_schedule"}, "car-1", 17)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "deactivated", changes[0].Action)
+}
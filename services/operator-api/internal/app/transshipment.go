@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/operator-api/internal/outbox"
+
+	"github.com/google/uuid"
+)
+
+type TransshipmentRequest struct {
+	SourceTripID    string   `json:"source_trip_id"`
+	TargetCarrierID string   `json:"target_carrier_id"`
+	BoxIDs          []string `json:"box_ids"`
+	Reason          string   `json:"reason"`
+}
+
+type Reconciliation struct {
+	Moved   []string `json:"moved"`
+	Missing []string `json:"missing"`
+	Extra   []string `json:"extra"`
+}
+
+type Transshipment struct {
+	ID              string         `json:"id"`
+	SourceTripID    string         `json:"source_trip_id"`
+	TargetTripID    *string        `json:"target_trip_id"`
+	SourceCarrierID string         `json:"source_carrier_id"`
+	TargetCarrierID string         `json:"target_carrier_id"`
+	Status          string         `json:"status"`
+	RejectionReason *string        `json:"rejection_reason,omitempty"`
+	InitiatedBy     string         `json:"initiated_by"`
+	Reason          string         `json:"reason"`
+	CreatedAt       time.Time      `json:"created_at"`
+	CompletedAt     *time.Time     `json:"completed_at"`
+	Expected        int            `json:"expected"`
+	ScannedOut      int            `json:"scanned_out"`
+	Reconciliation  Reconciliation `json:"reconciliation"`
+}
+
+// StartTransshipment asks routing-service to open a cargo transfer and returns
+// its id. The checks of trip, boxes and capacity happen in routing; the
+// outcome is visible through GetTransshipment.
+func (s *Service) StartTransshipment(ctx context.Context, req TransshipmentRequest, operatorID string) (string, error) {
+	id := uuid.NewString()
+	boxIDs := req.BoxIDs
+	if boxIDs == nil {
+		boxIDs = []string{}
+	}
+	err := s.enqueueTransshipmentCommand(ctx, "commands.transshipment.initiate", id, map[string]interface{}{
+		"transshipment_id":  id,
+		"source_trip_id":    req.SourceTripID,
+		"target_carrier_id": req.TargetCarrierID,
+		"box_ids":           boxIDs,
+		"reason":            req.Reason,
+		"initiated_by":      operatorID,
+	})
+	return id, err
+}
+
+// CompleteTransshipment closes the scanning on behalf of the carriers.
+func (s *Service) CompleteTransshipment(ctx context.Context, id, operatorID string) error {
+	return s.enqueueTransshipmentCommand(ctx, "commands.transshipment.complete", id, map[string]interface{}{
+		"transshipment_id": id,
+		"completed_by":     operatorID,
+	})
+}
+
+func (s *Service) enqueueTransshipmentCommand(ctx context.Context, eventType, id string, data map[string]interface{}) error {
+	now := time.Now().UTC()
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     eventType,
+		"occurred_at":    now,
+		"correlation_id": id,
+		"data":           data,
+	}
+	payload, _ := json.Marshal(envelope)
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	evt := outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     eventType,
+		CorrelationID: id,
+		Topic:         s.transshipmentTopic,
+		PartitionKey:  id,
+		Payload:       payload,
+		OccurredAt:    now,
+	}
+	if err := outbox.EnqueueTx(ctx, tx, evt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *Service) GetTransshipment(ctx context.Context, id string) (Transshipment, error) {
+	body, err := s.clients.Routing.Get(ctx, "/transshipments/"+id)
+	if err != nil {
+		slog.Error("failed to call routing-service for transshipment", "transshipment_id", id, "error", err)
+		return Transshipment{}, fmt.Errorf("routing service unavailable: %w", err)
+	}
+	var t Transshipment
+	if err := json.Unmarshal(body, &t); err != nil {
+		return Transshipment{}, err
+	}
+	return t, nil
+}
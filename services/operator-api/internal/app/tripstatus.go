@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/operator-api/internal/outbox"
+
+	"github.com/google/uuid"
+)
+
+// Operator commands on the trip state machine in routing-service.
+const (
+	TripCancelCommand = "commands.trip.cancel"
+	TripHoldCommand   = "commands.trip.hold"
+	TripResumeCommand = "commands.trip.resume"
+)
+
+type TripStatusChange struct {
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	Reason     string    `json:"reason"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+func (s *Service) WithTripStatusTopic(topic string) *Service {
+	if topic != "" {
+		s.tripStatusTopic = topic
+	}
+	return s
+}
+
+// ChangeTripStatus queues a cancel, hold or resume command. routing-service
+// checks it against the allowed transitions and publishes
+// trips.status_changed when it is applied.
+func (s *Service) ChangeTripStatus(ctx context.Context, command, tripID, reason, operatorID string) error {
+	now := time.Now().UTC()
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     command,
+		"occurred_at":    now,
+		"correlation_id": tripID,
+		"data": map[string]interface{}{
+			"trip_id":      tripID,
+			"reason":       reason,
+			"operator_id":  operatorID,
+			"requested_at": now,
+		},
+	}
+	payload, _ := json.Marshal(envelope)
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	evt := outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     command,
+		CorrelationID: tripID + "/" + uuid.NewString(),
+		Topic:         s.tripStatusTopic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	}
+	if err := outbox.EnqueueTx(ctx, tx, evt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *Service) TripHistory(ctx context.Context, tripID string) ([]TripStatusChange, error) {
+	body, err := s.clients.Routing.Get(ctx, "/trips/"+tripID+"/history")
+	if err != nil {
+		slog.Error("failed to call routing-service for trip history", "trip_id", tripID, "error", err)
+		return nil, fmt.Errorf("routing service unavailable: %w", err)
+	}
+	var history []TripStatusChange
+	if err := json.Unmarshal(body, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
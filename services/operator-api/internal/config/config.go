@@ -21,11 +21,22 @@ type Config struct {
 		ReferenceURL string
 	}
 	Kafka struct {
-		Brokers      []string
-		Timeout      time.Duration
-		CommandTopic string
-		GroupID      string
-		SyncTopics   []string
+		Brokers            []string
+		Timeout            time.Duration
+		CommandTopic       string
+		TransshipmentTopic string
+		TripStatusTopic    string
+		GroupID            string
+		SyncTopics         []string
+	}
+	Blob struct {
+		Driver    string
+		Dir       string
+		Endpoint  string
+		Region    string
+		Bucket    string
+		AccessKey string
+		SecretKey string
 	}
 	OTLP struct {
 		Endpoint string
@@ -48,8 +59,17 @@ func Load() (*Config, error) {
 	v.SetDefault("kafka.brokers", []string{"redpanda:9092"})
 	v.SetDefault("kafka.timeout", 5*time.Second)
 	v.SetDefault("kafka.commandtopic", "commands.trip.reassign")
+	v.SetDefault("kafka.transshipmenttopic", "commands.transshipment")
+	v.SetDefault("kafka.tripstatustopic", "commands.trip.status")
 	v.SetDefault("kafka.groupid", "operator-api-sync")
-	v.SetDefault("kafka.synctopics", []string{"trips.updated", "batches.updated"})
+	v.SetDefault("kafka.synctopics", []string{"trips.updated", "batches.updated", "trips.status_changed", "events.delivery_proof_recorded"})
+	v.SetDefault("blob.driver", "fs")
+	v.SetDefault("blob.dir", "./data/blobs")
+	v.SetDefault("blob.endpoint", "")
+	v.SetDefault("blob.region", "")
+	v.SetDefault("blob.bucket", "")
+	v.SetDefault("blob.accesskey", "")
+	v.SetDefault("blob.secretkey", "")
 	v.SetDefault("otlp.endpoint", "")
 	v.SetDefault("auth.hs256secret", "")
 	v.SetDefault("auth.issuer", "")
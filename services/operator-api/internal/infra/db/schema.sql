@@ -13,3 +13,23 @@ CREATE TABLE IF NOT EXISTS batches_cache (
     trip_id TEXT,
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
+
+CREATE TABLE IF NOT EXISTS delivery_proofs (
+    id TEXT PRIMARY KEY,
+    trip_id TEXT NOT NULL,
+    batch_id TEXT NOT NULL,
+    pvp_id TEXT NOT NULL,
+    carrier_id TEXT NOT NULL,
+    confirmed_by TEXT,
+    signature_key TEXT NOT NULL,
+    signature_content_type TEXT NOT NULL,
+    photo_key TEXT,
+    photo_content_type TEXT,
+    delivered_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_proofs_trip ON delivery_proofs(trip_id, batch_id);
+
+-- Код подтверждения не хранится: таблицы, созданные раньше, теряют его
+ALTER TABLE delivery_proofs DROP COLUMN IF EXISTS confirmation_code;
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS confirmed_by TEXT;
@@ -17,10 +17,13 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
 	"bel-parcel/services/order-service/internal/app"
+	"bel-parcel/services/order-service/internal/auth"
 	"bel-parcel/services/order-service/internal/config"
 	"bel-parcel/services/order-service/internal/delivery/handler"
 	"bel-parcel/services/order-service/internal/infra/db"
 	"bel-parcel/services/order-service/internal/infra/kafka"
+	"bel-parcel/services/order-service/internal/infra/reference"
+	"bel-parcel/services/order-service/internal/metrics"
 	"bel-parcel/services/order-service/internal/outbox"
 
 	"github.com/google/uuid"
@@ -35,6 +38,10 @@ func main() {
 		slog.Error("failed to load config", "error", err)
 		os.Exit(1)
 	}
+	if cfg.Pickup.CodeSecret == "" {
+		slog.Error("empty PICKUP_CODESECRET, refusing to start")
+		os.Exit(1)
+	}
 
 	setupLogger()
 	shutdown := setupOTLP(cfg)
@@ -58,12 +65,44 @@ func main() {
 	}
 	defer kafkaProducer.Close()
 
-	service := app.NewOrderService(dbPool, kafkaProducer, cfg.Kafka.Topic)
+	service := app.NewOrderService(dbPool, kafkaProducer, cfg.Kafka.Topic).WithPickupCodeSecret(cfg.Pickup.CodeSecret)
 
 	consumer := kafka.NewConsumer(cfg.Kafka.Brokers, cfg.Kafka.GroupID, cfg.Kafka.ConsumerTopics, cfg.Kafka.Timeout)
 	cctx, ccancel := context.WithCancel(context.Background())
 	defer ccancel()
 	go outbox.StartPublisher(cctx, dbPool, kafkaProducer)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cctx.Done():
+				return
+			case <-ticker.C:
+				if err := service.ProcessStorage(cctx, time.Now().UTC()); err != nil {
+					slog.Error("storage sweep failed", "error", err)
+				}
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(cfg.Import.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cctx.Done():
+				return
+			case <-ticker.C:
+				if err := service.ProcessImports(cctx); err != nil {
+					slog.Error("order import processing failed", "error", err)
+				}
+			}
+		}
+	}()
+	// The reference projections are filled from the snapshot before
+	// consuming; events older than the stored rows are then ignored by seq.
+	bootstrapReference(cctx, service, reference.NewClient(cfg.Reference.URL, cfg.Reference.Token, cfg.Reference.Timeout))
+
 	consumer.Start(cctx, func(topic string, key, value []byte) error {
 		if err := service.HandleKafkaEvent(cctx, topic, key, value); err != nil {
 			now := time.Now().UTC()
@@ -103,11 +142,22 @@ func main() {
 		return nil
 	})
 
-	handler := handler.NewHandler(service)
+	if cfg.Auth.HS256Secret == "" {
+		slog.Warn("auth.hs256secret not set, admin endpoints will reject all tokens")
+	}
+	handler := handler.NewHandler(service, handler.Options{
+		Validator:        auth.NewValidator(cfg.Auth.HS256Secret, cfg.Auth.Issuer, cfg.Auth.Audience),
+		TrackingLimiter:  handler.NewRateLimiter(cfg.Public.TrackingRateLimit, time.Minute, cfg.Public.TrustProxy),
+		KeyRotationGrace: cfg.Auth.KeyRotationGrace,
+		MaxImportBytes:   cfg.Import.MaxBytes,
+	})
+	mux := http.NewServeMux()
+	metrics.Init(mux)
+	mux.Handle("/", handler)
 
 	srv := &http.Server{
 		Addr:    ":" + cfg.Server.Port,
-		Handler: handler,
+		Handler: mux,
 	}
 
 	go func() {
@@ -134,6 +184,30 @@ func main() {
 	slog.Info("server stopped")
 }
 
+// bootstrapReference loads the reference snapshot with a few retries. If the
+// reference service stays unavailable the service starts on the projection it
+// already has and catches up from the event stream.
+func bootstrapReference(ctx context.Context, service *app.OrderService, client *reference.Client) {
+	backoff := time.Second
+	for attempt := 1; attempt <= 5; attempt++ {
+		snap, err := client.Snapshot(ctx)
+		if err == nil {
+			err = service.ApplyReferenceSnapshot(ctx, snap)
+		}
+		if err == nil {
+			return
+		}
+		slog.Warn("reference snapshot bootstrap failed", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	slog.Warn("starting without reference snapshot")
+}
+
 func setupLogger() {
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"bel-parcel/services/order-service/internal/auth"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrInvalidScopes = errors.New("invalid scopes")
+	ErrKeyRevoked    = errors.New("api key revoked")
+)
+
+// APIKey describes a seller API key without its secret.
+type APIKey struct {
+	ID         string     `json:"id"`
+	SellerID   string     `json:"seller_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ReplacedBy string     `json:"replaced_by,omitempty"`
+}
+
+// IssuedAPIKey is returned once, when a key is created or rotated.
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+func validScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, s := range scopes {
+		known := false
+		for _, k := range auth.KnownScopes {
+			if s == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *OrderService) CreateAPIKey(ctx context.Context, sellerID, name string, scopes []string) (*IssuedAPIKey, error) {
+	if !validScopes(scopes) {
+		return nil, ErrInvalidScopes
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	issued, err := createAPIKeyTx(ctx, tx, sellerID, name, scopes, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+func createAPIKeyTx(ctx context.Context, tx pgx.Tx, sellerID, name string, scopes []string, now time.Time) (*IssuedAPIKey, error) {
+	id, plaintext, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate api key: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO seller_api_keys (id, seller_id, name, secret_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, id, sellerID, name, hash, scopes, now); err != nil {
+		return nil, err
+	}
+	return &IssuedAPIKey{
+		APIKey: APIKey{ID: id, SellerID: sellerID, Name: name, Scopes: scopes, CreatedAt: now},
+		Key:    plaintext,
+	}, nil
+}
+
+func (s *OrderService) ListAPIKeys(ctx context.Context, sellerID string) ([]APIKey, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, seller_id::text, name, scopes, created_at, expires_at, revoked_at, last_used_at, COALESCE(replaced_by, '')
+		FROM seller_api_keys WHERE seller_id::text = $1 ORDER BY created_at
+	`, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.SellerID, &k.Name, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.RevokedAt, &k.LastUsedAt, &k.ReplacedBy); err != nil {
+			return nil, err
+		}
+		list = append(list, k)
+	}
+	return list, rows.Err()
+}
+
+// RotateAPIKey issues a replacement with the same name and scopes. The old
+// key keeps working for the grace period so the seller can roll it out.
+func (s *OrderService) RotateAPIKey(ctx context.Context, sellerID, keyID string, grace time.Duration) (*IssuedAPIKey, error) {
+	now := time.Now().UTC()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	var name string
+	var scopes []string
+	var expiresAt, revokedAt *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT name, scopes, expires_at, revoked_at FROM seller_api_keys
+		WHERE id = $1 AND seller_id::text = $2 FOR UPDATE
+	`, keyID, sellerID).Scan(&name, &scopes, &expiresAt, &revokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, auth.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt != nil || (expiresAt != nil && !now.Before(*expiresAt)) {
+		return nil, ErrKeyRevoked
+	}
+	issued, err := createAPIKeyTx(ctx, tx, sellerID, name, scopes, now)
+	if err != nil {
+		return nil, err
+	}
+	graceEnd := now.Add(grace)
+	if expiresAt == nil || graceEnd.Before(*expiresAt) {
+		expiresAt = &graceEnd
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE seller_api_keys SET expires_at = $2, replaced_by = $3 WHERE id = $1
+	`, keyID, *expiresAt, issued.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+func (s *OrderService) RevokeAPIKey(ctx context.Context, sellerID, keyID string) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE seller_api_keys SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1 AND seller_id::text = $2
+	`, keyID, sellerID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return auth.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *OrderService) LookupAPIKey(ctx context.Context, id string) (*auth.StoredKey, error) {
+	k := &auth.StoredKey{ID: id}
+	err := s.db.QueryRow(ctx, `
+		SELECT seller_id::text, secret_hash, scopes, expires_at, revoked_at
+		FROM seller_api_keys WHERE id = $1
+	`, id).Scan(&k.SellerID, &k.SecretHash, &k.Scopes, &k.ExpiresAt, &k.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, auth.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// TouchAPIKey records key usage, at most once a minute per key.
+func (s *OrderService) TouchAPIKey(ctx context.Context, id string) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE seller_api_keys SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`, id)
+	return err
+}
@@ -0,0 +1,454 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+
+	ImportStatusPending    = "PENDING"
+	ImportStatusProcessing = "PROCESSING"
+	ImportStatusCompleted  = "COMPLETED"
+	ImportStatusFailed     = "FAILED"
+
+	MaxImportRows    = 50000
+	importChunkSize  = 500
+	maxExternalIDLen = 64
+	// A job whose worker stopped updating it is picked up again
+	importStaleAfter = 5 * time.Minute
+)
+
+var (
+	ErrImportNotFound  = errors.New("import job not found")
+	ErrImportMalformed = errors.New("malformed import file")
+
+	// errImportLeaseLost means another worker took the job over after this
+	// one was considered stale; whatever this worker had not committed is
+	// redone by the new owner.
+	errImportLeaseLost = errors.New("import job taken over by another worker")
+
+	phoneRe = regexp.MustCompile(`^\+?[0-9]{9,15}$`)
+)
+
+// ImportRow is one order of a bulk import. Coordinates are taken from the
+// reference data; columns carrying them are ignored.
+type ImportRow struct {
+	ExternalID     string `json:"external_id"`
+	WarehouseID    string `json:"warehouse_id"`
+	PVZID          string `json:"pvz_id"`
+	CustomerPhone  string `json:"customer_phone"`
+	CustomerEmail  string `json:"customer_email"`
+	CustomerLocale string `json:"customer_locale"`
+}
+
+type ImportRowError struct {
+	Row        int    `json:"row"`
+	ExternalID string `json:"external_id,omitempty"`
+	Error      string `json:"error"`
+}
+
+type ImportJob struct {
+	ID             string           `json:"id"`
+	Format         string           `json:"format"`
+	Status         string           `json:"status"`
+	TotalRows      int              `json:"total_rows"`
+	ProcessedRows  int              `json:"processed_rows"`
+	CreatedCount   int              `json:"created"`
+	DuplicateCount int              `json:"duplicates"`
+	FailedCount    int              `json:"failed"`
+	CreatedAt      time.Time        `json:"created_at"`
+	FinishedAt     *time.Time       `json:"finished_at,omitempty"`
+	Errors         []ImportRowError `json:"errors"`
+}
+
+// parsedRow keeps the row number of the file (1-based, header excluded) so
+// errors point at the line the seller has to fix.
+type parsedRow struct {
+	num int
+	row ImportRow
+	err string
+}
+
+// parseImport reads all rows of the file. A row that cannot be read is kept
+// with its error; only a file that cannot be read at all is rejected.
+func parseImport(format string, content []byte) ([]parsedRow, error) {
+	switch format {
+	case ImportFormatCSV:
+		return parseImportCSV(content)
+	case ImportFormatNDJSON:
+		return parseImportNDJSON(content)
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", ErrImportMalformed, format)
+	}
+}
+
+func parseImportCSV(content []byte) ([]parsedRow, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImportMalformed, err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	for _, required := range []string{"external_id", "pvz_id"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("%w: missing column %s", ErrImportMalformed, required)
+		}
+	}
+	var rows []parsedRow
+	for num := 1; ; num++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows) >= MaxImportRows {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrImportMalformed, MaxImportRows)
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) && perr.Err != csv.ErrFieldCount {
+				rows = append(rows, parsedRow{num: num, err: perr.Err.Error()})
+				continue
+			}
+			return nil, fmt.Errorf("%w: %v", ErrImportMalformed, err)
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		rows = append(rows, parsedRow{num: num, row: ImportRow{
+			ExternalID:     field("external_id"),
+			WarehouseID:    field("warehouse_id"),
+			PVZID:          field("pvz_id"),
+			CustomerPhone:  field("customer_phone"),
+			CustomerEmail:  field("customer_email"),
+			CustomerLocale: field("customer_locale"),
+		}})
+	}
+	return rows, nil
+}
+
+func parseImportNDJSON(content []byte) ([]parsedRow, error) {
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var rows []parsedRow
+	for num := 1; sc.Scan(); num++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(rows) >= MaxImportRows {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrImportMalformed, MaxImportRows)
+		}
+		p := parsedRow{num: num}
+		if err := json.Unmarshal(line, &p.row); err != nil {
+			p.err = "invalid JSON"
+		}
+		p.row.ExternalID = strings.TrimSpace(p.row.ExternalID)
+		p.row.WarehouseID = strings.TrimSpace(p.row.WarehouseID)
+		p.row.PVZID = strings.TrimSpace(p.row.PVZID)
+		rows = append(rows, p)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImportMalformed, err)
+	}
+	return rows, nil
+}
+
+// validateImportRow checks what can be checked without reference data.
+func validateImportRow(r ImportRow) string {
+	switch {
+	case r.ExternalID == "":
+		return "external_id is required"
+	case len(r.ExternalID) > maxExternalIDLen:
+		return fmt.Sprintf("external_id longer than %d characters", maxExternalIDLen)
+	case r.PVZID == "":
+		return "pvz_id is required"
+	case r.CustomerPhone == "" && r.CustomerEmail == "":
+		return "customer_phone or customer_email is required"
+	case r.CustomerPhone != "" && !phoneRe.MatchString(r.CustomerPhone):
+		return "customer_phone is invalid"
+	case !ValidCustomerLocale(r.CustomerLocale):
+		return "customer_locale must be ru, be or en"
+	}
+	if r.CustomerEmail != "" {
+		if a, err := mail.ParseAddress(r.CustomerEmail); err != nil || a.Address != r.CustomerEmail {
+			return "customer_email is invalid"
+		}
+	}
+	return ""
+}
+
+// SubmitImport registers an import job. The same file uploaded again by the
+// same seller returns the existing job instead of a new one.
+func (s *OrderService) SubmitImport(ctx context.Context, sellerID, format string, content []byte) (*ImportJob, bool, error) {
+	rows, err := parseImport(format, content)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rows) == 0 {
+		return nil, false, fmt.Errorf("%w: no rows", ErrImportMalformed)
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	job := &ImportJob{ID: uuid.NewString(), Format: format, Status: ImportStatusPending, TotalRows: len(rows), CreatedAt: time.Now().UTC(), Errors: []ImportRowError{}}
+	tag, err := s.db.Exec(ctx, `
+		INSERT INTO order_import_jobs (id, seller_id, file_hash, format, content, status, total_rows, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		ON CONFLICT (seller_id, file_hash) DO NOTHING
+	`, job.ID, sellerID, hash, format, content, job.Status, job.TotalRows, job.CreatedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	if tag.RowsAffected() == 1 {
+		slog.InfoContext(ctx, "order import submitted", "job_id", job.ID, "seller_id", sellerID, "rows", job.TotalRows)
+		return job, true, nil
+	}
+	var id string
+	if err := s.db.QueryRow(ctx, `
+		SELECT id::text FROM order_import_jobs WHERE seller_id::text = $1 AND file_hash = $2
+	`, sellerID, hash).Scan(&id); err != nil {
+		return nil, false, err
+	}
+	existing, err := s.ImportJob(ctx, sellerID, id)
+	return existing, false, err
+}
+
+func (s *OrderService) ImportJob(ctx context.Context, sellerID, jobID string) (*ImportJob, error) {
+	if _, err := uuid.Parse(jobID); err != nil {
+		return nil, ErrImportNotFound
+	}
+	j := &ImportJob{ID: jobID, Errors: []ImportRowError{}}
+	err := s.db.QueryRow(ctx, `
+		SELECT format, status, total_rows, processed_rows, created_count, duplicate_count, failed_count, created_at, finished_at
+		FROM order_import_jobs WHERE id = $1 AND seller_id::text = $2
+	`, jobID, sellerID).Scan(&j.Format, &j.Status, &j.TotalRows, &j.ProcessedRows, &j.CreatedCount, &j.DuplicateCount, &j.FailedCount, &j.CreatedAt, &j.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT row_number, COALESCE(external_id, ''), error FROM order_import_errors
+		WHERE job_id = $1 ORDER BY row_number
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ImportRowError
+		if err := rows.Scan(&e.Row, &e.ExternalID, &e.Error); err != nil {
+			return nil, err
+		}
+		j.Errors = append(j.Errors, e)
+	}
+	return j, rows.Err()
+}
+
+// ProcessImports works through pending import jobs until none is left.
+// Replicas share the jobs through SKIP LOCKED.
+func (s *OrderService) ProcessImports(ctx context.Context) error {
+	for {
+		ok, err := s.processNextImport(ctx)
+		if err != nil || !ok {
+			return err
+		}
+	}
+}
+
+type importJobRef struct {
+	id, sellerID, format string
+	content              []byte
+	processed            int
+	// lease is set when the job is claimed; every write of the job checks
+	// it, so a worker that lost the job cannot commit over its new owner.
+	lease string
+}
+
+func (s *OrderService) processNextImport(ctx context.Context) (bool, error) {
+	j := importJobRef{lease: uuid.NewString()}
+	err := s.db.QueryRow(ctx, `
+		UPDATE order_import_jobs SET status = $1, lease_token = $4, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM order_import_jobs
+			WHERE status = $2 OR (status = $1 AND updated_at < NOW() - $3::interval)
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id::text, seller_id::text, format, content, processed_rows
+	`, ImportStatusProcessing, ImportStatusPending, fmt.Sprintf("%d seconds", int(importStaleAfter.Seconds())), j.lease).Scan(&j.id, &j.sellerID, &j.format, &j.content, &j.processed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = s.runImport(ctx, j)
+	if errors.Is(err, errImportLeaseLost) {
+		slog.WarnContext(ctx, "order import taken over", "job_id", j.id)
+		return true, nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "order import failed", "job_id", j.id, "error", err)
+		_, ferr := s.db.Exec(ctx, `
+			UPDATE order_import_jobs SET status = $2, error = $3, finished_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND lease_token = $4
+		`, j.id, ImportStatusFailed, err.Error(), j.lease)
+		return true, ferr
+	}
+	return true, nil
+}
+
+func (s *OrderService) runImport(ctx context.Context, j importJobRef) error {
+	rows, err := parseImport(j.format, j.content)
+	if err != nil {
+		return err
+	}
+	// Rows repeated within the file are reported instead of silently skipped
+	firstSeen := map[string]int{}
+	for i, p := range rows {
+		if p.err != "" || p.row.ExternalID == "" {
+			continue
+		}
+		if first, ok := firstSeen[p.row.ExternalID]; ok {
+			rows[i].err = fmt.Sprintf("external_id repeats row %d", first)
+			continue
+		}
+		firstSeen[p.row.ExternalID] = p.num
+	}
+	// Chunks commit with the progress, so a job resumed after a crash
+	// continues after the last committed chunk
+	for start := j.processed; start < len(rows); start += importChunkSize {
+		end := start + importChunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err := s.importChunk(ctx, j, rows[start:end], end); err != nil {
+			return err
+		}
+	}
+	tag, err := s.db.Exec(ctx, `
+		UPDATE order_import_jobs SET status = $2, content = NULL, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND lease_token = $3
+	`, j.id, ImportStatusCompleted, j.lease)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errImportLeaseLost
+	}
+	slog.InfoContext(ctx, "order import completed", "job_id", j.id, "seller_id", j.sellerID)
+	return nil
+}
+
+func (s *OrderService) importChunk(ctx context.Context, j importJobRef, chunk []parsedRow, processed int) error {
+	warehouseIDs := []string{j.sellerID}
+	pvpIDs := make([]string, 0, len(chunk))
+	for _, p := range chunk {
+		if p.row.WarehouseID != "" {
+			warehouseIDs = append(warehouseIDs, p.row.WarehouseID)
+		}
+		if p.row.PVZID != "" {
+			pvpIDs = append(pvpIDs, p.row.PVZID)
+		}
+	}
+	warehouses, err := s.warehouseLocations(ctx, warehouseIDs)
+	if err != nil {
+		return err
+	}
+	pickupPoints, err := s.pickupPointLocations(ctx, pvpIDs)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var created, duplicates, failed int
+	for _, p := range chunk {
+		msg := p.err
+		if msg == "" {
+			msg = validateImportRow(p.row)
+		}
+		params := CreateOrderParams{
+			SellerID:       j.sellerID,
+			ExternalID:     p.row.ExternalID,
+			WarehouseID:    p.row.WarehouseID,
+			PVZID:          p.row.PVZID,
+			CustomerPhone:  p.row.CustomerPhone,
+			CustomerEmail:  p.row.CustomerEmail,
+			CustomerLocale: p.row.CustomerLocale,
+		}
+		if msg == "" {
+			var lerr *LocationError
+			if err := locateOrder(&params, warehouses, pickupPoints); errors.As(err, &lerr) {
+				msg = lerr.Reason
+			}
+		}
+		if msg != "" {
+			failed++
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO order_import_errors (job_id, row_number, external_id, error)
+				VALUES ($1, $2, NULLIF($3, ''), $4)
+				ON CONFLICT (job_id, row_number) DO NOTHING
+			`, j.id, p.num, p.row.ExternalID, msg); err != nil {
+				return err
+			}
+			continue
+		}
+		order, err := s.insertOrderTx(ctx, tx, params, now)
+		if err != nil {
+			return err
+		}
+		if order == nil {
+			duplicates++
+		} else {
+			created++
+		}
+	}
+	// The progress update is the lease check: if the job was taken over, the
+	// whole chunk is rolled back, including the orders it inserted.
+	tag, err := tx.Exec(ctx, `
+		UPDATE order_import_jobs SET processed_rows = $2,
+			created_count = created_count + $3, duplicate_count = duplicate_count + $4, failed_count = failed_count + $5,
+			updated_at = NOW()
+		WHERE id = $1 AND lease_token = $6
+	`, j.id, processed, created, duplicates, failed, j.lease)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errImportLeaseLost
+	}
+	return tx.Commit(ctx)
+}
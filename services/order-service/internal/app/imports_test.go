@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	content := "\ufeffexternal_id,warehouse_id,pvz_id,customer_phone,destination_lat\n" +
+		"A-1,wh-1,pvp-1,+375291112233,53.9\n" +
+		"A-2,wh-1,pv\"p-1,+375291112233,53.9\n" +
+		"A-3,,pvp-2,,\n"
+	rows, err := parseImport(ImportFormatCSV, []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(rows))
+	}
+	if rows[0].err != "" || rows[0].row.ExternalID != "A-1" || rows[0].row.WarehouseID != "wh-1" {
+		t.Fatalf("unexpected first row %+v", rows[0])
+	}
+	if rows[1].err == "" || rows[1].num != 2 {
+		t.Fatalf("expected a parse error on row 2, got %+v", rows[1])
+	}
+	if msg := validateImportRow(rows[2].row); msg != "customer_phone or customer_email is required" {
+		t.Fatalf("expected missing contact error, got %q", msg)
+	}
+}
+
+func TestParseImportCSV_MissingColumn(t *testing.T) {
+	_, err := parseImport(ImportFormatCSV, []byte("id,pvz_id\n1,p\n"))
+	if !errors.Is(err, ErrImportMalformed) {
+		t.Fatalf("expected ErrImportMalformed, got %v", err)
+	}
+}
+
+func TestParseImportNDJSON(t *testing.T) {
+	content := `{"external_id":"A-1","pvz_id":"pvp-1","customer_email":"a@example.com"}` + "\n\n" +
+		`{"external_id":` + "\n"
+	rows, err := parseImport(ImportFormatNDJSON, []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	if rows[0].err != "" || validateImportRow(rows[0].row) != "" {
+		t.Fatalf("expected a valid first row, got %+v", rows[0])
+	}
+	if rows[1].err != "invalid JSON" || rows[1].num != 3 {
+		t.Fatalf("expected invalid JSON on line 3, got %+v", rows[1])
+	}
+}
+
+func TestValidateImportRow(t *testing.T) {
+	valid := ImportRow{ExternalID: "A-1", PVZID: "pvp-1", CustomerPhone: "+375291112233"}
+	if msg := validateImportRow(valid); msg != "" {
+		t.Fatalf("expected valid row, got %q", msg)
+	}
+	cases := map[string]func(r *ImportRow){
+		"external_id is required": func(r *ImportRow) { r.ExternalID = "" },
+		"pvz_id is required":      func(r *ImportRow) { r.PVZID = "" },
+		"customer_phone is invalid": func(r *ImportRow) {
+			r.CustomerPhone = "call me"
+		},
+		"customer_email is invalid": func(r *ImportRow) {
+			r.CustomerEmail = "Bob <bob@example.com>"
+		},
+		"customer_locale must be ru, be or en": func(r *ImportRow) {
+			r.CustomerLocale = "de"
+		},
+	}
+	for want, mutate := range cases {
+		r := valid
+		mutate(&r)
+		if got := validateImportRow(r); got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+// leaseTx matches the progress update only while the worker holds the
+// lease.
+type leaseTx struct {
+	receiptTx
+	holder string
+	leases []any
+}
+
+func (t *leaseTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if _, err := t.receiptTx.Exec(ctx, sql, args...); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	if strings.Contains(sql, "lease_token") {
+		lease := args[len(args)-1]
+		t.leases = append(t.leases, lease)
+		if lease != t.holder {
+			return pgconn.NewCommandTag("UPDATE 0"), nil
+		}
+		return pgconn.NewCommandTag("UPDATE 1"), nil
+	}
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+type importDB struct {
+	receiptDB
+	tx *leaseTx
+}
+
+func (d *importDB) Begin(ctx context.Context) (pgx.Tx, error) { return d.tx, nil }
+func (d *importDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return emptyRows{}, nil
+}
+
+func TestImportChunk_ChecksLease(t *testing.T) {
+	chunk := []parsedRow{{num: 1, err: "invalid JSON"}}
+	for _, tc := range []struct {
+		name, holder string
+		wantErr      error
+	}{
+		{name: "held", holder: "l1"},
+		{name: "taken over", holder: "l2", wantErr: errImportLeaseLost},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &importDB{tx: &leaseTx{holder: tc.holder}}
+			svc := NewOrderService(db, nil, "topic")
+			err := svc.importChunk(context.Background(), importJobRef{id: "j1", sellerID: "s1", lease: "l1"}, chunk, 1)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			if len(db.tx.leases) != 1 || db.tx.leases[0] != "l1" {
+				t.Fatalf("expected the progress update to check the lease, got %v", db.tx.leases)
+			}
+			if db.tx.committed != (tc.wantErr == nil) {
+				t.Fatalf("committed = %v", db.tx.committed)
+			}
+		})
+	}
+}
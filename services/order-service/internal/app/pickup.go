@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"bel-parcel/pkg/codehash"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	StatusDeliveredToCustomer = "DELIVERED_TO_CUSTOMER"
+
+	pickupCodeIssuedEvent   = "orders.pickup_code_issued"
+	pickupCodeTopic         = "orders.pickup_codes"
+	pickupNotificationEvent = "notifications.pickup_code"
+	notificationTopic       = "notifications.customer"
+	pickupCodeDigits        = 6
+)
+
+var errPickupSecretMissing = errors.New("pickup code secret not configured")
+
+type orderContact struct {
+	OrderID       string `json:"order_id"`
+	CustomerPhone string `json:"customer_phone"`
+	CustomerEmail string `json:"customer_email"`
+}
+
+// storeOrderContacts keeps the customer contacts batching passes along with
+// the batch, so the pickup code can be sent when the order reaches the PVP.
+func (s *OrderService) storeOrderContacts(ctx context.Context, contacts []orderContact) error {
+	for _, c := range contacts {
+		if c.OrderID == "" || (c.CustomerPhone == "" && c.CustomerEmail == "") {
+			continue
+		}
+		if _, err := s.db.Exec(ctx, `
+			INSERT INTO order_contacts (order_id, customer_phone, customer_email)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, ''))
+			ON CONFLICT (order_id) DO UPDATE SET
+				customer_phone = EXCLUDED.customer_phone,
+				customer_email = EXCLUDED.customer_email
+		`, c.OrderID, c.CustomerPhone, c.CustomerEmail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newPickupCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < pickupCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", pickupCodeDigits, n), nil
+}
+
+// issuePickupCodeTx generates the one-time code when the order is received
+// at the PVP. The hash, keyed with the pickup code secret shared with
+// mobile-gateway, goes there for verification, the code
+// itself only to the customer together with the end of the storage period.
+func (s *OrderService) issuePickupCodeTx(ctx context.Context, tx pgx.Tx, orderID string, storageExpiresAt, now time.Time) error {
+	if len(s.pickupSecret) == 0 {
+		return errPickupSecretMissing
+	}
+	code, err := newPickupCode()
+	if err != nil {
+		return err
+	}
+	var pvpID string
+	var phone, email *string
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(o.pvz_id::text, ''), c.customer_phone, c.customer_email
+		FROM orders o LEFT JOIN order_contacts c ON c.order_id = o.id::text
+		WHERE o.id = $1
+	`, orderID).Scan(&pvpID, &phone, &email); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if err := enqueueOrderEventTx(ctx, tx, pickupCodeIssuedEvent, pickupCodeTopic, orderID, map[string]interface{}{
+		"order_id":  orderID,
+		"pvp_id":    pvpID,
+		"code_hash": codehash.Hash(s.pickupSecret, orderID, code),
+		"issued_at": now,
+	}, now); err != nil {
+		return err
+	}
+	return enqueueOrderEventTx(ctx, tx, pickupNotificationEvent, notificationTopic, orderID, map[string]interface{}{
+		"order_id":           orderID,
+		"pvp_id":             pvpID,
+		"customer_phone":     phone,
+		"customer_email":     email,
+		"pickup_code":        code,
+		"storage_expires_at": storageExpiresAt,
+		"issued_at":          now,
+	}, now)
+}
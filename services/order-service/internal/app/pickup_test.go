@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type emptyRows struct{}
+
+func (emptyRows) Close()                                       {}
+func (emptyRows) Err() error                                   { return nil }
+func (emptyRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (emptyRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (emptyRows) Next() bool                                   { return false }
+func (emptyRows) Scan(dest ...any) error                       { return nil }
+func (emptyRows) Values() ([]any, error)                       { return nil, nil }
+func (emptyRows) RawValues() [][]byte                          { return nil }
+func (emptyRows) Conn() *pgx.Conn                              { return nil }
+
+type contactsDB struct{ recordingDB }
+
+func (d *contactsDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return emptyRows{}, nil
+}
+
+func TestNewPickupCode(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		code, err := newPickupCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != pickupCodeDigits || strings.Trim(code, "0123456789") != "" {
+			t.Fatalf("unexpected code %q", code)
+		}
+	}
+}
+
+func TestHandleKafkaEvent_BatchFormedStoresContacts(t *testing.T) {
+	db := &contactsDB{}
+	svc := NewOrderService(db, nil, "topic")
+	envelope := map[string]interface{}{
+		"event_id":    "e3",
+		"event_type":  "batches.formed",
+		"occurred_at": time.Now(),
+		"data": map[string]interface{}{
+			"batch_id":  "b1",
+			"order_ids": []string{"o1", "o2"},
+			"order_contacts": []map[string]string{
+				{"order_id": "o1", "customer_phone": "+375291112233"},
+				{"order_id": "o2"},
+			},
+		},
+	}
+	body, _ := json.Marshal(envelope)
+	if err := svc.HandleKafkaEvent(context.Background(), "batches.formed", []byte("b1"), body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var contacts int
+	for _, q := range db.execs {
+		if strings.Contains(q, "INSERT INTO order_contacts") {
+			contacts++
+		}
+	}
+	if contacts != 1 {
+		t.Fatalf("expected one contact stored, got %d", contacts)
+	}
+}
+
+func TestHandleOrderPickedUp_MarkCommitsWithStatus(t *testing.T) {
+	db := &receiptDB{tx: &receiptTx{statuses: map[string]string{"o1": "RECEIVED_BY_PVP"}}}
+	svc := NewOrderService(db, nil, "topic").WithPickupCodeSecret("s1")
+	if err := svc.handleOrderPickedUp(context.Background(), "e1", "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if db.begins != 1 || !db.tx.committed {
+		t.Fatalf("expected the mark and the status change in one committed transaction")
+	}
+
+	// a refused change must not leave the event marked processed
+	db = &receiptDB{tx: &receiptTx{statuses: map[string]string{"o1": "BATCHED"}}}
+	svc = NewOrderService(db, nil, "topic").WithPickupCodeSecret("s1")
+	if err := svc.handleOrderPickedUp(context.Background(), "e1", "o1"); err == nil {
+		t.Fatalf("expected the invalid transition to be returned")
+	}
+	if db.tx.committed || !db.tx.rolledBack {
+		t.Fatalf("the processed mark must roll back with the status change")
+	}
+}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"bel-parcel/services/order-service/internal/outbox"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	StatusMissingAtReceipt = "MISSING_AT_RECEIPT"
+
+	receiptDiscrepancyAlert = "alerts.receipt_discrepancy"
+)
+
+// ReceiptReconciliation compares what the PVP scanned with what the batch
+// was supposed to contain.
+type ReceiptReconciliation struct {
+	Confirmed []string `json:"confirmed"`
+	Missing   []string `json:"missing"`
+	Surplus   []string `json:"surplus"`
+}
+
+func (r ReceiptReconciliation) HasDiscrepancy() bool {
+	return len(r.Missing) > 0 || len(r.Surplus) > 0
+}
+
+func reconcileReceipt(expected, received []string) ReceiptReconciliation {
+	exp := make(map[string]bool, len(expected))
+	for _, id := range expected {
+		exp[id] = true
+	}
+	seen := make(map[string]bool, len(received))
+	rec := ReceiptReconciliation{Confirmed: []string{}, Missing: []string{}, Surplus: []string{}}
+	for _, id := range received {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if exp[id] {
+			rec.Confirmed = append(rec.Confirmed, id)
+		} else {
+			rec.Surplus = append(rec.Surplus, id)
+		}
+	}
+	for _, id := range expected {
+		if !seen[id] {
+			rec.Missing = append(rec.Missing, id)
+		}
+	}
+	sort.Strings(rec.Confirmed)
+	sort.Strings(rec.Missing)
+	sort.Strings(rec.Surplus)
+	return rec
+}
+
+type batchReceipt struct {
+	BatchID     string    `json:"batch_id"`
+	PVPID       string    `json:"pvp_id"`
+	PVPWorkerID string    `json:"pvp_worker_id"`
+	OrderIDs    []string  `json:"order_ids"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+// handleBatchReceived moves only the scanned orders of the batch to
+// RECEIVED_BY_PVP. Orders of the batch that were not scanned become
+// MISSING_AT_RECEIPT; scanned orders that do not belong to the batch are left
+// alone and listed as surplus. A repeated receipt of the batch only touches
+// orders no earlier receipt has accepted. Any mismatch opens a discrepancy case. The
+// status changes, the case and the processed mark of the event commit
+// together, so a failed receipt is redelivered whole instead of half applied.
+func (s *OrderService) handleBatchReceived(ctx context.Context, eventID string, r batchReceipt) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if ok, err := markEventProcessedTx(ctx, tx, eventID); err != nil || !ok {
+		return err
+	}
+	expected, err := batchOrderIDsTx(ctx, tx, r.BatchID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	rec := reconcileReceipt(expected, r.OrderIDs)
+	for _, oid := range rec.Confirmed {
+		status, err := orderStatusTx(ctx, tx, oid)
+		if err != nil {
+			return err
+		}
+		// Scanned again after an earlier receipt already accepted it
+		if status != "DELIVERED_TO_PVP" && status != StatusMissingAtReceipt {
+			continue
+		}
+		if _, err := s.applyOrderStatusTx(ctx, tx, oid, "RECEIVED_BY_PVP", now); err != nil {
+			return err
+		}
+	}
+	missing := []string{}
+	for _, oid := range rec.Missing {
+		status, err := orderStatusTx(ctx, tx, oid)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case "DELIVERED_TO_PVP":
+			if _, err := s.applyOrderStatusTx(ctx, tx, oid, StatusMissingAtReceipt, now); err != nil {
+				return err
+			}
+		case StatusMissingAtReceipt:
+		default:
+			// Received by an earlier scan of the batch, not missing
+			continue
+		}
+		missing = append(missing, oid)
+	}
+	rec.Missing = missing
+	if rec.HasDiscrepancy() {
+		slog.WarnContext(ctx, "receipt discrepancy", "batch_id", r.BatchID, "pvp_id", r.PVPID, "missing", len(rec.Missing), "surplus", len(rec.Surplus))
+		if err := openReceiptDiscrepancyTx(ctx, tx, r, rec, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func orderStatusTx(ctx context.Context, tx pgx.Tx, orderID string) (string, error) {
+	var status string
+	err := tx.QueryRow(ctx, "SELECT status FROM orders WHERE id=$1 FOR UPDATE", orderID).Scan(&status)
+	return status, err
+}
+
+func batchOrderIDsTx(ctx context.Context, tx pgx.Tx, batchID string) ([]string, error) {
+	rows, err := tx.Query(ctx, "SELECT order_id FROM order_batches WHERE batch_id=$1", batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var oid string
+		if err := rows.Scan(&oid); err != nil {
+			return nil, err
+		}
+		ids = append(ids, oid)
+	}
+	return ids, rows.Err()
+}
+
+// openReceiptDiscrepancyTx records the case and raises an operator alert.
+func openReceiptDiscrepancyTx(ctx context.Context, tx pgx.Tx, r batchReceipt, rec ReceiptReconciliation, now time.Time) error {
+	caseID := uuid.NewString()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO receipt_discrepancies (id, batch_id, pvp_id, pvp_worker_id, missing_order_ids, surplus_order_ids, status, received_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, 'OPEN', $7, $8)
+	`, caseID, r.BatchID, r.PVPID, r.PVPWorkerID, rec.Missing, rec.Surplus, r.ReceivedAt, now); err != nil {
+		return err
+	}
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     receiptDiscrepancyAlert,
+		"occurred_at":    now,
+		"correlation_id": caseID,
+		"data": map[string]interface{}{
+			"discrepancy_id": caseID,
+			"batch_id":       r.BatchID,
+			"pvp_id":         r.PVPID,
+			"missing":        rec.Missing,
+			"surplus":        rec.Surplus,
+			"message":        fmt.Sprintf("Приёмка партии %s на ПВЗ %s: недостача %d, лишних заказов %d", r.BatchID, r.PVPID, len(rec.Missing), len(rec.Surplus)),
+			"severity":       "warning",
+		},
+	}
+	payload, _ := json.Marshal(envelope)
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     receiptDiscrepancyAlert,
+		CorrelationID: caseID,
+		Topic:         receiptDiscrepancyAlert,
+		PartitionKey:  r.BatchID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+type ReceiptDiscrepancy struct {
+	ID         string    `json:"id"`
+	BatchID    string    `json:"batch_id"`
+	PVPID      string    `json:"pvp_id"`
+	Missing    []string  `json:"missing"`
+	Surplus    []string  `json:"surplus"`
+	Status     string    `json:"status"`
+	ReceivedAt time.Time `json:"received_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ReceiptDiscrepancies lists discrepancy cases, optionally for one batch.
+func (s *OrderService) ReceiptDiscrepancies(ctx context.Context, batchID string) ([]ReceiptDiscrepancy, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, batch_id, pvp_id, missing_order_ids, surplus_order_ids, status, received_at, created_at
+		FROM receipt_discrepancies
+		WHERE $1 = '' OR batch_id = $1
+		ORDER BY created_at DESC
+	`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []ReceiptDiscrepancy{}
+	for rows.Next() {
+		var d ReceiptDiscrepancy
+		if err := rows.Scan(&d.ID, &d.BatchID, &d.PVPID, &d.Missing, &d.Surplus, &d.Status, &d.ReceivedAt, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestReconcileReceipt(t *testing.T) {
+	rec := reconcileReceipt([]string{"o1", "o2", "o3"}, []string{"o3", "o1", "o9", "o1"})
+	if !reflect.DeepEqual(rec.Confirmed, []string{"o1", "o3"}) {
+		t.Fatalf("confirmed = %v", rec.Confirmed)
+	}
+	if !reflect.DeepEqual(rec.Missing, []string{"o2"}) {
+		t.Fatalf("missing = %v", rec.Missing)
+	}
+	if !reflect.DeepEqual(rec.Surplus, []string{"o9"}) {
+		t.Fatalf("surplus = %v", rec.Surplus)
+	}
+	if !rec.HasDiscrepancy() {
+		t.Fatalf("expected a discrepancy")
+	}
+	if reconcileReceipt([]string{"o1"}, []string{"o1"}).HasDiscrepancy() {
+		t.Fatalf("a full receipt must not be a discrepancy")
+	}
+}
+
+type idRows struct {
+	emptyRows
+	ids []string
+	i   int
+}
+
+func (r *idRows) Next() bool { r.i++; return r.i <= len(r.ids) }
+func (r *idRows) Scan(dest ...any) error {
+	*dest[0].(*string) = r.ids[r.i-1]
+	return nil
+}
+
+type valueRow struct {
+	val string
+	err error
+}
+
+func (r valueRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*string) = r.val
+	return nil
+}
+
+// receiptTx plays a batch of o1 and o2 delivered to the PVP, unless
+// statuses says otherwise, and records what the receipt writes.
+type receiptTx struct {
+	pgx.Tx
+	statuses   map[string]string
+	execs      []string
+	failOn     string
+	committed  bool
+	rolledBack bool
+}
+
+func (t *receiptTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	t.execs = append(t.execs, sql)
+	if t.failOn != "" && strings.Contains(sql, t.failOn) {
+		return pgconn.CommandTag{}, errors.New("boom")
+	}
+	return pgconn.CommandTag{}, nil
+}
+func (t *receiptTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return &idRows{ids: []string{"o1", "o2"}}, nil
+}
+func (t *receiptTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	switch {
+	case strings.Contains(sql, "processed_events"):
+		return valueRow{val: "e1"}
+	case strings.Contains(sql, "SELECT status FROM orders"):
+		if status, ok := t.statuses[args[0].(string)]; ok {
+			return valueRow{val: status}
+		}
+		return valueRow{val: "DELIVERED_TO_PVP"}
+	}
+	return valueRow{err: pgx.ErrNoRows}
+}
+func (t *receiptTx) Commit(ctx context.Context) error   { t.committed = true; return nil }
+func (t *receiptTx) Rollback(ctx context.Context) error { t.rolledBack = !t.committed; return nil }
+
+type receiptDB struct {
+	stubDB
+	tx     *receiptTx
+	begins int
+}
+
+func (d *receiptDB) Begin(ctx context.Context) (pgx.Tx, error) { d.begins++; return d.tx, nil }
+
+func TestHandleBatchReceived_OneTransaction(t *testing.T) {
+	db := &receiptDB{tx: &receiptTx{}}
+	svc := NewOrderService(db, nil, "topic").WithPickupCodeSecret("s1")
+	err := svc.handleBatchReceived(context.Background(), "e1", batchReceipt{BatchID: "b1", PVPID: "p1", OrderIDs: []string{"o1", "o9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.begins != 1 || !db.tx.committed {
+		t.Fatalf("expected one committed transaction, got %d begins", db.begins)
+	}
+	var statusUpdates int
+	var discrepancy bool
+	for _, q := range db.tx.execs {
+		if strings.Contains(q, "UPDATE orders SET status") {
+			statusUpdates++
+		}
+		discrepancy = discrepancy || strings.Contains(q, "receipt_discrepancies")
+	}
+	if statusUpdates != 2 || !discrepancy {
+		t.Fatalf("expected status updates and the discrepancy case in the transaction, got %v", db.tx.execs)
+	}
+}
+
+func TestHandleBatchReceived_FailureRollsBackEverything(t *testing.T) {
+	db := &receiptDB{tx: &receiptTx{failOn: "receipt_discrepancies"}}
+	svc := NewOrderService(db, nil, "topic").WithPickupCodeSecret("s1")
+	err := svc.handleBatchReceived(context.Background(), "e1", batchReceipt{BatchID: "b1", PVPID: "p1", OrderIDs: []string{"o1"}})
+	if err == nil {
+		t.Fatalf("expected the failed discrepancy insert to be returned")
+	}
+	if db.tx.committed || !db.tx.rolledBack {
+		t.Fatalf("status updates and the processed mark must roll back with the case")
+	}
+}
+
+func TestHandleBatchReceived_SecondPartialReceipt(t *testing.T) {
+	// the first receipt accepted o1, which the customer has since collected,
+	// and reported o2 missing; the second scan finds only o2
+	db := &receiptDB{tx: &receiptTx{statuses: map[string]string{"o1": StatusDeliveredToCustomer, "o2": StatusMissingAtReceipt}}}
+	svc := NewOrderService(db, nil, "topic").WithPickupCodeSecret("s1")
+	err := svc.handleBatchReceived(context.Background(), "e2", batchReceipt{BatchID: "b1", PVPID: "p1", OrderIDs: []string{"o2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.tx.committed {
+		t.Fatalf("expected the second receipt to commit")
+	}
+	var statusUpdates int
+	for _, q := range db.tx.execs {
+		if strings.Contains(q, "UPDATE orders SET status") {
+			statusUpdates++
+		}
+		if strings.Contains(q, "receipt_discrepancies") {
+			t.Fatalf("an order accepted by the first receipt is not missing")
+		}
+	}
+	if statusUpdates != 1 {
+		t.Fatalf("expected only o2 to be received, got %d status updates", statusUpdates)
+	}
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"bel-parcel/services/order-service/internal/infra/reference"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrInvalidLocation is returned for orders whose warehouse or pickup point
+// is unknown to the reference data, inactive or has no coordinates.
+var ErrInvalidLocation = errors.New("invalid location")
+
+// LocationError tells the seller what is wrong with the order's locations.
+type LocationError struct {
+	Reason string
+}
+
+func (e *LocationError) Error() string { return "invalid location: " + e.Reason }
+
+func (e *LocationError) Is(target error) bool { return target == ErrInvalidLocation }
+
+type refPickupPoint struct {
+	ID        string  `json:"pvp_id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	IsHub     bool    `json:"is_hub"`
+	IsActive  *bool   `json:"is_active"`
+}
+
+type refWarehouse struct {
+	ID        string  `json:"warehouse_id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	IsActive  *bool   `json:"is_active"`
+}
+
+// refLocation is a warehouse or pickup point as known to order-service.
+type refLocation struct {
+	Latitude  *float64
+	Longitude *float64
+	Active    bool
+}
+
+// execer is satisfied by both the pool and a transaction, so the reference
+// upserts serve single events and the snapshot bootstrap alike.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// upsertPickupPoint keeps the local copy of the pickup points used to
+// validate orders. Updates that carry only the hub flag keep the known name,
+// coordinates and activity. seq is the change number of the reference record;
+// rows built from a newer change are left as they are.
+func upsertPickupPoint(ctx context.Context, ex execer, p refPickupPoint, seq int64) error {
+	if p.ID == "" {
+		return nil
+	}
+	_, err := ex.Exec(ctx, `
+		INSERT INTO ref_pickup_points (pvp_id, name, latitude, longitude, is_hub, is_active, source_seq, updated_at)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, 0), $5, COALESCE($6, true), $7, NOW())
+		ON CONFLICT (pvp_id) DO UPDATE SET
+			name = COALESCE(NULLIF(EXCLUDED.name, ''), ref_pickup_points.name),
+			latitude = COALESCE(EXCLUDED.latitude, ref_pickup_points.latitude),
+			longitude = COALESCE(EXCLUDED.longitude, ref_pickup_points.longitude),
+			is_hub = EXCLUDED.is_hub,
+			is_active = COALESCE($6, ref_pickup_points.is_active),
+			source_seq = EXCLUDED.source_seq,
+			updated_at = NOW()
+		WHERE ref_pickup_points.source_seq <= EXCLUDED.source_seq
+	`, p.ID, p.Name, p.Latitude, p.Longitude, p.IsHub, p.IsActive, seq)
+	return err
+}
+
+// upsertWarehouse keeps the local copy of the warehouses orders are shipped
+// from, the same way batching-service does.
+func upsertWarehouse(ctx context.Context, ex execer, w refWarehouse, seq int64) error {
+	if w.ID == "" {
+		return nil
+	}
+	_, err := ex.Exec(ctx, `
+		INSERT INTO ref_warehouses (warehouse_id, name, latitude, longitude, is_active, source_seq, updated_at)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, 0), COALESCE($5, true), $6, NOW())
+		ON CONFLICT (warehouse_id) DO UPDATE SET
+			name = COALESCE(NULLIF(EXCLUDED.name, ''), ref_warehouses.name),
+			latitude = COALESCE(EXCLUDED.latitude, ref_warehouses.latitude),
+			longitude = COALESCE(EXCLUDED.longitude, ref_warehouses.longitude),
+			is_active = COALESCE($5, ref_warehouses.is_active),
+			source_seq = EXCLUDED.source_seq,
+			updated_at = NOW()
+		WHERE ref_warehouses.source_seq <= EXCLUDED.source_seq
+	`, w.ID, w.Name, w.Latitude, w.Longitude, w.IsActive, seq)
+	return err
+}
+
+// ApplyReferenceSnapshot fills the pickup point, warehouse and storage period
+// projections from a reference-service snapshot. Events consumed afterwards
+// only overwrite rows when they are newer than the snapshot.
+func (s *OrderService) ApplyReferenceSnapshot(ctx context.Context, snap *reference.Snapshot) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, p := range snap.PickupPoints {
+		active := p.IsActive
+		ref := refPickupPoint{ID: p.ID, Name: p.Name, Latitude: p.Latitude, Longitude: p.Longitude, IsHub: p.IsHub, IsActive: &active}
+		if err := upsertPickupPoint(ctx, tx, ref, p.Seq); err != nil {
+			return err
+		}
+		if err := upsertPVPStorage(ctx, tx, p.ID, p.StorageDays, p.Seq); err != nil {
+			return err
+		}
+	}
+	for _, w := range snap.Warehouses {
+		active := w.IsActive
+		ref := refWarehouse{ID: w.ID, Name: w.Name, Latitude: w.Latitude, Longitude: w.Longitude, IsActive: &active}
+		if err := upsertWarehouse(ctx, tx, ref, w.Seq); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("reference snapshot applied", "seq", snap.Seq,
+		"pickup_points", len(snap.PickupPoints), "warehouses", len(snap.Warehouses))
+	return nil
+}
+
+func (s *OrderService) pickupPointLocations(ctx context.Context, ids []string) (map[string]refLocation, error) {
+	return s.lookupLocations(ctx, `
+		SELECT pvp_id, latitude, longitude, is_active FROM ref_pickup_points WHERE pvp_id = ANY($1)
+	`, ids)
+}
+
+func (s *OrderService) warehouseLocations(ctx context.Context, ids []string) (map[string]refLocation, error) {
+	return s.lookupLocations(ctx, `
+		SELECT warehouse_id, latitude, longitude, is_active FROM ref_warehouses WHERE warehouse_id = ANY($1)
+	`, ids)
+}
+
+func (s *OrderService) lookupLocations(ctx context.Context, query string, ids []string) (map[string]refLocation, error) {
+	found := make(map[string]refLocation, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	rows, err := s.db.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var l refLocation
+		if err := rows.Scan(&id, &l.Latitude, &l.Longitude, &l.Active); err != nil {
+			return nil, err
+		}
+		found[id] = l
+	}
+	return found, rows.Err()
+}
+
+// locateOrder checks the order's warehouse and pickup point against the
+// reference data and fills in their coordinates. Coordinates sent by the
+// seller are never used.
+func locateOrder(p *CreateOrderParams, warehouses, pickupPoints map[string]refLocation) error {
+	if p.WarehouseID == "" {
+		p.WarehouseID = p.SellerID
+	}
+	wh, err := checkLocation(warehouses, "warehouse_id", p.WarehouseID)
+	if err != nil {
+		return err
+	}
+	pvp, err := checkLocation(pickupPoints, "pvz_id", p.PVZID)
+	if err != nil {
+		return err
+	}
+	p.WarehouseLat, p.WarehouseLng = *wh.Latitude, *wh.Longitude
+	p.DestinationLat, p.DestinationLng = *pvp.Latitude, *pvp.Longitude
+	return nil
+}
+
+func checkLocation(known map[string]refLocation, field, id string) (refLocation, error) {
+	l, ok := known[id]
+	switch {
+	case !ok:
+		return l, &LocationError{Reason: "unknown " + field}
+	case !l.Active:
+		return l, &LocationError{Reason: field + " is inactive"}
+	case l.Latitude == nil || l.Longitude == nil:
+		return l, &LocationError{Reason: field + " has no coordinates"}
+	}
+	return l, nil
+}
+
+// resolveLocations looks up the locations of a single order.
+func (s *OrderService) resolveLocations(ctx context.Context, p *CreateOrderParams) error {
+	warehouseID := p.WarehouseID
+	if warehouseID == "" {
+		warehouseID = p.SellerID
+	}
+	warehouses, err := s.warehouseLocations(ctx, []string{warehouseID})
+	if err != nil {
+		return err
+	}
+	pickupPoints, err := s.pickupPointLocations(ctx, []string{p.PVZID})
+	if err != nil {
+		return err
+	}
+	return locateOrder(p, warehouses, pickupPoints)
+}
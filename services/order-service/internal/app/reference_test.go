@@ -0,0 +1,52 @@
+package app
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLocateOrder_FillsCoordinatesFromReference(t *testing.T) {
+	lat, lng := 53.9, 27.56
+	plat, plng := 52.1, 23.7
+	warehouses := map[string]refLocation{"s1": {Latitude: &lat, Longitude: &lng, Active: true}}
+	pickupPoints := map[string]refLocation{"p1": {Latitude: &plat, Longitude: &plng, Active: true}}
+
+	p := CreateOrderParams{SellerID: "s1", PVZID: "p1", DestinationLat: 1, DestinationLng: 1}
+	if err := locateOrder(&p, warehouses, pickupPoints); err != nil {
+		t.Fatal(err)
+	}
+	if p.WarehouseID != "s1" {
+		t.Fatalf("expected the seller's warehouse, got %q", p.WarehouseID)
+	}
+	if p.WarehouseLat != lat || p.WarehouseLng != lng || p.DestinationLat != plat || p.DestinationLng != plng {
+		t.Fatalf("coordinates not taken from the reference: %+v", p)
+	}
+}
+
+func TestLocateOrder_RejectsUnknownAndInactive(t *testing.T) {
+	lat, lng := 53.9, 27.56
+	warehouses := map[string]refLocation{
+		"s1":  {Latitude: &lat, Longitude: &lng, Active: true},
+		"old": {Latitude: &lat, Longitude: &lng, Active: false},
+	}
+	pickupPoints := map[string]refLocation{
+		"p1":     {Latitude: &lat, Longitude: &lng, Active: true},
+		"nocoor": {Active: true},
+	}
+	cases := map[string]CreateOrderParams{
+		"unknown pvz_id":            {SellerID: "s1", PVZID: "p2"},
+		"unknown warehouse_id":      {SellerID: "s2", PVZID: "p1"},
+		"warehouse_id is inactive":  {SellerID: "s1", WarehouseID: "old", PVZID: "p1"},
+		"pvz_id has no coordinates": {SellerID: "s1", PVZID: "nocoor"},
+	}
+	for want, p := range cases {
+		err := locateOrder(&p, warehouses, pickupPoints)
+		var lerr *LocationError
+		if !errors.As(err, &lerr) || lerr.Reason != want {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+		if !errors.Is(err, ErrInvalidLocation) {
+			t.Fatalf("expected ErrInvalidLocation, got %v", err)
+		}
+	}
+}
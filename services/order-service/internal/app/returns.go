@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ReturnTypeStorageExpired = "storage_expired"
+	ReturnTypeCustomer       = "customer"
+
+	returnCreatedEvent         = "orders.return_created"
+	returnsTopic               = "orders.returns"
+	sellerReturnCreatedEvent   = "sellers.return_created"
+	sellerReturnDeliveredEvent = "sellers.return_delivered"
+	sellerNotificationTopic    = "sellers.notifications"
+)
+
+// ReturnOrder is a shipment of an order back from a PVP to the seller's
+// warehouse, either uncollected or returned by the customer.
+type ReturnOrder struct {
+	ID          string     `json:"id"`
+	OrderID     string     `json:"order_id"`
+	SellerID    string     `json:"seller_id,omitempty"`
+	Type        string     `json:"type"`
+	Reason      string     `json:"reason"`
+	Comment     string     `json:"comment,omitempty"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	OriginPVPID string     `json:"origin_pvp_id"`
+	WarehouseID string     `json:"warehouse_id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ReturnedAt  *time.Time `json:"returned_at,omitempty"`
+}
+
+type customerReturnRequest struct {
+	ReturnID    string    `json:"return_id"`
+	OrderID     string    `json:"order_id"`
+	PVPID       string    `json:"pvp_id"`
+	Reason      string    `json:"reason"`
+	Comment     string    `json:"comment"`
+	RequestedBy string    `json:"pvp_worker_id"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// openReturnTx records the return and hands it to batching, which groups
+// returns into batches from the PVP to the warehouse. The seller learns about
+// it from sellers.notifications.
+func openReturnTx(ctx context.Context, tx pgx.Tx, r ReturnOrder) error {
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO return_orders (id, order_id, return_type, reason, comment, requested_by, origin_pvp_id, warehouse_id, status, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, NULLIF($8, ''), 'CREATED', $9)
+	`, r.ID, r.OrderID, r.Type, r.Reason, r.Comment, r.RequestedBy, r.OriginPVPID, r.WarehouseID, r.CreatedAt); err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"return_id":    r.ID,
+		"order_id":     r.OrderID,
+		"seller_id":    r.SellerID,
+		"return_type":  r.Type,
+		"pvp_id":       r.OriginPVPID,
+		"warehouse_id": r.WarehouseID,
+		"reason":       r.Reason,
+		"created_at":   r.CreatedAt,
+	}
+	if err := enqueueOrderEventTx(ctx, tx, returnCreatedEvent, returnsTopic, r.OrderID, data, r.CreatedAt); err != nil {
+		return err
+	}
+	return enqueueOrderEventTx(ctx, tx, sellerReturnCreatedEvent, sellerNotificationTopic, r.OrderID, data, r.CreatedAt)
+}
+
+// createCustomerReturn opens a return for an order the customer brought back
+// to a PVP. Only orders handed over to the customer can be returned. The
+// processed mark of the request event commits with the return.
+func (s *OrderService) createCustomerReturn(ctx context.Context, eventID string, req customerReturnRequest) error {
+	if req.ReturnID == "" || req.OrderID == "" || req.PVPID == "" {
+		return fmt.Errorf("incomplete return request")
+	}
+	now := req.RequestedAt
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if ok, err := markEventProcessedTx(ctx, tx, eventID); err != nil || !ok {
+		return err
+	}
+	var status, warehouseID, sellerID string
+	err = tx.QueryRow(ctx, `
+		SELECT status, COALESCE(warehouse_id::text, seller_id::text, ''), COALESCE(seller_id::text, '')
+		FROM orders WHERE id = $1 FOR UPDATE
+	`, req.OrderID).Scan(&status, &warehouseID, &sellerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("order %s not found", req.OrderID)
+	}
+	if err != nil {
+		return err
+	}
+	if status == StatusReturning || status == StatusReturnedToSeller {
+		// Return already open for this order
+		return tx.Commit(ctx)
+	}
+	if status != StatusDeliveredToCustomer {
+		return fmt.Errorf("order %s in status %s cannot be returned", req.OrderID, status)
+	}
+	if _, err := s.applyOrderStatusTx(ctx, tx, req.OrderID, StatusReturning, now); err != nil {
+		return err
+	}
+	if err := openReturnTx(ctx, tx, ReturnOrder{
+		ID:          req.ReturnID,
+		OrderID:     req.OrderID,
+		SellerID:    sellerID,
+		Type:        ReturnTypeCustomer,
+		Reason:      req.Reason,
+		Comment:     req.Comment,
+		RequestedBy: req.RequestedBy,
+		OriginPVPID: req.PVPID,
+		WarehouseID: warehouseID,
+		CreatedAt:   now,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// completeReturnTx closes the return once its batch reached the warehouse.
+func completeReturnTx(ctx context.Context, tx pgx.Tx, orderID string, now time.Time) error {
+	var returnID, returnType, sellerID string
+	err := tx.QueryRow(ctx, `
+		UPDATE return_orders r SET status='RETURNED', returned_at=$2
+		FROM orders o
+		WHERE r.order_id = $1 AND o.id = r.order_id
+		RETURNING r.id::text, r.return_type, COALESCE(o.seller_id::text, '')
+	`, orderID, now).Scan(&returnID, &returnType, &sellerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return enqueueOrderEventTx(ctx, tx, sellerReturnDeliveredEvent, sellerNotificationTopic, orderID, map[string]interface{}{
+		"return_id":   returnID,
+		"order_id":    orderID,
+		"seller_id":   sellerID,
+		"return_type": returnType,
+		"returned_at": now,
+	}, now)
+}
+
+// markReturnsInTransit records that the returns of a return batch left the PVP.
+func (s *OrderService) markReturnsInTransit(ctx context.Context, orderIDs []string) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE return_orders SET status='IN_TRANSIT' WHERE order_id::text = ANY($1) AND status='CREATED'
+	`, orderIDs)
+	return err
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type noRow struct{}
+
+func (noRow) Scan(dest ...any) error { return pgx.ErrNoRows }
+
+type missingOrderDB struct{ stubDB }
+
+func (d *missingOrderDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return noRow{}
+}
+
+func TestCreateCustomerReturn_RequiresOrderAndPVP(t *testing.T) {
+	svc := NewOrderService(&stubDB{}, nil, "topic")
+	for _, req := range []customerReturnRequest{
+		{OrderID: "o1", PVPID: "p1"},
+		{ReturnID: "r1", PVPID: "p1"},
+		{ReturnID: "r1", OrderID: "o1"},
+	} {
+		if err := svc.createCustomerReturn(context.Background(), "e1", req); err == nil {
+			t.Fatalf("expected incomplete request %+v to be rejected", req)
+		}
+	}
+}
+
+func TestOrderTimeline_UnknownOrder(t *testing.T) {
+	svc := NewOrderService(&missingOrderDB{}, nil, "topic")
+	if _, err := svc.OrderTimeline(context.Background(), "s1", "o1"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+type stringsRow []string
+
+func (r stringsRow) Scan(dest ...any) error {
+	for i := range dest {
+		*dest[i].(*string) = r[i]
+	}
+	return nil
+}
+
+// deliveredOrderTx holds one order already handed over to the customer.
+type deliveredOrderTx struct{ receiptTx }
+
+func (t *deliveredOrderTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	if strings.Contains(sql, "COALESCE(warehouse_id::text") {
+		return stringsRow{StatusDeliveredToCustomer, "w1", "s1"}
+	}
+	return stringsRow{StatusDeliveredToCustomer}
+}
+
+type deliveredOrderDB struct {
+	stubDB
+	tx *deliveredOrderTx
+}
+
+func (d *deliveredOrderDB) Begin(ctx context.Context) (pgx.Tx, error) { return d.tx, nil }
+
+func TestCreateCustomerReturn_DeliveredOrderStartsReturning(t *testing.T) {
+	db := &deliveredOrderDB{tx: &deliveredOrderTx{}}
+	svc := NewOrderService(db, nil, "topic")
+	err := svc.createCustomerReturn(context.Background(), "e1", customerReturnRequest{ReturnID: "r1", OrderID: "o1", PVPID: "p1", Reason: "damaged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.tx.committed {
+		t.Fatalf("expected the return to be committed")
+	}
+	var statusUpdate, returnOpened bool
+	for _, q := range db.tx.execs {
+		statusUpdate = statusUpdate || strings.Contains(q, "UPDATE orders SET status")
+		returnOpened = returnOpened || strings.Contains(q, "INSERT INTO return_orders")
+	}
+	if !statusUpdate || !returnOpened {
+		t.Fatalf("expected the order to move to RETURNING with a return opened, got %v", db.tx.execs)
+	}
+}
+
+func TestCreateCustomerReturn_FailureKeepsEventUnprocessed(t *testing.T) {
+	db := &deliveredOrderDB{tx: &deliveredOrderTx{receiptTx{failOn: "INSERT INTO return_orders"}}}
+	svc := NewOrderService(db, nil, "topic")
+	err := svc.createCustomerReturn(context.Background(), "e1", customerReturnRequest{ReturnID: "r1", OrderID: "o1", PVPID: "p1"})
+	if err == nil {
+		t.Fatalf("expected the failed return insert to be returned")
+	}
+	if db.tx.committed || !db.tx.rolledBack {
+		t.Fatalf("the processed mark must roll back with the return")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bel-parcel/services/order-service/internal/outbox"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -15,11 +16,25 @@ import (
 )
 
 type Order struct {
-	ID        string    `json:"id"`
-	SellerID  string    `json:"seller_id"`
-	PVZID     string    `json:"pvz_id"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
+	ID             string    `json:"id"`
+	ExternalID     string    `json:"external_id,omitempty"`
+	TrackingNumber string    `json:"tracking_number"`
+	SellerID       string    `json:"seller_id"`
+	WarehouseID    string    `json:"warehouse_id"`
+	PVZID          string    `json:"pvz_id"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+var ErrDuplicateOrder = errors.New("order with this external id already exists")
+
+// customerLocales are the languages customer notifications are written in.
+var customerLocales = map[string]bool{"ru": true, "be": true, "en": true}
+
+// ValidCustomerLocale reports whether notifications can be sent in locale;
+// an empty locale leaves the choice to the notification service.
+func ValidCustomerLocale(locale string) bool {
+	return locale == "" || customerLocales[locale]
 }
 
 type DB interface {
@@ -34,17 +49,31 @@ type OrderService struct {
 	db       DB
 	producer *kafka.Producer
 	topic    string
+
+	pickupSecret []byte
 }
 
 func NewOrderService(db DB, producer *kafka.Producer, topic string) *OrderService {
 	return &OrderService{db: db, producer: producer, topic: topic}
 }
 
+// WithPickupCodeSecret sets the key pickup codes are hashed with. It must be
+// the one mobile-gateway verifies codes with.
+func (s *OrderService) WithPickupCodeSecret(secret string) *OrderService {
+	s.pickupSecret = []byte(secret)
+	return s
+}
+
+// CreateOrderParams describes a new order. The coordinates are filled in from
+// the reference data, not taken from the seller.
 type CreateOrderParams struct {
 	SellerID       string
+	ExternalID     string
+	WarehouseID    string
 	PVZID          string
 	CustomerPhone  string
 	CustomerEmail  string
+	CustomerLocale string
 	WarehouseLat   float64
 	WarehouseLng   float64
 	DestinationLat float64
@@ -52,27 +81,59 @@ type CreateOrderParams struct {
 }
 
 func (s *OrderService) CreateOrder(ctx context.Context, params CreateOrderParams) (*Order, error) {
-	id := uuid.New().String()
-	now := time.Now().UTC()
-
-	order := &Order{
-		ID:        id,
-		SellerID:  params.SellerID,
-		PVZID:     params.PVZID,
-		Status:    "CREATED",
-		CreatedAt: now,
+	if err := s.resolveLocations(ctx, &params); err != nil {
+		return nil, err
 	}
-
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("begin tx failed: %w", err)
 	}
-	_, err = tx.Exec(ctx, `INSERT INTO orders (id, seller_id, pvz_id, status, created_at) VALUES ($1, $2, $3, $4, $5)`,
-		order.ID, order.SellerID, order.PVZID, order.Status, order.CreatedAt)
+	defer tx.Rollback(ctx)
+	order, err := s.insertOrderTx(ctx, tx, params, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, ErrDuplicateOrder
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit failed: %w", err)
+	}
+
+	slog.InfoContext(ctx, "order created", "order_id", order.ID, "seller_id", order.SellerID)
+	return order, nil
+}
+
+// insertOrderTx stores a new order with its orders.created event. An order
+// whose external id the seller already used is skipped and nil is returned.
+func (s *OrderService) insertOrderTx(ctx context.Context, tx pgx.Tx, params CreateOrderParams, now time.Time) (*Order, error) {
+	trackingNumber, err := newTrackingNumber()
+	if err != nil {
+		return nil, fmt.Errorf("tracking number: %w", err)
+	}
+	order := &Order{
+		ID:             uuid.New().String(),
+		ExternalID:     params.ExternalID,
+		TrackingNumber: trackingNumber,
+		SellerID:       params.SellerID,
+		WarehouseID:    params.WarehouseID,
+		PVZID:          params.PVZID,
+		Status:         "CREATED",
+		CreatedAt:      now,
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO orders (id, external_id, tracking_number, seller_id, pvz_id, status, created_at,
+			warehouse_id, warehouse_lat, warehouse_lng, destination_lat, destination_lng)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (seller_id, external_id) WHERE external_id IS NOT NULL DO NOTHING
+	`, order.ID, order.ExternalID, order.TrackingNumber, order.SellerID, order.PVZID, order.Status, order.CreatedAt,
+		order.WarehouseID, params.WarehouseLat, params.WarehouseLng, params.DestinationLat, params.DestinationLng)
 	if err != nil {
-		tx.Rollback(ctx)
 		return nil, fmt.Errorf("db insert failed: %w", err)
 	}
+	if tag.RowsAffected() == 0 {
+		return nil, nil
+	}
 	envelope := map[string]interface{}{
 		"event_id":       uuid.NewString(),
 		"event_type":     "orders.created",
@@ -80,12 +141,16 @@ func (s *OrderService) CreateOrder(ctx context.Context, params CreateOrderParams
 		"correlation_id": order.ID,
 		"data": map[string]interface{}{
 			"order_id":           order.ID,
-			"warehouse_id":       order.SellerID,
+			"tracking_number":    order.TrackingNumber,
+			"warehouse_id":       order.WarehouseID,
 			"warehouse_lat":      params.WarehouseLat,
 			"warehouse_lng":      params.WarehouseLng,
 			"destination_pvp_id": order.PVZID,
 			"destination_lat":    params.DestinationLat,
 			"destination_lng":    params.DestinationLng,
+			"customer_phone":     params.CustomerPhone,
+			"customer_email":     params.CustomerEmail,
+			"customer_locale":    params.CustomerLocale,
 			"created_at":         order.CreatedAt,
 		},
 	}
@@ -100,14 +165,8 @@ func (s *OrderService) CreateOrder(ctx context.Context, params CreateOrderParams
 		OccurredAt:    now,
 	}
 	if err := outbox.EnqueueTx(ctx, tx, evt); err != nil {
-		tx.Rollback(ctx)
 		return nil, fmt.Errorf("outbox enqueue failed: %w", err)
 	}
-	if err := tx.Commit(ctx); err != nil {
-		return nil, fmt.Errorf("commit failed: %w", err)
-	}
-
-	slog.InfoContext(ctx, "order created", "order_id", order.ID)
 	return order, nil
 }
 
@@ -132,17 +191,57 @@ func (s *OrderService) HandleKafkaEvent(ctx context.Context, topic string, key,
 			return nil
 		}
 		var data struct {
-			BatchID  string    `json:"batch_id"`
-			OrderIDs []string  `json:"order_ids"`
-			FormedAt time.Time `json:"formed_at"`
+			BatchID         string         `json:"batch_id"`
+			ParentBatchID   string         `json:"parent_batch_id"`
+			DestinationType string         `json:"destination_type"`
+			OrderIDs        []string       `json:"order_ids"`
+			OrderContacts   []orderContact `json:"order_contacts"`
+			FormedAt        time.Time      `json:"formed_at"`
 		}
 		if err := json.Unmarshal(envelope.Data, &data); err != nil {
 			return err
 		}
+		if data.ParentBatchID != "" {
+			// Sub-batch of a split batch: the orders are already BATCHED
+			return s.moveBatchOrders(ctx, data.ParentBatchID, data.BatchID, data.OrderIDs)
+		}
+		if data.DestinationType == "warehouse" {
+			// Return batch: the orders stay RETURNING until it is delivered
+			if err := s.storeBatchOrders(ctx, data.BatchID, data.OrderIDs); err != nil {
+				return err
+			}
+			return s.markReturnsInTransit(ctx, data.OrderIDs)
+		}
 		if err := s.storeBatchOrders(ctx, data.BatchID, data.OrderIDs); err != nil {
 			return err
 		}
+		if err := s.storeOrderContacts(ctx, data.OrderContacts); err != nil {
+			return err
+		}
 		return s.applyOrdersStatusForBatch(ctx, data.BatchID, "BATCHED")
+	case "batches.split":
+		var envelope struct {
+			EventID       string          `json:"event_id"`
+			EventType     string          `json:"event_type"`
+			OccurredAt    time.Time       `json:"occurred_at"`
+			CorrelationID string          `json:"correlation_id"`
+			Data          json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		if ok, _ := s.markEventProcessed(ctx, envelope.EventID); !ok {
+			return nil
+		}
+		var data struct {
+			ParentBatchID string   `json:"parent_batch_id"`
+			BatchID       string   `json:"batch_id"`
+			OrderIDs      []string `json:"order_ids"`
+		}
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return err
+		}
+		return s.moveBatchOrders(ctx, data.ParentBatchID, data.BatchID, data.OrderIDs)
 	case "events.batch_picked_up":
 		var envelope struct {
 			EventID       string          `json:"event_id"`
@@ -191,18 +290,117 @@ func (s *OrderService) HandleKafkaEvent(ctx context.Context, topic string, key,
 		if err := json.Unmarshal(value, &envelope); err != nil {
 			return err
 		}
+		var data batchReceipt
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return err
+		}
+		return s.handleBatchReceived(ctx, envelope.EventID, data)
+	case "events.order_picked_up":
+		var envelope struct {
+			EventID string          `json:"event_id"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		var data struct {
+			OrderID string `json:"order_id"`
+		}
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return err
+		}
+		return s.handleOrderPickedUp(ctx, envelope.EventID, data.OrderID)
+	case "events.customer_return_requested":
+		var envelope struct {
+			EventID string          `json:"event_id"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		var data customerReturnRequest
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return err
+		}
+		return s.createCustomerReturn(ctx, envelope.EventID, data)
+	case "trips.assigned":
+		var envelope struct {
+			EventID string          `json:"event_id"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
 		if ok, _ := s.markEventProcessed(ctx, envelope.EventID); !ok {
 			return nil
 		}
 		var data struct {
-			BatchID     string    `json:"batch_id"`
-			ReceivedAt  time.Time `json:"received_at"`
-			PVPWorkerID string    `json:"pvp_worker_id"`
+			TripID     string    `json:"trip_id"`
+			BatchID    string    `json:"batch_id"`
+			AssignedAt time.Time `json:"assigned_at"`
 		}
 		if err := json.Unmarshal(envelope.Data, &data); err != nil {
 			return err
 		}
-		return s.applyOrdersStatusForBatch(ctx, data.BatchID, "RECEIVED_BY_PVP")
+		return s.storeTripBatch(ctx, data.TripID, data.BatchID, data.AssignedAt)
+	case "events.trip_eta_updated":
+		var envelope struct {
+			EventID string          `json:"event_id"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		if ok, _ := s.markEventProcessed(ctx, envelope.EventID); !ok {
+			return nil
+		}
+		var data struct {
+			TripID           string    `json:"trip_id"`
+			EstimatedArrival time.Time `json:"estimated_arrival"`
+		}
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return err
+		}
+		return s.storeTripETA(ctx, data.TripID, data.EstimatedArrival)
+	case "events.reference_updated":
+		var envelope struct {
+			EventID string          `json:"event_id"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		var data struct {
+			UpdateType         string         `json:"update_type"`
+			Seq                int64          `json:"seq"`
+			PickupPoint        refPickupPoint `json:"pickup_point"`
+			Warehouse          refWarehouse   `json:"warehouse"`
+			PickupPointStorage struct {
+				PVPID       string `json:"pvp_id"`
+				StorageDays int    `json:"storage_days"`
+			} `json:"pickup_point_storage"`
+		}
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return err
+		}
+		switch data.UpdateType {
+		case "pickup_point":
+			if ok, _ := s.markEventProcessed(ctx, envelope.EventID); !ok {
+				return nil
+			}
+			return upsertPickupPoint(ctx, s.db, data.PickupPoint, data.Seq)
+		case "warehouse":
+			if ok, _ := s.markEventProcessed(ctx, envelope.EventID); !ok {
+				return nil
+			}
+			return upsertWarehouse(ctx, s.db, data.Warehouse, data.Seq)
+		case "pickup_point_storage":
+			if ok, _ := s.markEventProcessed(ctx, envelope.EventID); !ok {
+				return nil
+			}
+			return upsertPVPStorage(ctx, s.db, data.PickupPointStorage.PVPID, data.PickupPointStorage.StorageDays, data.Seq)
+		}
+		return nil
 	default:
 		return nil
 	}
@@ -214,29 +412,87 @@ func (s *OrderService) applyOrderStatus(ctx context.Context, orderID, next strin
 		return err
 	}
 	defer tx.Rollback(ctx)
+	if _, err := s.applyOrderStatusTx(ctx, tx, orderID, next, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
 
-	var current string
-	err = tx.QueryRow(ctx, "SELECT status FROM orders WHERE id=$1 FOR UPDATE", orderID).Scan(&current)
+// handleOrderPickedUp marks the order collected by the customer. The
+// processed mark commits with the status change, so a failed change is
+// redelivered instead of dropped.
+func (s *OrderService) handleOrderPickedUp(ctx context.Context, eventID, orderID string) error {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	allowed := map[string]string{
-		"CREATED":          "BATCHED",
-		"BATCHED":          "DELIVERED_TO_PVP",
-		"DELIVERED_TO_PVP": "RECEIVED_BY_PVP",
+	defer tx.Rollback(ctx)
+	if ok, err := markEventProcessedTx(ctx, tx, eventID); err != nil || !ok {
+		return err
+	}
+	if _, err := s.applyOrderStatusTx(ctx, tx, orderID, StatusDeliveredToCustomer, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// applyOrderStatusTx moves the order to next if the lifecycle allows it and
+// returns the status actually set.
+func (s *OrderService) applyOrderStatusTx(ctx context.Context, tx pgx.Tx, orderID, next string, now time.Time) (string, error) {
+	var current string
+	err := tx.QueryRow(ctx, "SELECT status FROM orders WHERE id=$1 FOR UPDATE", orderID).Scan(&current)
+	if err != nil {
+		return "", err
+	}
+	// A return batch reaching the seller's warehouse is reported like any
+	// other delivery
+	if next == "DELIVERED_TO_PVP" && current == StatusReturning {
+		next = StatusReturnedToSeller
+	}
+	allowed := map[string][]string{
+		"CREATED":          {"BATCHED"},
+		"BATCHED":          {"DELIVERED_TO_PVP"},
+		"DELIVERED_TO_PVP": {"RECEIVED_BY_PVP", StatusMissingAtReceipt},
+		// An order reported missing may still turn up at the PVP later
+		StatusMissingAtReceipt: {"RECEIVED_BY_PVP"},
+		"RECEIVED_BY_PVP":      {StatusDeliveredToCustomer, StatusReturning},
+		// The customer may bring a collected order back to the PVP
+		StatusDeliveredToCustomer: {StatusReturning},
+		StatusReturning:           {StatusReturnedToSeller},
 	}
-	target, ok := allowed[current]
-	if !ok || target != next {
+	ok := false
+	for _, target := range allowed[current] {
+		if target == next {
+			ok = true
+		}
+	}
+	if !ok {
 		// If already in target state, idempotent success
 		if current == next {
-			return nil
+			return current, nil
 		}
-		return fmt.Errorf("invalid transition %s -> %s", current, next)
+		return current, fmt.Errorf("invalid transition %s -> %s", current, next)
 	}
-	now := time.Now().UTC()
 	_, err = tx.Exec(ctx, "UPDATE orders SET status=$2, updated_at=$3 WHERE id=$1", orderID, next, now)
 	if err != nil {
-		return err
+		return current, err
+	}
+	if err := recordStatusTx(ctx, tx, orderID, current, next, now); err != nil {
+		return current, err
+	}
+	switch next {
+	case "RECEIVED_BY_PVP":
+		expiresAt, err := s.startStorageTx(ctx, tx, orderID, now)
+		if err != nil {
+			return current, err
+		}
+		if err := s.issuePickupCodeTx(ctx, tx, orderID, expiresAt, now); err != nil {
+			return current, err
+		}
+	case StatusReturnedToSeller:
+		if err := completeReturnTx(ctx, tx, orderID, now); err != nil {
+			return current, err
+		}
 	}
 
 	envelope := map[string]interface{}{
@@ -255,17 +511,16 @@ func (s *OrderService) applyOrderStatus(ctx context.Context, orderID, next strin
 	evt := outbox.Event{
 		ID:            uuid.NewString(),
 		EventType:     "orders.status_updated",
-		CorrelationID: orderID,
+		CorrelationID: orderID + "/" + next,
 		Topic:         "orders.status_updated",
 		PartitionKey:  orderID,
 		Payload:       payload,
 		OccurredAt:    now,
 	}
 	if err := outbox.EnqueueTx(ctx, tx, evt); err != nil {
-		return err
+		return current, err
 	}
-
-	return tx.Commit(ctx)
+	return next, nil
 }
 
 func (s *OrderService) applyOrdersStatusForBatch(ctx context.Context, batchID, next string) error {
@@ -295,6 +550,20 @@ func (s *OrderService) storeBatchOrders(ctx context.Context, batchID string, ord
 	}
 	return nil
 }
+
+// moveBatchOrders re-links orders from a split batch to its sub-batch, so the
+// delivery events of the sub-batch reach them.
+func (s *OrderService) moveBatchOrders(ctx context.Context, parentID, batchID string, orderIDs []string) error {
+	if parentID == "" || batchID == "" {
+		return nil
+	}
+	if err := s.storeBatchOrders(ctx, batchID, orderIDs); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(ctx, "DELETE FROM order_batches WHERE batch_id=$1 AND order_id = ANY($2)", parentID, orderIDs)
+	return err
+}
+
 func (s *OrderService) markEventProcessed(ctx context.Context, eventID string) (bool, error) {
 	var id string
 	err := s.db.QueryRow(ctx, "INSERT INTO processed_events(event_id, occurred_at) VALUES ($1, NOW()) ON CONFLICT (event_id) DO NOTHING RETURNING event_id", eventID).Scan(&id)
@@ -304,6 +573,20 @@ func (s *OrderService) markEventProcessed(ctx context.Context, eventID string) (
 	return id != "", nil
 }
 
+// markEventProcessedTx is markEventProcessed for handlers that keep the mark
+// in the same transaction as their writes.
+func markEventProcessedTx(ctx context.Context, tx pgx.Tx, eventID string) (bool, error) {
+	var id string
+	err := tx.QueryRow(ctx, "INSERT INTO processed_events(event_id, occurred_at) VALUES ($1, NOW()) ON CONFLICT (event_id) DO NOTHING RETURNING event_id", eventID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (s *OrderService) markPublished(ctx context.Context, eventType, correlationID string) (bool, error) {
 	var et string
 	err := s.db.QueryRow(ctx, `
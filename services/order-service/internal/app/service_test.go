@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -59,3 +60,39 @@ func TestHandleKafkaEvent_InvalidJSON(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+type recordingDB struct {
+	stubDB
+	execs []string
+}
+
+func (r *recordingDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	r.execs = append(r.execs, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func TestHandleKafkaEvent_SubBatchMovesOrdersFromParent(t *testing.T) {
+	db := &recordingDB{}
+	svc := NewOrderService(db, nil, "topic")
+	envelope := map[string]interface{}{
+		"event_id":       "e2",
+		"event_type":     "batches.formed",
+		"occurred_at":    time.Now(),
+		"correlation_id": "parent/child",
+		"data": map[string]interface{}{
+			"batch_id":        "child",
+			"parent_batch_id": "parent",
+			"order_ids":       []string{"o1", "o2"},
+		},
+	}
+	body, _ := json.Marshal(envelope)
+	if err := svc.HandleKafkaEvent(context.Background(), "batches.formed", []byte("child"), body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.execs) != 3 {
+		t.Fatalf("expected 2 inserts and 1 delete, got %d statements", len(db.execs))
+	}
+	if !strings.HasPrefix(db.execs[2], "DELETE FROM order_batches") {
+		t.Fatalf("expected orders to leave the parent batch, got %q", db.execs[2])
+	}
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"bel-parcel/services/order-service/internal/outbox"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	StatusReturning        = "RETURNING"
+	StatusReturnedToSeller = "RETURNED_TO_SELLER"
+
+	// DefaultStorageDays applies until reference-service has published a
+	// storage period for the PVP.
+	DefaultStorageDays = 7
+	// StorageReminderLead is how long before expiry the customer is reminded.
+	StorageReminderLead = 24 * time.Hour
+
+	storageReminderEvent   = "notifications.storage_expiry_reminder"
+	pickupCodeRevokedEvent = "orders.pickup_code_revoked"
+	storageSweepBatch      = 100
+)
+
+// upsertPVPStorage keeps the storage period reference-service publishes for
+// a PVP; it is read when an order is received there.
+func upsertPVPStorage(ctx context.Context, ex execer, pvpID string, days int, seq int64) error {
+	if pvpID == "" || days <= 0 {
+		return nil
+	}
+	_, err := ex.Exec(ctx, `
+		INSERT INTO ref_pvp_storage (pvp_id, storage_days, source_seq, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (pvp_id) DO UPDATE SET storage_days = EXCLUDED.storage_days, source_seq = EXCLUDED.source_seq, updated_at = NOW()
+		WHERE ref_pvp_storage.source_seq <= EXCLUDED.source_seq
+	`, pvpID, days, seq)
+	return err
+}
+
+// startStorageTx starts the storage period of an order received at the PVP
+// and returns when it expires.
+func (s *OrderService) startStorageTx(ctx context.Context, tx pgx.Tx, orderID string, now time.Time) (time.Time, error) {
+	days := DefaultStorageDays
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(r.storage_days, $2)
+		FROM orders o LEFT JOIN ref_pvp_storage r ON r.pvp_id = o.pvz_id::text
+		WHERE o.id = $1
+	`, orderID, DefaultStorageDays).Scan(&days)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, err
+	}
+	expiresAt := now.AddDate(0, 0, days)
+	if _, err := tx.Exec(ctx, `
+		UPDATE orders SET storage_expires_at=$2, storage_reminder_sent_at=NULL WHERE id=$1
+	`, orderID, expiresAt); err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
+}
+
+// ProcessStorage reminds customers about parcels whose storage period ends
+// soon and sends expired ones back to the seller. It is run periodically.
+func (s *OrderService) ProcessStorage(ctx context.Context, now time.Time) error {
+	reminders, err := s.dueOrders(ctx, `
+		SELECT id::text FROM orders
+		WHERE status = 'RECEIVED_BY_PVP' AND storage_reminder_sent_at IS NULL
+		  AND storage_expires_at > $1 AND storage_expires_at <= $2
+		ORDER BY storage_expires_at LIMIT $3
+	`, now, now.Add(StorageReminderLead), storageSweepBatch)
+	if err != nil {
+		return err
+	}
+	for _, id := range reminders {
+		if err := s.sendStorageReminder(ctx, id, now); err != nil {
+			slog.Error("storage reminder failed", "order_id", id, "error", err)
+		}
+	}
+	expired, err := s.dueOrders(ctx, `
+		SELECT id::text FROM orders
+		WHERE status = 'RECEIVED_BY_PVP' AND storage_expires_at <= $1
+		ORDER BY storage_expires_at LIMIT $2
+	`, now, storageSweepBatch)
+	if err != nil {
+		return err
+	}
+	for _, id := range expired {
+		if err := s.expireStorage(ctx, id, now); err != nil {
+			slog.Error("storage expiry failed", "order_id", id, "error", err)
+		}
+	}
+	return nil
+}
+
+func (s *OrderService) dueOrders(ctx context.Context, sql string, args ...any) ([]string, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *OrderService) sendStorageReminder(ctx context.Context, orderID string, now time.Time) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var pvpID string
+	var expiresAt time.Time
+	var phone, email *string
+	err = tx.QueryRow(ctx, `
+		UPDATE orders SET storage_reminder_sent_at=$2
+		WHERE id = $1 AND status = 'RECEIVED_BY_PVP' AND storage_reminder_sent_at IS NULL
+		RETURNING COALESCE(pvz_id::text, ''), storage_expires_at
+	`, orderID, now).Scan(&pvpID, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, `
+		SELECT customer_phone, customer_email FROM order_contacts WHERE order_id = $1
+	`, orderID).Scan(&phone, &email)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if err := enqueueOrderEventTx(ctx, tx, storageReminderEvent, notificationTopic, orderID, map[string]interface{}{
+		"order_id":           orderID,
+		"pvp_id":             pvpID,
+		"customer_phone":     phone,
+		"customer_email":     email,
+		"storage_expires_at": expiresAt,
+	}, now); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// expireStorage moves an uncollected order to RETURNING, opens a return
+// order towards the seller's warehouse and revokes the pickup code.
+func (s *OrderService) expireStorage(ctx context.Context, orderID string, now time.Time) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var pvpID, warehouseID, sellerID string
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(pvz_id::text, ''), COALESCE(warehouse_id::text, seller_id::text, ''), COALESCE(seller_id::text, '')
+		FROM orders
+		WHERE id = $1 AND status = 'RECEIVED_BY_PVP' AND storage_expires_at <= $2
+		FOR UPDATE SKIP LOCKED
+	`, orderID, now).Scan(&pvpID, &warehouseID, &sellerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Picked up meanwhile or handled by another instance
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := s.applyOrderStatusTx(ctx, tx, orderID, StatusReturning, now); err != nil {
+		return err
+	}
+	if err := openReturnTx(ctx, tx, ReturnOrder{
+		ID:          uuid.NewString(),
+		OrderID:     orderID,
+		SellerID:    sellerID,
+		Type:        ReturnTypeStorageExpired,
+		Reason:      ReturnTypeStorageExpired,
+		OriginPVPID: pvpID,
+		WarehouseID: warehouseID,
+		CreatedAt:   now,
+	}); err != nil {
+		return err
+	}
+	if err := enqueueOrderEventTx(ctx, tx, pickupCodeRevokedEvent, pickupCodeTopic, orderID, map[string]interface{}{
+		"order_id":   orderID,
+		"pvp_id":     pvpID,
+		"revoked_at": now,
+	}, now); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func enqueueOrderEventTx(ctx context.Context, tx pgx.Tx, eventType, topic, orderID string, data map[string]interface{}, now time.Time) error {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     eventType,
+		"occurred_at":    now,
+		"correlation_id": orderID,
+		"data":           data,
+	})
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     eventType,
+		CorrelationID: orderID + "/" + uuid.NewString(),
+		Topic:         topic,
+		PartitionKey:  orderID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func referenceUpdate(t *testing.T, eventID string, data map[string]interface{}) []byte {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{
+		"event_id":    eventID,
+		"event_type":  "events.reference_updated",
+		"occurred_at": time.Now(),
+		"data":        data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestHandleKafkaEvent_StoragePeriodUpdate(t *testing.T) {
+	db := &recordingDB{}
+	svc := NewOrderService(db, nil, "topic")
+	body := referenceUpdate(t, "e4", map[string]interface{}{
+		"update_type":          "pickup_point_storage",
+		"pickup_point_storage": map[string]interface{}{"pvp_id": "p1", "storage_days": 14},
+	})
+	if err := svc.HandleKafkaEvent(context.Background(), "events.reference_updated", nil, body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.execs) != 1 || !strings.Contains(db.execs[0], "INSERT INTO ref_pvp_storage") {
+		t.Fatalf("expected storage period upsert, got %v", db.execs)
+	}
+
+	db.execs = nil
+	body = referenceUpdate(t, "e5", map[string]interface{}{
+		"update_type":  "pickup_point",
+		"pickup_point": map[string]interface{}{"id": "p1"},
+	})
+	if err := svc.HandleKafkaEvent(context.Background(), "events.reference_updated", nil, body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.execs) != 0 {
+		t.Fatalf("other reference updates must be ignored, got %v", db.execs)
+	}
+}
+
+func TestHandleKafkaEvent_ReturnBatchKeepsStatus(t *testing.T) {
+	db := &recordingDB{}
+	svc := NewOrderService(db, nil, "topic")
+	body, _ := json.Marshal(map[string]interface{}{
+		"event_id":    "e6",
+		"event_type":  "batches.formed",
+		"occurred_at": time.Now(),
+		"data": map[string]interface{}{
+			"batch_id":         "rb1",
+			"origin_type":      "pvp",
+			"destination_type": "warehouse",
+			"order_ids":        []string{"o1"},
+		},
+	})
+	if err := svc.HandleKafkaEvent(context.Background(), "batches.formed", []byte("rb1"), body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.execs) != 2 || !strings.Contains(db.execs[0], "INSERT INTO order_batches") || !strings.Contains(db.execs[1], "IN_TRANSIT") {
+		t.Fatalf("expected the batch link and the return marked in transit, got %v", db.execs)
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrOrderNotFound = errors.New("order not found")
+
+type StatusChange struct {
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Status         string    `json:"status"`
+	ChangedAt      time.Time `json:"changed_at"`
+}
+
+// OrderTimeline is the status history of an order together with its returns.
+type OrderTimeline struct {
+	OrderID string         `json:"order_id"`
+	Status  string         `json:"status"`
+	Events  []StatusChange `json:"events"`
+	Returns []ReturnOrder  `json:"returns"`
+}
+
+func recordStatusTx(ctx context.Context, tx pgx.Tx, orderID, previous, next string, now time.Time) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO order_status_history (order_id, previous_status, status, changed_at)
+		VALUES ($1, $2, $3, $4)
+	`, orderID, previous, next, now)
+	return err
+}
+
+// OrderTimeline returns the timeline of one of the seller's orders. Orders of
+// other sellers are reported as not found.
+func (s *OrderService) OrderTimeline(ctx context.Context, sellerID, orderID string) (*OrderTimeline, error) {
+	t := &OrderTimeline{OrderID: orderID, Events: []StatusChange{}, Returns: []ReturnOrder{}}
+	var createdAt time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT status, created_at FROM orders WHERE id::text = $1 AND seller_id::text = $2
+	`, orderID, sellerID).Scan(&t.Status, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Events = append(t.Events, StatusChange{Status: "CREATED", ChangedAt: createdAt})
+
+	rows, err := s.db.Query(ctx, `
+		SELECT previous_status, status, changed_at FROM order_status_history
+		WHERE order_id::text = $1 ORDER BY changed_at, id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.PreviousStatus, &c.Status, &c.ChangedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.Events = append(t.Events, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT id::text, order_id::text, return_type, reason, COALESCE(comment, ''), COALESCE(requested_by, ''),
+		       origin_pvp_id, COALESCE(warehouse_id::text, ''), status, created_at, returned_at
+		FROM return_orders WHERE order_id::text = $1 ORDER BY created_at
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r ReturnOrder
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.Type, &r.Reason, &r.Comment, &r.RequestedBy,
+			&r.OriginPVPID, &r.WarehouseID, &r.Status, &r.CreatedAt, &r.ReturnedAt); err != nil {
+			return nil, err
+		}
+		t.Returns = append(t.Returns, r)
+	}
+	return t, rows.Err()
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Public statuses shown to customers. Internal statuses that mean nothing to
+// a customer (e.g. a receipt discrepancy) are not published at all.
+var publicStatuses = map[string]string{
+	"CREATED":                 "accepted",
+	"BATCHED":                 "in_transit",
+	"DELIVERED_TO_PVP":        "arrived_at_pickup_point",
+	"RECEIVED_BY_PVP":         "ready_for_pickup",
+	StatusDeliveredToCustomer: "delivered",
+	StatusReturning:           "returning_to_seller",
+	StatusReturnedToSeller:    "returned_to_seller",
+}
+
+var trackingNumberRe = regexp.MustCompile(`^BP[0-9]{12}$`)
+
+// newTrackingNumber issues a random, non-sequential tracking number so that
+// neighbouring numbers do not reveal other customers' parcels.
+func newTrackingNumber() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("BP%012d", n.Int64()), nil
+}
+
+func ValidTrackingNumber(s string) bool {
+	return trackingNumberRe.MatchString(s)
+}
+
+type TrackingEvent struct {
+	Status    string    `json:"status"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// PublicTracking is what an unauthenticated caller learns about a parcel:
+// no order, carrier, batch or location data.
+type PublicTracking struct {
+	TrackingNumber   string          `json:"tracking_number"`
+	Status           string          `json:"status"`
+	EstimatedArrival *time.Time      `json:"estimated_arrival,omitempty"`
+	Events           []TrackingEvent `json:"events"`
+}
+
+func (s *OrderService) TrackParcel(ctx context.Context, trackingNumber string) (*PublicTracking, error) {
+	if !ValidTrackingNumber(trackingNumber) {
+		return nil, ErrOrderNotFound
+	}
+	var orderID, status string
+	var createdAt time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT id::text, status, created_at FROM orders WHERE tracking_number = $1
+	`, trackingNumber).Scan(&orderID, &status, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &PublicTracking{
+		TrackingNumber: trackingNumber,
+		Status:         publicStatuses["CREATED"],
+		Events:         []TrackingEvent{{Status: publicStatuses["CREATED"], ChangedAt: createdAt}},
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT status, changed_at FROM order_status_history
+		WHERE order_id::text = $1 ORDER BY changed_at, id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st string
+		var at time.Time
+		if err := rows.Scan(&st, &at); err != nil {
+			return nil, err
+		}
+		public, ok := publicStatuses[st]
+		if !ok || public == t.Status {
+			continue
+		}
+		t.Status = public
+		t.Events = append(t.Events, TrackingEvent{Status: public, ChangedAt: at})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if public, ok := publicStatuses[status]; ok {
+		t.Status = public
+	}
+	if status == "BATCHED" {
+		eta, err := s.orderETA(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+		t.EstimatedArrival = eta
+	}
+	return t, nil
+}
+
+// orderETA is the latest ETA of the most recent trip carrying the order's batch.
+func (s *OrderService) orderETA(ctx context.Context, orderID string) (*time.Time, error) {
+	var eta time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT e.estimated_arrival
+		FROM order_batches ob
+		JOIN trip_batches tb ON tb.batch_id = ob.batch_id::text
+		JOIN trip_etas e ON e.trip_id = tb.trip_id
+		WHERE ob.order_id::text = $1
+		ORDER BY tb.assigned_at DESC
+		LIMIT 1
+	`, orderID).Scan(&eta)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &eta, nil
+}
+
+func (s *OrderService) storeTripBatch(ctx context.Context, tripID, batchID string, assignedAt time.Time) error {
+	if tripID == "" || batchID == "" {
+		return nil
+	}
+	if assignedAt.IsZero() {
+		assignedAt = time.Now().UTC()
+	}
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO trip_batches (trip_id, batch_id, assigned_at) VALUES ($1, $2, $3)
+		ON CONFLICT (trip_id, batch_id) DO NOTHING
+	`, tripID, batchID, assignedAt)
+	return err
+}
+
+func (s *OrderService) storeTripETA(ctx context.Context, tripID string, eta time.Time) error {
+	if tripID == "" || eta.IsZero() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO trip_etas (trip_id, estimated_arrival, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (trip_id) DO UPDATE SET estimated_arrival = EXCLUDED.estimated_arrival, updated_at = NOW()
+	`, tripID, eta)
+	return err
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNewTrackingNumber_Format(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		n, err := newTrackingNumber()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ValidTrackingNumber(n) {
+			t.Fatalf("invalid tracking number %q", n)
+		}
+		seen[n] = true
+	}
+	if len(seen) < 99 {
+		t.Fatalf("tracking numbers repeat too often: %d unique of 100", len(seen))
+	}
+}
+
+func TestTrackParcel_MalformedNumberNotFound(t *testing.T) {
+	// The stub DB would find any order, so a lookup must not happen.
+	svc := NewOrderService(&stubDB{}, nil, "topic")
+	for _, n := range []string{"", "BP123", "bp000000000001", "BP00000000000X", "' OR 1=1 --"} {
+		if _, err := svc.TrackParcel(context.Background(), n); !errors.Is(err, ErrOrderNotFound) {
+			t.Fatalf("expected ErrOrderNotFound for %q, got %v", n, err)
+		}
+	}
+}
+
+func TestPublicStatuses_HideInternalStates(t *testing.T) {
+	if _, ok := publicStatuses[StatusMissingAtReceipt]; ok {
+		t.Fatal("receipt discrepancies must not be published")
+	}
+}
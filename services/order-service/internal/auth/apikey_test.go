@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type memStore map[string]*StoredKey
+
+func (m memStore) LookupAPIKey(ctx context.Context, id string) (*StoredKey, error) {
+	if k, ok := m[id]; ok {
+		return k, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (m memStore) TouchAPIKey(ctx context.Context, id string) error { return nil }
+
+func TestGenerateAPIKey_RoundTrip(t *testing.T) {
+	id, plaintext, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotID, secret, ok := parseAPIKey(plaintext)
+	if !ok || gotID != id {
+		t.Fatalf("failed to parse generated key %q", plaintext)
+	}
+	if string(HashSecret(secret)) != string(hash) {
+		t.Fatal("hash of parsed secret does not match")
+	}
+	for _, bad := range []string{"", "bpk_", "bpk_abc_def", "xyz_0123456789abcdef_secret", "bpk_0123456789abcdef_"} {
+		if _, _, ok := parseAPIKey(bad); ok {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	id, plaintext, hash, _ := GenerateAPIKey()
+	past := time.Now().Add(-time.Minute)
+	expiredID, expiredKey, expiredHash, _ := GenerateAPIKey()
+	store := memStore{
+		id:        {ID: id, SellerID: "s1", SecretHash: hash, Scopes: []string{ScopeOrdersRead}},
+		expiredID: {ID: expiredID, SellerID: "s1", SecretHash: expiredHash, Scopes: []string{ScopeOrdersRead}, ExpiresAt: &past},
+	}
+	var seen *Seller
+	h := RequireAPIKey(store, ScopeOrdersRead, func(w http.ResponseWriter, r *http.Request) {
+		seen = SellerFromContext(r)
+	})
+	call := func(h http.HandlerFunc, header, key string) int {
+		req := httptest.NewRequest("GET", "/orders/o1/timeline", nil)
+		if key != "" {
+			req.Header.Set(header, key)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr.Code
+	}
+	if code := call(h, "X-API-Key", ""); code != http.StatusUnauthorized {
+		t.Fatalf("missing key: expected 401, got %d", code)
+	}
+	if code := call(h, "X-API-Key", plaintext+"x"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong secret: expected 401, got %d", code)
+	}
+	if code := call(h, "X-API-Key", expiredKey); code != http.StatusUnauthorized {
+		t.Fatalf("expired key: expected 401, got %d", code)
+	}
+	write := RequireAPIKey(store, ScopeOrdersWrite, func(w http.ResponseWriter, r *http.Request) {})
+	if code := call(write, "X-API-Key", plaintext); code != http.StatusForbidden {
+		t.Fatalf("missing scope: expected 403, got %d", code)
+	}
+	if code := call(h, "Authorization", "Bearer "+plaintext); code != http.StatusOK {
+		t.Fatalf("valid key: expected 200, got %d", code)
+	}
+	if seen == nil || seen.ID != "s1" || seen.KeyID != id {
+		t.Fatalf("expected seller from the key, got %+v", seen)
+	}
+}
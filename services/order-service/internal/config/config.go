@@ -22,6 +22,28 @@ type Config struct {
 		Timeout time.Duration
 		DLQTopic string
 	}
+	Auth struct {
+		HS256Secret      string
+		Issuer           string
+		Audience         string
+		KeyRotationGrace time.Duration
+	}
+	Pickup struct {
+		CodeSecret string
+	}
+	Import struct {
+		MaxBytes     int64
+		PollInterval time.Duration
+	}
+	Public struct {
+		TrackingRateLimit int
+		TrustProxy        bool
+	}
+	Reference struct {
+		URL     string
+		Token   string
+		Timeout time.Duration
+	}
 	OTLP struct {
 		Endpoint string
 	}
@@ -41,9 +63,27 @@ func Load() (*Config, error) {
 		"events.batch_picked_up",
 		"events.batch_delivered_to_pvp",
 		"events.batch_received_by_pvp",
+		"batches.split",
+		"events.order_picked_up",
+		"events.reference_updated",
+		"events.customer_return_requested",
+		"trips.assigned",
+		"events.trip_eta_updated",
 	})
 	v.SetDefault("kafka.timeout", 5*time.Second)
 	v.SetDefault("kafka.dlqtopic", "dlq.order")
+	v.SetDefault("auth.hs256secret", "")
+	v.SetDefault("auth.issuer", "")
+	v.SetDefault("auth.audience", "")
+	v.SetDefault("auth.keyrotationgrace", 24*time.Hour)
+	v.SetDefault("pickup.codesecret", "")
+	v.SetDefault("import.maxbytes", 32<<20)
+	v.SetDefault("import.pollinterval", 5*time.Second)
+	v.SetDefault("public.trackingratelimit", 30)
+	v.SetDefault("public.trustproxy", false)
+	v.SetDefault("reference.url", "http://localhost:8084")
+	v.SetDefault("reference.token", "")
+	v.SetDefault("reference.timeout", 10*time.Second)
 	v.SetDefault("otlp.endpoint", "")
 
 	// Config file
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"bel-parcel/services/order-service/internal/app"
+	"bel-parcel/services/order-service/internal/auth"
+
+	"github.com/google/uuid"
+)
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func sellerParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	sellerID := r.PathValue("seller_id")
+	if _, err := uuid.Parse(sellerID); err != nil {
+		http.Error(w, "invalid seller_id", http.StatusBadRequest)
+		return "", false
+	}
+	return sellerID, true
+}
+
+func adminID(r *http.Request) string {
+	if u := auth.FromContext(r); u != nil {
+		return u.ID
+	}
+	return ""
+}
+
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID, ok := sellerParam(w, r)
+	if !ok {
+		return
+	}
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	key, err := h.service.CreateAPIKey(ctx, sellerID, req.Name, req.Scopes)
+	if errors.Is(err, app.ErrInvalidScopes) {
+		http.Error(w, "scopes must be a non-empty subset of orders:write, orders:read", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create api key", "seller_id", sellerID, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "api key created", "seller_id", sellerID, "key_id", key.ID, "admin_id", adminID(r))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID, ok := sellerParam(w, r)
+	if !ok {
+		return
+	}
+	list, err := h.service.ListAPIKeys(ctx, sellerID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list api keys", "seller_id", sellerID, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *Handler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID, ok := sellerParam(w, r)
+	if !ok {
+		return
+	}
+	keyID := r.PathValue("key_id")
+	key, err := h.service.RotateAPIKey(ctx, sellerID, keyID, h.keyRotationGrace)
+	switch {
+	case errors.Is(err, auth.ErrKeyNotFound):
+		http.Error(w, "api key not found", http.StatusNotFound)
+		return
+	case errors.Is(err, app.ErrKeyRevoked):
+		http.Error(w, "api key is revoked or expired", http.StatusConflict)
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "failed to rotate api key", "seller_id", sellerID, "key_id", keyID, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "api key rotated", "seller_id", sellerID, "key_id", keyID, "new_key_id", key.ID, "admin_id", adminID(r))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID, ok := sellerParam(w, r)
+	if !ok {
+		return
+	}
+	keyID := r.PathValue("key_id")
+	err := h.service.RevokeAPIKey(ctx, sellerID, keyID)
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		http.Error(w, "api key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to revoke api key", "seller_id", sellerID, "key_id", keyID, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "api key revoked", "seller_id", sellerID, "key_id", keyID, "admin_id", adminID(r))
+	w.WriteHeader(http.StatusNoContent)
+}
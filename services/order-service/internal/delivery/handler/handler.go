@@ -2,33 +2,58 @@ package handler
 
 import (
 	"bel-parcel/services/order-service/internal/app"
+	"bel-parcel/services/order-service/internal/auth"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 type Handler struct {
-	service *app.OrderService
+	service          *app.OrderService
+	keyRotationGrace time.Duration
+	maxImportBytes   int64
 }
 
-func NewHandler(service *app.OrderService) http.Handler {
+type Options struct {
+	// Validator checks operator JWTs for the admin endpoints.
+	Validator        *auth.Validator
+	TrackingLimiter  *RateLimiter
+	KeyRotationGrace time.Duration
+	MaxImportBytes   int64
+}
+
+func NewHandler(service *app.OrderService, opts Options) http.Handler {
 	mux := http.NewServeMux()
-	h := &Handler{service: service}
-	mux.HandleFunc("POST /orders", h.CreateOrder)
+	h := &Handler{service: service, keyRotationGrace: opts.KeyRotationGrace, maxImportBytes: opts.MaxImportBytes}
+	mux.HandleFunc("POST /orders", auth.RequireAPIKey(service, auth.ScopeOrdersWrite, h.CreateOrder))
+	mux.HandleFunc("GET /orders/{id}/timeline", auth.RequireAPIKey(service, auth.ScopeOrdersRead, h.GetOrderTimeline))
+	mux.HandleFunc("POST /order-imports", auth.RequireAPIKey(service, auth.ScopeOrdersWrite, h.SubmitOrderImport))
+	mux.HandleFunc("GET /order-imports/{id}", auth.RequireAPIKey(service, auth.ScopeOrdersRead, h.GetOrderImport))
+	// Public, unauthenticated
+	mux.HandleFunc("GET /track/{tracking_number}", opts.TrackingLimiter.Wrap(h.TrackParcel))
+	mux.HandleFunc("GET /receipt-discrepancies", auth.RequireRoles(opts.Validator, []string{"moderator", "admin"}, h.ListReceiptDiscrepancies))
+	mux.HandleFunc("POST /admin/sellers/{seller_id}/api-keys", auth.RequireRoles(opts.Validator, []string{"admin"}, h.CreateAPIKey))
+	mux.HandleFunc("GET /admin/sellers/{seller_id}/api-keys", auth.RequireRoles(opts.Validator, []string{"admin"}, h.ListAPIKeys))
+	mux.HandleFunc("POST /admin/sellers/{seller_id}/api-keys/{key_id}/rotate", auth.RequireRoles(opts.Validator, []string{"admin"}, h.RotateAPIKey))
+	mux.HandleFunc("DELETE /admin/sellers/{seller_id}/api-keys/{key_id}", auth.RequireRoles(opts.Validator, []string{"admin"}, h.RevokeAPIKey))
 	mux.HandleFunc("GET /healthz", h.HealthCheck)
 	mux.HandleFunc("GET /readyz", h.ReadinessCheck)
 	return mux
 }
 
+// CreateOrderRequest is the body of POST /orders. The warehouse defaults to
+// the seller's own; coordinates come from the reference data and any sent by
+// the seller are ignored.
 type CreateOrderRequest struct {
-	SellerID       string  `json:"seller_id"`
-	PVZID          string  `json:"pvz_id"`
-	CustomerPhone  string  `json:"customer_phone"`
-	CustomerEmail  string  `json:"customer_email"`
-	WarehouseLat   float64 `json:"warehouse_lat"`
-	WarehouseLng   float64 `json:"warehouse_lng"`
-	DestinationLat float64 `json:"destination_lat"`
-	DestinationLng float64 `json:"destination_lng"`
+	SellerID       string `json:"seller_id"`
+	ExternalID     string `json:"external_id"`
+	WarehouseID    string `json:"warehouse_id"`
+	PVZID          string `json:"pvz_id"`
+	CustomerPhone  string `json:"customer_phone"`
+	CustomerEmail  string `json:"customer_email"`
+	CustomerLocale string `json:"customer_locale"`
 }
 
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
@@ -56,22 +81,45 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.SellerID == "" || req.PVZID == "" {
-		http.Error(w, "seller_id and pvz_id are required", http.StatusBadRequest)
+	if req.PVZID == "" {
+		http.Error(w, "pvz_id is required", http.StatusBadRequest)
+		return
+	}
+	if !app.ValidCustomerLocale(req.CustomerLocale) {
+		http.Error(w, "customer_locale must be ru, be or en", http.StatusBadRequest)
+		return
+	}
+	// The seller is the owner of the API key; seller_id in the body is
+	// optional and must match it
+	seller := auth.SellerFromContext(r)
+	if seller == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.SellerID != "" && req.SellerID != seller.ID {
+		http.Error(w, "seller_id does not match the API key", http.StatusForbidden)
 		return
 	}
 
 	order, err := h.service.CreateOrder(ctx, app.CreateOrderParams{
-		SellerID:       req.SellerID,
+		SellerID:       seller.ID,
+		ExternalID:     req.ExternalID,
+		WarehouseID:    req.WarehouseID,
 		PVZID:          req.PVZID,
 		CustomerPhone:  req.CustomerPhone,
 		CustomerEmail:  req.CustomerEmail,
-		WarehouseLat:   req.WarehouseLat,
-		WarehouseLng:   req.WarehouseLng,
-		DestinationLat: req.DestinationLat,
-		DestinationLng: req.DestinationLng,
+		CustomerLocale: req.CustomerLocale,
 	})
 
+	var lerr *app.LocationError
+	if errors.As(err, &lerr) {
+		http.Error(w, lerr.Reason, http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, app.ErrDuplicateOrder) {
+		http.Error(w, "order with this external_id already exists", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		slog.ErrorContext(ctx, "failed to create order", "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -82,3 +130,53 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(order)
 }
+
+func (h *Handler) ListReceiptDiscrepancies(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	list, err := h.service.ReceiptDiscrepancies(ctx, r.URL.Query().Get("batch_id"))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list receipt discrepancies", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *Handler) GetOrderTimeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	seller := auth.SellerFromContext(r)
+	if seller == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	timeline, err := h.service.OrderTimeline(ctx, seller.ID, r.PathValue("id"))
+	if errors.Is(err, app.ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load order timeline", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
+
+func (h *Handler) TrackParcel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tracking, err := h.service.TrackParcel(ctx, r.PathValue("tracking_number"))
+	if errors.Is(err, app.ErrOrderNotFound) {
+		http.Error(w, "parcel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load parcel tracking", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	json.NewEncoder(w).Encode(tracking)
+}
@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"bel-parcel/services/order-service/internal/auth"
 )
 
 func TestHealthz_OK(t *testing.T) {
@@ -42,3 +46,89 @@ func TestCreateOrder_MissingParams(t *testing.T) {
 		t.Fatalf("expected 400, got %d", rr.Code)
 	}
 }
+
+func TestRateLimiter_LimitsPerClient(t *testing.T) {
+	l := NewRateLimiter(2, time.Minute, false)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	h := l.Wrap(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	call := func(addr string) int {
+		req := httptest.NewRequest("GET", "/track/BP000000000001", nil)
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr.Code
+	}
+	for i := 0; i < 2; i++ {
+		if code := call("10.0.0.1:1234"); code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, code)
+		}
+	}
+	now = now.Add(20 * time.Second)
+	req := httptest.NewRequest("GET", "/track/BP000000000001", nil)
+	req.RemoteAddr = "10.0.0.1:5678"
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "40" {
+		t.Fatalf("expected 429 until the window ends, got %d retry after %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if code := call("10.0.0.2:1234"); code != http.StatusOK {
+		t.Fatalf("other clients must not be limited, got %d", code)
+	}
+	now = now.Add(40 * time.Second)
+	if code := call("10.0.0.1:1234"); code != http.StatusOK {
+		t.Fatalf("expected the window to reset, got %d", code)
+	}
+}
+
+func TestRateLimiter_ClientFromIngressHop(t *testing.T) {
+	l := NewRateLimiter(1, time.Minute, true)
+	req := httptest.NewRequest("GET", "/track/BP000000000001", nil)
+	req.RemoteAddr = "10.1.0.5:443"
+	// the client forged the first entry, the ingress appended the real one
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.7")
+	if got := l.clientKey(req); got != "203.0.113.7" {
+		t.Fatalf("expected the address appended by the ingress, got %q", got)
+	}
+	req.Header.Del("X-Forwarded-For")
+	if got := l.clientKey(req); got != "10.1.0.5" {
+		t.Fatalf("expected the peer address without X-Forwarded-For, got %q", got)
+	}
+}
+
+type keyStore map[string]*auth.StoredKey
+
+func (k keyStore) LookupAPIKey(ctx context.Context, id string) (*auth.StoredKey, error) {
+	if key, ok := k[id]; ok {
+		return key, nil
+	}
+	return nil, auth.ErrKeyNotFound
+}
+
+func (k keyStore) TouchAPIKey(ctx context.Context, id string) error { return nil }
+
+func TestCreateOrder_SellerFromKey(t *testing.T) {
+	id, plaintext, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := keyStore{id: {ID: id, SellerID: "s1", SecretHash: hash, Scopes: []string{auth.ScopeOrdersWrite}}}
+	h := &Handler{service: nil}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders", auth.RequireAPIKey(store, auth.ScopeOrdersWrite, h.CreateOrder))
+
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"seller_id":"s2","pvz_id":"p1"}`))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/orders", strings.NewReader(`{"seller_id":"s2","pvz_id":"p1"}`))
+	req.Header.Set("X-API-Key", plaintext)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another seller's id, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	"bel-parcel/services/order-service/internal/app"
+	"bel-parcel/services/order-service/internal/auth"
+)
+
+func importFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mt {
+	case "text/csv":
+		return app.ImportFormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return app.ImportFormatNDJSON
+	}
+	return ""
+}
+
+// SubmitOrderImport accepts a CSV or NDJSON file of orders and returns the
+// import job; rows are processed in the background.
+func (h *Handler) SubmitOrderImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	seller := auth.SellerFromContext(r)
+	if seller == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	format := importFormat(r)
+	if format != app.ImportFormatCSV && format != app.ImportFormatNDJSON {
+		http.Error(w, "Content-Type must be text/csv or application/x-ndjson", http.StatusUnsupportedMediaType)
+		return
+	}
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxImportBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	job, created, err := h.service.SubmitImport(ctx, seller.ID, format, content)
+	if errors.Is(err, app.ErrImportMalformed) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to submit order import", "seller_id", seller.ID, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/order-imports/"+job.ID)
+	if created {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *Handler) GetOrderImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	seller := auth.SellerFromContext(r)
+	if seller == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	job, err := h.service.ImportJob(ctx, seller.ID, r.PathValue("id"))
+	if errors.Is(err, app.ErrImportNotFound) {
+		http.Error(w, "import job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load order import", "seller_id", seller.ID, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
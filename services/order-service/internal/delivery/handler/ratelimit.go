@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter allows each client a fixed number of requests per window.
+// State is per replica, which is enough to stop scraping of the public API.
+type RateLimiter struct {
+	limit      int
+	window     time.Duration
+	trustProxy bool
+	now        func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*clientWindow
+}
+
+type clientWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter returns nil for a non-positive limit, which disables limiting.
+// With trustProxy the client is the address the ingress appended to
+// X-Forwarded-For; entries before it come from the client and can be forged.
+func NewRateLimiter(limit int, window time.Duration, trustProxy bool) *RateLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		limit:      limit,
+		window:     window,
+		trustProxy: trustProxy,
+		now:        time.Now,
+		clients:    map[string]*clientWindow{},
+	}
+}
+
+// Allow counts a request of client. A refused client is told how long is
+// left of its window.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.clients) > 10000 {
+		for k, w := range l.clients {
+			if now.Sub(w.start) >= l.window {
+				delete(l.clients, k)
+			}
+		}
+	}
+	w, ok := l.clients[client]
+	if !ok || now.Sub(w.start) >= l.window {
+		l.clients[client] = &clientWindow{start: now, count: 1}
+		return true, 0
+	}
+	if w.count >= l.limit {
+		return false, l.window - now.Sub(w.start)
+	}
+	w.count++
+	return true, 0
+}
+
+func (l *RateLimiter) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(l.clientKey(r)); !ok {
+			// Whole seconds, rounded up so a client that waits is let in
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (l *RateLimiter) clientKey(r *http.Request) string {
+	if l != nil && l.trustProxy {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			hops := strings.Split(fwd[len(fwd)-1], ",")
+			if client := strings.TrimSpace(hops[len(hops)-1]); client != "" {
+				return client
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
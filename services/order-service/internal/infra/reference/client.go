@@ -0,0 +1,41 @@
+package reference
+
+import (
+	"time"
+
+	"bel-parcel/pkg/refsnapshot"
+)
+
+// Snapshot is the part of the reference-service snapshot order-service keeps.
+type Snapshot struct {
+	Seq          int64         `json:"seq"`
+	PickupPoints []PickupPoint `json:"pickup_points"`
+	Warehouses   []Warehouse   `json:"warehouses"`
+}
+
+type PickupPoint struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	IsHub       bool    `json:"is_hub"`
+	IsActive    bool    `json:"is_active"`
+	StorageDays int     `json:"storage_days"`
+	Seq         int64   `json:"seq"`
+}
+
+type Warehouse struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	IsActive  bool    `json:"is_active"`
+	Seq       int64   `json:"seq"`
+}
+
+// Client reads the reference snapshot into Snapshot.
+type Client = refsnapshot.Client[Snapshot]
+
+func NewClient(baseURL, token string, timeout time.Duration) *Client {
+	return refsnapshot.NewClient[Snapshot](baseURL, token, timeout)
+}
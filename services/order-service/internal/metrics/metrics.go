@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	SellerRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_seller_requests_total",
+			Help: "Requests authenticated with a seller API key; the seller is in the request log",
+		},
+		[]string{"route", "code"},
+	)
+)
+
+func Init(mux *http.ServeMux) {
+	prometheus.MustRegister(SellerRequestsTotal)
+	mux.Handle("/metrics", promhttp.Handler())
+}
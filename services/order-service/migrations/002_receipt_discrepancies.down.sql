@@ -0,0 +1,3 @@
+-- Rollback for 002_receipt_discrepancies.up.sql
+
+DROP TABLE IF EXISTS receipt_discrepancies;
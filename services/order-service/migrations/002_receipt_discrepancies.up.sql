@@ -0,0 +1,14 @@
+-- Расхождения при приёмке партии на ПВЗ: недостача и лишние заказы
+CREATE TABLE IF NOT EXISTS receipt_discrepancies (
+    id UUID PRIMARY KEY,
+    batch_id TEXT NOT NULL,
+    pvp_id TEXT NOT NULL,
+    pvp_worker_id TEXT,
+    missing_order_ids TEXT[] NOT NULL DEFAULT '{}',
+    surplus_order_ids TEXT[] NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'OPEN',
+    received_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_receipt_discrepancies_batch ON receipt_discrepancies(batch_id);
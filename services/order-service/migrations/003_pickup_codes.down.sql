@@ -0,0 +1,3 @@
+-- Rollback for 003_pickup_codes.up.sql
+
+DROP TABLE IF EXISTS order_contacts;
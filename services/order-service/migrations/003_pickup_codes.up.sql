@@ -0,0 +1,6 @@
+-- Контакты покупателя для отправки кода выдачи
+CREATE TABLE IF NOT EXISTS order_contacts (
+    order_id TEXT PRIMARY KEY,
+    customer_phone TEXT,
+    customer_email TEXT
+);
@@ -0,0 +1,7 @@
+-- Rollback for 004_storage_returns.up.sql
+
+DROP TABLE IF EXISTS return_orders;
+DROP TABLE IF EXISTS ref_pvp_storage;
+DROP INDEX IF EXISTS idx_orders_storage_expires;
+ALTER TABLE orders DROP COLUMN IF EXISTS storage_reminder_sent_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS storage_expires_at;
@@ -0,0 +1,22 @@
+-- Срок хранения заказа в ПВЗ и напоминание покупателю
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS storage_expires_at TIMESTAMPTZ;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS storage_reminder_sent_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_orders_storage_expires ON orders(storage_expires_at) WHERE status = 'RECEIVED_BY_PVP';
+
+-- Сроки хранения ПВЗ из справочника
+CREATE TABLE IF NOT EXISTS ref_pvp_storage (
+    pvp_id TEXT PRIMARY KEY,
+    storage_days INT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Возвраты невостребованных заказов продавцу
+CREATE TABLE IF NOT EXISTS return_orders (
+    id UUID PRIMARY KEY,
+    order_id UUID NOT NULL UNIQUE REFERENCES orders(id),
+    origin_pvp_id TEXT NOT NULL,
+    warehouse_id UUID,
+    status TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    returned_at TIMESTAMPTZ
+);
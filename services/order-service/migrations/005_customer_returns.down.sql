@@ -0,0 +1,8 @@
+-- Rollback for 005_customer_returns.up.sql
+
+ALTER TABLE return_orders DROP COLUMN IF EXISTS requested_by;
+ALTER TABLE return_orders DROP COLUMN IF EXISTS comment;
+ALTER TABLE return_orders DROP COLUMN IF EXISTS reason;
+ALTER TABLE return_orders DROP COLUMN IF EXISTS return_type;
+DROP INDEX IF EXISTS idx_order_status_history_order;
+DROP TABLE IF EXISTS order_status_history;
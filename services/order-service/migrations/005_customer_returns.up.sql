@@ -0,0 +1,15 @@
+-- История статусов заказа для таймлайна
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_id UUID NOT NULL REFERENCES orders(id),
+    previous_status TEXT NOT NULL,
+    status TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history(order_id, changed_at);
+
+-- Возвраты по инициативе покупателя
+ALTER TABLE return_orders ADD COLUMN IF NOT EXISTS return_type TEXT NOT NULL DEFAULT 'storage_expired';
+ALTER TABLE return_orders ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT 'storage_expired';
+ALTER TABLE return_orders ADD COLUMN IF NOT EXISTS comment TEXT;
+ALTER TABLE return_orders ADD COLUMN IF NOT EXISTS requested_by TEXT;
@@ -0,0 +1,7 @@
+-- Rollback for 006_tracking_numbers.up.sql
+
+DROP TABLE IF EXISTS trip_etas;
+DROP INDEX IF EXISTS idx_trip_batches_batch;
+DROP TABLE IF EXISTS trip_batches;
+DROP INDEX IF EXISTS idx_orders_tracking_number;
+ALTER TABLE orders DROP COLUMN IF EXISTS tracking_number;
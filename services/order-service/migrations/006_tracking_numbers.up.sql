@@ -0,0 +1,26 @@
+-- Трек-номер для публичного отслеживания
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_number TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_tracking_number ON orders(tracking_number);
+
+-- Состав партий (заполняется из batches.formed)
+CREATE TABLE IF NOT EXISTS order_batches (
+    batch_id TEXT NOT NULL,
+    order_id UUID NOT NULL,
+    PRIMARY KEY (batch_id, order_id)
+);
+CREATE INDEX IF NOT EXISTS idx_order_batches_order ON order_batches(order_id);
+
+-- Рейсы, везущие партии, и их ETA из tracking-service
+CREATE TABLE IF NOT EXISTS trip_batches (
+    trip_id TEXT NOT NULL,
+    batch_id TEXT NOT NULL,
+    assigned_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (trip_id, batch_id)
+);
+CREATE INDEX IF NOT EXISTS idx_trip_batches_batch ON trip_batches(batch_id, assigned_at);
+
+CREATE TABLE IF NOT EXISTS trip_etas (
+    trip_id TEXT PRIMARY KEY,
+    estimated_arrival TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
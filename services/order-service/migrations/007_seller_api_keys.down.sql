@@ -0,0 +1,5 @@
+-- Rollback for 007_seller_api_keys.up.sql
+
+DROP INDEX IF EXISTS idx_orders_seller;
+DROP INDEX IF EXISTS idx_seller_api_keys_seller;
+DROP TABLE IF EXISTS seller_api_keys;
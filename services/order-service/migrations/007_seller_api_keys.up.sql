@@ -0,0 +1,18 @@
+-- API-ключи продавцов: хранится только хэш секрета
+CREATE TABLE IF NOT EXISTS seller_api_keys (
+    id TEXT PRIMARY KEY,
+    seller_id UUID NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    secret_hash BYTEA NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Срок действия старого ключа после ротации
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    replaced_by TEXT REFERENCES seller_api_keys(id)
+);
+CREATE INDEX IF NOT EXISTS idx_seller_api_keys_seller ON seller_api_keys(seller_id, created_at);
+
+-- Заказы продавца
+CREATE INDEX IF NOT EXISTS idx_orders_seller ON orders(seller_id, created_at);
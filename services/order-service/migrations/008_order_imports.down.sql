@@ -0,0 +1,8 @@
+-- Rollback for 008_order_imports.up.sql
+
+DROP TABLE IF EXISTS order_import_errors;
+DROP INDEX IF EXISTS idx_order_import_jobs_status;
+DROP TABLE IF EXISTS order_import_jobs;
+DROP TABLE IF EXISTS ref_pickup_points;
+DROP INDEX IF EXISTS idx_orders_seller_external_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS external_id;
@@ -0,0 +1,43 @@
+-- Идентификатор заказа в системе продавца: повторная загрузка не создаёт дублей
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS external_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_seller_external_id ON orders(seller_id, external_id) WHERE external_id IS NOT NULL;
+
+-- Справочник ПВЗ для проверки заказов (из events.reference_updated)
+CREATE TABLE IF NOT EXISTS ref_pickup_points (
+    pvp_id TEXT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    is_hub BOOLEAN NOT NULL DEFAULT false,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Массовая загрузка заказов
+CREATE TABLE IF NOT EXISTS order_import_jobs (
+    id UUID PRIMARY KEY,
+    seller_id UUID NOT NULL,
+    file_hash TEXT NOT NULL,
+    format TEXT NOT NULL,
+    -- Файл хранится до окончания обработки
+    content BYTEA,
+    status TEXT NOT NULL,
+    total_rows INT NOT NULL DEFAULT 0,
+    processed_rows INT NOT NULL DEFAULT 0,
+    created_count INT NOT NULL DEFAULT 0,
+    duplicate_count INT NOT NULL DEFAULT 0,
+    failed_count INT NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ,
+    UNIQUE (seller_id, file_hash)
+);
+CREATE INDEX IF NOT EXISTS idx_order_import_jobs_status ON order_import_jobs(status, created_at);
+
+CREATE TABLE IF NOT EXISTS order_import_errors (
+    job_id UUID NOT NULL REFERENCES order_import_jobs(id) ON DELETE CASCADE,
+    row_number INT NOT NULL,
+    external_id TEXT,
+    error TEXT NOT NULL,
+    PRIMARY KEY (job_id, row_number)
+);
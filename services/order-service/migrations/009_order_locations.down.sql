@@ -0,0 +1,6 @@
+-- Rollback for 009_order_locations.up.sql
+
+ALTER TABLE return_orders ALTER COLUMN warehouse_id TYPE UUID USING warehouse_id::uuid;
+ALTER TABLE orders ALTER COLUMN warehouse_id TYPE UUID USING warehouse_id::uuid;
+ALTER TABLE ref_pickup_points DROP COLUMN IF EXISTS is_active;
+DROP TABLE IF EXISTS ref_warehouses;
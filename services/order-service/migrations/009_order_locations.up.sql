@@ -0,0 +1,16 @@
+-- Справочник складов для проверки заказов (из events.reference_updated)
+CREATE TABLE IF NOT EXISTS ref_warehouses (
+    warehouse_id TEXT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Закрытые ПВЗ не принимают новые заказы
+ALTER TABLE ref_pickup_points ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT true;
+
+-- Идентификаторы складов в справочнике текстовые
+ALTER TABLE orders ALTER COLUMN warehouse_id TYPE TEXT USING warehouse_id::text;
+ALTER TABLE return_orders ALTER COLUMN warehouse_id TYPE TEXT USING warehouse_id::text;
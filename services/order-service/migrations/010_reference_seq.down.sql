@@ -0,0 +1,5 @@
+-- Rollback for 010_reference_seq.up.sql
+
+ALTER TABLE ref_pvp_storage DROP COLUMN IF EXISTS source_seq;
+ALTER TABLE ref_warehouses DROP COLUMN IF EXISTS source_seq;
+ALTER TABLE ref_pickup_points DROP COLUMN IF EXISTS source_seq;
@@ -0,0 +1,3 @@
+-- Rollback for 011_import_lease.up.sql
+
+ALTER TABLE order_import_jobs DROP COLUMN IF EXISTS lease_token;
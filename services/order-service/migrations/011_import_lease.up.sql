@@ -0,0 +1,3 @@
+-- Токен обработчика, захватившего задание загрузки; фиксация очередной
+-- порции проходит только у текущего владельца
+ALTER TABLE order_import_jobs ADD COLUMN IF NOT EXISTS lease_token UUID;
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"os"
@@ -15,6 +16,7 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"bel-parcel/pkg/leader"
 	"bel-parcel/services/reassignment-service/internal/config"
 	"bel-parcel/services/reassignment-service/internal/infra/db"
 	ikafka "bel-parcel/services/reassignment-service/internal/infra/kafka"
@@ -47,6 +49,10 @@ func main() {
 		slog.Error("failed to ensure outbox schema", "error", err)
 		os.Exit(1)
 	}
+	if err := leader.EnsureSchema(reassignDB); err != nil {
+		slog.Error("failed to ensure leader schema", "error", err)
+		os.Exit(1)
+	}
 	{
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -89,7 +95,8 @@ func main() {
 	go consumer.Start(ctx, func(topic string, key, value []byte) error {
 		return svc.HandleEvent(ctx, topic, key, value)
 	})
-	go w.Start(ctx)
+	elector := leader.NewElector(reassignDB, "reassignment-confirmation-timeouts", leader.InstanceID(), cfg.Leader.RenewInterval, metrics.SetLeader)
+	go elector.Run(ctx, w.Start)
 
 	// Manual commands are processed by routing-service; reassignment-service only publishes reassign commands.
 
@@ -107,8 +114,16 @@ func main() {
 			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 			return
 		}
+		lease, err := elector.Current(r.Context())
+		if err != nil {
+			slog.Warn("leader lookup failed", "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "OK",
+			"leader": lease,
+		})
 	})
 
 	srv := &http.Server{
@@ -25,6 +25,9 @@ type Config struct {
 		GroupID       string
 		ConsumeTopics []string
 	}
+	Leader struct {
+		RenewInterval time.Duration
+	}
 	OTLP struct {
 		Endpoint string
 	}
@@ -43,6 +46,7 @@ func Load() (*Config, error) {
 	v.SetDefault("kafka.commandtopic", "commands.trip.reassign")
 	v.SetDefault("kafka.groupid", "reassignment-service")
 	v.SetDefault("kafka.consumetopics", []string{"trips.assigned", "trips.confirmed", "trips.rejected", "commands.trip.reassign"})
+	v.SetDefault("leader.renewinterval", 5*time.Second)
 	v.SetDefault("otlp.endpoint", "")
 
 	// Config file
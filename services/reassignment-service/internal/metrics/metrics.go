@@ -42,13 +42,28 @@ var (
 			Help: "Total number of confirmation timeouts detected",
 		},
 	)
+	leaderIsLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "reassignment_leader_is_leader",
+			Help: "Whether this reassignment-service instance leads a singleton job (1) or not (0)",
+		},
+		[]string{"job"},
+	)
 )
 
 func Init(mux *http.ServeMux) {
-	prometheus.MustRegister(ErrorsTotal, OutboxQueueSize, DLQSize, ReassignmentsTotal, ConfirmationTimeoutsTotal)
+	prometheus.MustRegister(ErrorsTotal, OutboxQueueSize, DLQSize, ReassignmentsTotal, ConfirmationTimeoutsTotal, leaderIsLeader)
 	mux.Handle("/metrics", promhttp.Handler())
 }
 
+func SetLeader(job string, leader bool) {
+	v := 0.0
+	if leader {
+		v = 1
+	}
+	leaderIsLeader.WithLabelValues(job).Set(v)
+}
+
 func StartGauges(ctx context.Context, db *pgxpool.Pool) {
 	go func() {
 		t := time.NewTicker(10 * time.Second)
@@ -54,6 +54,7 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go outbox.StartPublisher(ctx, pool, producer)
+	go svc.RunScheduler(ctx, cfg.Scheduler.Interval)
 
 	mux := http.NewServeMux()
 	h := app.NewHandlers(svc, validator)
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Entity types of the audit log.
+const (
+	AuditPickupPoint     = "pickup_point"
+	AuditWarehouse       = "warehouse"
+	AuditCarrier         = "carrier"
+	AuditCarrierSchedule = "carrier_schedule"
+)
+
+// Actions of the audit log.
+const (
+	AuditCreated     = "created"
+	AuditUpdated     = "updated"
+	AuditDeactivated = "deactivated"
+)
+
+// MaxAuditPageSize bounds one page of the audit log.
+const MaxAuditPageSize = 200
+
+// AuditEntry is one change of a reference record. Before is empty for
+// created records.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	OperatorID string          `json:"operator_id"`
+	Reason     string          `json:"reason"`
+	ChangedAt  time.Time       `json:"changed_at"`
+}
+
+// AuditFilter selects audit entries; empty fields match everything. Entries
+// are returned newest first, BeforeID continues from the last entry of the
+// previous page.
+type AuditFilter struct {
+	EntityTypes []string
+	EntityID    string
+	OperatorID  string
+	From        *time.Time
+	To          *time.Time
+	BeforeID    int64
+	Limit       int
+}
+
+// recordAuditTx appends a change to the audit log in the transaction that
+// makes it, so the log never disagrees with the data.
+func recordAuditTx(ctx context.Context, tx pgx.Tx, entityType, entityID, action string, before, after any, audit AuditInfo) error {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+	at := audit.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO reference_audit_log (entity_type, entity_id, action, before_value, after_value, operator_id, reason, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, entityType, entityID, action, beforeJSON, afterJSON, audit.OperatorID, audit.Reason, at.UTC())
+	return err
+}
+
+// updateAction tells a deactivation apart from other updates, so that
+// closures are easy to find in the log.
+func updateAction(wasActive, isActive bool) string {
+	if wasActive && !isActive {
+		return AuditDeactivated
+	}
+	return AuditUpdated
+}
+
+// auditJSON returns nil for a missing value so that it is stored as NULL.
+func auditJSON(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// ListAudit returns audit entries matching the filter, newest first.
+func (s *Service) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	if f.Limit <= 0 || f.Limit > MaxAuditPageSize {
+		f.Limit = MaxAuditPageSize
+	}
+	if f.EntityTypes == nil {
+		f.EntityTypes = []string{}
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT id, entity_type, entity_id, action, before_value, after_value, operator_id, reason, changed_at
+		FROM reference_audit_log
+		WHERE (cardinality($1::text[]) = 0 OR entity_type = ANY($1))
+		  AND ($2 = '' OR entity_id = $2)
+		  AND ($3 = '' OR operator_id = $3)
+		  AND ($4::timestamptz IS NULL OR changed_at >= $4)
+		  AND ($5::timestamptz IS NULL OR changed_at < $5)
+		  AND ($6 = 0 OR id < $6)
+		ORDER BY id DESC
+		LIMIT $7
+	`, f.EntityTypes, f.EntityID, f.OperatorID, f.From, f.To, f.BeforeID, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.EntityType, &e.EntityID, &e.Action, &before, &after, &e.OperatorID, &e.Reason, &e.ChangedAt); err != nil {
+			return nil, err
+		}
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
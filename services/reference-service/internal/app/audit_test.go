@@ -0,0 +1,67 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseAuditFilter(t *testing.T) {
+	f, err := parseAuditFilter(url.Values{
+		"entity_type": {"carrier", "carrier_schedule"},
+		"entity_id":   {"car-1"},
+		"operator_id": {"op-1"},
+		"from":        {"2024-01-01T00:00:00Z"},
+		"before_id":   {"42"},
+		"limit":       {"10"},
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(f.EntityTypes) != 2 || f.EntityTypes[1] != AuditCarrierSchedule || f.EntityID != "car-1" || f.OperatorID != "op-1" || f.From == nil || f.To != nil || f.BeforeID != 42 || f.Limit != 10 {
+		t.Fatalf("unexpected filter %+v", f)
+	}
+
+	for name, q := range map[string]url.Values{
+		"unknown type":       {"entity_type": {"orders"}},
+		"id without type":    {"entity_id": {"pvp-1"}},
+		"bad time":           {"to": {"yesterday"}},
+		"bad cursor":         {"before_id": {"-1"}},
+		"limit out of range": {"limit": {"1000"}},
+	} {
+		if _, err := parseAuditFilter(q); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestUpdateAction(t *testing.T) {
+	if updateAction(true, false) != AuditDeactivated {
+		t.Fatalf("expected deactivated")
+	}
+	if updateAction(true, true) != AuditUpdated || updateAction(false, true) != AuditUpdated {
+		t.Fatalf("expected updated")
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	mux, token := scheduleMux(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/audit?entity_type=pickup_point", nil)
+	req.Header.Set("Authorization", token("user"))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for user, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/audit?entity_type=orders", nil)
+	req.Header.Set("Authorization", token("moderator"))
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid entity_type") {
+		t.Fatalf("expected 400, got %d %s", rr.Code, rr.Body.String())
+	}
+}
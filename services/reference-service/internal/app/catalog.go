@@ -0,0 +1,610 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"bel-parcel/pkg/schedule"
+)
+
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrAlreadyExists    = errors.New("already exists")
+	ErrVersionConflict  = errors.New("version conflict: the record was changed by someone else")
+	ErrChangeConflict   = errors.New("overlaps another scheduled change of the same fields")
+	ErrChangeNotPending = errors.New("only pending changes can be cancelled")
+)
+
+// ValidationError is a problem with the submitted data, reported to the
+// operator as is.
+type ValidationError struct {
+	Msg string
+}
+
+func (e *ValidationError) Error() string { return e.Msg }
+
+const (
+	maxIDLen   = 64
+	maxNameLen = 200
+)
+
+type PickupPoint struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Address     string  `json:"address"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	IsHub       bool    `json:"is_hub"`
+	IsActive    bool    `json:"is_active"`
+	StorageDays int     `json:"storage_days"`
+	// Capacity is how many parcels the PVP can hold at once, 0 means no
+	// limit. The embedded hours say when it is open and takes deliveries.
+	Capacity int `json:"capacity"`
+	schedule.Hours
+	Version   int       `json:"version"`
+	Seq       int64     `json:"seq"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Warehouse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	IsActive  bool      `json:"is_active"`
+	Version   int       `json:"version"`
+	Seq       int64     `json:"seq"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Carrier struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	IsActive  bool      `json:"is_active"`
+	Version   int       `json:"version"`
+	Seq       int64     `json:"seq"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Patches change only the fields that are set. Version is the version the
+// operator last saw; 0 skips the check (used by the CSV import when the file
+// has no version column).
+type PickupPointPatch struct {
+	Version     int      `json:"version"`
+	Name        *string  `json:"name"`
+	Address     *string  `json:"address"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	IsHub       *bool    `json:"is_hub"`
+	IsActive    *bool    `json:"is_active"`
+	StorageDays *int     `json:"storage_days"`
+	Capacity    *int     `json:"capacity"`
+
+	OpeningHours     *[]schedule.Rule      `json:"opening_hours"`
+	Holidays         *[]schedule.Exception `json:"holidays"`
+	ReceivingWindows *[]schedule.Rule      `json:"receiving_windows"`
+}
+
+type WarehousePatch struct {
+	Version   int      `json:"version"`
+	Name      *string  `json:"name"`
+	Address   *string  `json:"address"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	IsActive  *bool    `json:"is_active"`
+}
+
+type CarrierPatch struct {
+	Version  int     `json:"version"`
+	Name     *string `json:"name"`
+	IsActive *bool   `json:"is_active"`
+}
+
+func validateID(id string) error {
+	if len(id) > maxIDLen || strings.ContainsAny(id, "/ ") {
+		return &ValidationError{Msg: "id must be at most 64 characters without spaces or slashes"}
+	}
+	return nil
+}
+
+func validateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return &ValidationError{Msg: "name is required"}
+	}
+	if len(name) > maxNameLen {
+		return &ValidationError{Msg: "name is longer than 200 characters"}
+	}
+	return nil
+}
+
+// validateCoordinates rejects (0, 0): it is what an unset location decodes to
+// and is far outside the delivery area.
+func validateCoordinates(lat, lng float64) error {
+	switch {
+	case lat == 0 && lng == 0:
+		return &ValidationError{Msg: "latitude and longitude are required"}
+	case lat < -90 || lat > 90:
+		return &ValidationError{Msg: "latitude must be between -90 and 90"}
+	case lng < -180 || lng > 180:
+		return &ValidationError{Msg: "longitude must be between -180 and 180"}
+	}
+	return nil
+}
+
+func (p *PickupPoint) validate() error {
+	if err := validateName(p.Name); err != nil {
+		return err
+	}
+	if err := validateCoordinates(p.Latitude, p.Longitude); err != nil {
+		return err
+	}
+	if p.StorageDays < MinStorageDays || p.StorageDays > MaxStorageDays {
+		return &ValidationError{Msg: "storage_days must be between 1 and 60"}
+	}
+	if p.Capacity < 0 {
+		return &ValidationError{Msg: "capacity must not be negative"}
+	}
+	if err := p.Hours.Validate(); err != nil {
+		return &ValidationError{Msg: err.Error()}
+	}
+	return nil
+}
+
+func (w *Warehouse) validate() error {
+	if err := validateName(w.Name); err != nil {
+		return err
+	}
+	return validateCoordinates(w.Latitude, w.Longitude)
+}
+
+func (c *Carrier) validate() error {
+	return validateName(c.Name)
+}
+
+func (p *PickupPointPatch) apply(pp *PickupPoint) {
+	setIf(&pp.Name, p.Name)
+	setIf(&pp.Address, p.Address)
+	setIf(&pp.Latitude, p.Latitude)
+	setIf(&pp.Longitude, p.Longitude)
+	setIf(&pp.IsHub, p.IsHub)
+	setIf(&pp.IsActive, p.IsActive)
+	setIf(&pp.StorageDays, p.StorageDays)
+	setIf(&pp.Capacity, p.Capacity)
+	setIf(&pp.OpeningHours, p.OpeningHours)
+	setIf(&pp.Holidays, p.Holidays)
+	setIf(&pp.ReceivingWindows, p.ReceivingWindows)
+}
+
+func (p *WarehousePatch) apply(w *Warehouse) {
+	setIf(&w.Name, p.Name)
+	setIf(&w.Address, p.Address)
+	setIf(&w.Latitude, p.Latitude)
+	setIf(&w.Longitude, p.Longitude)
+	setIf(&w.IsActive, p.IsActive)
+}
+
+func (p *CarrierPatch) apply(c *Carrier) {
+	setIf(&c.Name, p.Name)
+	setIf(&c.IsActive, p.IsActive)
+}
+
+func setIf[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func checkVersion(expected, current int) error {
+	if expected != 0 && expected != current {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func newID(id string) (string, error) {
+	if id == "" {
+		return uuid.NewString(), nil
+	}
+	return id, validateID(id)
+}
+
+const pickupPointColumns = `id, name, COALESCE(address, ''), COALESCE(location_lat, 0), COALESCE(location_lng, 0),
+	COALESCE(is_hub, false), is_active, storage_days, capacity, hours, version, change_seq, updated_at`
+
+func scanPickupPoint(row pgx.Row) (*PickupPoint, error) {
+	var p PickupPoint
+	err := row.Scan(&p.ID, &p.Name, &p.Address, &p.Latitude, &p.Longitude, &p.IsHub, &p.IsActive, &p.StorageDays, &p.Capacity, &p.Hours, &p.Version, &p.Seq, &p.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+const warehouseColumns = `id, name, COALESCE(address, ''), COALESCE(location_lat, 0), COALESCE(location_lng, 0),
+	is_active, version, change_seq, updated_at`
+
+func scanWarehouse(row pgx.Row) (*Warehouse, error) {
+	var w Warehouse
+	err := row.Scan(&w.ID, &w.Name, &w.Address, &w.Latitude, &w.Longitude, &w.IsActive, &w.Version, &w.Seq, &w.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+const carrierColumns = `id, name, COALESCE(is_active, true), version, change_seq, updated_at`
+
+func scanCarrier(row pgx.Row) (*Carrier, error) {
+	var c Carrier
+	err := row.Scan(&c.ID, &c.Name, &c.IsActive, &c.Version, &c.Seq, &c.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *Service) GetPickupPoint(ctx context.Context, id string) (*PickupPoint, error) {
+	return scanPickupPoint(s.db.QueryRow(ctx, `SELECT `+pickupPointColumns+` FROM pickup_points WHERE id=$1`, id))
+}
+
+func (s *Service) GetWarehouse(ctx context.Context, id string) (*Warehouse, error) {
+	return scanWarehouse(s.db.QueryRow(ctx, `SELECT `+warehouseColumns+` FROM warehouses WHERE id=$1`, id))
+}
+
+func (s *Service) GetCarrier(ctx context.Context, id string) (*Carrier, error) {
+	return scanCarrier(s.db.QueryRow(ctx, `SELECT `+carrierColumns+` FROM carriers WHERE id=$1`, id))
+}
+
+// inTx runs fn in a transaction that is committed only if fn succeeds.
+func (s *Service) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *Service) CreatePickupPoint(ctx context.Context, p PickupPoint, audit AuditInfo) (*PickupPoint, error) {
+	var created *PickupPoint
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		created, err = s.createPickupPointTx(ctx, tx, p, audit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("pickup point created", "pvp_id", created.ID, "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return created, nil
+}
+
+func (s *Service) createPickupPointTx(ctx context.Context, tx pgx.Tx, p PickupPoint, audit AuditInfo) (*PickupPoint, error) {
+	id, err := newID(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	p.ID = id
+	p.IsActive = true
+	if p.StorageDays == 0 {
+		p.StorageDays = 7
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	created, err := scanPickupPoint(tx.QueryRow(ctx, `
+		INSERT INTO pickup_points (id, name, address, location_lat, location_lng, is_hub, is_active, storage_days, capacity, hours, version, change_seq, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, true, $7, $8, $9, 1, nextval('reference_change_seq'), NOW())
+		ON CONFLICT (id) DO NOTHING
+		RETURNING `+pickupPointColumns,
+		p.ID, p.Name, p.Address, p.Latitude, p.Longitude, p.IsHub, p.StorageDays, p.Capacity, p.Hours))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAuditTx(ctx, tx, AuditPickupPoint, created.ID, AuditCreated, nil, created, audit); err != nil {
+		return nil, err
+	}
+	if err := s.publishStorageDaysTx(ctx, tx, created.ID, created.StorageDays, created.Seq, audit); err != nil {
+		return nil, err
+	}
+	return created, s.publishPickupPointTx(ctx, tx, created, audit)
+}
+
+func (s *Service) UpdatePickupPoint(ctx context.Context, id string, patch PickupPointPatch, audit AuditInfo) (*PickupPoint, error) {
+	var updated *PickupPoint
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		updated, err = s.updatePickupPointTx(ctx, tx, id, patch, audit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("pickup point updated", "pvp_id", id, "version", updated.Version, "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return updated, nil
+}
+
+func (s *Service) updatePickupPointTx(ctx context.Context, tx pgx.Tx, id string, patch PickupPointPatch, audit AuditInfo) (*PickupPoint, error) {
+	p, err := scanPickupPoint(tx.QueryRow(ctx, `SELECT `+pickupPointColumns+` FROM pickup_points WHERE id=$1 FOR UPDATE`, id))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(patch.Version, p.Version); err != nil {
+		return nil, err
+	}
+	before := *p
+	patch.apply(p)
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	updated, err := scanPickupPoint(tx.QueryRow(ctx, `
+		UPDATE pickup_points SET name=$2, address=NULLIF($3, ''), location_lat=$4, location_lng=$5,
+			is_hub=$6, is_active=$7, storage_days=$8, capacity=$9, hours=$10,
+			version=version+1, change_seq=nextval('reference_change_seq'), updated_at=NOW()
+		WHERE id=$1
+		RETURNING `+pickupPointColumns,
+		id, p.Name, p.Address, p.Latitude, p.Longitude, p.IsHub, p.IsActive, p.StorageDays, p.Capacity, p.Hours))
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAuditTx(ctx, tx, AuditPickupPoint, id, updateAction(before.IsActive, updated.IsActive), &before, updated, audit); err != nil {
+		return nil, err
+	}
+	// order-service follows storage periods through their own event
+	if updated.StorageDays != before.StorageDays {
+		if err := s.publishStorageDaysTx(ctx, tx, id, updated.StorageDays, updated.Seq, audit); err != nil {
+			return nil, err
+		}
+	}
+	return updated, s.publishPickupPointTx(ctx, tx, updated, audit)
+}
+
+func (s *Service) CreateWarehouse(ctx context.Context, w Warehouse, audit AuditInfo) (*Warehouse, error) {
+	var created *Warehouse
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		created, err = s.createWarehouseTx(ctx, tx, w, audit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("warehouse created", "warehouse_id", created.ID, "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return created, nil
+}
+
+func (s *Service) createWarehouseTx(ctx context.Context, tx pgx.Tx, w Warehouse, audit AuditInfo) (*Warehouse, error) {
+	id, err := newID(w.ID)
+	if err != nil {
+		return nil, err
+	}
+	w.ID = id
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	created, err := scanWarehouse(tx.QueryRow(ctx, `
+		INSERT INTO warehouses (id, name, address, location_lat, location_lng, is_active, version, change_seq, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, true, 1, nextval('reference_change_seq'), NOW())
+		ON CONFLICT (id) DO NOTHING
+		RETURNING `+warehouseColumns,
+		w.ID, w.Name, w.Address, w.Latitude, w.Longitude))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAuditTx(ctx, tx, AuditWarehouse, created.ID, AuditCreated, nil, created, audit); err != nil {
+		return nil, err
+	}
+	return created, s.publishWarehouseTx(ctx, tx, created, audit)
+}
+
+func (s *Service) UpdateWarehouse(ctx context.Context, id string, patch WarehousePatch, audit AuditInfo) (*Warehouse, error) {
+	var updated *Warehouse
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		updated, err = s.updateWarehouseTx(ctx, tx, id, patch, audit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("warehouse updated", "warehouse_id", id, "version", updated.Version, "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return updated, nil
+}
+
+func (s *Service) updateWarehouseTx(ctx context.Context, tx pgx.Tx, id string, patch WarehousePatch, audit AuditInfo) (*Warehouse, error) {
+	w, err := scanWarehouse(tx.QueryRow(ctx, `SELECT `+warehouseColumns+` FROM warehouses WHERE id=$1 FOR UPDATE`, id))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(patch.Version, w.Version); err != nil {
+		return nil, err
+	}
+	before := *w
+	patch.apply(w)
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	updated, err := scanWarehouse(tx.QueryRow(ctx, `
+		UPDATE warehouses SET name=$2, address=NULLIF($3, ''), location_lat=$4, location_lng=$5,
+			is_active=$6, version=version+1, change_seq=nextval('reference_change_seq'), updated_at=NOW()
+		WHERE id=$1
+		RETURNING `+warehouseColumns,
+		id, w.Name, w.Address, w.Latitude, w.Longitude, w.IsActive))
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAuditTx(ctx, tx, AuditWarehouse, id, updateAction(before.IsActive, updated.IsActive), &before, updated, audit); err != nil {
+		return nil, err
+	}
+	return updated, s.publishWarehouseTx(ctx, tx, updated, audit)
+}
+
+func (s *Service) CreateCarrier(ctx context.Context, c Carrier, audit AuditInfo) (*Carrier, error) {
+	var created *Carrier
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		created, err = s.createCarrierTx(ctx, tx, c, audit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("carrier created", "carrier_id", created.ID, "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return created, nil
+}
+
+func (s *Service) createCarrierTx(ctx context.Context, tx pgx.Tx, c Carrier, audit AuditInfo) (*Carrier, error) {
+	id, err := newID(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	c.ID = id
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	created, err := scanCarrier(tx.QueryRow(ctx, `
+		INSERT INTO carriers (id, name, is_active, version, change_seq, updated_at)
+		VALUES ($1, $2, true, 1, nextval('reference_change_seq'), NOW())
+		ON CONFLICT (id) DO NOTHING
+		RETURNING `+carrierColumns,
+		c.ID, c.Name))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAuditTx(ctx, tx, AuditCarrier, created.ID, AuditCreated, nil, created, audit); err != nil {
+		return nil, err
+	}
+	return created, s.publishCarrierTx(ctx, tx, created, audit)
+}
+
+func (s *Service) UpdateCarrier(ctx context.Context, id string, patch CarrierPatch, audit AuditInfo) (*Carrier, error) {
+	var updated *Carrier
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		updated, err = s.updateCarrierTx(ctx, tx, id, patch, audit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("carrier updated", "carrier_id", id, "version", updated.Version, "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return updated, nil
+}
+
+func (s *Service) updateCarrierTx(ctx context.Context, tx pgx.Tx, id string, patch CarrierPatch, audit AuditInfo) (*Carrier, error) {
+	c, err := scanCarrier(tx.QueryRow(ctx, `SELECT `+carrierColumns+` FROM carriers WHERE id=$1 FOR UPDATE`, id))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(patch.Version, c.Version); err != nil {
+		return nil, err
+	}
+	before := *c
+	patch.apply(c)
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	updated, err := scanCarrier(tx.QueryRow(ctx, `
+		UPDATE carriers SET name=$2, is_active=$3, version=version+1, change_seq=nextval('reference_change_seq'), updated_at=NOW()
+		WHERE id=$1
+		RETURNING `+carrierColumns,
+		id, c.Name, c.IsActive))
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAuditTx(ctx, tx, AuditCarrier, id, updateAction(before.IsActive, updated.IsActive), &before, updated, audit); err != nil {
+		return nil, err
+	}
+	return updated, s.publishCarrierTx(ctx, tx, updated, audit)
+}
+
+// The publish helpers always send the whole record, so a consumer can
+// upsert its projection from any single event. seq is the change number of
+// the record: consumers keep it and skip events older than what they have,
+// which is also how they line up events with a snapshot.
+
+func (s *Service) publishPickupPointTx(ctx context.Context, tx pgx.Tx, p *PickupPoint, audit AuditInfo) error {
+	return s.publishReferenceTx(ctx, tx, p.ID, "pickup_point", map[string]interface{}{
+		"pvp_id":            p.ID,
+		"name":              p.Name,
+		"address":           p.Address,
+		"latitude":          p.Latitude,
+		"longitude":         p.Longitude,
+		"is_hub":            p.IsHub,
+		"is_active":         p.IsActive,
+		"storage_days":      p.StorageDays,
+		"capacity":          p.Capacity,
+		"opening_hours":     p.OpeningHours,
+		"holidays":          p.Holidays,
+		"receiving_windows": p.ReceivingWindows,
+		"version":           p.Version,
+	}, p.Seq, audit)
+}
+
+func (s *Service) publishWarehouseTx(ctx context.Context, tx pgx.Tx, w *Warehouse, audit AuditInfo) error {
+	return s.publishReferenceTx(ctx, tx, w.ID, "warehouse", map[string]interface{}{
+		"warehouse_id": w.ID,
+		"name":         w.Name,
+		"address":      w.Address,
+		"latitude":     w.Latitude,
+		"longitude":    w.Longitude,
+		"is_active":    w.IsActive,
+		"version":      w.Version,
+	}, w.Seq, audit)
+}
+
+func (s *Service) publishCarrierTx(ctx context.Context, tx pgx.Tx, c *Carrier, audit AuditInfo) error {
+	return s.publishReferenceTx(ctx, tx, c.ID, "carrier", map[string]interface{}{
+		"carrier_id": c.ID,
+		"name":       c.Name,
+		"is_active":  c.IsActive,
+		"version":    c.Version,
+	}, c.Seq, audit)
+}
+
+func (s *Service) publishReferenceTx(ctx context.Context, tx pgx.Tx, id, updateType string, record map[string]interface{}, seq int64, audit AuditInfo) error {
+	now := time.Now().UTC()
+	payload := map[string]interface{}{
+		"event_id":       uuid.New().String(),
+		"event_type":     "events.reference_updated",
+		"occurred_at":    now,
+		"correlation_id": id,
+		"data": map[string]interface{}{
+			"update_type": updateType,
+			updateType:    record,
+			"seq":         seq,
+			"operator_id": audit.OperatorID,
+			"reason":      audit.Reason,
+			"updated_at":  now,
+		},
+	}
+	return s.enqueueEvent(ctx, tx, "events.reference_updated", id, payload)
+}
@@ -0,0 +1,243 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"bel-parcel/services/reference-service/internal/auth"
+)
+
+const (
+	maxRecordBody = 1 << 20
+	maxImportBody = 10 << 20
+)
+
+// catalogRoutes registers create, read, update, deactivate and CSV import of
+// pickup points, warehouses and carriers. Updates and deactivation need the
+// version the operator last read and fail with 409 if it has changed since.
+func (h *Handlers) catalogRoutes(mux *http.ServeMux) {
+	editors := []string{"moderator", "admin"}
+	readers := []string{"user", "moderator", "admin"}
+	admins := []string{"admin"}
+
+	mux.HandleFunc("GET /pvp/{id}", getRoute(h.validator, readers, h.svc.GetPickupPoint, h.svc.GetPickupPointAt))
+	mux.HandleFunc("POST /pvp", createRoute(h.validator, editors, h.svc.CreatePickupPoint))
+	mux.HandleFunc("PATCH /pvp/{id}", updateRoute(h.validator, editors, func(p PickupPointPatch) int { return p.Version }, h.svc.UpdatePickupPoint))
+	mux.HandleFunc("POST /pvp/{id}/deactivate", deactivateRoute(h.validator, editors, func(ctx context.Context, id string, version int, audit AuditInfo) (*PickupPoint, error) {
+		inactive := false
+		return h.svc.UpdatePickupPoint(ctx, id, PickupPointPatch{Version: version, IsActive: &inactive}, audit)
+	}))
+	mux.HandleFunc("POST /pvp/import", importRoute(h.validator, admins, KindPickupPoints, h.svc.ImportCSV))
+	h.scheduledChangeRoutes(mux, "/pvp", AuditPickupPoint, readers, editors)
+
+	mux.HandleFunc("GET /warehouses/{id}", getRoute(h.validator, readers, h.svc.GetWarehouse, h.svc.GetWarehouseAt))
+	mux.HandleFunc("POST /warehouses", createRoute(h.validator, editors, h.svc.CreateWarehouse))
+	mux.HandleFunc("PATCH /warehouses/{id}", updateRoute(h.validator, editors, func(p WarehousePatch) int { return p.Version }, h.svc.UpdateWarehouse))
+	mux.HandleFunc("POST /warehouses/{id}/deactivate", deactivateRoute(h.validator, editors, func(ctx context.Context, id string, version int, audit AuditInfo) (*Warehouse, error) {
+		inactive := false
+		return h.svc.UpdateWarehouse(ctx, id, WarehousePatch{Version: version, IsActive: &inactive}, audit)
+	}))
+	mux.HandleFunc("POST /warehouses/import", importRoute(h.validator, admins, KindWarehouses, h.svc.ImportCSV))
+	h.scheduledChangeRoutes(mux, "/warehouses", AuditWarehouse, readers, editors)
+
+	mux.HandleFunc("GET /carriers/{id}", getRoute(h.validator, readers, h.svc.GetCarrier, h.svc.GetCarrierAt))
+	mux.HandleFunc("POST /carriers", createRoute(h.validator, admins, h.svc.CreateCarrier))
+	mux.HandleFunc("PATCH /carriers/{id}", updateRoute(h.validator, admins, func(p CarrierPatch) int { return p.Version }, h.svc.UpdateCarrier))
+	mux.HandleFunc("POST /carriers/{id}/deactivate", deactivateRoute(h.validator, admins, func(ctx context.Context, id string, version int, audit AuditInfo) (*Carrier, error) {
+		inactive := false
+		return h.svc.UpdateCarrier(ctx, id, CarrierPatch{Version: version, IsActive: &inactive}, audit)
+	}))
+	mux.HandleFunc("POST /carriers/import", importRoute(h.validator, admins, KindCarriers, h.svc.ImportCSV))
+	h.scheduledChangeRoutes(mux, "/carriers", AuditCarrier, readers, admins)
+}
+
+// scheduledChangeRoutes registers changes that take effect later. Scheduling
+// and cancelling need the same roles as an immediate update.
+func (h *Handlers) scheduledChangeRoutes(mux *http.ServeMux, prefix, entityType string, readers, editors []string) {
+	mux.HandleFunc("GET "+prefix+"/{id}/scheduled-changes", metricsMiddleware(auth.RequireRoles(h.validator, readers, func(w http.ResponseWriter, r *http.Request) {
+		changes, err := h.svc.ListScheduledChanges(r.Context(), entityType, r.PathValue("id"))
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, changes)
+	})))
+	mux.HandleFunc("POST "+prefix+"/{id}/scheduled-changes", metricsMiddleware(auth.RequireRoles(h.validator, editors, func(w http.ResponseWriter, r *http.Request) {
+		var req ChangeRequest
+		reason, ok := decodeWithReason(w, r, &req)
+		if !ok {
+			return
+		}
+		change, err := h.svc.ScheduleChange(r.Context(), entityType, r.PathValue("id"), req, auditFromRequest(r, reason))
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, change)
+	})))
+	mux.HandleFunc("DELETE "+prefix+"/{id}/scheduled-changes/{change_id}", metricsMiddleware(auth.RequireRoles(h.validator, editors, func(w http.ResponseWriter, r *http.Request) {
+		reason := r.URL.Query().Get("reason")
+		if strings.TrimSpace(reason) == "" {
+			writeJSONError(w, http.StatusBadRequest, "reason is required")
+			return
+		}
+		if err := h.svc.CancelScheduledChange(r.Context(), entityType, r.PathValue("id"), r.PathValue("change_id"), auditFromRequest(r, reason)); err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": ChangeCancelled})
+	})))
+}
+
+// getRoute returns the current record or, with ?at=<RFC3339>, the record as it
+// was or is scheduled to be at that time.
+func getRoute[R any](v *auth.Validator, roles []string, get func(context.Context, string) (R, error), getAt func(context.Context, string, time.Time) (R, error)) http.HandlerFunc {
+	return metricsMiddleware(auth.RequireRoles(v, roles, func(w http.ResponseWriter, r *http.Request) {
+		var res R
+		var err error
+		if s := r.URL.Query().Get("at"); s != "" {
+			at, perr := time.Parse(time.RFC3339, s)
+			if perr != nil {
+				writeJSONError(w, http.StatusBadRequest, "at must be an RFC3339 time")
+				return
+			}
+			res, err = getAt(r.Context(), r.PathValue("id"), at)
+		} else {
+			res, err = get(r.Context(), r.PathValue("id"))
+		}
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}))
+}
+
+func createRoute[T, R any](v *auth.Validator, roles []string, create func(context.Context, T, AuditInfo) (R, error)) http.HandlerFunc {
+	return metricsMiddleware(auth.RequireRoles(v, roles, func(w http.ResponseWriter, r *http.Request) {
+		var rec T
+		reason, ok := decodeWithReason(w, r, &rec)
+		if !ok {
+			return
+		}
+		res, err := create(r.Context(), rec, auditFromRequest(r, reason))
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, res)
+	}))
+}
+
+func updateRoute[P, R any](v *auth.Validator, roles []string, version func(P) int, update func(context.Context, string, P, AuditInfo) (R, error)) http.HandlerFunc {
+	return metricsMiddleware(auth.RequireRoles(v, roles, func(w http.ResponseWriter, r *http.Request) {
+		var patch P
+		reason, ok := decodeWithReason(w, r, &patch)
+		if !ok {
+			return
+		}
+		if version(patch) <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "version is required")
+			return
+		}
+		res, err := update(r.Context(), r.PathValue("id"), patch, auditFromRequest(r, reason))
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}))
+}
+
+func deactivateRoute[R any](v *auth.Validator, roles []string, deactivate func(context.Context, string, int, AuditInfo) (R, error)) http.HandlerFunc {
+	return metricsMiddleware(auth.RequireRoles(v, roles, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Version int `json:"version"`
+		}
+		reason, ok := decodeWithReason(w, r, &body)
+		if !ok {
+			return
+		}
+		if body.Version <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "version is required")
+			return
+		}
+		res, err := deactivate(r.Context(), r.PathValue("id"), body.Version, auditFromRequest(r, reason))
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}))
+}
+
+// importRoute takes the CSV file as the request body and the reason as a
+// query parameter.
+func importRoute(v *auth.Validator, roles []string, kind string, importCSV func(context.Context, string, []byte, AuditInfo) ([]ImportResult, error)) http.HandlerFunc {
+	return metricsMiddleware(auth.RequireRoles(v, roles, func(w http.ResponseWriter, r *http.Request) {
+		reason := r.URL.Query().Get("reason")
+		if strings.TrimSpace(reason) == "" {
+			writeJSONError(w, http.StatusBadRequest, "reason is required")
+			return
+		}
+		content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBody))
+		if err != nil {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "file is larger than 10 MB")
+			return
+		}
+		results, err := importCSV(r.Context(), kind, content, auditFromRequest(r, reason))
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"results": results})
+	}))
+}
+
+// decodeWithReason reads the JSON body into v and returns the reason from the
+// same body. It writes the error response itself when the body is unusable.
+func decodeWithReason(w http.ResponseWriter, r *http.Request, v any) (string, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRecordBody))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid json")
+		return "", false
+	}
+	var audit struct {
+		Reason string `json:"reason"`
+	}
+	if json.Unmarshal(body, v) != nil || json.Unmarshal(body, &audit) != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid json")
+		return "", false
+	}
+	if strings.TrimSpace(audit.Reason) == "" {
+		writeJSONError(w, http.StatusBadRequest, "reason is required")
+		return "", false
+	}
+	return audit.Reason, true
+}
+
+func auditFromRequest(r *http.Request, reason string) AuditInfo {
+	return AuditInfo{OperatorID: auth.FromContext(r).ID, Reason: reason, Timestamp: time.Now()}
+}
+
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &verr):
+		writeJSONError(w, http.StatusBadRequest, verr.Msg)
+	case errors.Is(err, ErrNotFound):
+		writeJSONError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, ErrVersionConflict), errors.Is(err, ErrAlreadyExists), errors.Is(err, ErrChangeConflict),
+		errors.Is(err, ErrChangeNotPending):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), "reference request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal")
+	}
+}
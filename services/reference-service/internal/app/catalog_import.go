@@ -0,0 +1,305 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxImportRows bounds a CSV import; the whole file is handled in one request.
+const MaxImportRows = 5000
+
+// Reference kinds accepted by the CSV import, named after their URL prefix.
+const (
+	KindPickupPoints = "pvp"
+	KindWarehouses   = "warehouses"
+	KindCarriers     = "carriers"
+)
+
+var importableColumns = map[string][]string{
+	KindPickupPoints: {"id", "version", "name", "address", "latitude", "longitude", "is_hub", "is_active", "storage_days", "capacity"},
+	KindWarehouses:   {"id", "version", "name", "address", "latitude", "longitude", "is_active"},
+	KindCarriers:     {"id", "version", "name", "is_active"},
+}
+
+// ImportResult is the outcome of one CSV row. Row is the line number of the
+// file without the header.
+type ImportResult struct {
+	Row     int    `json:"row"`
+	ID      string `json:"id,omitempty"`
+	Status  string `json:"status"`
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportFailed  = "failed"
+)
+
+// csvRow holds the cells of a row by column name. Empty cells are unset and
+// leave the stored value as it is.
+type csvRow map[string]string
+
+func (r csvRow) str(name string) *string {
+	if v, ok := r[name]; ok && v != "" {
+		return &v
+	}
+	return nil
+}
+
+func (r csvRow) float(name string) (*float64, error) {
+	v := r.str(name)
+	if v == nil {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(*v, 64)
+	if err != nil {
+		return nil, &ValidationError{Msg: name + ": not a number"}
+	}
+	return &f, nil
+}
+
+func (r csvRow) integer(name string) (*int, error) {
+	v := r.str(name)
+	if v == nil {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(*v)
+	if err != nil {
+		return nil, &ValidationError{Msg: name + ": not an integer"}
+	}
+	return &n, nil
+}
+
+func (r csvRow) boolean(name string) (*bool, error) {
+	v := r.str(name)
+	if v == nil {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(*v)
+	if err != nil {
+		return nil, &ValidationError{Msg: name + ": expected true or false"}
+	}
+	return &b, nil
+}
+
+// parseReferenceCSV reads the header and rows of an import file. Unknown
+// columns are rejected so that a typo does not silently drop a field.
+func parseReferenceCSV(kind string, content []byte) ([]csvRow, error) {
+	allowed, ok := importableColumns[kind]
+	if !ok {
+		return nil, &ValidationError{Msg: "unknown reference kind " + kind}
+	}
+	r := csv.NewReader(bytes.NewReader(content))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, &ValidationError{Msg: "cannot read CSV header: " + err.Error()}
+	}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		known := false
+		for _, a := range allowed {
+			if h == a {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, &ValidationError{Msg: fmt.Sprintf("unknown column %q", h)}
+		}
+		header[i] = h
+	}
+	var rows []csvRow
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ValidationError{Msg: "cannot read CSV: " + err.Error()}
+		}
+		if len(rows) >= MaxImportRows {
+			return nil, &ValidationError{Msg: fmt.Sprintf("more than %d rows", MaxImportRows)}
+		}
+		row := make(csvRow, len(header))
+		for i, h := range header {
+			row[h] = strings.TrimSpace(rec[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ImportCSV creates or updates reference records from a CSV file. A row with
+// the id of an existing record updates it, other rows create new records.
+// Each row is saved and published on its own, so one bad row does not stop
+// the rest of the file.
+func (s *Service) ImportCSV(ctx context.Context, kind string, content []byte, audit AuditInfo) ([]ImportResult, error) {
+	rows, err := parseReferenceCSV(kind, content)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ImportResult, 0, len(rows))
+	var created, updated, failed int
+	for i, row := range rows {
+		res := ImportResult{Row: i + 1, ID: row["id"]}
+		err := s.inTx(ctx, func(tx pgx.Tx) error {
+			var err error
+			res.ID, res.Status, res.Version, err = s.importRowTx(ctx, tx, kind, row, audit)
+			return err
+		})
+		var verr *ValidationError
+		switch {
+		case err == nil:
+		case errors.As(err, &verr), errors.Is(err, ErrVersionConflict), errors.Is(err, ErrAlreadyExists):
+			res.Status, res.Error = ImportFailed, err.Error()
+		default:
+			return nil, fmt.Errorf("row %d: %w", res.Row, err)
+		}
+		switch res.Status {
+		case ImportCreated:
+			created++
+		case ImportUpdated:
+			updated++
+		default:
+			failed++
+		}
+		results = append(results, res)
+	}
+	slog.Info("reference csv imported", "kind", kind, "created", created, "updated", updated, "failed", failed,
+		"operator_id", audit.OperatorID, "reason", audit.Reason)
+	return results, nil
+}
+
+func (s *Service) importRowTx(ctx context.Context, tx pgx.Tx, kind string, row csvRow, audit AuditInfo) (string, string, int, error) {
+	id := row["id"]
+	version, err := row.integer("version")
+	if err != nil {
+		return id, "", 0, err
+	}
+	expected := 0
+	if version != nil {
+		expected = *version
+	}
+	switch kind {
+	case KindPickupPoints:
+		patch, err := pickupPointPatchFromRow(row)
+		if err != nil {
+			return id, "", 0, err
+		}
+		patch.Version = expected
+		if id != "" {
+			p, err := s.updatePickupPointTx(ctx, tx, id, patch, audit)
+			if err == nil {
+				return p.ID, ImportUpdated, p.Version, nil
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return id, "", 0, err
+			}
+		}
+		p := PickupPoint{ID: id}
+		patch.apply(&p)
+		created, err := s.createPickupPointTx(ctx, tx, p, audit)
+		if err != nil {
+			return id, "", 0, err
+		}
+		return created.ID, ImportCreated, created.Version, nil
+	case KindWarehouses:
+		patch, err := warehousePatchFromRow(row)
+		if err != nil {
+			return id, "", 0, err
+		}
+		patch.Version = expected
+		if id != "" {
+			w, err := s.updateWarehouseTx(ctx, tx, id, patch, audit)
+			if err == nil {
+				return w.ID, ImportUpdated, w.Version, nil
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return id, "", 0, err
+			}
+		}
+		w := Warehouse{ID: id}
+		patch.apply(&w)
+		created, err := s.createWarehouseTx(ctx, tx, w, audit)
+		if err != nil {
+			return id, "", 0, err
+		}
+		return created.ID, ImportCreated, created.Version, nil
+	default:
+		patch, err := carrierPatchFromRow(row)
+		if err != nil {
+			return id, "", 0, err
+		}
+		patch.Version = expected
+		if id != "" {
+			c, err := s.updateCarrierTx(ctx, tx, id, patch, audit)
+			if err == nil {
+				return c.ID, ImportUpdated, c.Version, nil
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return id, "", 0, err
+			}
+		}
+		c := Carrier{ID: id}
+		patch.apply(&c)
+		created, err := s.createCarrierTx(ctx, tx, c, audit)
+		if err != nil {
+			return id, "", 0, err
+		}
+		return created.ID, ImportCreated, created.Version, nil
+	}
+}
+
+func pickupPointPatchFromRow(row csvRow) (PickupPointPatch, error) {
+	p := PickupPointPatch{Name: row.str("name"), Address: row.str("address")}
+	var err error
+	if p.Latitude, err = row.float("latitude"); err != nil {
+		return p, err
+	}
+	if p.Longitude, err = row.float("longitude"); err != nil {
+		return p, err
+	}
+	if p.IsHub, err = row.boolean("is_hub"); err != nil {
+		return p, err
+	}
+	if p.IsActive, err = row.boolean("is_active"); err != nil {
+		return p, err
+	}
+	if p.StorageDays, err = row.integer("storage_days"); err != nil {
+		return p, err
+	}
+	p.Capacity, err = row.integer("capacity")
+	return p, err
+}
+
+func warehousePatchFromRow(row csvRow) (WarehousePatch, error) {
+	w := WarehousePatch{Name: row.str("name"), Address: row.str("address")}
+	var err error
+	if w.Latitude, err = row.float("latitude"); err != nil {
+		return w, err
+	}
+	if w.Longitude, err = row.float("longitude"); err != nil {
+		return w, err
+	}
+	w.IsActive, err = row.boolean("is_active")
+	return w, err
+}
+
+func carrierPatchFromRow(row csvRow) (CarrierPatch, error) {
+	c := CarrierPatch{Name: row.str("name")}
+	var err error
+	c.IsActive, err = row.boolean("is_active")
+	return c, err
+}
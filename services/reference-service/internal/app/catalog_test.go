@@ -0,0 +1,125 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bel-parcel/pkg/schedule"
+	"bel-parcel/services/reference-service/internal/auth"
+)
+
+func TestPickupPointValidate(t *testing.T) {
+	valid := PickupPoint{Name: "ПВЗ Немига", Latitude: 53.9, Longitude: 27.55, StorageDays: 7}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("expected valid, got %v", err)
+	}
+	cases := map[string]func(p *PickupPoint){
+		"name is required":                       func(p *PickupPoint) { p.Name = " " },
+		"latitude and longitude are required":    func(p *PickupPoint) { p.Latitude, p.Longitude = 0, 0 },
+		"latitude must be between -90 and 90":    func(p *PickupPoint) { p.Latitude = 91 },
+		"longitude must be between -180 and 180": func(p *PickupPoint) { p.Longitude = -181 },
+		"storage_days must be between 1 and 60":  func(p *PickupPoint) { p.StorageDays = 0 },
+		"capacity must not be negative":          func(p *PickupPoint) { p.Capacity = -1 },
+		"kind must be off or on":                 func(p *PickupPoint) { p.Holidays = []schedule.Exception{{Kind: "closed"}} },
+		"receiving windows never fall into opening hours": func(p *PickupPoint) {
+			p.OpeningHours = []schedule.Rule{{Weekday: time.Monday, Start: "09:00", End: "18:00"}}
+			p.ReceivingWindows = []schedule.Rule{{Weekday: time.Monday, Start: "19:00", End: "21:00"}}
+		},
+	}
+	for want, mutate := range cases {
+		p := valid
+		mutate(&p)
+		var verr *ValidationError
+		if err := p.validate(); !errors.As(err, &verr) || verr.Msg != want {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+	}
+}
+
+func TestPatchApplyKeepsUnsetFields(t *testing.T) {
+	w := Warehouse{ID: "wh-1", Name: "Склад", Address: "Минск", Latitude: 53.9, Longitude: 27.5, IsActive: true}
+	lat := 52.4
+	patch := WarehousePatch{Latitude: &lat}
+	patch.apply(&w)
+	if w.Latitude != lat || w.Longitude != 27.5 || w.Name != "Склад" || !w.IsActive {
+		t.Fatalf("unexpected warehouse after patch: %+v", w)
+	}
+	if err := checkVersion(2, 3); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected version conflict, got %v", err)
+	}
+	if err := checkVersion(0, 3); err != nil {
+		t.Fatalf("version 0 must skip the check, got %v", err)
+	}
+}
+
+func TestParseReferenceCSV(t *testing.T) {
+	content := "\ufeffid,name,latitude,longitude,is_hub\n" +
+		"pvp-1,ПВЗ 1,53.9,27.5,true\n" +
+		"pvp-2,,,,\n"
+	rows, err := parseReferenceCSV(KindPickupPoints, []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	patch, err := pickupPointPatchFromRow(rows[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch.Name == nil || *patch.Name != "ПВЗ 1" || patch.IsHub == nil || !*patch.IsHub || patch.StorageDays != nil {
+		t.Fatalf("unexpected patch %+v", patch)
+	}
+	patch, err = pickupPointPatchFromRow(rows[1])
+	if err != nil || patch.Name != nil || patch.Latitude != nil {
+		t.Fatalf("empty cells must leave fields unset, got %+v, %v", patch, err)
+	}
+
+	rows[0]["latitude"] = "north"
+	if _, err := pickupPointPatchFromRow(rows[0]); err == nil || err.Error() != "latitude: not a number" {
+		t.Fatalf("expected number error, got %v", err)
+	}
+
+	var verr *ValidationError
+	if _, err := parseReferenceCSV(KindCarriers, []byte("id,name,latitude\n")); !errors.As(err, &verr) {
+		t.Fatalf("expected unknown column error, got %v", err)
+	}
+}
+
+func TestCatalogHandlers_Validation(t *testing.T) {
+	val := auth.NewValidator("s", "", "")
+	h := NewHandlers(&Service{}, val)
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	token := makeToken(t, "s", "", "", "op-1", "admin", time.Now().Add(time.Hour))
+
+	cases := []struct {
+		method, path, body, want string
+	}{
+		{"POST", "/warehouses", `{"name":"Склад"}`, "reason is required"},
+		{"PATCH", "/pvp/pvp-1", `{"name":"ПВЗ","reason":"rename"}`, "version is required"},
+		{"POST", "/carriers/car-1/deactivate", `{"reason":"fired"}`, "version is required"},
+		{"POST", "/pvp/import", "id,name\n", "reason is required"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), c.want) {
+			t.Fatalf("%s %s: expected 400 %q, got %d %s", c.method, c.path, c.want, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/carriers", strings.NewReader(`{"name":"ИП","reason":"new"}`))
+	req.Header.Set("Authorization", "Bearer "+makeToken(t, "s", "", "", "op-2", "moderator", time.Now().Add(time.Hour)))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected moderators to be refused carriers, got %d", w.Code)
+	}
+}
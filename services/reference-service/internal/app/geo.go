@@ -0,0 +1,247 @@
+package app
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"bel-parcel/services/reference-service/internal/metrics"
+)
+
+// Kinds of located records returned by the geo queries. Hubs are pickup
+// points with is_hub set; carriers have no fixed location.
+const (
+	GeoPickupPoint = "pvp"
+	GeoHub         = "hub"
+	GeoWarehouse   = "warehouse"
+)
+
+// Limits of the geo queries.
+const (
+	DefaultNearest     = 5
+	MaxNearest         = 50
+	DefaultGeoLimit    = 500
+	MaxGeoLimit        = 2000
+	MaxGeoRadiusMeters = 1_000_000
+)
+
+const earthRadiusMeters = 6371008.8
+
+// nearestStartRadius is the first radius tried by Nearest; it grows four
+// times per round until enough records are found or the whole globe is
+// covered.
+const nearestStartRadius = 5000
+
+// GeoItem is a pickup point or warehouse found by a geo query.
+// DistanceMeters is set for queries around a point.
+type GeoItem struct {
+	Type           string   `json:"type"`
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Address        string   `json:"address"`
+	Latitude       float64  `json:"latitude"`
+	Longitude      float64  `json:"longitude"`
+	IsHub          bool     `json:"is_hub"`
+	DistanceMeters *float64 `json:"distance_meters,omitempty"`
+}
+
+// GeoBox is a latitude/longitude rectangle. West greater than East means the
+// box crosses the antimeridian.
+type GeoBox struct {
+	South, West, North, East float64
+}
+
+// parts splits a box crossing the antimeridian into two that do not, so
+// each can be matched with plain BETWEEN conditions.
+func (b GeoBox) parts() []GeoBox {
+	if b.West <= b.East {
+		return []GeoBox{b}
+	}
+	return []GeoBox{
+		{South: b.South, West: b.West, North: b.North, East: 180},
+		{South: b.South, West: -180, North: b.North, East: b.East},
+	}
+}
+
+func (b GeoBox) contains(lat, lng float64) bool {
+	if lat < b.South || lat > b.North {
+		return false
+	}
+	if b.West <= b.East {
+		return lng >= b.West && lng <= b.East
+	}
+	return lng >= b.West || lng <= b.East
+}
+
+// distanceMeters is the great-circle distance between two points.
+func distanceMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	φ1, φ2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dφ := φ2 - φ1
+	dλ := (lng2 - lng1) * math.Pi / 180
+	a := math.Sin(dφ/2)*math.Sin(dφ/2) + math.Cos(φ1)*math.Cos(φ2)*math.Sin(dλ/2)*math.Sin(dλ/2)
+	a = math.Min(1, math.Max(0, a))
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// radiusBox is the smallest box containing every point within radius of
+// (lat, lng). A circle reaching a pole spans all longitudes; one crossing the
+// antimeridian gives a box with West > East.
+func radiusBox(lat, lng, radius float64) GeoBox {
+	d := radius / earthRadiusMeters
+	φ := lat * math.Pi / 180
+	south, north := φ-d, φ+d
+	if north >= math.Pi/2 || south <= -math.Pi/2 {
+		return GeoBox{
+			South: math.Max(south, -math.Pi/2) * 180 / math.Pi,
+			West:  -180,
+			North: math.Min(north, math.Pi/2) * 180 / math.Pi,
+			East:  180,
+		}
+	}
+	dλ := math.Asin(math.Sin(d)/math.Cos(φ)) * 180 / math.Pi
+	west, east := lng-dλ, lng+dλ
+	if west < -180 {
+		west += 360
+	}
+	if east > 180 {
+		east -= 360
+	}
+	return GeoBox{South: south * 180 / math.Pi, West: west, North: north * 180 / math.Pi, East: east}
+}
+
+// Nearest returns the n active records of the given kinds closest to
+// (lat, lng), nearest first.
+func (s *Service) Nearest(ctx context.Context, lat, lng float64, kinds []string, n int) ([]GeoItem, error) {
+	for radius := float64(nearestStartRadius); ; radius *= 4 {
+		whole := radius >= math.Pi*earthRadiusMeters
+		items, err := s.within(ctx, lat, lng, radius, kinds)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) >= n || whole {
+			if len(items) > n {
+				items = items[:n]
+			}
+			return items, nil
+		}
+	}
+}
+
+// WithinRadius returns the active records of the given kinds at most radius
+// meters from (lat, lng), nearest first.
+func (s *Service) WithinRadius(ctx context.Context, lat, lng, radius float64, kinds []string, limit int) ([]GeoItem, error) {
+	items, err := s.within(ctx, lat, lng, radius, kinds)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// WithinBox returns the active records of the given kinds inside the box,
+// ordered by type and id.
+func (s *Service) WithinBox(ctx context.Context, box GeoBox, kinds []string, limit int) ([]GeoItem, error) {
+	items, err := s.geoCandidates(ctx, box, kinds)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Type != items[j].Type {
+			return items[i].Type < items[j].Type
+		}
+		return items[i].ID < items[j].ID
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// within reads the records in the bounding box of the circle, drops the
+// ones in its corners and sorts the rest by distance.
+func (s *Service) within(ctx context.Context, lat, lng, radius float64, kinds []string) ([]GeoItem, error) {
+	cands, err := s.geoCandidates(ctx, radiusBox(lat, lng, radius), kinds)
+	if err != nil {
+		return nil, err
+	}
+	items := cands[:0]
+	for _, it := range cands {
+		d := distanceMeters(lat, lng, it.Latitude, it.Longitude)
+		if d > radius {
+			continue
+		}
+		it.DistanceMeters = &d
+		items = append(items, it)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return *items[i].DistanceMeters < *items[j].DistanceMeters })
+	return items, nil
+}
+
+// geoCandidates runs one indexed range query per kind and box part.
+func (s *Service) geoCandidates(ctx context.Context, box GeoBox, kinds []string) ([]GeoItem, error) {
+	var pickupPoints, hubsOnly, warehouses bool
+	for _, k := range kinds {
+		switch k {
+		case GeoPickupPoint:
+			pickupPoints = true
+		case GeoHub:
+			hubsOnly = true
+		case GeoWarehouse:
+			warehouses = true
+		}
+	}
+	var items []GeoItem
+	for _, p := range box.parts() {
+		if pickupPoints || hubsOnly {
+			found, err := s.geoQuery(ctx, "geo_pvz", `
+				SELECT id, name, COALESCE(address, ''), location_lat, location_lng, COALESCE(is_hub, false)
+				FROM pickup_points
+				WHERE is_active AND location_lat BETWEEN $1 AND $2 AND location_lng BETWEEN $3 AND $4
+				  AND ($5 OR is_hub)
+			`, p, pickupPoints)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, found...)
+		}
+		if warehouses {
+			found, err := s.geoQuery(ctx, "geo_warehouses", `
+				SELECT id, name, COALESCE(address, ''), location_lat, location_lng, false
+				FROM warehouses
+				WHERE is_active AND location_lat BETWEEN $1 AND $2 AND location_lng BETWEEN $3 AND $4
+			`, p)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, found...)
+		}
+	}
+	return items, nil
+}
+
+func (s *Service) geoQuery(ctx context.Context, label, sql string, box GeoBox, extra ...any) ([]GeoItem, error) {
+	start := time.Now()
+	args := append([]any{box.South, box.North, box.West, box.East}, extra...)
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	metrics.DBQueryDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	var items []GeoItem
+	for rows.Next() {
+		var it GeoItem
+		if err := rows.Scan(&it.ID, &it.Name, &it.Address, &it.Latitude, &it.Longitude, &it.IsHub); err != nil {
+			return nil, err
+		}
+		it.Type = GeoPickupPoint
+		if label == "geo_warehouses" {
+			it.Type = GeoWarehouse
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
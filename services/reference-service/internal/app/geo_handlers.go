@@ -0,0 +1,227 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"bel-parcel/services/reference-service/internal/auth"
+)
+
+func (h *Handlers) geoRoutes(mux *http.ServeMux) {
+	readers := []string{"user", "moderator", "admin", "service"}
+
+	mux.HandleFunc("GET /geo/nearest", metricsMiddleware(auth.RequireRoles(h.validator, readers, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		lat, lng, err := parsePoint(q)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		kinds, err := parseGeoKinds(q, []string{GeoPickupPoint})
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		n, err := parseBoundedInt(q, "n", DefaultNearest, MaxNearest)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		items, err := h.svc.Nearest(r.Context(), lat, lng, kinds, n)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeGeo(w, q, items)
+	})))
+
+	mux.HandleFunc("GET /geo/radius", metricsMiddleware(auth.RequireRoles(h.validator, readers, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		lat, lng, err := parsePoint(q)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		radius, err := strconv.ParseFloat(q.Get("radius_m"), 64)
+		if err != nil || math.IsNaN(radius) || radius <= 0 || radius > MaxGeoRadiusMeters {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("radius_m must be a number in (0, %d]", MaxGeoRadiusMeters))
+			return
+		}
+		kinds, err := parseGeoKinds(q, []string{GeoPickupPoint, GeoWarehouse})
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit, err := parseBoundedInt(q, "limit", DefaultGeoLimit, MaxGeoLimit)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		items, err := h.svc.WithinRadius(r.Context(), lat, lng, radius, kinds, limit)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeGeo(w, q, items)
+	})))
+
+	mux.HandleFunc("GET /geo/bbox", metricsMiddleware(auth.RequireRoles(h.validator, readers, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		box, err := parseBBox(q.Get("bbox"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		kinds, err := parseGeoKinds(q, []string{GeoPickupPoint, GeoWarehouse})
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit, err := parseBoundedInt(q, "limit", DefaultGeoLimit, MaxGeoLimit)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		items, err := h.svc.WithinBox(r.Context(), box, kinds, limit)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeGeo(w, q, items)
+	})))
+}
+
+func parsePoint(q url.Values) (float64, float64, error) {
+	lat, err := strconv.ParseFloat(q.Get("lat"), 64)
+	if err != nil || !validLatitude(lat) {
+		return 0, 0, errors.New("lat must be a number in [-90, 90]")
+	}
+	lng, err := strconv.ParseFloat(q.Get("lng"), 64)
+	if err != nil || !validLongitude(lng) {
+		return 0, 0, errors.New("lng must be a number in [-180, 180]")
+	}
+	return lat, lng, nil
+}
+
+func validLatitude(v float64) bool  { return v >= -90 && v <= 90 }
+func validLongitude(v float64) bool { return v >= -180 && v <= 180 }
+
+// parseBBox reads "west,south,east,north" in degrees. West greater than east
+// selects a box crossing the antimeridian.
+func parseBBox(s string) (GeoBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return GeoBox{}, errors.New("bbox must be west,south,east,north")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return GeoBox{}, errors.New("bbox must be west,south,east,north")
+		}
+		v[i] = f
+	}
+	box := GeoBox{West: v[0], South: v[1], East: v[2], North: v[3]}
+	if !validLongitude(box.West) || !validLongitude(box.East) {
+		return GeoBox{}, errors.New("bbox longitudes must be in [-180, 180]")
+	}
+	if !validLatitude(box.South) || !validLatitude(box.North) || box.South > box.North {
+		return GeoBox{}, errors.New("bbox latitudes must be in [-90, 90] with south <= north")
+	}
+	return box, nil
+}
+
+// parseGeoKinds reads the type parameter, repeated or comma-separated.
+func parseGeoKinds(q url.Values, def []string) ([]string, error) {
+	var kinds []string
+	for _, v := range q["type"] {
+		for _, k := range strings.Split(v, ",") {
+			switch k {
+			case GeoPickupPoint, GeoHub, GeoWarehouse:
+				kinds = append(kinds, k)
+			default:
+				return nil, errors.New("invalid type: must be one of pvp, hub, warehouse")
+			}
+		}
+	}
+	if len(kinds) == 0 {
+		return def, nil
+	}
+	return kinds, nil
+}
+
+func parseBoundedInt(q url.Values, name string, def, max int) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 || v > max {
+		return 0, fmt.Errorf("%s must be between 1 and %d", name, max)
+	}
+	return v, nil
+}
+
+// writeGeo answers with a JSON array or, with format=geojson, an RFC 7946
+// FeatureCollection of points.
+func writeGeo(w http.ResponseWriter, q url.Values, items []GeoItem) {
+	if items == nil {
+		items = []GeoItem{}
+	}
+	switch q.Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, items)
+	case "geojson":
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(geoJSON(items))
+	default:
+		writeJSONError(w, http.StatusBadRequest, "format must be json or geojson")
+	}
+}
+
+type geoFeatureCollection struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id"`
+	Geometry   geoPoint       `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+func geoJSON(items []GeoItem) geoFeatureCollection {
+	fc := geoFeatureCollection{Type: "FeatureCollection", Features: make([]geoFeature, 0, len(items))}
+	for _, it := range items {
+		props := map[string]any{
+			"type":    it.Type,
+			"name":    it.Name,
+			"address": it.Address,
+			"is_hub":  it.IsHub,
+		}
+		if it.DistanceMeters != nil {
+			props["distance_meters"] = *it.DistanceMeters
+		}
+		fc.Features = append(fc.Features, geoFeature{
+			Type: "Feature",
+			ID:   it.ID,
+			// GeoJSON puts longitude first.
+			Geometry:   geoPoint{Type: "Point", Coordinates: [2]float64{it.Longitude, it.Latitude}},
+			Properties: props,
+		})
+	}
+	return fc
+}
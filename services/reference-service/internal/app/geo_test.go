@@ -0,0 +1,171 @@
+package app
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// destination is the point reached from (lat, lng) going dist meters along
+// the initial bearing, in degrees.
+func destination(lat, lng, dist, bearing float64) (float64, float64) {
+	φ, λ, θ := lat*math.Pi/180, lng*math.Pi/180, bearing*math.Pi/180
+	δ := dist / earthRadiusMeters
+	φ2 := math.Asin(math.Sin(φ)*math.Cos(δ) + math.Cos(φ)*math.Sin(δ)*math.Cos(θ))
+	λ2 := λ + math.Atan2(math.Sin(θ)*math.Sin(δ)*math.Cos(φ), math.Cos(δ)-math.Sin(φ)*math.Sin(φ2))
+	lng2 := math.Mod(λ2*180/math.Pi+540, 360) - 180
+	return φ2 * 180 / math.Pi, lng2
+}
+
+func TestDistanceMeters(t *testing.T) {
+	cases := []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want                   float64
+	}{
+		{"minsk-brest", 53.9045, 27.5615, 52.0976, 23.7341, 326_000},
+		{"across antimeridian", 0, 179.5, 0, -179.5, 111_195},
+		{"over the north pole", 89.5, 0, 89.5, 180, 111_195},
+		{"pole to pole", 90, 0, -90, 0, math.Pi * earthRadiusMeters},
+		{"same point", 10, 20, 10, 20, 0},
+	}
+	for _, c := range cases {
+		got := distanceMeters(c.lat1, c.lng1, c.lat2, c.lng2)
+		if math.Abs(got-c.want) > c.want*0.005+1 {
+			t.Fatalf("%s: got %.0f m, want %.0f m", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRadiusBoxContainsCircle(t *testing.T) {
+	cases := []struct {
+		name           string
+		lat, lng, dist float64
+	}{
+		{"minsk", 53.9, 27.56, 50_000},
+		{"antimeridian east side", 0, 179.9, 50_000},
+		{"antimeridian west side", -16.5, -179.8, 200_000},
+		{"near north pole", 89.9, 10, 50_000},
+		{"near south pole", -89.5, -120, 100_000},
+		{"on the north pole", 90, 0, 1000},
+		{"high latitude", 70, 170, MaxGeoRadiusMeters},
+	}
+	for _, c := range cases {
+		box := radiusBox(c.lat, c.lng, c.dist)
+		if !box.contains(c.lat, c.lng) {
+			t.Fatalf("%s: box %+v misses the center", c.name, box)
+		}
+		for bearing := 0.0; bearing < 360; bearing += 5 {
+			for _, frac := range []float64{0.5, 0.999} {
+				lat, lng := destination(c.lat, c.lng, c.dist*frac, bearing)
+				if !box.contains(lat, lng) {
+					t.Fatalf("%s: box %+v misses (%f, %f) at bearing %.0f", c.name, box, lat, lng, bearing)
+				}
+			}
+		}
+	}
+}
+
+func TestRadiusBoxShape(t *testing.T) {
+	box := radiusBox(0, 179.9, 50_000)
+	if box.West <= box.East {
+		t.Fatalf("expected box crossing the antimeridian, got %+v", box)
+	}
+	if parts := box.parts(); len(parts) != 2 || parts[0].East != 180 || parts[1].West != -180 {
+		t.Fatalf("unexpected parts %+v", parts)
+	}
+
+	box = radiusBox(89.9, 10, 50_000)
+	if box.West != -180 || box.East != 180 || box.North != 90 {
+		t.Fatalf("expected polar cap, got %+v", box)
+	}
+
+	box = radiusBox(53.9, 27.56, 10_000)
+	if box.West >= box.East || len(box.parts()) != 1 {
+		t.Fatalf("expected plain box, got %+v", box)
+	}
+	if box.contains(53.9, 27.56+1) || box.contains(53.9+1, 27.56) {
+		t.Fatalf("box %+v is too wide", box)
+	}
+}
+
+func TestParseBBox(t *testing.T) {
+	box, err := parseBBox("170,-20,-170,-10")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if box.West != 170 || box.South != -20 || box.East != -170 || box.North != -10 {
+		t.Fatalf("unexpected box %+v", box)
+	}
+	if !box.contains(-15, 179) || !box.contains(-15, -175) || box.contains(-15, 0) {
+		t.Fatalf("antimeridian box %+v matches wrong side", box)
+	}
+
+	for _, s := range []string{"", "1,2,3", "a,1,2,3", "-181,0,10,10", "0,10,10,0", "0,-91,10,10"} {
+		if _, err := parseBBox(s); err == nil {
+			t.Fatalf("%q: expected error", s)
+		}
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	d := 1234.5
+	fc := geoJSON([]GeoItem{{Type: GeoPickupPoint, ID: "pvp-1", Name: "ПВЗ", Latitude: 53.9, Longitude: 27.56, DistanceMeters: &d}})
+	raw, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var out struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			ID       string `json:"id"`
+			Geometry struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if out.Type != "FeatureCollection" || len(out.Features) != 1 {
+		t.Fatalf("unexpected collection %s", raw)
+	}
+	f := out.Features[0]
+	if f.Type != "Feature" || f.ID != "pvp-1" || f.Geometry.Type != "Point" {
+		t.Fatalf("unexpected feature %s", raw)
+	}
+	if len(f.Geometry.Coordinates) != 2 || f.Geometry.Coordinates[0] != 27.56 || f.Geometry.Coordinates[1] != 53.9 {
+		t.Fatalf("coordinates must be [lng, lat], got %v", f.Geometry.Coordinates)
+	}
+	if f.Properties["type"] != GeoPickupPoint || f.Properties["distance_meters"] != d {
+		t.Fatalf("unexpected properties %v", f.Properties)
+	}
+}
+
+func TestGeoHandlers_Validation(t *testing.T) {
+	mux, token := scheduleMux(t)
+
+	for _, target := range []string{
+		"/geo/nearest?lat=91&lng=0",
+		"/geo/nearest?lat=0&lng=181",
+		"/geo/nearest?lat=0&lng=0&n=0",
+		"/geo/nearest?lat=0&lng=0&type=carrier",
+		"/geo/radius?lat=0&lng=0",
+		"/geo/radius?lat=0&lng=0&radius_m=5000000",
+		"/geo/bbox?bbox=0,10,10,0",
+		"/geo/bbox?bbox=0,0,10,10&limit=100000",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", token("user"))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d %s", target, rr.Code, rr.Body.String())
+		}
+	}
+}
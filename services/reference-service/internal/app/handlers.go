@@ -2,11 +2,15 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"bel-parcel/pkg/schedule"
 	"bel-parcel/services/reference-service/internal/auth"
 	"bel-parcel/services/reference-service/internal/metrics"
 )
@@ -83,6 +87,38 @@ func (h *Handlers) Routes(mux *http.ServeMux) {
 		})(w, r)
 	}))
 
+	mux.HandleFunc("PUT /pvp/{id}/storage", metricsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		auth.RequireRoles(h.validator, []string{"moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				StorageDays int    `json:"storage_days"`
+				Reason      string `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid json")
+				return
+			}
+			if strings.TrimSpace(body.Reason) == "" {
+				writeJSONError(w, http.StatusBadRequest, "reason is required")
+				return
+			}
+			if body.StorageDays < MinStorageDays || body.StorageDays > MaxStorageDays {
+				writeJSONError(w, http.StatusBadRequest, "storage_days must be between 1 and 60")
+				return
+			}
+			u := auth.FromContext(r)
+			audit := AuditInfo{
+				OperatorID: u.ID,
+				Reason:     body.Reason,
+				Timestamp:  time.Now(),
+			}
+			if err := h.svc.UpdatePVZStorageDays(r.Context(), r.PathValue("id"), body.StorageDays, audit); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+		})(w, r)
+	}))
+
 	mux.HandleFunc("PUT /carriers/{id}", metricsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		auth.RequireRoles(h.validator, []string{"admin"}, func(w http.ResponseWriter, r *http.Request) {
 			var body struct {
@@ -110,6 +146,189 @@ func (h *Handlers) Routes(mux *http.ServeMux) {
 			writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
 		})(w, r)
 	}))
+
+	mux.HandleFunc("GET /carriers/on-shift", metricsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		auth.RequireRoles(h.validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+			at := time.Now().UTC()
+			if v := r.URL.Query().Get("at"); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, "invalid at: expected RFC3339")
+					return
+				}
+				at = t
+			}
+			res, err := h.svc.CarriersOnShift(r.Context(), at)
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "internal")
+				return
+			}
+			writeJSON(w, http.StatusOK, res)
+		})(w, r)
+	}))
+
+	mux.HandleFunc("GET /carriers/{id}/schedule", metricsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		auth.RequireRoles(h.validator, []string{"user", "moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+			sc, err := h.svc.GetCarrierSchedule(r.Context(), r.PathValue("id"))
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, sc)
+		})(w, r)
+	}))
+
+	mux.HandleFunc("PUT /carriers/{id}/schedule", metricsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		auth.RequireRoles(h.validator, []string{"admin"}, func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Rules  []schedule.Rule `json:"rules"`
+				Reason string          `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid json")
+				return
+			}
+			if strings.TrimSpace(body.Reason) == "" {
+				writeJSONError(w, http.StatusBadRequest, "reason is required")
+				return
+			}
+			u := auth.FromContext(r)
+			audit := AuditInfo{
+				OperatorID: u.ID,
+				Reason:     body.Reason,
+				Timestamp:  time.Now(),
+			}
+			if err := h.svc.ReplaceShiftRules(r.Context(), r.PathValue("id"), body.Rules, audit); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+		})(w, r)
+	}))
+
+	mux.HandleFunc("POST /carriers/{id}/schedule/exceptions", metricsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		auth.RequireRoles(h.validator, []string{"moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+			var body schedule.Exception
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid json")
+				return
+			}
+			if strings.TrimSpace(body.Reason) == "" {
+				writeJSONError(w, http.StatusBadRequest, "reason is required")
+				return
+			}
+			u := auth.FromContext(r)
+			audit := AuditInfo{
+				OperatorID: u.ID,
+				Reason:     body.Reason,
+				Timestamp:  time.Now(),
+			}
+			id, err := h.svc.AddScheduleException(r.Context(), r.PathValue("id"), body, audit)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+		})(w, r)
+	}))
+
+	mux.HandleFunc("DELETE /carriers/{id}/schedule/exceptions/{exception_id}", metricsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		auth.RequireRoles(h.validator, []string{"moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+			reason := r.URL.Query().Get("reason")
+			if strings.TrimSpace(reason) == "" {
+				writeJSONError(w, http.StatusBadRequest, "reason is required")
+				return
+			}
+			u := auth.FromContext(r)
+			audit := AuditInfo{
+				OperatorID: u.ID,
+				Reason:     reason,
+				Timestamp:  time.Now(),
+			}
+			if err := h.svc.DeleteScheduleException(r.Context(), r.PathValue("id"), r.PathValue("exception_id"), audit); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+		})(w, r)
+	}))
+
+	// Snapshot for consumers that bootstrap their projections on startup.
+	// The ETag is the snapshot seq, so an unchanged snapshot costs a 304.
+	mux.HandleFunc("GET /snapshot", metricsMiddleware(auth.RequireRoles(h.validator, []string{"service", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		snap, err := h.svc.Snapshot(r.Context())
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		etag := `"` + strconv.FormatInt(snap.Seq, 10) + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, snap)
+	})))
+
+	// History of reference changes, filtered by entity and operator
+	mux.HandleFunc("GET /audit", metricsMiddleware(auth.RequireRoles(h.validator, []string{"moderator", "admin"}, func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseAuditFilter(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		entries, err := h.svc.ListAudit(r.Context(), f)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})))
+
+	h.geoRoutes(mux)
+	h.catalogRoutes(mux)
+}
+
+func parseAuditFilter(q url.Values) (AuditFilter, error) {
+	f := AuditFilter{
+		EntityTypes: q["entity_type"],
+		EntityID:    q.Get("entity_id"),
+		OperatorID:  q.Get("operator_id"),
+	}
+	for _, t := range f.EntityTypes {
+		switch t {
+		case AuditPickupPoint, AuditWarehouse, AuditCarrier, AuditCarrierSchedule:
+		default:
+			return f, errors.New("invalid entity_type")
+		}
+	}
+	if f.EntityID != "" && len(f.EntityTypes) == 0 {
+		return f, errors.New("entity_type is required with entity_id")
+	}
+	for name, dst := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, errors.New("invalid " + name)
+			}
+			*dst = &t
+		}
+	}
+	if v := q.Get("before_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return f, errors.New("invalid before_id")
+		}
+		f.BeforeID = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxAuditPageSize {
+			return f, fmt.Errorf("limit must be between 1 and %d", MaxAuditPageSize)
+		}
+		f.Limit = n
+	}
+	return f, nil
 }
 
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
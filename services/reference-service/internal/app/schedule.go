@@ -0,0 +1,332 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"bel-parcel/pkg/schedule"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// OnShiftCarrier is a row of the "who is on shift" view. Scheduled is false
+// for carriers without weekly rules, which are treated as always available.
+type OnShiftCarrier struct {
+	CarrierID string `json:"carrier_id"`
+	Name      string `json:"name"`
+	Scheduled bool   `json:"scheduled"`
+}
+
+func (s *Service) GetCarrierSchedule(ctx context.Context, carrierID string) (schedule.Schedule, error) {
+	var exists bool
+	if err := s.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM carriers WHERE id=$1)`, carrierID).Scan(&exists); err != nil {
+		return schedule.Schedule{}, err
+	}
+	if !exists {
+		return schedule.Schedule{}, errors.New("carrier not found")
+	}
+	return loadSchedule(ctx, s.db, carrierID, time.Now().UTC())
+}
+
+// ReplaceShiftRules swaps the carrier's weekly rules and publishes the new
+// calendar so that routing can update its projection.
+func (s *Service) ReplaceShiftRules(ctx context.Context, carrierID string, rules []schedule.Rule, audit AuditInfo) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := lockCarrier(ctx, tx, carrierID); err != nil {
+		return err
+	}
+	before, err := loadSchedule(ctx, tx, carrierID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM carrier_shift_rules WHERE carrier_id=$1`, carrierID); err != nil {
+		return err
+	}
+	for _, r := range rules {
+		start, _ := schedule.ParseClock(r.Start)
+		end, _ := schedule.ParseClock(r.End)
+		tz := r.Timezone
+		if tz == "" {
+			tz = schedule.DefaultTimezone
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO carrier_shift_rules (carrier_id, weekday, start_minute, end_minute, timezone)
+			VALUES ($1, $2, $3, $4, $5)
+		`, carrierID, int(r.Weekday), start, end, tz); err != nil {
+			return err
+		}
+	}
+	if err := s.auditScheduleTx(ctx, tx, carrierID, before, audit); err != nil {
+		return err
+	}
+	if err := s.publishScheduleTx(ctx, tx, carrierID, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("carrier shift rules replaced", "carrier_id", carrierID, "rules", len(rules), "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return nil
+}
+
+// AddScheduleException records time off or an extra shift and returns its id.
+func (s *Service) AddScheduleException(ctx context.Context, carrierID string, e schedule.Exception, audit AuditInfo) (string, error) {
+	if err := e.Validate(); err != nil {
+		return "", err
+	}
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+	if err := lockCarrier(ctx, tx, carrierID); err != nil {
+		return "", err
+	}
+	before, err := loadSchedule(ctx, tx, carrierID, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+	id := uuid.NewString()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO carrier_schedule_exceptions (id, carrier_id, kind, starts_at, ends_at, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, id, carrierID, e.Kind, e.StartsAt.UTC(), e.EndsAt.UTC(), e.Reason, audit.OperatorID); err != nil {
+		return "", err
+	}
+	if err := s.auditScheduleTx(ctx, tx, carrierID, before, audit); err != nil {
+		return "", err
+	}
+	if err := s.publishScheduleTx(ctx, tx, carrierID, audit); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+	slog.Info("carrier schedule exception added", "carrier_id", carrierID, "exception_id", id, "kind", e.Kind, "operator_id", audit.OperatorID)
+	return id, nil
+}
+
+func (s *Service) DeleteScheduleException(ctx context.Context, carrierID, exceptionID string, audit AuditInfo) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := lockCarrier(ctx, tx, carrierID); err != nil {
+		return err
+	}
+	before, err := loadSchedule(ctx, tx, carrierID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	ct, err := tx.Exec(ctx, `DELETE FROM carrier_schedule_exceptions WHERE id=$1 AND carrier_id=$2`, exceptionID, carrierID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return errors.New("schedule exception not found")
+	}
+	if err := s.auditScheduleTx(ctx, tx, carrierID, before, audit); err != nil {
+		return err
+	}
+	if err := s.publishScheduleTx(ctx, tx, carrierID, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("carrier schedule exception deleted", "carrier_id", carrierID, "exception_id", exceptionID, "operator_id", audit.OperatorID)
+	return nil
+}
+
+// CarriersOnShift lists active carriers available at the given moment.
+func (s *Service) CarriersOnShift(ctx context.Context, at time.Time) ([]OnShiftCarrier, error) {
+	rows, err := s.db.Query(ctx, `SELECT id, name FROM carriers WHERE is_active = true ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	type carrier struct{ id, name string }
+	var carriers []carrier
+	for rows.Next() {
+		var c carrier
+		if err := rows.Scan(&c.id, &c.name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		carriers = append(carriers, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	schedules, err := loadSchedules(ctx, s.db, at)
+	if err != nil {
+		return nil, err
+	}
+	res := []OnShiftCarrier{}
+	for _, c := range carriers {
+		sc := schedules[c.id]
+		if sc.OnShift(at) {
+			res = append(res, OnShiftCarrier{CarrierID: c.id, Name: c.name, Scheduled: len(sc.Rules) > 0})
+		}
+	}
+	return res, nil
+}
+
+func lockCarrier(ctx context.Context, tx pgx.Tx, carrierID string) error {
+	var id string
+	if err := tx.QueryRow(ctx, `SELECT id FROM carriers WHERE id=$1 FOR UPDATE`, carrierID).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.New("carrier not found")
+		}
+		return err
+	}
+	return nil
+}
+
+// auditScheduleTx records the calendar before and after a change. Only
+// exceptions that have not ended yet are part of either side.
+func (s *Service) auditScheduleTx(ctx context.Context, tx pgx.Tx, carrierID string, before schedule.Schedule, audit AuditInfo) error {
+	after, err := loadSchedule(ctx, tx, carrierID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return recordAuditTx(ctx, tx, AuditCarrierSchedule, carrierID, AuditUpdated, before, after, audit)
+}
+
+// publishScheduleTx emits the whole calendar rather than a diff, so consumers
+// can replace their copy and never depend on event history.
+func (s *Service) publishScheduleTx(ctx context.Context, tx pgx.Tx, carrierID string, audit AuditInfo) error {
+	now := time.Now().UTC()
+	sc, err := loadSchedule(ctx, tx, carrierID, now)
+	if err != nil {
+		return err
+	}
+	var seq int64
+	if err := tx.QueryRow(ctx, `
+		UPDATE carriers SET schedule_seq=nextval('reference_change_seq') WHERE id=$1 RETURNING schedule_seq
+	`, carrierID).Scan(&seq); err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"event_id":       uuid.New().String(),
+		"event_type":     "events.reference_updated",
+		"occurred_at":    now,
+		"correlation_id": carrierID,
+		"data": map[string]interface{}{
+			"update_type":      "carrier_schedule",
+			"carrier_schedule": sc,
+			"seq":              seq,
+			"operator_id":      audit.OperatorID,
+			"reason":           audit.Reason,
+			"updated_at":       now,
+		},
+	}
+	return s.enqueueEvent(ctx, tx, "events.reference_updated", carrierID, payload)
+}
+
+func loadSchedule(ctx context.Context, q querier, carrierID string, now time.Time) (schedule.Schedule, error) {
+	sc := schedule.Schedule{CarrierID: carrierID, Rules: []schedule.Rule{}, Exceptions: []schedule.Exception{}}
+	rows, err := q.Query(ctx, `
+		SELECT weekday, start_minute, end_minute, timezone
+		FROM carrier_shift_rules WHERE carrier_id=$1
+		ORDER BY weekday, start_minute
+	`, carrierID)
+	if err != nil {
+		return sc, err
+	}
+	for rows.Next() {
+		var wd, start, end int
+		var tz string
+		if err := rows.Scan(&wd, &start, &end, &tz); err != nil {
+			rows.Close()
+			return sc, err
+		}
+		sc.Rules = append(sc.Rules, schedule.Rule{Weekday: time.Weekday(wd), Start: schedule.FormatClock(start), End: schedule.FormatClock(end), Timezone: tz})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return sc, err
+	}
+	rows, err = q.Query(ctx, `
+		SELECT id, kind, starts_at, ends_at, COALESCE(reason, '')
+		FROM carrier_schedule_exceptions
+		WHERE carrier_id=$1 AND ends_at > $2
+		ORDER BY starts_at
+	`, carrierID, now)
+	if err != nil {
+		return sc, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e schedule.Exception
+		if err := rows.Scan(&e.ID, &e.Kind, &e.StartsAt, &e.EndsAt, &e.Reason); err != nil {
+			return sc, err
+		}
+		sc.Exceptions = append(sc.Exceptions, e)
+	}
+	return sc, rows.Err()
+}
+
+// loadSchedules returns the rules of every carrier and the exceptions that
+// cover the given moment, keyed by carrier id.
+func loadSchedules(ctx context.Context, q querier, at time.Time) (map[string]schedule.Schedule, error) {
+	res := make(map[string]schedule.Schedule)
+	rows, err := q.Query(ctx, `SELECT carrier_id, weekday, start_minute, end_minute, timezone FROM carrier_shift_rules`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id, tz string
+		var wd, start, end int
+		if err := rows.Scan(&id, &wd, &start, &end, &tz); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sc := res[id]
+		sc.CarrierID = id
+		sc.Rules = append(sc.Rules, schedule.Rule{Weekday: time.Weekday(wd), Start: schedule.FormatClock(start), End: schedule.FormatClock(end), Timezone: tz})
+		res[id] = sc
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = q.Query(ctx, `
+		SELECT id, carrier_id, kind, starts_at, ends_at
+		FROM carrier_schedule_exceptions
+		WHERE starts_at <= $1 AND ends_at > $1
+	`, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e schedule.Exception
+		var id string
+		if err := rows.Scan(&e.ID, &id, &e.Kind, &e.StartsAt, &e.EndsAt); err != nil {
+			return nil, err
+		}
+		sc := res[id]
+		sc.CarrierID = id
+		sc.Exceptions = append(sc.Exceptions, e)
+		res[id] = sc
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bel-parcel/services/reference-service/internal/auth"
+)
+
+func scheduleMux(t *testing.T) (*http.ServeMux, func(role string) string) {
+	secret := "s"
+	v := auth.NewValidator(secret, "iss", "aud")
+	mux := http.NewServeMux()
+	NewHandlers(&Service{}, v).Routes(mux)
+	return mux, func(role string) string {
+		return "Bearer " + makeToken(t, secret, "iss", "aud", "op-1", role, time.Now().Add(time.Minute))
+	}
+}
+
+func TestPutScheduleReasonRequired(t *testing.T) {
+	mux, token := scheduleMux(t)
+	req := httptest.NewRequest(http.MethodPut, "/carriers/car-1/schedule", strings.NewReader(`{"rules":[]}`))
+	req.Header.Set("Authorization", token("admin"))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "reason is required") {
+		t.Fatalf("expected reason error, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestPutScheduleInvalidRule(t *testing.T) {
+	mux, token := scheduleMux(t)
+	body := `{"rules":[{"weekday":1,"start":"25:00","end":"20:00"}],"reason":"new roster"}`
+	req := httptest.NewRequest(http.MethodPut, "/carriers/car-1/schedule", strings.NewReader(body))
+	req.Header.Set("Authorization", token("admin"))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid time") {
+		t.Fatalf("expected validation error, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestPutScheduleAdminOnly(t *testing.T) {
+	mux, token := scheduleMux(t)
+	req := httptest.NewRequest(http.MethodPut, "/carriers/car-1/schedule", strings.NewReader(`{"rules":[],"reason":"x"}`))
+	req.Header.Set("Authorization", token("moderator"))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rr.Code)
+	}
+}
+
+func TestPostScheduleExceptionInvalidRange(t *testing.T) {
+	mux, token := scheduleMux(t)
+	body := `{"kind":"off","starts_at":"2024-01-02T10:00:00Z","ends_at":"2024-01-02T09:00:00Z","reason":"sick"}`
+	req := httptest.NewRequest(http.MethodPost, "/carriers/car-1/schedule/exceptions", strings.NewReader(body))
+	req.Header.Set("Authorization", token("moderator"))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "ends_at") {
+		t.Fatalf("expected range error, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeleteScheduleExceptionReasonRequired(t *testing.T) {
+	mux, token := scheduleMux(t)
+	req := httptest.NewRequest(http.MethodDelete, "/carriers/car-1/schedule/exceptions/ex-1", nil)
+	req.Header.Set("Authorization", token("admin"))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestOnShiftInvalidAt(t *testing.T) {
+	mux, token := scheduleMux(t)
+	req := httptest.NewRequest(http.MethodGet, "/carriers/on-shift?at=tomorrow", nil)
+	req.Header.Set("Authorization", token("user"))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
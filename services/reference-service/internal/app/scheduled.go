@@ -0,0 +1,674 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"bel-parcel/services/reference-service/internal/metrics"
+)
+
+// Statuses of a scheduled change. A pending change is applied at
+// EffectiveFrom and, if it has an EffectiveTo, reverted then. A change whose
+// whole window passed before the scheduler got to it expires unapplied.
+const (
+	ChangePending   = "pending"
+	ChangeApplied   = "applied"
+	ChangeReverted  = "reverted"
+	ChangeExpired   = "expired"
+	ChangeCancelled = "cancelled"
+	ChangeFailed    = "failed"
+)
+
+// maxScheduledChanges bounds the list of changes of one record.
+const maxScheduledChanges = 200
+
+// A change that fails for a reason other than the record rejecting it is
+// retried after changeRetryBase, doubling per attempt up to changeRetryMax.
+const (
+	changeRetryBase = 30 * time.Second
+	changeRetryMax  = time.Hour
+)
+
+func changeRetryDelay(attempts int) time.Duration {
+	d := changeRetryBase
+	for i := 1; i < attempts && d < changeRetryMax; i++ {
+		d *= 2
+	}
+	return min(d, changeRetryMax)
+}
+
+// ScheduledChange is a change of a pickup point, warehouse or carrier that
+// takes effect later. Changes holds the same fields as an update (without
+// version); Revert holds their values from before the change and is set when
+// the change is applied and has an end.
+type ScheduledChange struct {
+	ID            string          `json:"id"`
+	EntityType    string          `json:"entity_type"`
+	EntityID      string          `json:"entity_id"`
+	Changes       json.RawMessage `json:"changes"`
+	EffectiveFrom time.Time       `json:"effective_from"`
+	EffectiveTo   *time.Time      `json:"effective_to,omitempty"`
+	Revert        json.RawMessage `json:"revert,omitempty"`
+	Status        string          `json:"status"`
+	Error         string          `json:"error,omitempty"`
+	Attempts      int             `json:"attempts,omitempty"`
+	RetryAt       *time.Time      `json:"retry_at,omitempty"`
+	OperatorID    string          `json:"operator_id"`
+	Reason        string          `json:"reason"`
+	CreatedAt     time.Time       `json:"created_at"`
+	AppliedAt     *time.Time      `json:"applied_at,omitempty"`
+	RevertedAt    *time.Time      `json:"reverted_at,omitempty"`
+}
+
+// ChangeRequest is the body of a scheduled change.
+type ChangeRequest struct {
+	Changes       json.RawMessage `json:"changes"`
+	EffectiveFrom time.Time       `json:"effective_from"`
+	EffectiveTo   *time.Time      `json:"effective_to"`
+}
+
+const scheduledChangeColumns = `id, entity_type, entity_id, changes, effective_from, effective_to, revert, status,
+	COALESCE(error, ''), attempts, retry_at, operator_id, reason, created_at, applied_at, reverted_at`
+
+func scanScheduledChange(row pgx.Row) (*ScheduledChange, error) {
+	var c ScheduledChange
+	var id uuid.UUID
+	var changes, revert []byte
+	err := row.Scan(&id, &c.EntityType, &c.EntityID, &changes, &c.EffectiveFrom, &c.EffectiveTo, &revert, &c.Status,
+		&c.Error, &c.Attempts, &c.RetryAt, &c.OperatorID, &c.Reason, &c.CreatedAt, &c.AppliedAt, &c.RevertedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.ID = id.String()
+	c.Changes, c.Revert = changes, revert
+	return &c, nil
+}
+
+// ScheduleChange plans a change of a record. Changes of the same fields may
+// not overlap when either of them has an end, since the revert of one would
+// undo the other.
+func (s *Service) ScheduleChange(ctx context.Context, entityType, id string, req ChangeRequest, audit AuditInfo) (*ScheduledChange, error) {
+	if req.EffectiveFrom.IsZero() {
+		return nil, &ValidationError{Msg: "effective_from is required"}
+	}
+	if req.EffectiveTo != nil {
+		if !req.EffectiveTo.After(req.EffectiveFrom) {
+			return nil, &ValidationError{Msg: "effective_to must be after effective_from"}
+		}
+		if !req.EffectiveTo.After(time.Now()) {
+			return nil, &ValidationError{Msg: "effective_to must be in the future"}
+		}
+	}
+	fields, err := changedFields(req.Changes)
+	if err != nil {
+		return nil, err
+	}
+	var created *ScheduledChange
+	err = s.inTx(ctx, func(tx pgx.Tx) error {
+		// checkChangesTx locks the record, which also serializes the overlap check
+		if err := s.checkChangesTx(ctx, tx, entityType, id, req.Changes); err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, `
+			SELECT changes FROM reference_scheduled_changes
+			WHERE entity_type=$1 AND entity_id=$2 AND status IN ('pending', 'applied')
+			  AND tstzrange(effective_from, effective_to) && tstzrange($3, $4)
+			  AND (effective_to IS NOT NULL OR $4::timestamptz IS NOT NULL)
+		`, entityType, id, req.EffectiveFrom, req.EffectiveTo)
+		if err != nil {
+			return err
+		}
+		var overlapping [][]byte
+		for rows.Next() {
+			var changes []byte
+			if err := rows.Scan(&changes); err != nil {
+				rows.Close()
+				return err
+			}
+			overlapping = append(overlapping, changes)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, other := range overlapping {
+			otherFields, err := changedFields(other)
+			if err != nil {
+				return err
+			}
+			for f := range fields {
+				if otherFields[f] {
+					return ErrChangeConflict
+				}
+			}
+		}
+		created, err = scanScheduledChange(tx.QueryRow(ctx, `
+			INSERT INTO reference_scheduled_changes (id, entity_type, entity_id, changes, effective_from, effective_to, operator_id, reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING `+scheduledChangeColumns,
+			uuid.New(), entityType, id, []byte(req.Changes), req.EffectiveFrom, req.EffectiveTo, audit.OperatorID, audit.Reason))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("reference change scheduled", "change_id", created.ID, "entity_type", entityType, "entity_id", id,
+		"effective_from", created.EffectiveFrom, "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return created, nil
+}
+
+// ListScheduledChanges returns the changes of a record, latest effective_from
+// first, including finished and cancelled ones.
+func (s *Service) ListScheduledChanges(ctx context.Context, entityType, id string) ([]ScheduledChange, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+scheduledChangeColumns+` FROM reference_scheduled_changes
+		WHERE entity_type=$1 AND entity_id=$2
+		ORDER BY effective_from DESC, created_at DESC
+		LIMIT $3
+	`, entityType, id, maxScheduledChanges)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []ScheduledChange{}
+	for rows.Next() {
+		c, err := scanScheduledChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *c)
+	}
+	return changes, rows.Err()
+}
+
+// CancelScheduledChange drops a change that has not taken effect yet.
+func (s *Service) CancelScheduledChange(ctx context.Context, entityType, id, changeID string, audit AuditInfo) error {
+	cid, err := uuid.Parse(changeID)
+	if err != nil {
+		return ErrNotFound
+	}
+	err = s.inTx(ctx, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx, `
+			SELECT status FROM reference_scheduled_changes
+			WHERE id=$1 AND entity_type=$2 AND entity_id=$3
+			FOR UPDATE
+		`, cid, entityType, id).Scan(&status)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != ChangePending {
+			return ErrChangeNotPending
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE reference_scheduled_changes SET status='cancelled', cancelled_by=$2, cancel_reason=$3
+			WHERE id=$1
+		`, cid, audit.OperatorID, audit.Reason)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("scheduled reference change cancelled", "change_id", changeID, "entity_type", entityType, "entity_id", id,
+		"operator_id", audit.OperatorID, "reason", audit.Reason)
+	return nil
+}
+
+// RunScheduler applies and reverts scheduled changes as they come due until
+// ctx is done. Each change is handled in its own transaction with SKIP LOCKED,
+// so several replicas can run it side by side.
+func (s *Service) RunScheduler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for {
+				found, err := s.runNextChange(ctx, time.Now())
+				if err != nil {
+					slog.Error("scheduled reference change failed", "error", err)
+					break
+				}
+				if !found {
+					break
+				}
+			}
+		}
+	}
+}
+
+// runNextChange handles the earliest due change, if any. A change the
+// record no longer accepts is marked failed. On other errors the change is
+// put off with a growing delay, so the changes due after it still run; an
+// error is returned only when that cannot be recorded either.
+func (s *Service) runNextChange(ctx context.Context, now time.Time) (bool, error) {
+	var c *ScheduledChange
+	var outcome string
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		c, err = scanScheduledChange(tx.QueryRow(ctx, `
+			SELECT `+scheduledChangeColumns+` FROM reference_scheduled_changes
+			WHERE ((status='pending' AND effective_from <= $1) OR (status='applied' AND effective_to <= $1))
+			  AND (retry_at IS NULL OR retry_at <= $1)
+			ORDER BY CASE WHEN status='pending' THEN effective_from ELSE effective_to END, created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		`, now))
+		if err != nil {
+			return err
+		}
+		outcome, err = s.runChangeTx(ctx, tx, c, now)
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil && c == nil {
+		return false, err
+	}
+	if err != nil {
+		return true, s.postponeChange(ctx, c, now, err)
+	}
+	metrics.ScheduledChangesTotal.WithLabelValues(c.EntityType, outcome).Inc()
+	slog.Info("scheduled reference change processed", "change_id", c.ID, "entity_type", c.EntityType, "entity_id", c.EntityID, "outcome", outcome)
+	return true, nil
+}
+
+// postponeChange records a failed attempt at the change's current step and
+// when to try it again.
+func (s *Service) postponeChange(ctx context.Context, c *ScheduledChange, now time.Time, cause error) error {
+	attempts := c.Attempts + 1
+	retryAt := now.Add(changeRetryDelay(attempts))
+	// Matching the attempts read keeps a replica that failed the same step
+	// at the same time from counting it twice
+	if _, err := s.db.Exec(ctx, `
+		UPDATE reference_scheduled_changes SET attempts=$4, retry_at=$5, error=$6
+		WHERE id=$1 AND status=$2 AND attempts=$3
+	`, c.ID, c.Status, c.Attempts, attempts, retryAt, cause.Error()); err != nil {
+		return errors.Join(cause, err)
+	}
+	metrics.ScheduledChangesTotal.WithLabelValues(c.EntityType, "retry").Inc()
+	slog.Error("scheduled reference change failed, retrying later", "change_id", c.ID, "entity_type", c.EntityType, "entity_id", c.EntityID,
+		"attempt", attempts, "retry_at", retryAt, "error", cause)
+	return nil
+}
+
+func (s *Service) runChangeTx(ctx context.Context, tx pgx.Tx, c *ScheduledChange, now time.Time) (string, error) {
+	audit := AuditInfo{OperatorID: c.OperatorID, Reason: c.Reason, Timestamp: now}
+	if c.Status == ChangePending && c.EffectiveTo != nil && !c.EffectiveTo.After(now) {
+		_, err := tx.Exec(ctx, `UPDATE reference_scheduled_changes SET status='expired' WHERE id=$1`, c.ID)
+		return ChangeExpired, err
+	}
+
+	changes := c.Changes
+	if c.Status == ChangeApplied {
+		changes = c.Revert
+	}
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	before, err := s.applyChangesTx(ctx, sp, c.EntityType, c.EntityID, changes, audit)
+	if err != nil {
+		_ = sp.Rollback(ctx)
+		var verr *ValidationError
+		if !errors.As(err, &verr) && !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+		_, err = tx.Exec(ctx, `UPDATE reference_scheduled_changes SET status='failed', error=$2 WHERE id=$1`, c.ID, err.Error())
+		return ChangeFailed, err
+	}
+	if err := sp.Commit(ctx); err != nil {
+		return "", err
+	}
+
+	if c.Status == ChangeApplied {
+		_, err = tx.Exec(ctx, `UPDATE reference_scheduled_changes SET status='reverted', reverted_at=$2, error=NULL, attempts=0, retry_at=NULL WHERE id=$1`, c.ID, now)
+		return ChangeReverted, err
+	}
+	var revert []byte
+	if c.EffectiveTo != nil {
+		if revert, err = revertOf(c.Changes, before); err != nil {
+			return "", err
+		}
+	}
+	_, err = tx.Exec(ctx, `UPDATE reference_scheduled_changes SET status='applied', applied_at=$2, revert=$3, error=NULL, attempts=0, retry_at=NULL WHERE id=$1`, c.ID, now, revert)
+	return ChangeApplied, err
+}
+
+// changedFields returns the fields a change sets. Changes may set any field
+// of an update except version: they are applied without the version check.
+func changedFields(changes json.RawMessage) (map[string]bool, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(changes, &values); err != nil || len(values) == 0 {
+		return nil, &ValidationError{Msg: "changes must be a non-empty object"}
+	}
+	fields := make(map[string]bool, len(values))
+	for k, v := range values {
+		if k == "version" {
+			return nil, &ValidationError{Msg: "changes must not include version"}
+		}
+		if string(v) == "null" {
+			return nil, &ValidationError{Msg: "changes." + k + " must not be null"}
+		}
+		fields[k] = true
+	}
+	return fields, nil
+}
+
+// decodeChanges reads changes into an update patch, rejecting fields the
+// patch does not have.
+func decodeChanges(changes json.RawMessage, patch any) error {
+	if _, err := changedFields(changes); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(changes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(patch); err != nil {
+		return &ValidationError{Msg: "invalid changes: " + err.Error()}
+	}
+	return nil
+}
+
+// checkChangesTx locks the record and checks that changes would be a valid
+// update of it as it is now.
+func (s *Service) checkChangesTx(ctx context.Context, tx pgx.Tx, entityType, id string, changes json.RawMessage) error {
+	switch entityType {
+	case AuditPickupPoint:
+		var patch PickupPointPatch
+		if err := decodeChanges(changes, &patch); err != nil {
+			return err
+		}
+		p, err := scanPickupPoint(tx.QueryRow(ctx, `SELECT `+pickupPointColumns+` FROM pickup_points WHERE id=$1 FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+		patch.apply(p)
+		return p.validate()
+	case AuditWarehouse:
+		var patch WarehousePatch
+		if err := decodeChanges(changes, &patch); err != nil {
+			return err
+		}
+		w, err := scanWarehouse(tx.QueryRow(ctx, `SELECT `+warehouseColumns+` FROM warehouses WHERE id=$1 FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+		patch.apply(w)
+		return w.validate()
+	case AuditCarrier:
+		var patch CarrierPatch
+		if err := decodeChanges(changes, &patch); err != nil {
+			return err
+		}
+		c, err := scanCarrier(tx.QueryRow(ctx, `SELECT `+carrierColumns+` FROM carriers WHERE id=$1 FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+		patch.apply(c)
+		return c.validate()
+	}
+	return ErrNotFound
+}
+
+// applyChangesTx updates the record through the regular update path, so the
+// change is audited and published like an operator's, and returns the
+// record as it was before.
+func (s *Service) applyChangesTx(ctx context.Context, tx pgx.Tx, entityType, id string, changes json.RawMessage, audit AuditInfo) (any, error) {
+	switch entityType {
+	case AuditPickupPoint:
+		var patch PickupPointPatch
+		if err := decodeChanges(changes, &patch); err != nil {
+			return nil, err
+		}
+		before, err := scanPickupPoint(tx.QueryRow(ctx, `SELECT `+pickupPointColumns+` FROM pickup_points WHERE id=$1 FOR UPDATE`, id))
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.updatePickupPointTx(ctx, tx, id, patch, audit)
+		return before, err
+	case AuditWarehouse:
+		var patch WarehousePatch
+		if err := decodeChanges(changes, &patch); err != nil {
+			return nil, err
+		}
+		before, err := scanWarehouse(tx.QueryRow(ctx, `SELECT `+warehouseColumns+` FROM warehouses WHERE id=$1 FOR UPDATE`, id))
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.updateWarehouseTx(ctx, tx, id, patch, audit)
+		return before, err
+	case AuditCarrier:
+		var patch CarrierPatch
+		if err := decodeChanges(changes, &patch); err != nil {
+			return nil, err
+		}
+		before, err := scanCarrier(tx.QueryRow(ctx, `SELECT `+carrierColumns+` FROM carriers WHERE id=$1 FOR UPDATE`, id))
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.updateCarrierTx(ctx, tx, id, patch, audit)
+		return before, err
+	}
+	return nil, ErrNotFound
+}
+
+// revertOf picks from the record the values of the fields changes sets.
+func revertOf(changes json.RawMessage, record any) (json.RawMessage, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, err
+	}
+	return pickFields(changes, state)
+}
+
+func pickFields(changes json.RawMessage, state map[string]json.RawMessage) (json.RawMessage, error) {
+	fields, err := changedFields(changes)
+	if err != nil {
+		return nil, err
+	}
+	revert := make(map[string]json.RawMessage, len(fields))
+	for f := range fields {
+		if v, ok := state[f]; ok {
+			revert[f] = v
+		}
+	}
+	return json.Marshal(revert)
+}
+
+// GetPickupPointAt, GetWarehouseAt and GetCarrierAt return a record as it
+// was or is scheduled to be at the given time.
+func (s *Service) GetPickupPointAt(ctx context.Context, id string, at time.Time) (*PickupPoint, error) {
+	return recordAt(ctx, s, AuditPickupPoint, id, at, s.GetPickupPoint)
+}
+
+func (s *Service) GetWarehouseAt(ctx context.Context, id string, at time.Time) (*Warehouse, error) {
+	return recordAt(ctx, s, AuditWarehouse, id, at, s.GetWarehouse)
+}
+
+func (s *Service) GetCarrierAt(ctx context.Context, id string, at time.Time) (*Carrier, error) {
+	return recordAt(ctx, s, AuditCarrier, id, at, s.GetCarrier)
+}
+
+// recordAt reads the past from the audit log and the future from the
+// current record with the scheduled changes due by then laid over it.
+// Version, seq and updated_at of a future state are those of the current
+// record.
+func recordAt[R any](ctx context.Context, s *Service, entityType, id string, at time.Time, get func(context.Context, string) (*R, error)) (*R, error) {
+	now := time.Now()
+	var raw json.RawMessage
+	if at.After(now) {
+		cur, err := get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = json.Marshal(cur); err != nil {
+			return nil, err
+		}
+		changes, err := s.dueChanges(ctx, entityType, id, at)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = projectChanges(raw, changes, at, now); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		raw, err = s.auditStateAt(ctx, entityType, id, at)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			return get(ctx, id)
+		}
+	}
+	var rec R
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// auditStateAt returns the record after its last change at or before at. With
+// no such change, the first later change tells what the record was before it;
+// nil means the record has not changed since the log began.
+func (s *Service) auditStateAt(ctx context.Context, entityType, id string, at time.Time) (json.RawMessage, error) {
+	var after []byte
+	err := s.db.QueryRow(ctx, `
+		SELECT after_value FROM reference_audit_log
+		WHERE entity_type=$1 AND entity_id=$2 AND changed_at <= $3
+		ORDER BY changed_at DESC, id DESC
+		LIMIT 1
+	`, entityType, id, at).Scan(&after)
+	if err == nil {
+		return after, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	var before []byte
+	err = s.db.QueryRow(ctx, `
+		SELECT before_value FROM reference_audit_log
+		WHERE entity_type=$1 AND entity_id=$2 AND changed_at > $3
+		ORDER BY changed_at, id
+		LIMIT 1
+	`, entityType, id, at).Scan(&before)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		// created after at
+		return nil, ErrNotFound
+	}
+	return before, nil
+}
+
+// dueChanges returns the pending and applied changes of a record that take
+// effect or end by at.
+func (s *Service) dueChanges(ctx context.Context, entityType, id string, at time.Time) ([]ScheduledChange, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+scheduledChangeColumns+` FROM reference_scheduled_changes
+		WHERE entity_type=$1 AND entity_id=$2
+		  AND ((status='pending' AND effective_from <= $3) OR (status='applied' AND effective_to <= $3))
+	`, entityType, id, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []ScheduledChange
+	for rows.Next() {
+		c, err := scanScheduledChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *c)
+	}
+	return changes, rows.Err()
+}
+
+// projectChanges plays the changes due by at over the record in the order
+// the scheduler would. Pending changes whose window ended by now will
+// expire and are skipped.
+func projectChanges(record json.RawMessage, changes []ScheduledChange, at, now time.Time) (json.RawMessage, error) {
+	type step struct {
+		at     time.Time
+		change int
+		revert bool
+	}
+	var steps []step
+	for i, c := range changes {
+		switch c.Status {
+		case ChangePending:
+			if c.EffectiveTo != nil && !c.EffectiveTo.After(now) {
+				continue
+			}
+			if !c.EffectiveFrom.After(at) {
+				steps = append(steps, step{at: c.EffectiveFrom, change: i})
+			}
+			if c.EffectiveTo != nil && !c.EffectiveTo.After(at) {
+				steps = append(steps, step{at: *c.EffectiveTo, change: i, revert: true})
+			}
+		case ChangeApplied:
+			if c.EffectiveTo != nil && !c.EffectiveTo.After(at) {
+				steps = append(steps, step{at: *c.EffectiveTo, change: i, revert: true})
+			}
+		}
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].at.Before(steps[j].at) })
+
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(record, &state); err != nil {
+		return nil, err
+	}
+	reverts := make(map[int]json.RawMessage)
+	for _, st := range steps {
+		c := changes[st.change]
+		patch := c.Changes
+		if st.revert {
+			patch = c.Revert
+			if r, ok := reverts[st.change]; ok {
+				patch = r
+			}
+		} else if c.EffectiveTo != nil {
+			r, err := pickFields(c.Changes, state)
+			if err != nil {
+				return nil, err
+			}
+			reverts[st.change] = r
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(patch, &values); err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			state[k] = v
+		}
+	}
+	return json.Marshal(state)
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedFields(t *testing.T) {
+	fields, err := changedFields(json.RawMessage(`{"is_hub": true, "name": "Хаб"}`))
+	if err != nil {
+		t.Fatalf("changed fields: %v", err)
+	}
+	if len(fields) != 2 || !fields["is_hub"] || !fields["name"] {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+
+	for name, changes := range map[string]string{
+		"empty":   `{}`,
+		"array":   `[1]`,
+		"version": `{"version": 3, "is_hub": true}`,
+		"null":    `{"is_hub": null}`,
+	} {
+		if _, err := changedFields(json.RawMessage(changes)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	var patch CarrierPatch
+	if err := decodeChanges(json.RawMessage(`{"is_hub": true}`), &patch); err == nil {
+		t.Fatalf("expected unknown field to be rejected")
+	}
+	if err := decodeChanges(json.RawMessage(`{"is_active": false}`), &patch); err != nil || patch.IsActive == nil || *patch.IsActive {
+		t.Fatalf("unexpected patch %+v, err %v", patch, err)
+	}
+}
+
+func TestRevertOf(t *testing.T) {
+	p := PickupPoint{ID: "pvp-1", Name: "ПВЗ", IsHub: false, Capacity: 40}
+	revert, err := revertOf(json.RawMessage(`{"is_hub": true, "capacity": 200}`), &p)
+	if err != nil {
+		t.Fatalf("revert: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(revert, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(got) != 2 || got["is_hub"] != false || got["capacity"] != float64(40) {
+		t.Fatalf("unexpected revert %s", revert)
+	}
+}
+
+func TestChangeRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		8:  time.Hour,
+		50: time.Hour,
+	} {
+		if got := changeRetryDelay(attempts); got != want {
+			t.Fatalf("attempt %d: expected %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+func TestProjectChanges(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return now.AddDate(0, 0, d) }
+	ptr := func(t time.Time) *time.Time { return &t }
+	carrier := json.RawMessage(`{"id":"car-1","name":"ИП Петров","is_active":true,"version":4}`)
+
+	vacation := ScheduledChange{
+		Status:        ChangePending,
+		Changes:       json.RawMessage(`{"is_active": false}`),
+		EffectiveFrom: day(3),
+		EffectiveTo:   ptr(day(10)),
+	}
+	rename := ScheduledChange{
+		Status:        ChangePending,
+		Changes:       json.RawMessage(`{"name": "ООО Петров"}`),
+		EffectiveFrom: day(5),
+	}
+	cases := []struct {
+		name       string
+		changes    []ScheduledChange
+		at         time.Time
+		wantActive bool
+		wantName   string
+	}{
+		{"before the vacation", []ScheduledChange{vacation}, day(2), true, "ИП Петров"},
+		{"during the vacation", []ScheduledChange{vacation}, day(4), false, "ИП Петров"},
+		{"after the vacation", []ScheduledChange{vacation}, day(11), true, "ИП Петров"},
+		{"rename during the vacation", []ScheduledChange{rename, vacation}, day(6), false, "ООО Петров"},
+		{"rename survives the revert", []ScheduledChange{rename, vacation}, day(12), true, "ООО Петров"},
+		{
+			"applied vacation ends",
+			[]ScheduledChange{{
+				Status:        ChangeApplied,
+				Changes:       json.RawMessage(`{"is_active": true}`),
+				Revert:        json.RawMessage(`{"is_active": false}`),
+				EffectiveFrom: day(-2),
+				EffectiveTo:   ptr(day(1)),
+			}},
+			day(2), false, "ИП Петров",
+		},
+		{
+			"missed window expires",
+			[]ScheduledChange{{
+				Status:        ChangePending,
+				Changes:       json.RawMessage(`{"name": "Никогда"}`),
+				EffectiveFrom: day(-3),
+				EffectiveTo:   ptr(day(-1)),
+			}},
+			day(1), true, "ИП Петров",
+		},
+	}
+	for _, c := range cases {
+		raw, err := projectChanges(carrier, c.changes, c.at, now)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var got Carrier
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatalf("%s: unmarshal: %v", c.name, err)
+		}
+		if got.IsActive != c.wantActive || got.Name != c.wantName || got.ID != "car-1" || got.Version != 4 {
+			t.Fatalf("%s: unexpected state %+v", c.name, got)
+		}
+	}
+}
+
+func TestScheduledChangeHandlers_Validation(t *testing.T) {
+	mux, token := scheduleMux(t)
+
+	cases := []struct {
+		method, path, body, want string
+	}{
+		{"POST", "/pvp/pvp-1/scheduled-changes", `{"changes":{"is_hub":true},"effective_from":"2030-01-07T00:00:00+03:00"}`, "reason is required"},
+		{"POST", "/pvp/pvp-1/scheduled-changes", `{"changes":{"is_hub":true},"reason":"hub"}`, "effective_from is required"},
+		{"POST", "/pvp/pvp-1/scheduled-changes", `{"changes":{"version":2},"effective_from":"2030-01-07T00:00:00Z","reason":"hub"}`, "must not include version"},
+		{"POST", "/carriers/car-1/scheduled-changes", `{"changes":{"is_active":false},"effective_from":"2030-01-07T00:00:00Z","effective_to":"2030-01-01T00:00:00Z","reason":"vacation"}`, "effective_to must be after effective_from"},
+		{"DELETE", "/warehouses/wh-1/scheduled-changes/0b9e0a1e-4a8b-4a4e-9a7a-2b5c1f1d2e3f", "", "reason is required"},
+		{"GET", "/pvp/pvp-1?at=monday", "", "at must be an RFC3339 time"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", token("admin"))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), c.want) {
+			t.Fatalf("%s %s: expected 400 %q, got %d %s", c.method, c.path, c.want, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/carriers/car-1/scheduled-changes", strings.NewReader(`{"changes":{"is_active":false},"effective_from":"2030-01-07T00:00:00Z","reason":"vacation"}`))
+	req.Header.Set("Authorization", token("moderator"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected moderators to be refused carrier changes, got %d", w.Code)
+	}
+}
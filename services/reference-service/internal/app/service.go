@@ -3,16 +3,13 @@ package app
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log/slog"
 	"strings"
 	"time"
 
 	"bel-parcel/services/reference-service/internal/infra/outbox"
 	"bel-parcel/services/reference-service/internal/metrics"
 
-	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -37,86 +34,17 @@ type AuditInfo struct {
 	Timestamp  time.Time `json:"timestamp"`
 }
 
+// UpdatePVZHubFlag and UpdateCarrierActive are the older single-field
+// endpoints; they go through the same path as full updates so the published
+// event carries the whole record.
 func (s *Service) UpdatePVZHubFlag(ctx context.Context, id string, isHub bool, audit AuditInfo) error {
-	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-
-	ct, err := tx.Exec(ctx, `
-		UPDATE pickup_points SET is_hub=$2, updated_at=NOW() WHERE id=$1
-	`, id, isHub)
-	if err != nil {
-		return err
-	}
-	if ct.RowsAffected() == 0 {
-		return errors.New("pickup point not found")
-	}
-
-	eventID := uuid.New().String()
-	now := time.Now().UTC()
-	payload := map[string]interface{}{
-		"event_id":       eventID,
-		"event_type":     "events.reference_updated",
-		"occurred_at":    now,
-		"correlation_id": id,
-		"data": map[string]interface{}{
-			"update_type":  "pickup_point",
-			"pickup_point": map[string]interface{}{"pvp_id": id, "is_hub": isHub},
-			"operator_id":  audit.OperatorID,
-			"reason":       audit.Reason,
-			"updated_at":   now,
-		},
-	}
-	if err := s.enqueueEvent(ctx, tx, "events.reference_updated", id, payload); err != nil {
-		return err
-	}
-	if err := tx.Commit(ctx); err != nil {
-		return err
-	}
-	slog.Info("pickup point updated", "pvp_id", id, "is_hub", isHub, "operator_id", audit.OperatorID, "reason", audit.Reason, "timestamp", now)
-	return nil
+	_, err := s.UpdatePickupPoint(ctx, id, PickupPointPatch{IsHub: &isHub}, audit)
+	return err
 }
 
 func (s *Service) UpdateCarrierActive(ctx context.Context, id string, isActive bool, audit AuditInfo) error {
-	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-	ct, err := tx.Exec(ctx, `
-		UPDATE carriers SET is_active=$2, updated_at=NOW() WHERE id=$1
-	`, id, isActive)
-	if err != nil {
-		return err
-	}
-	if ct.RowsAffected() == 0 {
-		return errors.New("carrier not found")
-	}
-	eventID := uuid.New().String()
-	now := time.Now().UTC()
-	payload := map[string]interface{}{
-		"event_id":       eventID,
-		"event_type":     "events.reference_updated",
-		"occurred_at":    now,
-		"correlation_id": id,
-		"data": map[string]interface{}{
-			"update_type": "carrier",
-			"carrier":     map[string]interface{}{"carrier_id": id, "is_active": isActive},
-			"operator_id": audit.OperatorID,
-			"reason":      audit.Reason,
-			"updated_at":  now,
-		},
-	}
-	if err := s.enqueueEvent(ctx, tx, "events.reference_updated", id, payload); err != nil {
-		return err
-	}
-	if err := tx.Commit(ctx); err != nil {
-		return err
-	}
-	slog.Info("carrier updated", "carrier_id", id, "is_active", isActive, "operator_id", audit.OperatorID, "reason", audit.Reason, "timestamp", now)
-	return nil
+	_, err := s.UpdateCarrier(ctx, id, CarrierPatch{IsActive: &isActive}, audit)
+	return err
 }
 
 func (s *Service) Search(ctx context.Context, typ string, q string, limit int, offset int) ([]map[string]interface{}, error) {
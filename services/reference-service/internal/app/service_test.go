@@ -58,3 +58,21 @@ func TestHandlers_MissingReason(t *testing.T) {
 		t.Fatalf("expected 400, got %d", w2.Code)
 	}
 }
+
+func TestHandlers_StorageDaysOutOfRange(t *testing.T) {
+	val := auth.NewValidator("s", "", "")
+	h := NewHandlers(&Service{}, val)
+	mux := http.NewServeMux()
+	h.Routes(mux)
+	token := makeToken(t, "s", "", "", "op-1", "moderator", time.Now().Add(time.Hour))
+
+	for _, body := range []string{`{"storage_days": 0, "reason": "x"}`, `{"storage_days": 61, "reason": "x"}`} {
+		req := httptest.NewRequest(http.MethodPut, "/pvp/pvp-1/storage", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"bel-parcel/pkg/schedule"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CarrierSnapshot is a carrier together with its current calendar.
+// ScheduleSeq is the change number of the last carrier_schedule event.
+type CarrierSnapshot struct {
+	Carrier
+	Schedule    schedule.Schedule `json:"schedule"`
+	ScheduleSeq int64             `json:"schedule_seq"`
+}
+
+// Snapshot is the whole reference data as of one moment. Seq is the highest
+// change number it contains; every record carries its own seq as well, which
+// is what consumers compare with the seq of later events.
+type Snapshot struct {
+	Seq          int64             `json:"seq"`
+	TakenAt      time.Time         `json:"taken_at"`
+	PickupPoints []PickupPoint     `json:"pickup_points"`
+	Warehouses   []Warehouse       `json:"warehouses"`
+	Carriers     []CarrierSnapshot `json:"carriers"`
+}
+
+// Snapshot reads all reference data in one repeatable-read transaction, so
+// the records are consistent with each other.
+func (s *Service) Snapshot(ctx context.Context) (*Snapshot, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	snap := &Snapshot{
+		TakenAt:      time.Now().UTC(),
+		PickupPoints: []PickupPoint{},
+		Warehouses:   []Warehouse{},
+		Carriers:     []CarrierSnapshot{},
+	}
+	rows, err := tx.Query(ctx, `SELECT `+pickupPointColumns+` FROM pickup_points ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		p, err := scanPickupPoint(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snap.PickupPoints = append(snap.PickupPoints, *p)
+		snap.Seq = max(snap.Seq, p.Seq)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(ctx, `SELECT `+warehouseColumns+` FROM warehouses ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		w, err := scanWarehouse(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snap.Warehouses = append(snap.Warehouses, *w)
+		snap.Seq = max(snap.Seq, w.Seq)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(ctx, `SELECT `+carrierColumns+`, schedule_seq FROM carriers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c CarrierSnapshot
+		if err := rows.Scan(&c.ID, &c.Name, &c.IsActive, &c.Version, &c.Seq, &c.UpdatedAt, &c.ScheduleSeq); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snap.Carriers = append(snap.Carriers, c)
+		snap.Seq = max(snap.Seq, c.Seq, c.ScheduleSeq)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range snap.Carriers {
+		sc, err := loadSchedule(ctx, tx, snap.Carriers[i].ID, snap.TakenAt)
+		if err != nil {
+			return nil, err
+		}
+		snap.Carriers[i].Schedule = sc
+	}
+	return snap, nil
+}
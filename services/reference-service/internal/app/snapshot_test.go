@@ -0,0 +1,20 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSnapshotServiceRoleOnly(t *testing.T) {
+	mux, token := scheduleMux(t)
+	for _, role := range []string{"user", "moderator"} {
+		req := httptest.NewRequest(http.MethodGet, "/snapshot", nil)
+		req.Header.Set("Authorization", token(role))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403, got %d", role, rr.Code)
+		}
+	}
+}
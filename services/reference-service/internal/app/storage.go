@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Bounds of the storage period a PVP may keep an uncollected parcel.
+const (
+	MinStorageDays = 1
+	MaxStorageDays = 60
+)
+
+// UpdatePVZStorageDays sets how long a PVP keeps an uncollected parcel before
+// it is sent back to the seller. order-service applies the new period to
+// parcels that arrive after the change.
+func (s *Service) UpdatePVZStorageDays(ctx context.Context, id string, days int, audit AuditInfo) error {
+	if days < MinStorageDays || days > MaxStorageDays {
+		return fmt.Errorf("storage_days must be between %d and %d", MinStorageDays, MaxStorageDays)
+	}
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := scanPickupPoint(tx.QueryRow(ctx, `SELECT `+pickupPointColumns+` FROM pickup_points WHERE id=$1 FOR UPDATE`, id))
+	if errors.Is(err, ErrNotFound) {
+		return errors.New("pickup point not found")
+	}
+	if err != nil {
+		return err
+	}
+	updated, err := scanPickupPoint(tx.QueryRow(ctx, `
+		UPDATE pickup_points SET storage_days=$2, version=version+1, change_seq=nextval('reference_change_seq'), updated_at=NOW()
+		WHERE id=$1
+		RETURNING `+pickupPointColumns,
+		id, days))
+	if err != nil {
+		return err
+	}
+	if err := recordAuditTx(ctx, tx, AuditPickupPoint, id, AuditUpdated, before, updated, audit); err != nil {
+		return err
+	}
+	if err := s.publishStorageDaysTx(ctx, tx, id, days, updated.Seq, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("pickup point storage period updated", "pvp_id", id, "storage_days", days, "operator_id", audit.OperatorID, "reason", audit.Reason)
+	return nil
+}
+
+func (s *Service) publishStorageDaysTx(ctx context.Context, tx pgx.Tx, id string, days int, seq int64, audit AuditInfo) error {
+	now := time.Now().UTC()
+	payload := map[string]interface{}{
+		"event_id":       uuid.New().String(),
+		"event_type":     "events.reference_updated",
+		"occurred_at":    now,
+		"correlation_id": id,
+		"data": map[string]interface{}{
+			"update_type":          "pickup_point_storage",
+			"pickup_point_storage": map[string]interface{}{"pvp_id": id, "storage_days": days},
+			"seq":                  seq,
+			"operator_id":          audit.OperatorID,
+			"reason":               audit.Reason,
+			"updated_at":           now,
+		},
+	}
+	return s.enqueueEvent(ctx, tx, "events.reference_updated", id, payload)
+}
@@ -24,6 +24,10 @@ type Config struct {
 		Issuer      string
 		Audience    string
 	}
+	// Scheduler applies scheduled reference changes when they take effect.
+	Scheduler struct {
+		Interval time.Duration
+	}
 }
 
 func Load() (*Config, error) {
@@ -39,6 +43,7 @@ func Load() (*Config, error) {
 	v.SetDefault("auth.hs256secret", "")
 	v.SetDefault("auth.issuer", "")
 	v.SetDefault("auth.audience", "")
+	v.SetDefault("scheduler.interval", 10*time.Second)
 
 	cfg := &Config{}
 	if err := v.Unmarshal(cfg); err != nil {
@@ -50,3 +50,107 @@ CREATE TABLE IF NOT EXISTS published_events (
     occurred_at TIMESTAMPTZ NOT NULL,
     PRIMARY KEY (event_type, correlation_id, occurred_at)
 );
+
+CREATE TABLE IF NOT EXISTS carrier_shift_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    carrier_id TEXT NOT NULL REFERENCES carriers(id) ON DELETE CASCADE,
+    weekday SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+    start_minute SMALLINT NOT NULL CHECK (start_minute BETWEEN 0 AND 1439),
+    end_minute SMALLINT NOT NULL CHECK (end_minute BETWEEN 0 AND 1439),
+    timezone TEXT NOT NULL DEFAULT 'Europe/Minsk',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_carrier_shift_rules_carrier ON carrier_shift_rules(carrier_id);
+
+CREATE TABLE IF NOT EXISTS carrier_schedule_exceptions (
+    id UUID PRIMARY KEY,
+    carrier_id TEXT NOT NULL REFERENCES carriers(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('off', 'on')),
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    reason TEXT,
+    created_by TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    CHECK (ends_at > starts_at)
+);
+CREATE INDEX IF NOT EXISTS idx_carrier_schedule_exceptions_range ON carrier_schedule_exceptions(carrier_id, ends_at);
+
+ALTER TABLE pickup_points ADD COLUMN IF NOT EXISTS storage_days INT NOT NULL DEFAULT 7 CHECK (storage_days BETWEEN 1 AND 60);
+
+-- Версия записи для оптимистичной блокировки; закрытые ПВЗ и склады не удаляются
+ALTER TABLE pickup_points ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE pickup_points ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE warehouses ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE warehouses ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE carriers ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+-- Сквозной номер изменения справочников: по нему потребители сверяют снимок и события
+CREATE SEQUENCE IF NOT EXISTS reference_change_seq;
+ALTER TABLE pickup_points ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE warehouses ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE carriers ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE carriers ADD COLUMN IF NOT EXISTS schedule_seq BIGINT NOT NULL DEFAULT 0;
+
+-- Журнал изменений справочников: только добавление, значения до и после изменения
+CREATE TABLE IF NOT EXISTS reference_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    before_value JSONB,
+    after_value JSONB,
+    operator_id TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_reference_audit_entity ON reference_audit_log(entity_type, entity_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_reference_audit_operator ON reference_audit_log(operator_id, id DESC);
+
+-- Записи журнала нельзя изменить или удалить
+CREATE OR REPLACE FUNCTION reference_audit_log_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'reference_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS reference_audit_log_no_change ON reference_audit_log;
+CREATE TRIGGER reference_audit_log_no_change BEFORE UPDATE OR DELETE ON reference_audit_log
+    FOR EACH ROW EXECUTE FUNCTION reference_audit_log_immutable();
+
+-- Вместимость ПВЗ в посылках (0 — без ограничения), часы работы, праздники и окна приёмки
+ALTER TABLE pickup_points ADD COLUMN IF NOT EXISTS capacity INT NOT NULL DEFAULT 0 CHECK (capacity >= 0);
+ALTER TABLE pickup_points ADD COLUMN IF NOT EXISTS hours JSONB NOT NULL DEFAULT '{}';
+
+-- Индексы по координатам для геопоиска: ближайшие ПВЗ, радиус и прямоугольник
+CREATE INDEX IF NOT EXISTS idx_pickup_points_location ON pickup_points(location_lat, location_lng) WHERE is_active;
+CREATE INDEX IF NOT EXISTS idx_warehouses_location ON warehouses(location_lat, location_lng) WHERE is_active;
+
+-- Отложенные изменения справочников: планировщик применяет changes в effective_from
+-- и возвращает прежние значения (revert) в effective_to
+CREATE TABLE IF NOT EXISTS reference_scheduled_changes (
+    id UUID PRIMARY KEY,
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    changes JSONB NOT NULL,
+    effective_from TIMESTAMPTZ NOT NULL,
+    effective_to TIMESTAMPTZ,
+    revert JSONB,
+    status TEXT NOT NULL DEFAULT 'pending',
+    error TEXT,
+    -- Неудачные попытки текущего шага и время следующей; изменение с ошибкой
+    -- откладывается и не задерживает остальные
+    attempts INT NOT NULL DEFAULT 0,
+    retry_at TIMESTAMPTZ,
+    operator_id TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    applied_at TIMESTAMPTZ,
+    reverted_at TIMESTAMPTZ,
+    cancelled_by TEXT,
+    cancel_reason TEXT,
+    CHECK (effective_to IS NULL OR effective_to > effective_from)
+);
+CREATE INDEX IF NOT EXISTS idx_reference_scheduled_entity ON reference_scheduled_changes(entity_type, entity_id, effective_from);
+CREATE INDEX IF NOT EXISTS idx_reference_scheduled_due_from ON reference_scheduled_changes(effective_from) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_reference_scheduled_due_to ON reference_scheduled_changes(effective_to) WHERE status = 'applied';
+-- Поиск состояния записи на момент времени по журналу
+CREATE INDEX IF NOT EXISTS idx_reference_audit_entity_time ON reference_audit_log(entity_type, entity_id, changed_at);
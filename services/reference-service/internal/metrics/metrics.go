@@ -58,6 +58,13 @@ var (
 			Help: "Pending outbox events",
 		},
 	)
+	ScheduledChangesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reference_scheduled_changes_total",
+			Help: "Scheduled reference changes processed by the scheduler",
+		},
+		[]string{"entity_type", "outcome"},
+	)
 	OutboxEventAge = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "reference_outbox_event_age_seconds",
@@ -78,5 +85,6 @@ func MustRegister() {
 		CacheMissesTotal,
 		OutboxPending,
 		OutboxEventAge,
+		ScheduledChangesTotal,
 	)
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
@@ -15,9 +17,11 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"bel-parcel/pkg/leader"
 	"bel-parcel/services/routing-service/internal/config"
 	"bel-parcel/services/routing-service/internal/infra/db"
 	"bel-parcel/services/routing-service/internal/infra/kafka"
+	"bel-parcel/services/routing-service/internal/infra/reference"
 	"bel-parcel/services/routing-service/internal/metrics"
 	"bel-parcel/services/routing-service/internal/outbox"
 	"bel-parcel/services/routing-service/internal/routing"
@@ -47,99 +51,13 @@ func main() {
 		slog.Error("failed to ensure outbox schema", "error", err)
 		os.Exit(1)
 	}
-	// Ensure business schema
-	{
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if _, err := tripDB.Exec(ctx, `
-			CREATE TABLE IF NOT EXISTS trips (
-				id UUID PRIMARY KEY,
-				origin_warehouse_id TEXT,
-				pickup_point_id TEXT,
-				carrier_id TEXT,
-				status TEXT NOT NULL,
-				assigned_at TIMESTAMPTZ NOT NULL
-			)
-		`); err != nil {
-			slog.Error("failed to ensure trips schema", "error", err)
-			os.Exit(1)
-		}
-		if _, err := tripDB.Exec(ctx, `
-			CREATE TABLE IF NOT EXISTS trip_batches (
-				trip_id UUID NOT NULL,
-				batch_id TEXT NOT NULL,
-				PRIMARY KEY (trip_id, batch_id)
-			)
-		`); err != nil {
-			slog.Error("failed to ensure trip_batches schema", "error", err)
-			os.Exit(1)
-		}
-		if _, err := tripDB.Exec(ctx, `
-			ALTER TABLE trips ADD COLUMN IF NOT EXISTS assigned_distance_meters INT DEFAULT 0;
-		`); err != nil {
-			slog.Error("failed to alter trips schema", "error", err)
-			os.Exit(1)
-		}
-		if _, err := tripDB.Exec(ctx, `
-			ALTER TABLE trips 
-			ADD COLUMN IF NOT EXISTS origin_lat DOUBLE PRECISION DEFAULT 0,
-			ADD COLUMN IF NOT EXISTS origin_lng DOUBLE PRECISION DEFAULT 0,
-			ADD COLUMN IF NOT EXISTS dest_lat DOUBLE PRECISION DEFAULT 0,
-			ADD COLUMN IF NOT EXISTS dest_lng DOUBLE PRECISION DEFAULT 0;
-		`); err != nil {
-			slog.Error("failed to add coords to trips schema", "error", err)
-			os.Exit(1)
-		}
-		if _, err := tripDB.Exec(ctx, `
-			CREATE TABLE IF NOT EXISTS pending_assignments (
-				trip_id UUID PRIMARY KEY,
-				timeout_at TIMESTAMPTZ NOT NULL
-			)
-		`); err != nil {
-			slog.Error("failed to ensure pending_assignments schema", "error", err)
-			os.Exit(1)
-		}
-		if _, err := tripDB.Exec(ctx, `
-			CREATE TABLE IF NOT EXISTS processed_events (
-				event_id TEXT PRIMARY KEY,
-				occurred_at TIMESTAMPTZ NOT NULL
-			)
-		`); err != nil {
-			slog.Error("failed to ensure processed_events schema", "error", err)
-			os.Exit(1)
-		}
+	if err := leader.EnsureSchema(tripDB); err != nil {
+		slog.Error("failed to ensure leader schema", "error", err)
+		os.Exit(1)
 	}
-	{
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if _, err := tripDB.Exec(ctx, `
-			CREATE TABLE IF NOT EXISTS carrier_positions (
-				carrier_id TEXT PRIMARY KEY,
-				lat DOUBLE PRECISION NOT NULL,
-				lng DOUBLE PRECISION NOT NULL,
-				updated_at TIMESTAMPTZ NOT NULL
-			)
-		`); err != nil {
-			slog.Error("failed to ensure carrier_positions schema", "error", err)
-			os.Exit(1)
-		}
-		if _, err := tripDB.Exec(ctx, `
-			CREATE TABLE IF NOT EXISTS carrier_activity_cache (
-				carrier_id TEXT PRIMARY KEY,
-				is_active BOOLEAN DEFAULT TRUE,
-				updated_at TIMESTAMPTZ
-			)
-		`); err != nil {
-			slog.Error("failed to ensure carrier_activity_cache schema", "error", err)
-			os.Exit(1)
-		}
-		// Ensure updated_at exists if table existed with different schema
-		if _, err := tripDB.Exec(ctx, `
-			ALTER TABLE carrier_activity_cache ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
-		`); err != nil {
-			slog.Error("failed to alter carrier_activity_cache schema", "error", err)
-			os.Exit(1)
-		}
+	if err := routing.EnsureSchema(tripDB); err != nil {
+		slog.Error("failed to ensure trip schema", "error", err)
+		os.Exit(1)
 	}
 
 	cctx, ccancel := context.WithCancel(context.Background())
@@ -152,8 +70,35 @@ func main() {
 	consumer := kafka.NewConsumer(cfg.Kafka.Brokers, cfg.Kafka.GroupID, cfg.Kafka.ConsumeTopics, cfg.Kafka.Timeout)
 	defer consumer.Close()
 
-	svc := routing.NewService(tripDB, producer, cfg.Kafka.ProduceTopic)
-	svc.Start(cctx)
+	svc := routing.NewService(tripDB, producer, cfg.Kafka.ProduceTopic).WithPendingPolicy(routing.PendingPolicy{
+		TickInterval:      cfg.Pending.TickInterval,
+		RetryIntervals:    cfg.Pending.RetryIntervals,
+		SearchRadiiMeters: cfg.Pending.SearchRadiiMeters,
+		MaxAttempts:       cfg.Pending.MaxAttempts,
+		AlertAttempt:      cfg.Pending.AlertAttempt,
+	}).WithOfferPolicy(routing.OfferPolicy{
+		Enabled:      cfg.Assignment.Mode == "offer",
+		TopN:         cfg.Assignment.OfferTopN,
+		Expiry:       cfg.Assignment.OfferExpiry,
+		RadiusMeters: cfg.Assignment.OfferRadiusMeters,
+		TickInterval: cfg.Assignment.OfferTickInterval,
+		Topic:        cfg.Assignment.OfferTopic,
+	}).WithTransshipmentPolicy(routing.TransshipmentPolicy{
+		VehicleCapacityBoxes: cfg.Transshipment.VehicleCapacityBoxes,
+		Topic:                cfg.Transshipment.Topic,
+		SplitTopic:           cfg.Transshipment.SplitTopic,
+	}).WithSplitPolicy(routing.SplitPolicy{
+		Topic: cfg.Split.Topic,
+	}).WithStatusTopic(cfg.Kafka.StatusTopic)
+	instanceID := leader.InstanceID()
+	elector := leader.NewElector(tripDB, "routing-pending-assignments", instanceID, cfg.Leader.RenewInterval, metrics.SetLeader)
+	go elector.Run(cctx, svc.StartPendingReassignmentLoop)
+	offerElector := leader.NewElector(tripDB, "routing-offer-expiry", instanceID, cfg.Leader.RenewInterval, metrics.SetLeader)
+	go offerElector.Run(cctx, svc.StartOfferExpiryLoop)
+
+	// Carrier projections are filled from the reference snapshot before
+	// consuming; events older than the stored rows are then ignored by seq.
+	bootstrapReference(cctx, svc, reference.NewClient(cfg.Reference.URL, cfg.Reference.Token, cfg.Reference.Timeout))
 
 	consumer.Start(cctx, func(topic string, key, value []byte) error {
 		return svc.HandleEvent(cctx, topic, key, value)
@@ -172,8 +117,69 @@ func main() {
 			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 			return
 		}
+		lease, err := elector.Current(r.Context())
+		if err != nil {
+			slog.Warn("leader lookup failed", "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "OK",
+			"leader": lease,
+		})
+	})
+
+	mux.HandleFunc("GET /transshipments/{id}", func(w http.ResponseWriter, r *http.Request) {
+		v, err := svc.GetTransshipment(r.Context(), r.PathValue("id"))
+		if errors.Is(err, routing.ErrTransshipmentNotFound) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("transshipment lookup failed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	})
+	mux.HandleFunc("GET /trips/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		history, err := svc.TripHistory(r.Context(), r.PathValue("id"))
+		if err != nil {
+			slog.Error("trip history failed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(history)
+	})
+	mux.HandleFunc("GET /incidents", func(w http.ResponseWriter, r *http.Request) {
+		to := time.Now().UTC()
+		from := to.Add(-7 * 24 * time.Hour)
+		if v := r.URL.Query().Get("from"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid from", http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
+		if v := r.URL.Query().Get("to"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid to", http.StatusBadRequest)
+				return
+			}
+			to = t
+		}
+		rep, err := svc.IncidentReport(r.Context(), from, to)
+		if err != nil {
+			slog.Error("incident report failed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
 	})
 
 	srv := &http.Server{
@@ -201,6 +207,30 @@ func main() {
 	slog.Info("server stopped")
 }
 
+// bootstrapReference loads the reference snapshot with a few retries. If the
+// reference service stays unavailable the service starts on the projection it
+// already has and catches up from the event stream.
+func bootstrapReference(ctx context.Context, svc *routing.Service, client *reference.Client) {
+	backoff := time.Second
+	for attempt := 1; attempt <= 5; attempt++ {
+		snap, err := client.Snapshot(ctx)
+		if err == nil {
+			err = svc.ApplyReferenceSnapshot(ctx, snap)
+		}
+		if err == nil {
+			return
+		}
+		slog.Warn("reference snapshot bootstrap failed", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	slog.Warn("starting without reference snapshot")
+}
+
 func setupLogger() {
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
@@ -20,6 +20,38 @@ type Config struct {
 		GroupID       string
 		ConsumeTopics []string
 		ProduceTopic  string
+		StatusTopic   string
+	}
+	Pending struct {
+		TickInterval      time.Duration
+		RetryIntervals    []time.Duration
+		SearchRadiiMeters []int
+		MaxAttempts       int
+		AlertAttempt      int
+	}
+	Assignment struct {
+		Mode              string
+		OfferTopN         int
+		OfferExpiry       time.Duration
+		OfferRadiusMeters int
+		OfferTickInterval time.Duration
+		OfferTopic        string
+	}
+	Transshipment struct {
+		VehicleCapacityBoxes int
+		Topic                string
+		SplitTopic           string
+	}
+	Split struct {
+		Topic string
+	}
+	Leader struct {
+		RenewInterval time.Duration
+	}
+	Reference struct {
+		URL     string
+		Token   string
+		Timeout time.Duration
 	}
 	OTLP struct {
 		Endpoint string
@@ -34,8 +66,28 @@ func Load() (*Config, error) {
 	v.SetDefault("kafka.brokers", []string{"redpanda:9092"})
 	v.SetDefault("kafka.timeout", 5*time.Second)
 	v.SetDefault("kafka.groupid", "routing-service")
-	v.SetDefault("kafka.consumetopics", []string{"batches.formed", "commands.trip.reassign", "events.batch_picked_up", "events.batch_delivered_to_pvp", "events.carrier_location", "events.reference_updated"})
+	v.SetDefault("kafka.consumetopics", []string{"batches.formed", "commands.trip.reassign", "events.batch_picked_up", "events.batch_delivered_to_pvp", "events.carrier_location", "events.reference_updated", "events.trip_offer_accepted", "events.trip_incident_reported", "commands.transshipment", "commands.trip.status"})
 	v.SetDefault("kafka.producetopic", "trips.assigned")
+	v.SetDefault("kafka.statustopic", "trips.status_changed")
+	v.SetDefault("pending.tickinterval", 1*time.Minute)
+	v.SetDefault("pending.retryintervals", []time.Duration{5 * time.Minute})
+	v.SetDefault("pending.searchradiimeters", []int{5000, 10000, 20000})
+	v.SetDefault("pending.maxattempts", 10)
+	v.SetDefault("pending.alertattempt", 8)
+	v.SetDefault("assignment.mode", "push")
+	v.SetDefault("assignment.offertopn", 3)
+	v.SetDefault("assignment.offerexpiry", 2*time.Minute)
+	v.SetDefault("assignment.offerradiusmeters", 5000)
+	v.SetDefault("assignment.offertickinterval", 15*time.Second)
+	v.SetDefault("assignment.offertopic", "trips.offers")
+	v.SetDefault("transshipment.vehiclecapacityboxes", 200)
+	v.SetDefault("transshipment.topic", "trips.transshipments")
+	v.SetDefault("transshipment.splittopic", "batches.split")
+	v.SetDefault("split.topic", "commands.batch.split")
+	v.SetDefault("leader.renewinterval", 5*time.Second)
+	v.SetDefault("reference.url", "http://localhost:8084")
+	v.SetDefault("reference.token", "")
+	v.SetDefault("reference.timeout", 10*time.Second)
 	v.SetDefault("otlp.endpoint", "")
 
 	v.SetConfigName("config")
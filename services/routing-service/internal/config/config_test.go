@@ -1,6 +1,10 @@
 package config
 
-import "testing"
+import (
+	"os"
+	"testing"
+	"time"
+)
 
 func TestLoadDefaults(t *testing.T) {
 	cfg, err := Load()
@@ -14,3 +18,23 @@ func TestLoadDefaults(t *testing.T) {
 		t.Fatalf("missing TripDSN")
 	}
 }
+
+func TestLoadPendingPolicyFromEnv(t *testing.T) {
+	os.Setenv("PENDING_RETRYINTERVALS", "1m,2m,10m")
+	os.Setenv("PENDING_SEARCHRADIIMETERS", "3000,8000")
+	defer os.Unsetenv("PENDING_RETRYINTERVALS")
+	defer os.Unsetenv("PENDING_SEARCHRADIIMETERS")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if len(cfg.Pending.RetryIntervals) != 3 || cfg.Pending.RetryIntervals[2] != 10*time.Minute {
+		t.Fatalf("unexpected retry intervals: %v", cfg.Pending.RetryIntervals)
+	}
+	if len(cfg.Pending.SearchRadiiMeters) != 2 || cfg.Pending.SearchRadiiMeters[1] != 8000 {
+		t.Fatalf("unexpected radii: %v", cfg.Pending.SearchRadiiMeters)
+	}
+	if cfg.Pending.MaxAttempts != 10 || cfg.Pending.TickInterval != time.Minute {
+		t.Fatalf("unexpected defaults: %+v", cfg.Pending)
+	}
+}
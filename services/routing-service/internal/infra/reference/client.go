@@ -0,0 +1,40 @@
+package reference
+
+import (
+	"encoding/json"
+	"time"
+
+	"bel-parcel/pkg/refsnapshot"
+	"bel-parcel/pkg/schedule"
+)
+
+// Snapshot is the part of the reference-service snapshot routing keeps.
+type Snapshot struct {
+	Seq          int64         `json:"seq"`
+	Carriers     []Carrier     `json:"carriers"`
+	PickupPoints []PickupPoint `json:"pickup_points"`
+}
+
+// Carrier is a carrier with its shift calendar. Seq and ScheduleSeq are the
+// change numbers of the carrier record and of its calendar.
+type Carrier struct {
+	ID          string          `json:"id"`
+	IsActive    bool            `json:"is_active"`
+	Seq         int64           `json:"seq"`
+	Schedule    json.RawMessage `json:"schedule"`
+	ScheduleSeq int64           `json:"schedule_seq"`
+}
+
+// PickupPoint carries the PVP hours routing checks arrivals against.
+type PickupPoint struct {
+	ID string `json:"id"`
+	schedule.Hours
+	Seq int64 `json:"seq"`
+}
+
+// Client reads the reference snapshot into Snapshot.
+type Client = refsnapshot.Client[Snapshot]
+
+func NewClient(baseURL, token string, timeout time.Duration) *Client {
+	return refsnapshot.NewClient[Snapshot](baseURL, token, timeout)
+}
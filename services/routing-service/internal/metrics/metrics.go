@@ -37,13 +37,28 @@ var (
 			Help: "Number of pending/error events in outbox",
 		},
 	)
+	leaderIsLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "routing_leader_is_leader",
+			Help: "Whether this instance leads a singleton job (1) or not (0)",
+		},
+		[]string{"job"},
+	)
 )
 
 func Init(mux *http.ServeMux) {
-	prometheus.MustRegister(outboxErrors, retryAttempts, dlqSize, outboxQueueSize)
+	prometheus.MustRegister(outboxErrors, retryAttempts, dlqSize, outboxQueueSize, leaderIsLeader)
 	mux.Handle("/metrics", promhttp.Handler())
 }
 
+func SetLeader(job string, leader bool) {
+	v := 0.0
+	if leader {
+		v = 1
+	}
+	leaderIsLeader.WithLabelValues(job).Set(v)
+}
+
 func IncOutboxError(topic string) {
 	outboxErrors.WithLabelValues(topic, "error").Inc()
 }
@@ -0,0 +1,447 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Incident types reported by carriers. Breakdowns and accidents take the
+// vehicle out of service, delays only warn operators.
+const (
+	IncidentBreakdown = "breakdown"
+	IncidentAccident  = "accident"
+	IncidentDelay     = "delay"
+)
+
+// Incident statuses.
+const (
+	incidentOpen                = "open"
+	incidentRecovered           = "recovered"
+	incidentAwaitingReplacement = "awaiting_replacement"
+	incidentResolved            = "resolved"
+)
+
+const incidentAlertTopic = "alerts.trip_incident"
+
+type incidentReport struct {
+	IncidentID  string    `json:"incident_id"`
+	TripID      string    `json:"trip_id"`
+	CarrierID   string    `json:"carrier_id"`
+	Type        string    `json:"type"`
+	Latitude    float64   `json:"latitude"`
+	Longitude   float64   `json:"longitude"`
+	Description string    `json:"description"`
+	ReportedAt  time.Time `json:"reported_at"`
+}
+
+func takesVehicleOut(incidentType string) bool {
+	return incidentType == IncidentBreakdown || incidentType == IncidentAccident
+}
+
+// handleIncidentReported records the incident and, when the vehicle is out
+// of service, moves the trip's batches to a new trip with a carrier chosen
+// around the incident location, or around the trip's origin if the goods
+// were not picked up yet. Operators are alerted in every case. Reports from
+// a carrier other than the trip's are ignored.
+func (s *Service) handleIncidentReported(ctx context.Context, eventID, eventType string, in incidentReport) error {
+	if in.IncidentID == "" || in.TripID == "" {
+		return nil
+	}
+	if in.ReportedAt.IsZero() {
+		in.ReportedAt = time.Now().UTC()
+	}
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var inserted string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, event_type, processed_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, eventID, eventType).Scan(&inserted); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	var status string
+	var tripCarrier *string
+	var originLat, originLng, destLat, destLng float64
+	if err := tx.QueryRow(ctx, `
+		SELECT status, carrier_id, COALESCE(origin_lat, 0), COALESCE(origin_lng, 0), COALESCE(dest_lat, 0), COALESCE(dest_lng, 0)
+		FROM trips WHERE id=$1 FOR UPDATE
+	`, in.TripID).Scan(&status, &tripCarrier, &originLat, &originLng, &destLat, &destLng); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			slog.Warn("Incident reported for unknown trip", "trip_id", in.TripID, "incident_id", in.IncidentID)
+			return tx.Commit(ctx)
+		}
+		return err
+	}
+	if tripCarrier != nil && in.CarrierID != "" && in.CarrierID != *tripCarrier {
+		slog.Warn("Incident reported by a carrier not assigned to the trip", "trip_id", in.TripID, "incident_id", in.IncidentID, "carrier_id", in.CarrierID)
+		return tx.Commit(ctx)
+	}
+	if in.CarrierID == "" && tripCarrier != nil {
+		in.CarrierID = *tripCarrier
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO trip_incidents (incident_id, trip_id, carrier_id, incident_type, latitude, longitude, description, status, reported_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (incident_id) DO NOTHING
+	`, in.IncidentID, in.TripID, in.CarrierID, in.Type, in.Latitude, in.Longitude, in.Description, incidentOpen, in.ReportedAt); err != nil {
+		return err
+	}
+
+	active := status == "ASSIGNED" || status == "IN_PROGRESS"
+	if !takesVehicleOut(in.Type) || !active {
+		msg := fmt.Sprintf("Перевозчик сообщил о задержке по рейсу %s", in.TripID)
+		if in.Type != IncidentDelay {
+			msg = fmt.Sprintf("Инцидент (%s) на рейсе %s в статусе %s, переназначение не требуется", in.Type, in.TripID, status)
+		}
+		if err := s.publishIncidentAlertTx(ctx, tx, in, "", msg, "warning"); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	// Goods already on board are picked up where the vehicle stopped; a trip
+	// not yet picked up still starts from its origin.
+	startLat, startLng := in.Latitude, in.Longitude
+	if status == "ASSIGNED" {
+		startLat, startLng = originLat, originLng
+	}
+	now := time.Now().UTC()
+	carrierID, dist, selErr := s.selectReplacementCarrier(ctx, startLat, startLng, in.CarrierID)
+	newStatus := "ASSIGNED"
+	if selErr != nil {
+		newStatus = "PENDING"
+	}
+	replacement := newTrip{Status: TripPending, OriginLat: startLat, OriginLng: startLng, DestLat: destLat, DestLng: destLng, Reason: "incident_replacement"}
+	if selErr == nil {
+		replacement.CarrierID, replacement.DistanceMeters = carrierID, dist
+	}
+	newTripID, err := s.createTripTx(ctx, tx, replacement, now)
+	if err != nil {
+		return err
+	}
+	batches, err := moveBatchesTx(ctx, tx, in.TripID, newTripID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: in.TripID, To: TripIncident, Actor: in.CarrierID, Reason: in.Type}, now); err != nil {
+		return err
+	}
+
+	if selErr != nil {
+		if len(batches) > 0 {
+			if err := s.registerPendingTx(ctx, tx, newTripID, batches[0], now); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE trip_incidents SET status=$2, replacement_trip_id=$3 WHERE incident_id=$1
+		`, in.IncidentID, incidentAwaitingReplacement, newTripID); err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("Инцидент (%s) на рейсе %s: замена рядом не найдена, новый рейс %s ожидает перевозчика", in.Type, in.TripID, newTripID)
+		if err := s.publishIncidentAlertTx(ctx, tx, in, newTripID, msg, "critical"); err != nil {
+			return err
+		}
+		slog.Warn("No replacement carrier for incident", "incident_id", in.IncidentID, "trip_id", in.TripID, "new_trip_id", newTripID, "error", selErr)
+		return tx.Commit(ctx)
+	}
+
+	for _, batchID := range batches {
+		if err := s.publishAssignedTx(ctx, tx, newTripID, batchID, carrierID, dist, startLat, startLng, destLat, destLng, now); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE trip_incidents SET status=$2, replacement_trip_id=$3, resolved_at=$4 WHERE incident_id=$1
+	`, in.IncidentID, incidentRecovered, newTripID, now); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("Инцидент (%s) на рейсе %s: партии переданы перевозчику %s, новый рейс %s", in.Type, in.TripID, carrierID, newTripID)
+	if err := s.publishIncidentAlertTx(ctx, tx, in, newTripID, msg, "warning"); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("Trip recovered after incident", "incident_id", in.IncidentID, "trip_id", in.TripID, "new_trip_id", newTripID, "carrier_id", carrierID, "status", newStatus)
+	return nil
+}
+
+// selectReplacementCarrier picks the nearest on-shift carrier around the
+// incident location, never the carrier that reported it.
+func (s *Service) selectReplacementCarrier(ctx context.Context, lat, lng float64, excludeCarrierID string) (string, int, error) {
+	cands, err := s.loadCandidates(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	return chooseCarrierWithin(lat, lng, excludeCarrier(cands, excludeCarrierID), defaultSearchRadiusMeters)
+}
+
+func excludeCarrier(cands []carrierCandidate, carrierID string) []carrierCandidate {
+	res := cands[:0:0]
+	for _, c := range cands {
+		if c.id != carrierID {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func moveBatchesTx(ctx context.Context, tx pgx.Tx, fromTripID, toTripID string) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		UPDATE trip_batches SET trip_id=$2 WHERE trip_id=$1 RETURNING batch_id
+	`, fromTripID, toTripID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var batches []string
+	for rows.Next() {
+		var b string
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+func (s *Service) publishAssignedTx(ctx context.Context, tx pgx.Tx, tripID, batchID, carrierID string, dist int, originLat, originLng, destLat, destLng float64, now time.Time) error {
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     "trips.assigned",
+		"occurred_at":    now,
+		"correlation_id": batchID,
+		"data": map[string]interface{}{
+			"trip_id":                  tripID,
+			"batch_id":                 batchID,
+			"carrier_id":               carrierID,
+			"origin_lat":               originLat,
+			"origin_lng":               originLng,
+			"destination_lat":          destLat,
+			"destination_lng":          destLng,
+			"assigned_distance_meters": dist,
+			"assigned_at":              now,
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     "trips.assigned",
+		CorrelationID: batchID + "/" + tripID,
+		Topic:         s.outTopic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+func (s *Service) publishIncidentAlertTx(ctx context.Context, tx pgx.Tx, in incidentReport, newTripID, message, severity string) error {
+	now := time.Now().UTC()
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     incidentAlertTopic,
+		"occurred_at":    now,
+		"correlation_id": in.IncidentID,
+		"data": map[string]interface{}{
+			"incident_id":         in.IncidentID,
+			"incident_type":       in.Type,
+			"trip_id":             in.TripID,
+			"carrier_id":          in.CarrierID,
+			"replacement_trip_id": newTripID,
+			"latitude":            in.Latitude,
+			"longitude":           in.Longitude,
+			"message":             message,
+			"severity":            severity,
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     incidentAlertTopic,
+		CorrelationID: in.IncidentID,
+		Topic:         incidentAlertTopic,
+		PartitionKey:  in.TripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+// completeReplacementTx runs when the pending loop finds a carrier for a
+// replacement trip. The loop announces a single batch, so the other batches
+// moved off the broken-down vehicle are announced here, and the incident that
+// was waiting for this trip is closed.
+func (s *Service) completeReplacementTx(ctx context.Context, tx pgx.Tx, tripID, announcedBatchID, carrierID string, dist int, originLat, originLng, destLat, destLng float64, now time.Time) error {
+	ct, err := tx.Exec(ctx, `
+		UPDATE trip_incidents SET status=$2, resolved_at=$3
+		WHERE replacement_trip_id=$1 AND resolved_at IS NULL
+	`, tripID, incidentRecovered, now)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return nil
+	}
+	rows, err := tx.Query(ctx, `SELECT batch_id FROM trip_batches WHERE trip_id=$1 AND batch_id <> $2`, tripID, announcedBatchID)
+	if err != nil {
+		return err
+	}
+	var batches []string
+	for rows.Next() {
+		var b string
+		if err := rows.Scan(&b); err != nil {
+			rows.Close()
+			return err
+		}
+		batches = append(batches, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, b := range batches {
+		if err := s.publishAssignedTx(ctx, tx, tripID, b, carrierID, dist, originLat, originLng, destLat, destLng, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveTripIncidentsTx closes the remaining open incidents of a finished trip.
+func resolveTripIncidentsTx(ctx context.Context, tx pgx.Tx, tripID string, now time.Time) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE trip_incidents SET status=$2, resolved_at=$3
+		WHERE trip_id=$1 AND resolved_at IS NULL
+	`, tripID, incidentResolved, now)
+	return err
+}
+
+// IncidentSummary aggregates incidents of one type. Recovered counts the
+// incidents closed by handing the batches to a replacement carrier.
+type IncidentSummary struct {
+	Type                 string  `json:"type"`
+	Total                int     `json:"total"`
+	Resolved             int     `json:"resolved"`
+	Recovered            int     `json:"recovered"`
+	Open                 int     `json:"open"`
+	AvgResolutionSeconds float64 `json:"avg_resolution_seconds"`
+	MaxResolutionSeconds float64 `json:"max_resolution_seconds"`
+}
+
+type IncidentRecord struct {
+	IncidentID        string     `json:"incident_id"`
+	TripID            string     `json:"trip_id"`
+	CarrierID         string     `json:"carrier_id"`
+	Type              string     `json:"type"`
+	Status            string     `json:"status"`
+	Latitude          float64    `json:"latitude"`
+	Longitude         float64    `json:"longitude"`
+	Description       string     `json:"description"`
+	ReplacementTripID *string    `json:"replacement_trip_id"`
+	ReportedAt        time.Time  `json:"reported_at"`
+	ResolvedAt        *time.Time `json:"resolved_at"`
+	ResolutionSeconds *float64   `json:"resolution_seconds"`
+}
+
+type IncidentReport struct {
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Summary   []IncidentSummary `json:"summary"`
+	Incidents []IncidentRecord  `json:"incidents"`
+}
+
+// IncidentReport lists incidents reported in [from, to) with their
+// time-to-resolution, plus per-type aggregates.
+func (s *Service) IncidentReport(ctx context.Context, from, to time.Time) (IncidentReport, error) {
+	rep := IncidentReport{From: from, To: to, Summary: []IncidentSummary{}, Incidents: []IncidentRecord{}}
+	rows, err := s.tripDB.Query(ctx, `
+		SELECT incident_id, trip_id, COALESCE(carrier_id, ''), incident_type, status, latitude, longitude,
+		       COALESCE(description, ''), replacement_trip_id::text, reported_at, resolved_at
+		FROM trip_incidents
+		WHERE reported_at >= $1 AND reported_at < $2
+		ORDER BY reported_at DESC
+		LIMIT 1000
+	`, from, to)
+	if err != nil {
+		return rep, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r IncidentRecord
+		if err := rows.Scan(&r.IncidentID, &r.TripID, &r.CarrierID, &r.Type, &r.Status, &r.Latitude, &r.Longitude,
+			&r.Description, &r.ReplacementTripID, &r.ReportedAt, &r.ResolvedAt); err != nil {
+			return rep, err
+		}
+		if r.ResolvedAt != nil {
+			secs := r.ResolvedAt.Sub(r.ReportedAt).Seconds()
+			r.ResolutionSeconds = &secs
+		}
+		rep.Incidents = append(rep.Incidents, r)
+	}
+	if err := rows.Err(); err != nil {
+		return rep, err
+	}
+	rep.Summary = summarizeIncidents(rep.Incidents)
+	return rep, nil
+}
+
+func summarizeIncidents(records []IncidentRecord) []IncidentSummary {
+	byType := make(map[string]*IncidentSummary)
+	var order []string
+	totals := make(map[string]float64)
+	for _, r := range records {
+		sum, ok := byType[r.Type]
+		if !ok {
+			sum = &IncidentSummary{Type: r.Type}
+			byType[r.Type] = sum
+			order = append(order, r.Type)
+		}
+		sum.Total++
+		if r.ResolutionSeconds == nil {
+			sum.Open++
+			continue
+		}
+		sum.Resolved++
+		totals[r.Type] += *r.ResolutionSeconds
+		if *r.ResolutionSeconds > sum.MaxResolutionSeconds {
+			sum.MaxResolutionSeconds = *r.ResolutionSeconds
+		}
+		if r.Status == incidentRecovered {
+			sum.Recovered++
+		}
+	}
+	res := make([]IncidentSummary, 0, len(order))
+	for _, t := range order {
+		sum := byType[t]
+		if sum.Resolved > 0 {
+			sum.AvgResolutionSeconds = totals[t] / float64(sum.Resolved)
+		}
+		res = append(res, *sum)
+	}
+	return res
+}
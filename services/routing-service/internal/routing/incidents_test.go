@@ -0,0 +1,187 @@
+package routing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestExcludeCarrier_DropsReporter(t *testing.T) {
+	cands := []carrierCandidate{{id: "broken"}, {id: "spare"}}
+	got := excludeCarrier(cands, "broken")
+	if len(got) != 1 || got[0].id != "spare" {
+		t.Fatalf("unexpected candidates: %+v", got)
+	}
+	if len(cands) != 2 || cands[0].id != "broken" {
+		t.Fatalf("input slice must not be modified: %+v", cands)
+	}
+}
+
+func TestSummarizeIncidents_ResolutionTimes(t *testing.T) {
+	secs := func(v float64) *float64 { return &v }
+	records := []IncidentRecord{
+		{Type: IncidentBreakdown, Status: incidentRecovered, ResolutionSeconds: secs(60)},
+		{Type: IncidentBreakdown, Status: incidentRecovered, ResolutionSeconds: secs(180)},
+		{Type: IncidentBreakdown, Status: incidentAwaitingReplacement},
+		{Type: IncidentDelay, Status: incidentResolved, ResolutionSeconds: secs(600)},
+	}
+	got := summarizeIncidents(records)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 types, got %+v", got)
+	}
+	b := got[0]
+	if b.Type != IncidentBreakdown || b.Total != 3 || b.Resolved != 2 || b.Open != 1 || b.Recovered != 2 {
+		t.Fatalf("unexpected breakdown summary: %+v", b)
+	}
+	if b.AvgResolutionSeconds != 120 || b.MaxResolutionSeconds != 180 {
+		t.Fatalf("unexpected breakdown resolution: %+v", b)
+	}
+	if d := got[1]; d.Type != IncidentDelay || d.Recovered != 0 || d.AvgResolutionSeconds != 600 {
+		t.Fatalf("unexpected delay summary: %+v", d)
+	}
+}
+
+func TestHandleIncidentReported_BreakdownMovesBatchesToReplacement(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips")
+
+	// Coordinates far from any real carrier so only the seeded ones match.
+	lat, lng := 10.0, 10.0
+	suffix := uuid.NewString()[:8]
+	broken, spare := "broken-"+suffix, "spare-"+suffix
+	defer func() {
+		_, _ = db.Exec(ctx, `DELETE FROM carrier_activity_cache WHERE carrier_id = ANY($1)`, []string{broken, spare})
+	}()
+	for _, c := range []string{broken, spare} {
+		if _, err := db.Exec(ctx, `INSERT INTO carrier_activity_cache (carrier_id, is_active, updated_at) VALUES ($1, true, NOW())`, c); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(ctx, `INSERT INTO carrier_positions (carrier_id, latitude, longitude, last_seen) VALUES ($1, $2, $3, NOW())`, c, lat, lng); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tripID := uuid.NewString()
+	if _, err := db.Exec(ctx, `
+		INSERT INTO trips (id, carrier_id, status, assigned_at, dest_lat, dest_lng) VALUES ($1, $2, 'IN_PROGRESS', NOW(), 53.9, 27.56)
+	`, tripID, broken); err != nil {
+		t.Fatal(err)
+	}
+	batches := []string{"batch-a-" + suffix, "batch-b-" + suffix}
+	for _, b := range batches {
+		if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	incidentID := uuid.NewString()
+	in := incidentReport{IncidentID: incidentID, TripID: tripID, CarrierID: broken, Type: IncidentBreakdown, Latitude: lat, Longitude: lng}
+	if err := svc.handleIncidentReported(ctx, uuid.NewString(), "events.trip_incident_reported", in); err != nil {
+		t.Fatal(err)
+	}
+
+	var oldStatus string
+	if err := db.QueryRow(ctx, `SELECT status FROM trips WHERE id=$1`, tripID).Scan(&oldStatus); err != nil {
+		t.Fatal(err)
+	}
+	if oldStatus != "INCIDENT" {
+		t.Fatalf("expected original trip INCIDENT, got %s", oldStatus)
+	}
+	var status, newTripID string
+	var resolved bool
+	if err := db.QueryRow(ctx, `
+		SELECT status, replacement_trip_id::text, resolved_at IS NOT NULL FROM trip_incidents WHERE incident_id=$1
+	`, incidentID).Scan(&status, &newTripID, &resolved); err != nil {
+		t.Fatal(err)
+	}
+	if status != incidentRecovered || !resolved {
+		t.Fatalf("expected recovered incident, got %s resolved=%v", status, resolved)
+	}
+	var carrier string
+	var moved int
+	if err := db.QueryRow(ctx, `
+		SELECT t.carrier_id, COUNT(tb.batch_id) FROM trips t JOIN trip_batches tb ON tb.trip_id = t.id
+		WHERE t.id=$1 GROUP BY t.carrier_id
+	`, newTripID).Scan(&carrier, &moved); err != nil {
+		t.Fatal(err)
+	}
+	if carrier != spare || moved != len(batches) {
+		t.Fatalf("expected %d batches on %s, got %d on %s", len(batches), spare, moved, carrier)
+	}
+	var assigned, alerts int
+	if err := db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE event_type='trips.assigned' AND partition_key=$1),
+		       COUNT(*) FILTER (WHERE event_type=$2 AND correlation_id=$3)
+		FROM outbox_events
+	`, newTripID, incidentAlertTopic, incidentID).Scan(&assigned, &alerts); err != nil {
+		t.Fatal(err)
+	}
+	if assigned != len(batches) || alerts != 1 {
+		t.Fatalf("expected %d assignments and 1 alert, got %d and %d", len(batches), assigned, alerts)
+	}
+	assertTripCreated(t, db, newTripID, TripAssigned)
+}
+
+func TestHandleIncidentReported_AssignedTripKeepsOrigin(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips")
+
+	// The goods are still at the origin, far from where the vehicle broke down.
+	originLat, originLng := 20.0, 20.0
+	suffix := uuid.NewString()[:8]
+	broken, spare := "broken-"+suffix, "spare-"+suffix
+	defer func() {
+		_, _ = db.Exec(ctx, `DELETE FROM carrier_activity_cache WHERE carrier_id = ANY($1)`, []string{broken, spare})
+	}()
+	if _, err := db.Exec(ctx, `INSERT INTO carrier_activity_cache (carrier_id, is_active, updated_at) VALUES ($1, true, NOW())`, spare); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO carrier_positions (carrier_id, latitude, longitude, last_seen) VALUES ($1, $2, $3, NOW())`, spare, originLat, originLng); err != nil {
+		t.Fatal(err)
+	}
+	tripID := uuid.NewString()
+	if _, err := db.Exec(ctx, `
+		INSERT INTO trips (id, carrier_id, status, assigned_at, origin_lat, origin_lng, dest_lat, dest_lng)
+		VALUES ($1, $2, 'ASSIGNED', NOW(), $3, $4, 53.9, 27.56)
+	`, tripID, broken, originLat, originLng); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, "batch-"+suffix); err != nil {
+		t.Fatal(err)
+	}
+
+	// A report from another carrier is ignored.
+	stranger := incidentReport{IncidentID: uuid.NewString(), TripID: tripID, CarrierID: "stranger-" + suffix, Type: IncidentBreakdown, Latitude: 10, Longitude: 10}
+	if err := svc.handleIncidentReported(ctx, uuid.NewString(), "events.trip_incident_reported", stranger); err != nil {
+		t.Fatal(err)
+	}
+	var recorded int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM trip_incidents WHERE trip_id=$1`, tripID).Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 0 {
+		t.Fatalf("expected the stranger's report to be ignored, got %d incidents", recorded)
+	}
+
+	incidentID := uuid.NewString()
+	in := incidentReport{IncidentID: incidentID, TripID: tripID, CarrierID: broken, Type: IncidentBreakdown, Latitude: 10, Longitude: 10}
+	if err := svc.handleIncidentReported(ctx, uuid.NewString(), "events.trip_incident_reported", in); err != nil {
+		t.Fatal(err)
+	}
+	var carrier string
+	var lat, lng float64
+	if err := db.QueryRow(ctx, `
+		SELECT t.carrier_id, t.origin_lat, t.origin_lng
+		FROM trip_incidents i JOIN trips t ON t.id = i.replacement_trip_id
+		WHERE i.incident_id=$1
+	`, incidentID).Scan(&carrier, &lat, &lng); err != nil {
+		t.Fatal(err)
+	}
+	if carrier != spare || lat != originLat || lng != originLng {
+		t.Fatalf("expected %s from the origin, got %s from (%v, %v)", spare, carrier, lat, lng)
+	}
+}
@@ -0,0 +1,490 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// OfferPolicy switches routing from push assignment to offer broadcast: a
+// new trip is offered to the TopN nearest carriers and the first valid
+// acceptance wins.
+type OfferPolicy struct {
+	Enabled      bool
+	TopN         int
+	Expiry       time.Duration
+	RadiusMeters int
+	TickInterval time.Duration
+	Topic        string
+}
+
+func (p OfferPolicy) normalized() OfferPolicy {
+	if p.TopN <= 0 {
+		p.TopN = 3
+	}
+	if p.Expiry <= 0 {
+		p.Expiry = 2 * time.Minute
+	}
+	if p.RadiusMeters <= 0 {
+		p.RadiusMeters = defaultSearchRadiusMeters
+	}
+	if p.TickInterval <= 0 {
+		p.TickInterval = 15 * time.Second
+	}
+	if p.Topic == "" {
+		p.Topic = "trips.offers"
+	}
+	return p
+}
+
+func (s *Service) WithOfferPolicy(p OfferPolicy) *Service {
+	s.offers = p.normalized()
+	return s
+}
+
+// Offer outcomes returned by AcceptOffer.
+var (
+	ErrOfferNotFound = errors.New("offer not found")
+	ErrOfferExpired  = errors.New("offer expired or already answered")
+	ErrTripTaken     = errors.New("trip already taken by another carrier")
+)
+
+type rankedCarrier struct {
+	id   string
+	dist int
+}
+
+// rankCarriers returns up to n carriers within radius ordered by distance.
+func rankCarriers(originLat, originLng float64, cands []carrierCandidate, radiusMeters, n int) []rankedCarrier {
+	var res []rankedCarrier
+	for _, c := range cands {
+		if !c.hasPos {
+			continue
+		}
+		d := haversine(originLat, originLng, c.lat, c.lng)
+		if d > float64(radiusMeters) {
+			continue
+		}
+		res = append(res, rankedCarrier{id: c.id, dist: int(math.Round(d))})
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].dist < res[j].dist })
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// offerTrip creates an OFFERED trip for the batch and broadcasts it to the
+// ranked carriers. It reports false when nobody is in range so the caller can
+// fall back to the PENDING flow.
+func (s *Service) offerTrip(ctx context.Context, eventID, eventType, batchID string, originLat, originLng, destLat, destLng float64) (bool, error) {
+	cands, err := s.loadCandidates(ctx)
+	if err != nil {
+		return false, err
+	}
+	ranked := rankCarriers(originLat, originLng, cands, s.offers.RadiusMeters, s.offers.TopN)
+	if len(ranked) == 0 {
+		return false, nil
+	}
+
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var inserted string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, event_type, processed_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, eventID, eventType).Scan(&inserted); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(s.offers.Expiry)
+	tripID, err := s.createTripTx(ctx, tx, newTrip{
+		Status: TripOffered, OriginLat: originLat, OriginLng: originLng, DestLat: destLat, DestLng: destLng, Reason: "offers_sent",
+	}, now)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)
+	`, tripID, batchID); err != nil {
+		return false, err
+	}
+	for i, c := range ranked {
+		offerID := uuid.NewString()
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO trip_offers (offer_id, trip_id, batch_id, carrier_id, rank, distance_meters, status, offered_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, 'pending', $7, $8)
+		`, offerID, tripID, batchID, c.id, i+1, c.dist, now, expiresAt); err != nil {
+			return false, err
+		}
+		if err := s.publishOfferEventTx(ctx, tx, "trips.offer_created", offerID, c.id, now, map[string]interface{}{
+			"offer_id":        offerID,
+			"trip_id":         tripID,
+			"batch_id":        batchID,
+			"carrier_id":      c.id,
+			"rank":            i + 1,
+			"distance_meters": c.dist,
+			"origin_lat":      originLat,
+			"origin_lng":      originLng,
+			"destination_lat": destLat,
+			"destination_lng": destLng,
+			"offered_at":      now,
+			"expires_at":      expiresAt,
+		}); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	slog.Info("Trip offered to carriers", "trip_id", tripID, "batch_id", batchID, "carriers", len(ranked))
+	return true, nil
+}
+
+func (s *Service) handleOfferAccepted(ctx context.Context, eventID, eventType, offerID, tripID, carrierID string) error {
+	err := s.AcceptOffer(ctx, eventID, eventType, offerID, tripID, carrierID)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrOfferNotFound), errors.Is(err, ErrOfferExpired), errors.Is(err, ErrTripTaken):
+		slog.Info("Offer acceptance refused", "offer_id", offerID, "trip_id", tripID, "carrier_id", carrierID, "reason", err)
+		return nil
+	default:
+		return err
+	}
+}
+
+// AcceptOffer records a carrier's acceptance. The trip row is locked and moved
+// out of OFFERED through the state machine, so among concurrent acceptances
+// exactly one wins; the remaining offers are withdrawn and the losers are told
+// why. The trip is locked before any of its offers, the same order
+// expireOffers uses, so concurrent acceptances queue on the trip instead of
+// deadlocking on each other's offer rows.
+func (s *Service) AcceptOffer(ctx context.Context, eventID, eventType, offerID, tripID, carrierID string) error {
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var inserted string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, event_type, processed_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, eventID, eventType).Scan(&inserted); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	var tripStatus string
+	if err := tx.QueryRow(ctx, `SELECT status FROM trips WHERE id=$1 FOR UPDATE`, tripID).Scan(&tripStatus); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOfferNotFound
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	var batchID string
+	var dist int
+	if err := tx.QueryRow(ctx, `
+		UPDATE trip_offers SET status='accepted', responded_at=$4
+		WHERE offer_id=$1 AND trip_id=$2 AND carrier_id=$3 AND status='pending' AND expires_at > $4
+		RETURNING batch_id, distance_meters
+	`, offerID, tripID, carrierID, now).Scan(&batchID, &dist); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		var offerStatus string
+		var answered bool
+		if err := tx.QueryRow(ctx, `
+			SELECT status, responded_at IS NOT NULL FROM trip_offers WHERE offer_id=$1 AND trip_id=$2 AND carrier_id=$3
+		`, offerID, tripID, carrierID).Scan(&offerStatus, &answered); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrOfferNotFound
+			}
+			return err
+		}
+		// Only an offer that ran out before the expiry loop closed it is
+		// rejected, and only once: responded_at marks the rejection while
+		// the offer stays pending for expireOffers. An offer that was
+		// already answered or closed is refused without another event.
+		if offerStatus != "pending" || answered {
+			return ErrOfferExpired
+		}
+		if _, err := tx.Exec(ctx, `UPDATE trip_offers SET responded_at=$2 WHERE offer_id=$1`, offerID, now); err != nil {
+			return err
+		}
+		if err := s.publishOfferEventTx(ctx, tx, "trips.offer_rejected", offerID, carrierID, now, map[string]interface{}{
+			"offer_id":   offerID,
+			"trip_id":    tripID,
+			"carrier_id": carrierID,
+			"reason":     "expired",
+		}); err != nil {
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		return ErrOfferExpired
+	}
+
+	var originLat, originLng, destLat, destLng float64
+	_, err = s.transitionTripTx(ctx, tx, tripTransition{TripID: tripID, From: TripOffered, To: TripAssigned, Actor: carrierID, Reason: "offer_accepted"}, now)
+	if err == nil {
+		err = tx.QueryRow(ctx, `
+			UPDATE trips SET carrier_id=$1, assigned_at=$2, assigned_distance_meters=$3
+			WHERE id=$4 AND carrier_id IS NULL
+			RETURNING origin_lat, origin_lng, dest_lat, dest_lng
+		`, carrierID, now, dist, tripID).Scan(&originLat, &originLng, &destLat, &destLng)
+	}
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) && !errors.Is(err, ErrInvalidTransition) {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE trip_offers SET status='lost' WHERE offer_id=$1`, offerID); err != nil {
+			return err
+		}
+		if err := s.publishOfferEventTx(ctx, tx, "trips.offer_rejected", offerID, carrierID, now, map[string]interface{}{
+			"offer_id":   offerID,
+			"trip_id":    tripID,
+			"carrier_id": carrierID,
+			"reason":     "taken",
+		}); err != nil {
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		return ErrTripTaken
+	}
+
+	if err := s.publishOfferEventTx(ctx, tx, "trips.offer_accepted", offerID, carrierID, now, map[string]interface{}{
+		"offer_id":   offerID,
+		"trip_id":    tripID,
+		"batch_id":   batchID,
+		"carrier_id": carrierID,
+	}); err != nil {
+		return err
+	}
+	if err := s.withdrawOffersTx(ctx, tx, tripID, "taken", now); err != nil {
+		return err
+	}
+
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     "trips.assigned",
+		"occurred_at":    now,
+		"correlation_id": batchID,
+		"data": map[string]interface{}{
+			"trip_id":                  tripID,
+			"batch_id":                 batchID,
+			"carrier_id":               carrierID,
+			"origin_lat":               originLat,
+			"origin_lng":               originLng,
+			"destination_lat":          destLat,
+			"destination_lng":          destLng,
+			"assigned_distance_meters": dist,
+			"assigned_at":              now,
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	if err := outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     "trips.assigned",
+		CorrelationID: batchID + "/" + tripID,
+		Topic:         s.outTopic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("Trip offer accepted", "trip_id", tripID, "offer_id", offerID, "carrier_id", carrierID)
+	return nil
+}
+
+// withdrawOffersTx withdraws every still-pending offer of the trip.
+func (s *Service) withdrawOffersTx(ctx context.Context, tx pgx.Tx, tripID, reason string, now time.Time) error {
+	rows, err := tx.Query(ctx, `
+		UPDATE trip_offers SET status='withdrawn', responded_at=$2
+		WHERE trip_id=$1 AND status='pending'
+		RETURNING offer_id, carrier_id
+	`, tripID, now)
+	if err != nil {
+		return err
+	}
+	type withdrawn struct{ offerID, carrierID string }
+	var list []withdrawn
+	for rows.Next() {
+		var w withdrawn
+		if err := rows.Scan(&w.offerID, &w.carrierID); err != nil {
+			rows.Close()
+			return err
+		}
+		list = append(list, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, w := range list {
+		if err := s.publishOfferEventTx(ctx, tx, "trips.offer_withdrawn", w.offerID, w.carrierID, now, map[string]interface{}{
+			"offer_id":   w.offerID,
+			"trip_id":    tripID,
+			"carrier_id": w.carrierID,
+			"reason":     reason,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartOfferExpiryLoop periodically expires unanswered offers. It must run on
+// a single replica, see leader.Elector.
+func (s *Service) StartOfferExpiryLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.offers.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.expireOffers(ctx); err != nil {
+				slog.Error("failed to expire trip offers", "error", err)
+			}
+		}
+	}
+}
+
+// expireOffers closes offers past their expiry. Trips left without any open
+// offer go back to PENDING and enter the regular retry policy.
+func (s *Service) expireOffers(ctx context.Context) error {
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	now := time.Now().UTC()
+	// Lock the affected trips first, in id order, so expiry and acceptance
+	// take trip and offer locks in the same order.
+	if _, err := tx.Exec(ctx, `
+		SELECT id FROM trips
+		WHERE id IN (SELECT trip_id FROM trip_offers WHERE status='pending' AND expires_at <= $1)
+		ORDER BY id
+		FOR UPDATE
+	`, now); err != nil {
+		return err
+	}
+	rows, err := tx.Query(ctx, `
+		UPDATE trip_offers SET status='expired', responded_at=$1
+		WHERE status='pending' AND expires_at <= $1
+		RETURNING offer_id, trip_id, carrier_id
+	`, now)
+	if err != nil {
+		return err
+	}
+	type expired struct{ offerID, tripID, carrierID string }
+	var list []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.offerID, &e.tripID, &e.carrierID); err != nil {
+			rows.Close()
+			return err
+		}
+		list = append(list, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	trips := make(map[string]struct{})
+	for _, e := range list {
+		trips[e.tripID] = struct{}{}
+		if err := s.publishOfferEventTx(ctx, tx, "trips.offer_withdrawn", e.offerID, e.carrierID, now, map[string]interface{}{
+			"offer_id":   e.offerID,
+			"trip_id":    e.tripID,
+			"carrier_id": e.carrierID,
+			"reason":     "expired",
+		}); err != nil {
+			return err
+		}
+	}
+	for tripID := range trips {
+		var batchID string
+		if err := tx.QueryRow(ctx, `
+			SELECT tb.batch_id
+			FROM trips t
+			JOIN trip_batches tb ON t.id = tb.trip_id
+			WHERE t.id=$1 AND t.status='OFFERED'
+			  AND NOT EXISTS (SELECT 1 FROM trip_offers o WHERE o.trip_id=t.id AND o.status='pending')
+		`, tripID).Scan(&batchID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			return err
+		}
+		if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: tripID, From: TripOffered, To: TripPending, Reason: "offers_expired"}, now); err != nil {
+			return err
+		}
+		if err := s.registerPendingTx(ctx, tx, tripID, batchID, now); err != nil {
+			return err
+		}
+		slog.Info("All trip offers expired, trip is pending", "trip_id", tripID)
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *Service) publishOfferEventTx(ctx context.Context, tx pgx.Tx, eventType, offerID, carrierID string, now time.Time, data map[string]interface{}) error {
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     eventType,
+		"occurred_at":    now,
+		"correlation_id": offerID,
+		"data":           data,
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     eventType,
+		CorrelationID: offerID,
+		Topic:         s.offers.Topic,
+		PartitionKey:  carrierID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
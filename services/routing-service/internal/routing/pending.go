@@ -0,0 +1,448 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	assignmentProgressTopic   = "trips.assignment_progress"
+	assignmentEscalationTopic = "alerts.trip_assignment_escalation"
+	manualAssignmentTopic     = "alerts.trip_requires_manual_assignment"
+)
+
+// Assignment progress steps published on trips.assignment_progress.
+const (
+	progressRegistered     = "pending_registered"
+	progressRetryScheduled = "retry_scheduled"
+	progressEscalated      = "escalated"
+	progressAssigned       = "assigned"
+	progressManualRequired = "manual_required"
+	progressWaitingForPVP  = "waiting_for_pvp"
+)
+
+// PendingPolicy controls how PENDING trips are retried. RetryIntervals[i] is
+// the delay after i failed attempts, so the first entry is the wait between
+// registration and attempt 1. SearchRadiiMeters[i] is the radius of attempt
+// i+1 (attempts are numbered from 1). Beyond the end of a list its last value
+// is reused.
+type PendingPolicy struct {
+	TickInterval      time.Duration
+	RetryIntervals    []time.Duration
+	SearchRadiiMeters []int
+	MaxAttempts       int
+	// AlertAttempt is the failed attempt after which operators are warned
+	// that the trip is about to need manual assignment. Zero disables it.
+	AlertAttempt int
+}
+
+func DefaultPendingPolicy() PendingPolicy {
+	return PendingPolicy{
+		TickInterval:      time.Minute,
+		RetryIntervals:    []time.Duration{5 * time.Minute},
+		SearchRadiiMeters: []int{defaultSearchRadiusMeters, 10000, 20000},
+		MaxAttempts:       10,
+		AlertAttempt:      8,
+	}
+}
+
+func (p PendingPolicy) normalized() PendingPolicy {
+	def := DefaultPendingPolicy()
+	if p.TickInterval <= 0 {
+		p.TickInterval = def.TickInterval
+	}
+	if len(p.RetryIntervals) == 0 {
+		p.RetryIntervals = def.RetryIntervals
+	}
+	if len(p.SearchRadiiMeters) == 0 {
+		p.SearchRadiiMeters = def.SearchRadiiMeters
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = def.MaxAttempts
+	}
+	if p.AlertAttempt >= p.MaxAttempts {
+		p.AlertAttempt = p.MaxAttempts - 1
+	}
+	return p
+}
+
+// RetryInterval is the delay before the next attempt once failed attempts
+// have been made; 0 is the wait after registration.
+func (p PendingPolicy) RetryInterval(failed int) time.Duration {
+	return p.RetryIntervals[clampIndex(failed, len(p.RetryIntervals))]
+}
+
+// SearchRadius is the carrier search radius used on attempt, which is
+// numbered from 1.
+func (p PendingPolicy) SearchRadius(attempt int) int {
+	return p.SearchRadiiMeters[clampIndex(attempt-1, len(p.SearchRadiiMeters))]
+}
+
+func clampIndex(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// StartPendingReassignmentLoop retries PENDING trips. It must run on a single
+// replica, so main starts it under leader election.
+func (s *Service) StartPendingReassignmentLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.pending.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.processPendingAssignments(ctx); err != nil {
+				slog.Error("failed to process pending assignments", "error", err)
+			}
+		}
+	}
+}
+
+// registerPendingTx queues a PENDING trip for the first retry.
+func (s *Service) registerPendingTx(ctx context.Context, tx pgx.Tx, tripID, batchID string, now time.Time) error {
+	next := now.Add(s.pending.RetryInterval(0))
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO pending_assignments (trip_id, batch_id, attempt_count, timeout_at, created_at)
+		VALUES ($1, $2, 0, $3, $4)
+		ON CONFLICT (trip_id) DO NOTHING
+	`, tripID, batchID, next, now); err != nil {
+		return err
+	}
+	return s.publishProgressTx(ctx, tx, now, map[string]interface{}{
+		"trip_id":              tripID,
+		"batch_id":             batchID,
+		"step":                 progressRegistered,
+		"attempt":              0,
+		"max_attempts":         s.pending.MaxAttempts,
+		"next_attempt_at":      next,
+		"search_radius_meters": s.pending.SearchRadius(1),
+	})
+}
+
+// processPendingAssignments makes the next attempt for each due PENDING trip.
+// Every trip is handled in its own transaction, so one that fails is retried
+// on the next tick without holding back the others.
+func (s *Service) processPendingAssignments(ctx context.Context) error {
+	rows, err := s.tripDB.Query(ctx, `
+		SELECT trip_id
+		FROM pending_assignments pa
+		WHERE timeout_at <= NOW()
+		  AND NOT EXISTS (SELECT 1 FROM trips t WHERE t.id = pa.trip_id AND t.status = 'ON_HOLD')
+		ORDER BY timeout_at ASC
+		LIMIT 50
+	`)
+	if err != nil {
+		return err
+	}
+	var tripIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		tripIDs = append(tripIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, tripID := range tripIDs {
+		if err := s.processPendingTrip(ctx, tripID); err != nil {
+			errs = append(errs, fmt.Errorf("trip %s: %w", tripID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// processPendingTrip makes one assignment attempt for a trip. The pending row
+// is checked again under lock, since the trip may have been handled or held
+// since it was listed.
+func (s *Service) processPendingTrip(ctx context.Context, tripID string) error {
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var attemptCount int
+	err = tx.QueryRow(ctx, `
+		SELECT attempt_count
+		FROM pending_assignments pa
+		WHERE trip_id = $1 AND timeout_at <= NOW()
+		  AND NOT EXISTS (SELECT 1 FROM trips t WHERE t.id = pa.trip_id AND t.status = 'ON_HOLD')
+		FOR UPDATE SKIP LOCKED
+	`, tripID).Scan(&attemptCount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	attempt := attemptCount + 1
+	radius := s.pending.SearchRadius(attempt)
+	slog.Info("Pending reassignment attempt", "attempt", attempt, "trip_id", tripID, "radius_m", radius)
+
+	var originLat, originLng, destLat, destLng float64
+	var batchID string
+	if err := tx.QueryRow(ctx, `
+		SELECT t.origin_lat, t.origin_lng, t.dest_lat, t.dest_lng, tb.batch_id
+		FROM trips t
+		JOIN trip_batches tb ON t.id = tb.trip_id
+		WHERE t.id = $1
+	`, tripID).Scan(&originLat, &originLng, &destLat, &destLng, &batchID); err != nil {
+		slog.Error("failed to load trip context", "trip_id", tripID, "error", err)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	pvpID, departAt, wait, err := s.receivingDeparture(ctx, batchID, originLat, originLng, destLat, destLng, now)
+	if err != nil {
+		return err
+	}
+	if wait {
+		if err := s.deferPendingTx(ctx, tx, tripID, batchID, pvpID, attemptCount, departAt, now); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	progress := map[string]interface{}{
+		"trip_id":              tripID,
+		"batch_id":             batchID,
+		"attempt":              attempt,
+		"max_attempts":         s.pending.MaxAttempts,
+		"search_radius_meters": radius,
+	}
+
+	carrierID, dist, err := s.selectCarrierWithin(ctx, originLat, originLng, radius)
+	if err == nil {
+		if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: tripID, To: TripAssigned, Reason: "pending_retry"}, now); err != nil {
+			if !errors.Is(err, ErrInvalidTransition) {
+				return err
+			}
+			if err := dropStalePendingTx(ctx, tx, tripID, err); err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE trips SET carrier_id=$1, assigned_at=$2, assigned_distance_meters=$3 WHERE id=$4
+		`, carrierID, now, dist, tripID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM pending_assignments WHERE trip_id=$1`, tripID); err != nil {
+			return err
+		}
+
+		envelope := map[string]interface{}{
+			"event_id":       uuid.NewString(),
+			"event_type":     "trips.assigned",
+			"occurred_at":    now,
+			"correlation_id": batchID,
+			"data": map[string]interface{}{
+				"trip_id":                  tripID,
+				"batch_id":                 batchID,
+				"carrier_id":               carrierID,
+				"origin_lat":               originLat,
+				"origin_lng":               originLng,
+				"destination_lat":          destLat,
+				"destination_lng":          destLng,
+				"assigned_distance_meters": dist,
+				"assigned_at":              now,
+			},
+		}
+		payload, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		evt := outbox.Event{
+			ID:            uuid.NewString(),
+			EventType:     "trips.assigned",
+			CorrelationID: batchID + "/" + tripID,
+			Topic:         s.outTopic,
+			PartitionKey:  tripID,
+			Payload:       payload,
+			OccurredAt:    now,
+		}
+		if err := outbox.EnqueueTx(ctx, tx, evt); err != nil {
+			return err
+		}
+		if err := s.completeReplacementTx(ctx, tx, tripID, batchID, carrierID, dist, originLat, originLng, destLat, destLng, now); err != nil {
+			return err
+		}
+		progress["step"] = progressAssigned
+		progress["carrier_id"] = carrierID
+		progress["assigned_distance_meters"] = dist
+		if err := s.publishProgressTx(ctx, tx, now, progress); err != nil {
+			return err
+		}
+
+		slog.Info("Trip assigned to carrier", "trip_id", tripID, "carrier_id", carrierID, "attempts", attempt)
+		return tx.Commit(ctx)
+	}
+
+	if attempt < s.pending.MaxAttempts {
+		next := now.Add(s.pending.RetryInterval(attempt))
+		if _, err := tx.Exec(ctx, `
+			UPDATE pending_assignments
+			SET attempt_count=$1, timeout_at=$2
+			WHERE trip_id=$3
+		`, attempt, next, tripID); err != nil {
+			return err
+		}
+		progress["step"] = progressRetryScheduled
+		progress["next_attempt_at"] = next
+		progress["next_search_radius_meters"] = s.pending.SearchRadius(attempt + 1)
+		progress["reason"] = err.Error()
+		if err := s.publishProgressTx(ctx, tx, now, progress); err != nil {
+			return err
+		}
+		if s.pending.AlertAttempt > 0 && attempt == s.pending.AlertAttempt {
+			if err := s.publishEscalationTx(ctx, tx, now, tripID, batchID, attempt); err != nil {
+				return err
+			}
+			progress["step"] = progressEscalated
+			if err := s.publishProgressTx(ctx, tx, now, progress); err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	}
+
+	if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: tripID, To: TripRequiresManual, Reason: "max_attempts_reached"}, now); err != nil {
+		if !errors.Is(err, ErrInvalidTransition) {
+			return err
+		}
+		if err := dropStalePendingTx(ctx, tx, tripID, err); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM pending_assignments WHERE trip_id=$1`, tripID); err != nil {
+		return err
+	}
+
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     "trip_requires_manual_assignment",
+		"occurred_at":    now,
+		"correlation_id": tripID,
+		"data": map[string]interface{}{
+			"trip_id": tripID,
+			"reason":  "max_attempts_reached",
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	evt := outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     "trip_requires_manual_assignment",
+		CorrelationID: tripID,
+		Topic:         manualAssignmentTopic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	}
+	if err := outbox.EnqueueTx(ctx, tx, evt); err != nil {
+		return err
+	}
+	progress["step"] = progressManualRequired
+	if err := s.publishProgressTx(ctx, tx, now, progress); err != nil {
+		return err
+	}
+	slog.Info("Trip requires manual assignment after failed attempts", "trip_id", tripID, "attempts", attempt)
+	return tx.Commit(ctx)
+}
+
+// publishProgressTx enqueues one trips.assignment_progress event. The outbox
+// deduplicates on (event_type, correlation_id), so the correlation id carries
+// the attempt and step.
+func (s *Service) publishProgressTx(ctx context.Context, tx pgx.Tx, now time.Time, data map[string]interface{}) error {
+	tripID, _ := data["trip_id"].(string)
+	correlationID := fmt.Sprintf("%s/%v/%v", tripID, data["attempt"], data["step"])
+	// A trip can wait for the PVP several times within one attempt, once
+	// per departure it is held until.
+	if departAt, ok := data["next_attempt_at"].(time.Time); ok && data["step"] == progressWaitingForPVP {
+		correlationID += "/" + departAt.UTC().Format(time.RFC3339)
+	}
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     "trips.assignment_progress",
+		"occurred_at":    now,
+		"correlation_id": tripID,
+		"data":           data,
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     "trips.assignment_progress",
+		CorrelationID: correlationID,
+		Topic:         assignmentProgressTopic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+func (s *Service) publishEscalationTx(ctx context.Context, tx pgx.Tx, now time.Time, tripID, batchID string, attempt int) error {
+	remaining := s.pending.MaxAttempts - attempt
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     "trip_assignment_escalation",
+		"occurred_at":    now,
+		"correlation_id": tripID,
+		"data": map[string]interface{}{
+			"trip_id":            tripID,
+			"batch_id":           batchID,
+			"attempt":            attempt,
+			"remaining_attempts": remaining,
+			"message":            fmt.Sprintf("No carrier found after %d attempts, %d left before manual assignment", attempt, remaining),
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     "trip_assignment_escalation",
+		CorrelationID: tripID,
+		Topic:         assignmentEscalationTopic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+// dropStalePendingTx forgets a pending entry whose trip has left PENDING by
+// other means, e.g. an operator cancelled it.
+func dropStalePendingTx(ctx context.Context, tx pgx.Tx, tripID string, cause error) error {
+	slog.Warn("Dropping stale pending assignment", "trip_id", tripID, "error", cause)
+	_, err := tx.Exec(ctx, `DELETE FROM pending_assignments WHERE trip_id=$1`, tripID)
+	return err
+}
@@ -0,0 +1,126 @@
+package routing
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestPendingPolicy_RadiusWidensAndSaturates(t *testing.T) {
+	p := PendingPolicy{SearchRadiiMeters: []int{5000, 10000, 20000}}.normalized()
+	// attempts are numbered from 1, so the first attempt uses the first radius
+	want := map[int]int{1: 5000, 2: 10000, 3: 20000, 4: 20000, 10: 20000}
+	for attempt, r := range want {
+		if got := p.SearchRadius(attempt); got != r {
+			t.Fatalf("attempt %d: expected radius %d, got %d", attempt, r, got)
+		}
+	}
+}
+
+func TestPendingPolicy_RetryIntervals(t *testing.T) {
+	p := PendingPolicy{RetryIntervals: []time.Duration{time.Minute, 5 * time.Minute}}.normalized()
+	if got := p.RetryInterval(0); got != time.Minute {
+		t.Fatalf("expected first retry after 1m, got %v", got)
+	}
+	if got := p.RetryInterval(1); got != 5*time.Minute {
+		t.Fatalf("expected retry after attempt 1 to back off to 5m, got %v", got)
+	}
+	if got := p.RetryInterval(7); got != 5*time.Minute {
+		t.Fatalf("expected last interval to repeat, got %v", got)
+	}
+}
+
+func TestPendingPolicy_NormalizedDefaults(t *testing.T) {
+	p := PendingPolicy{MaxAttempts: 3, AlertAttempt: 5}.normalized()
+	if p.TickInterval != time.Minute || len(p.RetryIntervals) == 0 || len(p.SearchRadiiMeters) == 0 {
+		t.Fatalf("expected defaults to be filled, got %+v", p)
+	}
+	if p.AlertAttempt != 2 {
+		t.Fatalf("expected alert attempt clamped below max attempts, got %d", p.AlertAttempt)
+	}
+}
+
+func TestChooseCarrierWithin_WiderRadiusFindsCarrier(t *testing.T) {
+	cands := []carrierCandidate{{id: "c1", hasPos: true, lat: 0, lng: 0.07}}
+	if _, _, err := chooseCarrierWithin(0, 0, cands, 5000); err == nil || err.Error() != "no active carriers within 5km" {
+		t.Fatalf("expected no carriers within 5km, got %v", err)
+	}
+	id, dist, err := chooseCarrierWithin(0, 0, cands, 10000)
+	if err != nil || id != "c1" {
+		t.Fatalf("expected c1 within 10km, got %q %v", id, err)
+	}
+	if dist <= 5000 || dist >= 10000 {
+		t.Fatalf("unexpected distance %d", dist)
+	}
+}
+
+// seedPendingTrip inserts a PENDING trip far from any carrier, due for its
+// first attempt.
+func seedPendingTrip(t *testing.T, ctx context.Context, db *pgxpool.Pool) string {
+	t.Helper()
+	tripID := uuid.NewString()
+	if _, err := db.Exec(ctx, `
+		INSERT INTO trips (id, carrier_id, status, origin_lat, origin_lng, dest_lat, dest_lng)
+		VALUES ($1, NULL, 'PENDING', -60, -60, -60.1, -60.1)
+	`, tripID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, "batch-"+tripID[:8]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `
+		INSERT INTO pending_assignments (trip_id, batch_id, attempt_count, timeout_at) VALUES ($1, $2, 0, NOW() - INTERVAL '1 minute')
+	`, tripID, "batch-"+tripID[:8]); err != nil {
+		t.Fatal(err)
+	}
+	return tripID
+}
+
+func TestProcessPendingAssignments_FailingTripDoesNotBlockOthers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips")
+
+	bad := seedPendingTrip(t, ctx, db)
+	good := seedPendingTrip(t, ctx, db)
+	// The retry event of the bad trip already exists, so its attempt fails
+	// on the outbox insert.
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID: uuid.NewString(), EventType: "trips.assignment_progress", CorrelationID: bad + "/1/" + progressRetryScheduled,
+		Topic: assignmentProgressTopic, PartitionKey: bad, Payload: []byte(`{}`), OccurredAt: time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.processPendingAssignments(ctx)
+	if err == nil || !strings.Contains(err.Error(), bad) {
+		t.Fatalf("expected the bad trip's error, got %v", err)
+	}
+	attempts := func(tripID string) int {
+		var n int
+		if err := db.QueryRow(ctx, `SELECT attempt_count FROM pending_assignments WHERE trip_id=$1`, tripID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if attempts(bad) != 0 {
+		t.Fatalf("the failed attempt must roll back")
+	}
+	if attempts(good) != 1 {
+		t.Fatalf("the other trip must still get its attempt, got %d", attempts(good))
+	}
+}
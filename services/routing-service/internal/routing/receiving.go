@@ -0,0 +1,163 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"bel-parcel/pkg/schedule"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A batch for a PVP must arrive while the PVP receives deliveries. routing
+// keeps the PVP hours from reference-service and the destination of every
+// batch; a trip that would arrive outside the receiving windows stays
+// PENDING until it can leave in time for the next one.
+
+// arrivalSpeedKmh is the average speed used to estimate arrival, the same
+// default tracking uses for ETAs.
+const arrivalSpeedKmh = 40
+
+func travelTime(originLat, originLng, destLat, destLng float64) time.Duration {
+	meters := haversine(originLat, originLng, destLat, destLng)
+	return time.Duration(meters / (arrivalSpeedKmh * 1000) * float64(time.Hour))
+}
+
+func upsertPickupPointHours(ctx context.Context, tx pgx.Tx, pvpID string, hours schedule.Hours, seq int64) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO ref_pickup_point_hours (pvp_id, hours, source_seq, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (pvp_id) DO UPDATE
+		SET hours = EXCLUDED.hours, source_seq = EXCLUDED.source_seq, updated_at = NOW()
+		WHERE ref_pickup_point_hours.source_seq <= EXCLUDED.source_seq
+	`, pvpID, hours, seq)
+	return err
+}
+
+// updatePickupPointHours applies the hours of a pickup_point reference event.
+func (s *Service) updatePickupPointHours(ctx context.Context, eventID, pvpID string, hours schedule.Hours, seq int64) error {
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	fresh, err := markProcessedTx(ctx, tx, eventID, "events.reference_updated")
+	if err != nil || !fresh {
+		return err
+	}
+	if err := upsertPickupPointHours(ctx, tx, pvpID, hours, seq); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// recordBatchDestination remembers which PVP a batch goes to. Replacement
+// and reassigned trips carry the same batch, so the check follows them.
+func (s *Service) recordBatchDestination(ctx context.Context, batchID, destType, destID string) error {
+	if batchID == "" || destID == "" || destType != "pvp" {
+		return nil
+	}
+	_, err := s.tripDB.Exec(ctx, `
+		INSERT INTO batch_destinations (batch_id, pvp_id) VALUES ($1, $2)
+		ON CONFLICT (batch_id) DO NOTHING
+	`, batchID, destID)
+	return err
+}
+
+// receivingDeparture reports whether a trip for the batch leaving at now
+// would reach its PVP outside the receiving windows, and if so when it
+// should leave instead. Batches without a known destination or hours, and
+// PVPs with no receiving slot in the next two weeks, are not held.
+func (s *Service) receivingDeparture(ctx context.Context, batchID string, originLat, originLng, destLat, destLng float64, now time.Time) (string, time.Time, bool, error) {
+	var pvpID string
+	var hours schedule.Hours
+	err := s.tripDB.QueryRow(ctx, `
+		SELECT d.pvp_id, h.hours
+		FROM batch_destinations d
+		JOIN ref_pickup_point_hours h ON h.pvp_id = d.pvp_id
+		WHERE d.batch_id = $1
+	`, batchID).Scan(&pvpID, &hours)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", time.Time{}, false, nil
+	}
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	travel := travelTime(originLat, originLng, destLat, destLng)
+	arrival := now.Add(travel)
+	if hours.ReceivesAt(arrival) {
+		return pvpID, time.Time{}, false, nil
+	}
+	next, ok := hours.NextReceiving(arrival)
+	if !ok {
+		slog.Warn("PVP has no receiving window ahead, trip not held", "pvp_id", pvpID, "batch_id", batchID)
+		return pvpID, time.Time{}, false, nil
+	}
+	return pvpID, next.Add(-travel), true, nil
+}
+
+// deferPendingTx puts the trip into pending_assignments until departAt
+// without spending an assignment attempt.
+func (s *Service) deferPendingTx(ctx context.Context, tx pgx.Tx, tripID, batchID, pvpID string, attempt int, departAt, now time.Time) error {
+	// A trip deferred again to the departure it already waits for has
+	// nothing new to report.
+	var deferred string
+	err := tx.QueryRow(ctx, `
+		INSERT INTO pending_assignments (trip_id, batch_id, attempt_count, timeout_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (trip_id) DO UPDATE SET timeout_at = EXCLUDED.timeout_at
+		WHERE pending_assignments.timeout_at IS DISTINCT FROM EXCLUDED.timeout_at
+		RETURNING trip_id
+	`, tripID, batchID, attempt, departAt, now).Scan(&deferred)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Trip held until PVP receives", "trip_id", tripID, "pvp_id", pvpID, "depart_at", departAt)
+	return s.publishProgressTx(ctx, tx, now, map[string]interface{}{
+		"trip_id":         tripID,
+		"batch_id":        batchID,
+		"step":            progressWaitingForPVP,
+		"attempt":         attempt,
+		"max_attempts":    s.pending.MaxAttempts,
+		"next_attempt_at": departAt,
+		"pvp_id":          pvpID,
+	})
+}
+
+// createDeferredTrip creates a PENDING trip for a batch that has to wait for
+// the receiving window of its PVP.
+func (s *Service) createDeferredTrip(ctx context.Context, eventID, eventType, batchID, pvpID string, originLat, originLng, destLat, destLng float64, departAt time.Time) error {
+	now := time.Now().UTC()
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	fresh, err := markProcessedTx(ctx, tx, eventID, eventType)
+	if err != nil || !fresh {
+		return err
+	}
+	tripID, err := s.createTripTx(ctx, tx, newTrip{
+		Status:    TripPending,
+		OriginLat: originLat,
+		OriginLng: originLng,
+		DestLat:   destLat,
+		DestLng:   destLng,
+		Reason:    "waiting_for_pvp",
+	}, now)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, batchID); err != nil {
+		return err
+	}
+	if err := s.deferPendingTx(ctx, tx, tripID, batchID, pvpID, 0, departAt, now); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
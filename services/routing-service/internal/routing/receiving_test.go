@@ -0,0 +1,120 @@
+package routing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bel-parcel/pkg/schedule"
+
+	"github.com/google/uuid"
+)
+
+func TestTravelTime_FortyKmPerHour(t *testing.T) {
+	// one degree of latitude is about 111 km
+	d := travelTime(53, 27, 54, 27)
+	if d < 2*time.Hour+45*time.Minute || d > 2*time.Hour+50*time.Minute {
+		t.Fatalf("unexpected travel time %s", d)
+	}
+}
+
+func TestReceivingDeparture_HoldsUntilWindow(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	s := &Service{tripDB: db, pending: DefaultPendingPolicy()}
+
+	batchID := uuid.NewString()
+	pvpID := "pvp-" + batchID
+	if err := s.recordBatchDestination(ctx, batchID, "pvp", pvpID); err != nil {
+		t.Fatal(err)
+	}
+	// no hours known yet: the trip goes right away
+	if _, _, wait, err := s.receivingDeparture(ctx, batchID, 53.9, 27.5, 53.9, 27.5, time.Now()); err != nil || wait {
+		t.Fatalf("expected no hold without hours, got wait=%v err=%v", wait, err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hours := schedule.Hours{ReceivingWindows: []schedule.Rule{{Weekday: time.Monday, Start: "10:00", End: "12:00", Timezone: "UTC"}}}
+	if err := upsertPickupPointHours(ctx, tx, pvpID, hours, 5); err != nil {
+		t.Fatal(err)
+	}
+	// an older event must not overwrite the newer hours
+	if err := upsertPickupPointHours(ctx, tx, pvpID, schedule.Hours{}, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Monday 2024-01-01 08:00 UTC, about 1h50m of travel to the PVP
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	got, departAt, wait, err := s.receivingDeparture(ctx, batchID, 53.0, 27.5, 53.66, 27.5, now)
+	if err != nil || !wait || got != pvpID {
+		t.Fatalf("expected hold, got pvp=%q wait=%v err=%v", got, wait, err)
+	}
+	travel := travelTime(53.0, 27.5, 53.66, 27.5)
+	if !departAt.Add(travel).Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("trip must arrive when receiving opens, departs at %s", departAt)
+	}
+	if _, _, wait, _ := s.receivingDeparture(ctx, batchID, 53.0, 27.5, 53.66, 27.5, now.Add(time.Hour)); wait {
+		t.Fatalf("arrival inside the window must not be held")
+	}
+}
+
+func TestDeferPending_SameTripDeferredTwice(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	s := &Service{tripDB: db, pending: DefaultPendingPolicy()}
+
+	batchID := uuid.NewString()
+	pvpID := "pvp-" + batchID
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	first := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	second := time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC)
+	if err := s.createDeferredTrip(ctx, uuid.NewString(), "events.batch_formed", batchID, pvpID, 53, 27.5, 53.66, 27.5, first); err != nil {
+		t.Fatal(err)
+	}
+	var tripID string
+	if err := db.QueryRow(ctx, `SELECT trip_id FROM trip_batches WHERE batch_id=$1`, batchID).Scan(&tripID); err != nil {
+		t.Fatal(err)
+	}
+	assertTripCreated(t, db, tripID, TripPending)
+
+	// the trip missed the window and waits for the next one, then the same
+	// departure is computed again
+	for _, departAt := range []time.Time{second, second} {
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.deferPendingTx(ctx, tx, tripID, batchID, pvpID, 0, departAt, now); err != nil {
+			t.Fatalf("defer again: %v", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var timeoutAt time.Time
+	if err := db.QueryRow(ctx, `SELECT timeout_at FROM pending_assignments WHERE trip_id=$1`, tripID).Scan(&timeoutAt); err != nil {
+		t.Fatal(err)
+	}
+	if !timeoutAt.Equal(second) {
+		t.Fatalf("expected the trip to wait until %s, got %s", second, timeoutAt)
+	}
+	var waits int
+	if err := db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM outbox_events
+		WHERE event_type='trips.assignment_progress' AND partition_key=$1 AND correlation_id LIKE '%/waiting_for_pvp/%'
+	`, tripID).Scan(&waits); err != nil {
+		t.Fatal(err)
+	}
+	if waits != 2 {
+		t.Fatalf("expected one progress event per departure, got %d", waits)
+	}
+}
@@ -0,0 +1,88 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/infra/reference"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Carrier projections keep the change number (seq) of the reference record
+// they were built from. A row is only overwritten by a newer or equal seq, so
+// the snapshot and the event stream can be applied in any order.
+
+func upsertCarrierActivity(ctx context.Context, tx pgx.Tx, carrierID string, isActive bool, updatedAt time.Time, seq int64) (bool, error) {
+	ct, err := tx.Exec(ctx, `
+		INSERT INTO carrier_activity_cache (carrier_id, is_active, updated_at, source_seq)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (carrier_id) DO UPDATE
+		SET is_active = EXCLUDED.is_active, updated_at = EXCLUDED.updated_at, source_seq = EXCLUDED.source_seq
+		WHERE carrier_activity_cache.source_seq <= EXCLUDED.source_seq
+	`, carrierID, isActive, updatedAt, seq)
+	if err != nil {
+		return false, err
+	}
+	return ct.RowsAffected() > 0, nil
+}
+
+func upsertCarrierSchedule(ctx context.Context, tx pgx.Tx, carrierID string, raw json.RawMessage, updatedAt time.Time, seq int64) (bool, error) {
+	ct, err := tx.Exec(ctx, `
+		INSERT INTO carrier_schedules (carrier_id, schedule, updated_at, source_seq)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (carrier_id) DO UPDATE
+		SET schedule = EXCLUDED.schedule, updated_at = EXCLUDED.updated_at, source_seq = EXCLUDED.source_seq
+		WHERE carrier_schedules.source_seq <= EXCLUDED.source_seq
+	`, carrierID, []byte(raw), updatedAt, seq)
+	if err != nil {
+		return false, err
+	}
+	return ct.RowsAffected() > 0, nil
+}
+
+// ApplyReferenceSnapshot fills carrier activity, shift calendars and PVP
+// hours from a reference-service snapshot.
+func (s *Service) ApplyReferenceSnapshot(ctx context.Context, snap *reference.Snapshot) error {
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	now := time.Now().UTC()
+	active := make(map[string]bool, len(snap.Carriers))
+	for _, c := range snap.Carriers {
+		applied, err := upsertCarrierActivity(ctx, tx, c.ID, c.IsActive, now, c.Seq)
+		if err != nil {
+			return err
+		}
+		if applied {
+			active[c.ID] = c.IsActive
+		}
+		if len(c.Schedule) == 0 {
+			continue
+		}
+		if _, err := upsertCarrierSchedule(ctx, tx, c.ID, c.Schedule, now, c.ScheduleSeq); err != nil {
+			return err
+		}
+	}
+	for _, p := range snap.PickupPoints {
+		if err := upsertPickupPointHours(ctx, tx, p.ID, p.Hours, p.Seq); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	for id, isActive := range active {
+		if isActive {
+			s.carriersCache.Store(id, true)
+		} else {
+			s.carriersCache.Delete(id)
+		}
+	}
+	slog.Info("reference snapshot applied", "seq", snap.Seq, "carriers", len(snap.Carriers), "pickup_points", len(snap.PickupPoints))
+	return nil
+}
@@ -0,0 +1,177 @@
+package routing
+
+import (
+	"context"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/outbox"
+)
+
+// schema creates the trip database tables on startup. The ALTER statements
+// bring databases created by older releases up to date, so each statement
+// must stay safe to repeat. Tests apply the same statements.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS trips (
+		id UUID PRIMARY KEY,
+		origin_warehouse_id TEXT,
+		pickup_point_id TEXT,
+		carrier_id TEXT,
+		status TEXT NOT NULL,
+		assigned_at TIMESTAMPTZ
+	)`,
+	// PENDING trips have neither a carrier nor an assignment time yet.
+	`ALTER TABLE trips
+		ALTER COLUMN carrier_id DROP NOT NULL,
+		ALTER COLUMN assigned_at DROP NOT NULL`,
+	`ALTER TABLE trips
+		ADD COLUMN IF NOT EXISTS assigned_distance_meters INT DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS origin_lat DOUBLE PRECISION DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS origin_lng DOUBLE PRECISION DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS dest_lat DOUBLE PRECISION DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS dest_lng DOUBLE PRECISION DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS held_from_status TEXT`,
+	`CREATE TABLE IF NOT EXISTS trip_batches (
+		trip_id UUID NOT NULL,
+		batch_id TEXT NOT NULL,
+		PRIMARY KEY (trip_id, batch_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS trip_status_history (
+		id BIGSERIAL PRIMARY KEY,
+		trip_id UUID NOT NULL,
+		from_status TEXT NOT NULL,
+		to_status TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		reason TEXT,
+		changed_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_trip_status_history_trip ON trip_status_history(trip_id, id)`,
+	`CREATE TABLE IF NOT EXISTS pending_assignments (
+		trip_id UUID PRIMARY KEY,
+		timeout_at TIMESTAMPTZ NOT NULL
+	)`,
+	`ALTER TABLE pending_assignments
+		ADD COLUMN IF NOT EXISTS batch_id TEXT,
+		ADD COLUMN IF NOT EXISTS attempt_count INT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+	`CREATE TABLE IF NOT EXISTS trip_offers (
+		offer_id UUID PRIMARY KEY,
+		trip_id UUID NOT NULL,
+		batch_id TEXT NOT NULL,
+		carrier_id TEXT NOT NULL,
+		rank INT NOT NULL,
+		distance_meters INT NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		offered_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		responded_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_trip_offers_trip ON trip_offers(trip_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_trip_offers_pending_expiry ON trip_offers(expires_at) WHERE status = 'pending'`,
+	`CREATE TABLE IF NOT EXISTS processed_events (
+		event_id TEXT PRIMARY KEY,
+		event_type TEXT,
+		processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	// Older releases created processed_events with a required occurred_at
+	// that no handler writes.
+	`ALTER TABLE processed_events
+		ADD COLUMN IF NOT EXISTS event_type TEXT,
+		ADD COLUMN IF NOT EXISTS processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+	`DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'processed_events' AND column_name = 'occurred_at') THEN
+			ALTER TABLE processed_events ALTER COLUMN occurred_at DROP NOT NULL;
+		END IF;
+	END $$`,
+	`CREATE TABLE IF NOT EXISTS carrier_positions (
+		carrier_id TEXT PRIMARY KEY,
+		latitude DOUBLE PRECISION NOT NULL,
+		longitude DOUBLE PRECISION NOT NULL,
+		last_seen TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS carrier_activity_cache (
+		carrier_id TEXT PRIMARY KEY,
+		is_active BOOLEAN DEFAULT TRUE,
+		updated_at TIMESTAMPTZ
+	)`,
+	`ALTER TABLE carrier_activity_cache
+		ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS source_seq BIGINT NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS carrier_schedules (
+		carrier_id TEXT PRIMARY KEY,
+		schedule JSONB NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`,
+	`ALTER TABLE carrier_schedules ADD COLUMN IF NOT EXISTS source_seq BIGINT NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS trip_incidents (
+		incident_id UUID PRIMARY KEY,
+		trip_id UUID NOT NULL,
+		carrier_id TEXT,
+		incident_type TEXT NOT NULL,
+		latitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+		longitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+		description TEXT,
+		status TEXT NOT NULL,
+		replacement_trip_id UUID,
+		reported_at TIMESTAMPTZ NOT NULL,
+		resolved_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_trip_incidents_trip ON trip_incidents(trip_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_trip_incidents_replacement ON trip_incidents(replacement_trip_id) WHERE resolved_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_trip_incidents_reported ON trip_incidents(reported_at)`,
+	`CREATE TABLE IF NOT EXISTS batch_boxes (
+		box_id TEXT PRIMARY KEY,
+		batch_id TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_batch_boxes_batch ON batch_boxes(batch_id)`,
+	`CREATE TABLE IF NOT EXISTS transshipments (
+		id UUID PRIMARY KEY,
+		source_trip_id UUID NOT NULL,
+		target_trip_id UUID,
+		source_carrier_id TEXT,
+		target_carrier_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		initiated_by TEXT,
+		completed_by TEXT,
+		reason TEXT,
+		rejection_reason TEXT,
+		moved_count INT NOT NULL DEFAULT 0,
+		missing_count INT NOT NULL DEFAULT 0,
+		extra_count INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL,
+		completed_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_transshipments_source_trip ON transshipments(source_trip_id)`,
+	`CREATE TABLE IF NOT EXISTS transshipment_boxes (
+		transshipment_id UUID NOT NULL,
+		box_id TEXT NOT NULL,
+		batch_id TEXT,
+		expected BOOLEAN NOT NULL,
+		scanned_out_at TIMESTAMPTZ,
+		scanned_in_at TIMESTAMPTZ,
+		PRIMARY KEY (transshipment_id, box_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ref_pickup_point_hours (
+		pvp_id TEXT PRIMARY KEY,
+		hours JSONB NOT NULL DEFAULT '{}',
+		source_seq BIGINT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS batch_destinations (
+		batch_id TEXT PRIMARY KEY,
+		pvp_id TEXT NOT NULL
+	)`,
+}
+
+// EnsureSchema creates or upgrades the trip database tables.
+func EnsureSchema(db outbox.Pgx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, stmt := range schema {
+		if _, err := db.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
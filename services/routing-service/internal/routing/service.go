@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"bel-parcel/pkg/schedule"
 	"bel-parcel/services/routing-service/internal/infra/kafka"
 	"bel-parcel/services/routing-service/internal/outbox"
 
@@ -22,179 +23,20 @@ type Service struct {
 	producer      *kafka.Producer
 	outTopic      string
 	carriersCache sync.Map
+	pending       PendingPolicy
+	offers        OfferPolicy
+	transship     TransshipmentPolicy
+	split         SplitPolicy
+	statusTopic   string
 }
 
 func NewService(tripDB *pgxpool.Pool, producer *kafka.Producer, outTopic string) *Service {
-	return &Service{tripDB: tripDB, producer: producer, outTopic: outTopic}
+	return &Service{tripDB: tripDB, producer: producer, outTopic: outTopic, pending: DefaultPendingPolicy(), offers: OfferPolicy{}.normalized(), transship: TransshipmentPolicy{}.normalized(), split: SplitPolicy{}.normalized(), statusTopic: tripStatusChanged}
 }
 
-func (s *Service) Start(ctx context.Context) {
-	go s.StartPendingReassignmentLoop(ctx)
-}
-
-func (s *Service) StartPendingReassignmentLoop(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			if err := s.processPendingAssignments(ctx); err != nil {
-				slog.Error("failed to process pending assignments", "error", err)
-			}
-		}
-	}
-}
-
-func (s *Service) processPendingAssignments(ctx context.Context) error {
-	tx, err := s.tripDB.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = tx.Rollback(ctx) }()
-
-	rows, err := tx.Query(ctx, `
-		SELECT trip_id, attempt_count 
-		FROM pending_assignments 
-		WHERE timeout_at <= NOW() 
-		ORDER BY timeout_at ASC 
-		LIMIT 50 
-		FOR UPDATE SKIP LOCKED
-	`)
-	if err != nil {
-		return err
-	}
-
-	type item struct {
-		tripID       string
-		attemptCount int
-	}
-	var items []item
-	for rows.Next() {
-		var i item
-		if err := rows.Scan(&i.tripID, &i.attemptCount); err != nil {
-			rows.Close()
-			return err
-		}
-		items = append(items, i)
-	}
-	rows.Close()
-
-	for _, it := range items {
-		slog.Info("Pending reassignment attempt", "attempt", it.attemptCount, "trip_id", it.tripID)
-
-		var originLat, originLng, destLat, destLng float64
-		var batchID string
-		if err := tx.QueryRow(ctx, `
-			SELECT t.origin_lat, t.origin_lng, t.dest_lat, t.dest_lng, tb.batch_id
-			FROM trips t
-			JOIN trip_batches tb ON t.id = tb.trip_id
-			WHERE t.id = $1
-		`, it.tripID).Scan(&originLat, &originLng, &destLat, &destLng, &batchID); err != nil {
-			slog.Error("failed to load trip context", "trip_id", it.tripID, "error", err)
-			continue
-		}
-
-		carrierID, dist, err := s.selectCarrier(ctx, batchID, originLat, originLng)
-		now := time.Now().UTC()
-		if err == nil {
-			if _, err := tx.Exec(ctx, `
-				UPDATE trips SET status='ASSIGNED', carrier_id=$1, assigned_at=$2, assigned_distance_meters=$3 WHERE id=$4
-			`, carrierID, now, dist, it.tripID); err != nil {
-				return err
-			}
-			if _, err := tx.Exec(ctx, `DELETE FROM pending_assignments WHERE trip_id=$1`, it.tripID); err != nil {
-				return err
-			}
-
-			envelope := map[string]interface{}{
-				"event_id":       uuid.NewString(),
-				"event_type":     "trips.assigned",
-				"occurred_at":    now,
-				"correlation_id": batchID,
-				"data": map[string]interface{}{
-					"trip_id":                  it.tripID,
-					"batch_id":                 batchID,
-					"carrier_id":               carrierID,
-					"origin_lat":               originLat,
-					"origin_lng":               originLng,
-					"destination_lat":          destLat,
-					"destination_lng":          destLng,
-					"assigned_distance_meters": dist,
-					"assigned_at":              now,
-				},
-			}
-			payload, err := json.Marshal(envelope)
-			if err != nil {
-				return err
-			}
-			evt := outbox.Event{
-				ID:            uuid.NewString(),
-				EventType:     "trips.assigned",
-				CorrelationID: batchID,
-				Topic:         s.outTopic,
-				PartitionKey:  it.tripID,
-				Payload:       payload,
-				OccurredAt:    now,
-			}
-			if err := outbox.EnqueueTx(ctx, tx, evt); err != nil {
-				return err
-			}
-
-			slog.Info("Trip assigned to carrier", "trip_id", it.tripID, "carrier_id", carrierID, "attempts", it.attemptCount)
-		} else {
-			newCount := it.attemptCount + 1
-			if newCount < 10 {
-				if _, err := tx.Exec(ctx, `
-					UPDATE pending_assignments 
-					SET attempt_count=$1, timeout_at=NOW() + INTERVAL '5 minutes' 
-					WHERE trip_id=$2
-				`, newCount, it.tripID); err != nil {
-					return err
-				}
-			} else {
-				if _, err := tx.Exec(ctx, `
-					UPDATE trips SET status='REQUIRES_MANUAL_ASSIGNMENT' WHERE id=$1
-				`, it.tripID); err != nil {
-					return err
-				}
-				if _, err := tx.Exec(ctx, `DELETE FROM pending_assignments WHERE trip_id=$1`, it.tripID); err != nil {
-					return err
-				}
-
-				envelope := map[string]interface{}{
-					"event_id":       uuid.NewString(),
-					"event_type":     "trip_requires_manual_assignment",
-					"occurred_at":    now,
-					"correlation_id": it.tripID,
-					"data": map[string]interface{}{
-						"trip_id": it.tripID,
-						"reason":  "max_attempts_reached",
-					},
-				}
-				payload, err := json.Marshal(envelope)
-				if err != nil {
-					return err
-				}
-				evt := outbox.Event{
-					ID:            uuid.NewString(),
-					EventType:     "trip_requires_manual_assignment",
-					CorrelationID: it.tripID,
-					Topic:         "alerts.trip_requires_manual_assignment",
-					PartitionKey:  it.tripID,
-					Payload:       payload,
-					OccurredAt:    now,
-				}
-				if err := outbox.EnqueueTx(ctx, tx, evt); err != nil {
-					return err
-				}
-				slog.Info("Trip requires manual assignment after failed attempts", "trip_id", it.tripID, "attempts", 10)
-			}
-		}
-	}
-
-	return tx.Commit(ctx)
+func (s *Service) WithPendingPolicy(p PendingPolicy) *Service {
+	s.pending = p.normalized()
+	return s
 }
 
 func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []byte) error {
@@ -227,10 +69,32 @@ func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []by
 		if err := json.Unmarshal(envelope.Data, &data); err != nil {
 			return err
 		}
+		if err := s.recordBatchBoxes(ctx, data.BatchID, data.OrderIDs); err != nil {
+			return err
+		}
+		if err := s.recordBatchDestination(ctx, data.BatchID, data.DestinationType, data.DestinationID); err != nil {
+			return err
+		}
 		// Idempotency check before heavy logic
 		if s.isProcessed(ctx, envelope.EventID) {
 			return nil
 		}
+		pvpID, departAt, wait, err := s.receivingDeparture(ctx, data.BatchID, data.OriginLat, data.OriginLng, data.DestinationLat, data.DestinationLng, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if wait {
+			return s.createDeferredTrip(ctx, envelope.EventID, envelope.EventType, data.BatchID, pvpID, data.OriginLat, data.OriginLng, data.DestinationLat, data.DestinationLng, departAt)
+		}
+		if s.offers.Enabled {
+			offered, err := s.offerTrip(ctx, envelope.EventID, envelope.EventType, data.BatchID, data.OriginLat, data.OriginLng, data.DestinationLat, data.DestinationLng)
+			if err != nil {
+				return err
+			}
+			if offered {
+				return nil
+			}
+		}
 		carrierID, dist, err := s.selectCarrier(ctx, data.BatchID, data.OriginLat, data.OriginLng)
 		if err != nil {
 			// Create PENDING trip when no suitable carriers are available (critical improvement)
@@ -264,11 +128,10 @@ func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []by
 			`, newTripID, data.BatchID); e != nil {
 				return e
 			}
-			if e := tx.Commit(ctx); e != nil {
+			if e := s.registerPendingTx(ctx, tx, newTripID, data.BatchID, now); e != nil {
 				return e
 			}
-			_ = now
-			return nil
+			return tx.Commit(ctx)
 		}
 		return s.createTripWithEvent(ctx, envelope.EventID, envelope.EventType, carrierID, data.BatchID, dist, data.OriginLat, data.OriginLng, data.DestinationLat, data.DestinationLng)
 	case "events.batch_picked_up":
@@ -351,17 +214,38 @@ func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []by
 		}
 		var data struct {
 			UpdateType string `json:"update_type"`
+			Seq        int64  `json:"seq"`
 			Carrier    struct {
-				ID       string `json:"id"`
-				IsActive bool   `json:"is_active"`
+				CarrierID string `json:"carrier_id"`
+				ID        string `json:"id"`
+				IsActive  bool   `json:"is_active"`
 			} `json:"carrier"`
+			CarrierSchedule json.RawMessage `json:"carrier_schedule"`
+			PickupPoint     struct {
+				ID string `json:"pvp_id"`
+				schedule.Hours
+			} `json:"pickup_point"`
 			Reason string `json:"reason"`
 		}
 		if err := json.Unmarshal(envelope.Data, &data); err != nil {
 			return err
 		}
-		if data.UpdateType == "carrier" && data.Carrier.ID != "" {
-			return s.updateCarrierStatus(ctx, envelope.EventID, data.Carrier.ID, data.Carrier.IsActive, envelope.OccurredAt, data.Reason)
+		if data.UpdateType == "carrier" {
+			// reference-service sends carrier_id; id is kept for older producers
+			carrierID := data.Carrier.CarrierID
+			if carrierID == "" {
+				carrierID = data.Carrier.ID
+			}
+			if carrierID == "" {
+				return nil
+			}
+			return s.updateCarrierStatus(ctx, envelope.EventID, carrierID, data.Carrier.IsActive, envelope.OccurredAt, data.Seq, data.Reason)
+		}
+		if data.UpdateType == "carrier_schedule" && len(data.CarrierSchedule) > 0 {
+			return s.updateCarrierSchedule(ctx, envelope.EventID, data.CarrierSchedule, envelope.OccurredAt, data.Seq)
+		}
+		if data.UpdateType == "pickup_point" && data.PickupPoint.ID != "" {
+			return s.updatePickupPointHours(ctx, envelope.EventID, data.PickupPoint.ID, data.PickupPoint.Hours, data.Seq)
 		}
 		return nil
 	case "commands.trip.reassign":
@@ -380,11 +264,89 @@ func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []by
 			BatchID        string    `json:"batch_id"`
 			Reason         string    `json:"reason"`
 			TimeoutAt      time.Time `json:"timeout_at"`
+			SplitBy        string    `json:"split_by"`
+		}
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return err
+		}
+		return s.handleReassign(ctx, envelope.EventID, envelope.EventType, data.OriginalTripID, data.BatchID, data.Reason, data.SplitBy)
+	case "commands.trip.status":
+		var envelope struct {
+			EventID   string          `json:"event_id"`
+			EventType string          `json:"event_type"`
+			Data      json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		var cmd tripStatusCommand
+		if err := json.Unmarshal(envelope.Data, &cmd); err != nil {
+			return err
+		}
+		return s.handleTripCommand(ctx, envelope.EventID, envelope.EventType, cmd)
+	case "events.trip_offer_accepted":
+		var envelope struct {
+			EventID       string          `json:"event_id"`
+			EventType     string          `json:"event_type"`
+			OccurredAt    time.Time       `json:"occurred_at"`
+			CorrelationID string          `json:"correlation_id"`
+			Data          json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		var data struct {
+			OfferID    string    `json:"offer_id"`
+			TripID     string    `json:"trip_id"`
+			CarrierID  string    `json:"carrier_id"`
+			AcceptedAt time.Time `json:"accepted_at"`
+		}
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return err
+		}
+		return s.handleOfferAccepted(ctx, envelope.EventID, envelope.EventType, data.OfferID, data.TripID, data.CarrierID)
+	case "commands.transshipment":
+		var envelope struct {
+			EventID   string          `json:"event_id"`
+			EventType string          `json:"event_type"`
+			Data      json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		if envelope.EventType == "commands.transshipment.scan" {
+			var scan boxScan
+			if err := json.Unmarshal(envelope.Data, &scan); err != nil {
+				return err
+			}
+			return s.handleBoxScanned(ctx, envelope.EventID, envelope.EventType, scan)
 		}
+		var cmd transshipmentCommand
+		if err := json.Unmarshal(envelope.Data, &cmd); err != nil {
+			return err
+		}
+		switch envelope.EventType {
+		case "commands.transshipment.initiate":
+			return s.handleTransshipmentInitiate(ctx, envelope.EventID, envelope.EventType, cmd)
+		case "commands.transshipment.complete":
+			return s.handleTransshipmentComplete(ctx, envelope.EventID, envelope.EventType, cmd)
+		default:
+			return nil
+		}
+	case "events.trip_incident_reported":
+		var envelope struct {
+			EventID   string          `json:"event_id"`
+			EventType string          `json:"event_type"`
+			Data      json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return err
+		}
+		var data incidentReport
 		if err := json.Unmarshal(envelope.Data, &data); err != nil {
 			return err
 		}
-		return s.handleReassign(ctx, envelope.EventID, envelope.EventType, data.OriginalTripID, data.BatchID, data.Reason)
+		return s.handleIncidentReported(ctx, envelope.EventID, envelope.EventType, data)
 	default:
 		return nil
 	}
@@ -419,11 +381,10 @@ func (s *Service) handleBatchPickedUp(ctx context.Context, eventID, eventType, b
 	// Update trip status to IN_PROGRESS (Algo 9)
 	var tripID, carrierID string
 	if err := tx.QueryRow(ctx, `
-		UPDATE trips t
-		SET status = 'IN_PROGRESS'
-		FROM trip_batches tb
-		WHERE t.id = tb.trip_id AND tb.batch_id = $1 AND t.status = 'ASSIGNED'
-		RETURNING t.id, t.carrier_id
+		SELECT t.id, t.carrier_id
+		FROM trips t
+		JOIN trip_batches tb ON t.id = tb.trip_id
+		WHERE tb.batch_id = $1 AND t.status = 'ASSIGNED'
 	`, batchID).Scan(&tripID, &carrierID); err != nil {
 		if err == sql.ErrNoRows {
 			// Trip might not be in ASSIGNED state or not found, ignore
@@ -431,9 +392,12 @@ func (s *Service) handleBatchPickedUp(ctx context.Context, eventID, eventType, b
 		}
 		return err
 	}
+	now := time.Now().UTC()
+	if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: tripID, From: TripAssigned, To: TripInProgress, Reason: "batch_picked_up"}, now); err != nil {
+		return err
+	}
 
 	// Publish trip.started
-	now := time.Now().UTC()
 	envelope := map[string]interface{}{
 		"event_id":       uuid.NewString(),
 		"event_type":     "trips.started",
@@ -489,11 +453,10 @@ func (s *Service) handleBatchDelivered(ctx context.Context, eventID, eventType,
 	// Update trip status to COMPLETED (Algo 10)
 	var tripID, carrierID string
 	if err := tx.QueryRow(ctx, `
-		UPDATE trips t
-		SET status = 'COMPLETED'
-		FROM trip_batches tb
-		WHERE t.id = tb.trip_id AND tb.batch_id = $1 AND t.status = 'IN_PROGRESS'
-		RETURNING t.id, t.carrier_id
+		SELECT t.id, t.carrier_id
+		FROM trips t
+		JOIN trip_batches tb ON t.id = tb.trip_id
+		WHERE tb.batch_id = $1 AND t.status = 'IN_PROGRESS'
 	`, batchID).Scan(&tripID, &carrierID); err != nil {
 		if err == sql.ErrNoRows {
 			return tx.Commit(ctx)
@@ -501,8 +464,15 @@ func (s *Service) handleBatchDelivered(ctx context.Context, eventID, eventType,
 		return err
 	}
 
-	// Publish trip.completed
 	now := time.Now().UTC()
+	if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: tripID, From: TripInProgress, To: TripCompleted, Reason: "batch_delivered"}, now); err != nil {
+		return err
+	}
+	if err := resolveTripIncidentsTx(ctx, tx, tripID, now); err != nil {
+		return err
+	}
+
+	// Publish trip.completed
 	envelope := map[string]interface{}{
 		"event_id":       uuid.NewString(),
 		"event_type":     "trips.completed",
@@ -536,6 +506,19 @@ func (s *Service) handleBatchDelivered(ctx context.Context, eventID, eventType,
 }
 
 func (s *Service) selectCarrier(ctx context.Context, batchID string, originLat, originLng float64) (string, int, error) {
+	return s.selectCarrierWithin(ctx, originLat, originLng, defaultSearchRadiusMeters)
+}
+
+func (s *Service) selectCarrierWithin(ctx context.Context, originLat, originLng float64, radiusMeters int) (string, int, error) {
+	cands, err := s.loadCandidates(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	return chooseCarrierWithin(originLat, originLng, cands, radiusMeters)
+}
+
+// loadCandidates returns active carriers that are on shift right now.
+func (s *Service) loadCandidates(ctx context.Context) ([]carrierCandidate, error) {
 	rows, err := s.tripDB.Query(ctx, `
 		SELECT a.carrier_id, p.latitude, p.longitude
 		FROM carrier_activity_cache a
@@ -543,14 +526,16 @@ func (s *Service) selectCarrier(ctx context.Context, batchID string, originLat,
 		WHERE a.is_active = true AND a.updated_at > NOW() - INTERVAL '1 hour'
 	`)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 	defer rows.Close()
 	var cands []carrierCandidate
 	for rows.Next() {
 		var id string
 		var lat, lng sql.NullFloat64
-		_ = rows.Scan(&id, &lat, &lng)
+		if err := rows.Scan(&id, &lat, &lng); err != nil {
+			return nil, err
+		}
 		c := carrierCandidate{id: id}
 		if lat.Valid && lng.Valid {
 			c.hasPos = true
@@ -559,7 +544,10 @@ func (s *Service) selectCarrier(ctx context.Context, batchID string, originLat,
 		}
 		cands = append(cands, c)
 	}
-	return chooseCarrier(originLat, originLng, cands)
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return s.filterOnShift(ctx, cands, time.Now().UTC())
 }
 
 type carrierCandidate struct {
@@ -569,7 +557,15 @@ type carrierCandidate struct {
 	hasPos bool
 }
 
+// defaultSearchRadiusMeters is the radius used for the first assignment
+// attempt when a batch is formed or a trip is reassigned.
+const defaultSearchRadiusMeters = 5000
+
 func chooseCarrier(originLat, originLng float64, cands []carrierCandidate) (string, int, error) {
+	return chooseCarrierWithin(originLat, originLng, cands, defaultSearchRadiusMeters)
+}
+
+func chooseCarrierWithin(originLat, originLng float64, cands []carrierCandidate, radiusMeters int) (string, int, error) {
 	if len(cands) == 0 {
 		return "", 0, fmt.Errorf("no active carriers")
 	}
@@ -580,7 +576,7 @@ func chooseCarrier(originLat, originLng float64, cands []carrierCandidate) (stri
 			continue
 		}
 		d := haversine(originLat, originLng, c.lat, c.lng)
-		if d > 5000 {
+		if d > float64(radiusMeters) {
 			continue
 		}
 		if d < bestDist {
@@ -589,7 +585,7 @@ func chooseCarrier(originLat, originLng float64, cands []carrierCandidate) (stri
 		}
 	}
 	if bestID == "" {
-		return "", 0, fmt.Errorf("no active carriers within 5km")
+		return "", 0, fmt.Errorf("no active carriers within %gkm", float64(radiusMeters)/1000)
 	}
 	return bestID, int(bestDist), nil
 }
@@ -701,7 +697,7 @@ func (s *Service) upsertCarrierLocation(ctx context.Context, eventID, eventType,
 	return tx.Commit(ctx)
 }
 
-func (s *Service) updateCarrierStatus(ctx context.Context, eventID, carrierID string, isActive bool, updatedAt time.Time, reason string) error {
+func (s *Service) updateCarrierStatus(ctx context.Context, eventID, carrierID string, isActive bool, updatedAt time.Time, seq int64, reason string) error {
 	tx, err := s.tripDB.Begin(ctx)
 	if err != nil {
 		return err
@@ -721,14 +717,14 @@ func (s *Service) updateCarrierStatus(ctx context.Context, eventID, carrierID st
 		return err
 	}
 
-	if _, err := tx.Exec(ctx, `
-		INSERT INTO carrier_activity_cache (carrier_id, is_active, updated_at)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (carrier_id) DO UPDATE
-		SET is_active = $2, updated_at = $3
-	`, carrierID, isActive, updatedAt); err != nil {
+	applied, err := upsertCarrierActivity(ctx, tx, carrierID, isActive, updatedAt, seq)
+	if err != nil {
 		return err
 	}
+	if !applied {
+		slog.Info("Stale carrier status ignored", "carrier_id", carrierID, "seq", seq)
+		return tx.Commit(ctx)
+	}
 
 	if isActive {
 		s.carriersCache.Store(carrierID, true)
@@ -752,7 +748,7 @@ func haversine(lat1, lon1, lat2, lon2 float64) float64 {
 	return R * c
 }
 
-func (s *Service) handleReassign(ctx context.Context, eventID, eventType, tripID, batchID, reason string) error {
+func (s *Service) handleReassign(ctx context.Context, eventID, eventType, tripID, batchID, reason, splitBy string) error {
 	// Idempotency
 	now := time.Now().UTC()
 	tx, err := s.tripDB.Begin(ctx)
@@ -793,19 +789,53 @@ func (s *Service) handleReassign(ctx context.Context, eventID, eventType, tripID
 		return tx.Commit(ctx)
 	}
 
+	if !CanTransition(status, TripReassigned) {
+		slog.Warn("Reassignment refused by trip state", "trip_id", tripID, "status", status)
+		return tx.Commit(ctx)
+	}
+
 	if !originLat.Valid || !originLng.Valid {
 		return fmt.Errorf("trip %s missing origin coordinates", tripID)
 	}
 
+	// Operator commands name only the trip; its batch is looked up here.
+	if batchID == "" {
+		var batches int
+		var only sql.NullString
+		if err := tx.QueryRow(ctx, `
+			SELECT COUNT(*), MIN(batch_id) FROM trip_batches WHERE trip_id=$1
+		`, tripID).Scan(&batches, &only); err != nil {
+			return err
+		}
+		if batches != 1 {
+			slog.Warn("Reassignment refused: trip must carry exactly one batch", "trip_id", tripID, "batches", batches)
+			return tx.Commit(ctx)
+		}
+		batchID = only.String
+	}
+
+	// A batch that no single vehicle can take is split into sub-batches
+	mode, err := s.splitModeTx(ctx, tx, batchID, splitBy)
+	if err != nil {
+		return err
+	}
+	if mode != "" {
+		if err := s.requestSplitTx(ctx, tx, tripID, batchID, mode, reason, now); err != nil {
+			return err
+		}
+		slog.Info("Batch split requested", "trip_id", tripID, "batch_id", batchID, "mode", mode)
+		return tx.Commit(ctx)
+	}
+
 	// Select new carrier
 	carrierID, dist, err := s.selectCarrier(ctx, batchID, originLat.Float64, originLng.Float64)
 	if err != nil {
 		// If carrier selection failed (likely no carrier found), create PENDING trip
-		var newTripID string
-		if err := tx.QueryRow(ctx, `
-			INSERT INTO trips (id, carrier_id, status, assigned_at, assigned_distance_meters, origin_lat, origin_lng, dest_lat, dest_lng) 
-			VALUES (gen_random_uuid(), NULL, 'PENDING', NULL, 0, $1, $2, $3, $4) RETURNING id
-		`, originLat.Float64, originLng.Float64, destLat.Float64, destLng.Float64).Scan(&newTripID); err != nil {
+		newTripID, err := s.createTripTx(ctx, tx, newTrip{
+			Status: TripPending, OriginLat: originLat.Float64, OriginLng: originLng.Float64, DestLat: destLat.Float64, DestLng: destLng.Float64,
+			Reason: reason,
+		}, now)
+		if err != nil {
 			return err
 		}
 		if _, err := tx.Exec(ctx, `
@@ -813,15 +843,19 @@ func (s *Service) handleReassign(ctx context.Context, eventID, eventType, tripID
 		`, newTripID, batchID); err != nil {
 			return err
 		}
+		if err := s.registerPendingTx(ctx, tx, newTripID, batchID, now); err != nil {
+			return err
+		}
 		return tx.Commit(ctx)
 	}
 
 	// Create new trip and attach batch
-	var newTripID string
-	if err := tx.QueryRow(ctx, `
-		INSERT INTO trips (id, carrier_id, status, assigned_at, assigned_distance_meters, origin_lat, origin_lng, dest_lat, dest_lng) 
-		VALUES (gen_random_uuid(), $1, 'ASSIGNED', NOW(), $2, $3, $4, $5, $6) RETURNING id
-	`, carrierID, dist, originLat.Float64, originLng.Float64, destLat.Float64, destLng.Float64).Scan(&newTripID); err != nil {
+	newTripID, err := s.createTripTx(ctx, tx, newTrip{
+		CarrierID: carrierID, DistanceMeters: dist,
+		OriginLat: originLat.Float64, OriginLng: originLng.Float64, DestLat: destLat.Float64, DestLng: destLng.Float64,
+		Reason: reason,
+	}, now)
+	if err != nil {
 		return err
 	}
 	if _, err := tx.Exec(ctx, `
@@ -831,7 +865,7 @@ func (s *Service) handleReassign(ctx context.Context, eventID, eventType, tripID
 	}
 
 	// Update old trip status to REASSIGNED
-	if _, err := tx.Exec(ctx, "UPDATE trips SET status = 'REASSIGNED' WHERE id = $1", tripID); err != nil {
+	if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: tripID, To: TripReassigned, Reason: reason}, now); err != nil {
 		return err
 	}
 
@@ -0,0 +1,97 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"bel-parcel/pkg/schedule"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// updateCarrierSchedule stores the calendar snapshot published by
+// reference-service. Snapshots older than the stored one are ignored.
+func (s *Service) updateCarrierSchedule(ctx context.Context, eventID string, raw json.RawMessage, updatedAt time.Time, seq int64) error {
+	var sc schedule.Schedule
+	if err := json.Unmarshal(raw, &sc); err != nil {
+		return err
+	}
+	if sc.CarrierID == "" {
+		return nil
+	}
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var inserted string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, event_type, processed_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, eventID, "events.reference_updated").Scan(&inserted); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if _, err := upsertCarrierSchedule(ctx, tx, sc.CarrierID, raw, updatedAt, seq); err != nil {
+		return err
+	}
+	slog.Info("Carrier schedule updated", "carrier_id", sc.CarrierID, "rules", len(sc.Rules), "exceptions", len(sc.Exceptions))
+	return tx.Commit(ctx)
+}
+
+// filterOnShift drops candidates that are off shift at now. Carriers without
+// a stored schedule are kept, so routing keeps working before the calendar is
+// filled in.
+func (s *Service) filterOnShift(ctx context.Context, cands []carrierCandidate, now time.Time) ([]carrierCandidate, error) {
+	if len(cands) == 0 {
+		return cands, nil
+	}
+	ids := make([]string, 0, len(cands))
+	for _, c := range cands {
+		ids = append(ids, c.id)
+	}
+	rows, err := s.tripDB.Query(ctx, `
+		SELECT carrier_id, schedule FROM carrier_schedules WHERE carrier_id = ANY($1)
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schedules := make(map[string]schedule.Schedule)
+	for rows.Next() {
+		var id string
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, err
+		}
+		var sc schedule.Schedule
+		if err := json.Unmarshal(raw, &sc); err != nil {
+			slog.Warn("skipping malformed carrier schedule", "carrier_id", id, "error", err)
+			continue
+		}
+		schedules[id] = sc
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return onShift(cands, schedules, now), nil
+}
+
+func onShift(cands []carrierCandidate, schedules map[string]schedule.Schedule, now time.Time) []carrierCandidate {
+	res := cands[:0:0]
+	for _, c := range cands {
+		if sc, ok := schedules[c.id]; ok && !sc.OnShift(now) {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
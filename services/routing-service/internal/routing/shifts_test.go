@@ -0,0 +1,29 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"bel-parcel/pkg/schedule"
+)
+
+func TestOnShift_FiltersScheduledCarriers(t *testing.T) {
+	// 2024-01-01 10:00 UTC is a Monday.
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	cands := []carrierCandidate{{id: "working"}, {id: "resting"}, {id: "on-break"}, {id: "unscheduled"}}
+	schedules := map[string]schedule.Schedule{
+		"working": {Rules: []schedule.Rule{{Weekday: time.Monday, Start: "08:00", End: "20:00", Timezone: "UTC"}}},
+		"resting": {Rules: []schedule.Rule{{Weekday: time.Tuesday, Start: "08:00", End: "20:00", Timezone: "UTC"}}},
+		"on-break": {
+			Rules:      []schedule.Rule{{Weekday: time.Monday, Start: "08:00", End: "20:00", Timezone: "UTC"}},
+			Exceptions: []schedule.Exception{{Kind: schedule.KindOff, StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour)}},
+		},
+	}
+	got := onShift(cands, schedules, now)
+	if len(got) != 2 || got[0].id != "working" || got[1].id != "unscheduled" {
+		t.Fatalf("unexpected candidates: %+v", got)
+	}
+	if len(cands) != 4 || cands[1].id != "resting" {
+		t.Fatalf("input slice must not be modified: %+v", cands)
+	}
+}
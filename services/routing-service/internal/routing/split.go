@@ -0,0 +1,99 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Split modes of a disrupted batch. By destination every final pickup point
+// gets its own sub-batch; by capacity the batch is cut into vehicle-sized
+// parts. Both modes respect the vehicle capacity.
+const (
+	SplitByDestination = "destination"
+	SplitByCapacity    = "capacity"
+)
+
+const batchSplitCommand = "commands.batch.split"
+
+// SplitPolicy configures where split commands go. batching-service owns the
+// batches, so routing only decides that a batch must be split and retires the
+// trip; the sub-batches come back as batches.formed and get their own trips.
+type SplitPolicy struct {
+	Topic string
+}
+
+func (p SplitPolicy) normalized() SplitPolicy {
+	if p.Topic == "" {
+		p.Topic = batchSplitCommand
+	}
+	return p
+}
+
+func (s *Service) WithSplitPolicy(p SplitPolicy) *Service {
+	s.split = p.normalized()
+	return s
+}
+
+// splitModeTx returns the split mode for a reassigned batch: the one asked
+// for in the command, or capacity when the batch is larger than any vehicle.
+// An empty mode means the batch moves whole.
+func (s *Service) splitModeTx(ctx context.Context, tx pgx.Tx, batchID, requested string) (string, error) {
+	switch requested {
+	case SplitByDestination, SplitByCapacity:
+		return requested, nil
+	case "":
+	default:
+		slog.Warn("unknown split mode, moving batch whole", "batch_id", batchID, "split_by", requested)
+		return "", nil
+	}
+	var boxes int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM batch_boxes WHERE batch_id=$1`, batchID).Scan(&boxes); err != nil {
+		return "", err
+	}
+	if boxes > s.transship.VehicleCapacityBoxes {
+		return SplitByCapacity, nil
+	}
+	return "", nil
+}
+
+// requestSplitTx retires the disrupted trip and asks batching-service to
+// split its batch.
+func (s *Service) requestSplitTx(ctx context.Context, tx pgx.Tx, tripID, batchID, mode, reason string, now time.Time) error {
+	if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: tripID, To: TripReassigned, Reason: "split_by_" + mode}, now); err != nil {
+		return err
+	}
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     batchSplitCommand,
+		"occurred_at":    now,
+		"correlation_id": batchID,
+		"data": map[string]interface{}{
+			"batch_id":     batchID,
+			"trip_id":      tripID,
+			"mode":         mode,
+			"max_orders":   s.transship.VehicleCapacityBoxes,
+			"reason":       reason,
+			"requested_at": now,
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     batchSplitCommand,
+		CorrelationID: batchID + "/" + tripID,
+		Topic:         s.split.Topic,
+		PartitionKey:  batchID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
@@ -0,0 +1,150 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReassign_OversizedBatchIsSplitByCapacity(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips").WithTransshipmentPolicy(TransshipmentPolicy{VehicleCapacityBoxes: 2})
+
+	suffix := uuid.NewString()[:8]
+	tripID := uuid.NewString()
+	if _, err := db.Exec(ctx, `
+		INSERT INTO trips (id, carrier_id, status, assigned_at, origin_lat, origin_lng, dest_lat, dest_lng)
+		VALUES ($1, $2, 'ASSIGNED', NOW(), 53.9, 27.56, 53.7, 27.3)
+	`, tripID, "c-"+suffix); err != nil {
+		t.Fatal(err)
+	}
+	batch := "b-" + suffix
+	if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.recordBatchBoxes(ctx, batch, []string{"x-" + suffix, "y-" + suffix, "z-" + suffix}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.handleReassign(ctx, uuid.NewString(), "commands.trip.reassign", tripID, batch, "breakdown", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var status string
+	if err := db.QueryRow(ctx, `SELECT status FROM trips WHERE id=$1`, tripID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != "REASSIGNED" {
+		t.Fatalf("disrupted trip must be retired, got %s", status)
+	}
+	var payload []byte
+	if err := db.QueryRow(ctx, `
+		SELECT payload FROM outbox_events WHERE event_type=$1 AND correlation_id=$2
+	`, batchSplitCommand, batch+"/"+tripID).Scan(&payload); err != nil {
+		t.Fatalf("expected split command: %v", err)
+	}
+	var env struct {
+		Data struct {
+			Mode      string `json:"mode"`
+			MaxOrders int    `json:"max_orders"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Data.Mode != SplitByCapacity || env.Data.MaxOrders != 2 {
+		t.Fatalf("unexpected split command %+v", env.Data)
+	}
+	var newTrips int
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM trip_batches WHERE batch_id=$1 AND trip_id<>$2`, batch, tripID).Scan(&newTrips)
+	if newTrips != 0 {
+		t.Fatalf("split batch must not be attached to a replacement trip, got %d", newTrips)
+	}
+}
+
+func TestReassign_OperatorCommandSplitsByDestination(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips").WithTransshipmentPolicy(TransshipmentPolicy{VehicleCapacityBoxes: 10})
+
+	suffix := uuid.NewString()[:8]
+	tripID := uuid.NewString()
+	if _, err := db.Exec(ctx, `
+		INSERT INTO trips (id, carrier_id, status, assigned_at, origin_lat, origin_lng, dest_lat, dest_lng)
+		VALUES ($1, $2, 'ASSIGNED', NOW(), 53.9, 27.56, 53.7, 27.3)
+	`, tripID, "c-"+suffix); err != nil {
+		t.Fatal(err)
+	}
+	batch := "b-" + suffix
+	if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// operator-api names the trip and the split mode, not the batch.
+	cmd, _ := json.Marshal(map[string]interface{}{
+		"event_id":   uuid.NewString(),
+		"event_type": "commands.trip.reassign",
+		"data": map[string]interface{}{
+			"trip_id":          tripID,
+			"original_trip_id": tripID,
+			"new_carrier_id":   "c2-" + suffix,
+			"reason":           "operator",
+			"split_by":         SplitByDestination,
+		},
+	})
+	if err := svc.HandleEvent(ctx, "commands.trip.reassign", nil, cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload []byte
+	if err := db.QueryRow(ctx, `
+		SELECT payload FROM outbox_events WHERE event_type=$1 AND correlation_id=$2
+	`, batchSplitCommand, batch+"/"+tripID).Scan(&payload); err != nil {
+		t.Fatalf("expected split command: %v", err)
+	}
+	var env struct {
+		Data struct {
+			Mode string `json:"mode"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Data.Mode != SplitByDestination {
+		t.Fatalf("unexpected split mode %q", env.Data.Mode)
+	}
+}
+
+func TestReassign_ReplacementTripGoesThroughStateMachine(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips")
+
+	// Far from any carrier, so the replacement waits as PENDING.
+	suffix := uuid.NewString()[:8]
+	tripID := uuid.NewString()
+	if _, err := db.Exec(ctx, `
+		INSERT INTO trips (id, carrier_id, status, assigned_at, origin_lat, origin_lng, dest_lat, dest_lng)
+		VALUES ($1, $2, 'ASSIGNED', NOW(), -60, -60, -60.1, -60.1)
+	`, tripID, "c-"+suffix); err != nil {
+		t.Fatal(err)
+	}
+	batch := "b-" + suffix
+	if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.handleReassign(ctx, uuid.NewString(), "commands.trip.reassign", tripID, batch, "breakdown", ""); err != nil {
+		t.Fatal(err)
+	}
+	var newTripID string
+	if err := db.QueryRow(ctx, `SELECT trip_id::text FROM trip_batches WHERE batch_id=$1 AND trip_id<>$2`, batch, tripID).Scan(&newTripID); err != nil {
+		t.Fatalf("expected a replacement trip: %v", err)
+	}
+	assertTripCreated(t, db, newTripID, TripPending)
+}
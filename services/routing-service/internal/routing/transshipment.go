@@ -0,0 +1,778 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// TransshipmentPolicy configures cargo transfer between vehicles. A box is a
+// single order; its barcode is the order id.
+type TransshipmentPolicy struct {
+	VehicleCapacityBoxes int
+	Topic                string
+	SplitTopic           string
+}
+
+func (p TransshipmentPolicy) normalized() TransshipmentPolicy {
+	if p.VehicleCapacityBoxes <= 0 {
+		p.VehicleCapacityBoxes = 200
+	}
+	if p.Topic == "" {
+		p.Topic = "trips.transshipments"
+	}
+	if p.SplitTopic == "" {
+		p.SplitTopic = "batches.split"
+	}
+	return p
+}
+
+func (s *Service) WithTransshipmentPolicy(p TransshipmentPolicy) *Service {
+	s.transship = p.normalized()
+	return s
+}
+
+// Transshipment event types. They implement the "перегрузка_инициирована",
+// "коробка_перемещена" and "перегрузка_завершена" events of the design notes.
+const (
+	transshipmentInitiatedEvent = "transshipments.initiated"
+	transshipmentRejectedEvent  = "transshipments.rejected"
+	transshipmentBoxMovedEvent  = "transshipments.box_moved"
+	transshipmentCompletedEvent = "transshipments.completed"
+	batchSplitEvent             = "batches.split"
+	transshipmentAlertTopic     = "alerts.transshipment_discrepancy"
+)
+
+// Transshipment statuses.
+const (
+	transshipmentInitiated                  = "initiated"
+	transshipmentRejected                   = "rejected"
+	transshipmentCompleted                  = "completed"
+	transshipmentCompletedWithDiscrepancies = "completed_with_discrepancies"
+)
+
+// Scan directions: out of the source vehicle, into the target vehicle.
+const (
+	ScanOut = "out"
+	ScanIn  = "in"
+)
+
+type transshipmentCommand struct {
+	TransshipmentID string   `json:"transshipment_id"`
+	SourceTripID    string   `json:"source_trip_id"`
+	TargetCarrierID string   `json:"target_carrier_id"`
+	BoxIDs          []string `json:"box_ids"`
+	Reason          string   `json:"reason"`
+	InitiatedBy     string   `json:"initiated_by"`
+	CompletedBy     string   `json:"completed_by"`
+}
+
+type boxScan struct {
+	TransshipmentID string    `json:"transshipment_id"`
+	BoxID           string    `json:"box_id"`
+	Direction       string    `json:"direction"`
+	CarrierID       string    `json:"carrier_id"`
+	ScannedAt       time.Time `json:"scanned_at"`
+}
+
+// recordBatchBoxes remembers which boxes travel in a batch so that transfers
+// can be checked box by box. A box re-batched at a hub follows its new batch.
+func (s *Service) recordBatchBoxes(ctx context.Context, batchID string, boxIDs []string) error {
+	if batchID == "" || len(boxIDs) == 0 {
+		return nil
+	}
+	_, err := s.tripDB.Exec(ctx, `
+		INSERT INTO batch_boxes (box_id, batch_id)
+		SELECT unnest($2::text[]), $1
+		ON CONFLICT (box_id) DO UPDATE SET batch_id = EXCLUDED.batch_id
+	`, batchID, boxIDs)
+	return err
+}
+
+// markProcessedTx records the event and reports false when it was seen before.
+func markProcessedTx(ctx context.Context, tx pgx.Tx, eventID, eventType string) (bool, error) {
+	var inserted string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO processed_events(event_id, event_type, processed_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING event_id
+	`, eventID, eventType).Scan(&inserted); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// handleTransshipmentInitiate opens a transfer session after checking the
+// source trip, the requested boxes and the free space of the target vehicle.
+// Refused requests are stored and announced so the initiator sees why.
+func (s *Service) handleTransshipmentInitiate(ctx context.Context, eventID, eventType string, cmd transshipmentCommand) error {
+	if cmd.TransshipmentID == "" || cmd.SourceTripID == "" {
+		return nil
+	}
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if fresh, err := markProcessedTx(ctx, tx, eventID, eventType); err != nil || !fresh {
+		return err
+	}
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM transshipments WHERE id=$1)`, cmd.TransshipmentID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return tx.Commit(ctx)
+	}
+
+	now := time.Now().UTC()
+	var status string
+	var sourceCarrier *string
+	err = tx.QueryRow(ctx, `SELECT status, carrier_id FROM trips WHERE id=$1 FOR UPDATE`, cmd.SourceTripID).Scan(&status, &sourceCarrier)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	source := ""
+	if sourceCarrier != nil {
+		source = *sourceCarrier
+	}
+	reject := func(reason string) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO transshipments (id, source_trip_id, source_carrier_id, target_carrier_id, status, initiated_by, reason, rejection_reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, cmd.TransshipmentID, cmd.SourceTripID, source, cmd.TargetCarrierID, transshipmentRejected, cmd.InitiatedBy, cmd.Reason, reason, now); err != nil {
+			return err
+		}
+		if err := s.publishTransshipmentTx(ctx, tx, transshipmentRejectedEvent, cmd.TransshipmentID, cmd.SourceTripID, now, map[string]interface{}{
+			"transshipment_id":  cmd.TransshipmentID,
+			"source_trip_id":    cmd.SourceTripID,
+			"target_carrier_id": cmd.TargetCarrierID,
+			"reason":            reason,
+		}); err != nil {
+			return err
+		}
+		slog.Warn("Transshipment rejected", "transshipment_id", cmd.TransshipmentID, "trip_id", cmd.SourceTripID, "reason", reason)
+		return tx.Commit(ctx)
+	}
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return reject("source trip not found")
+	case status != "ASSIGNED" && status != "IN_PROGRESS":
+		return reject("source trip is " + status)
+	case cmd.TargetCarrierID == "" || cmd.TargetCarrierID == source:
+		return reject("target carrier must differ from the source carrier")
+	}
+	var open bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM transshipments WHERE source_trip_id=$1 AND status=$2)
+	`, cmd.SourceTripID, transshipmentInitiated).Scan(&open); err != nil {
+		return err
+	}
+	if open {
+		return reject("another transshipment is in progress for the trip")
+	}
+
+	onTrip, err := tripBoxesTx(ctx, tx, cmd.SourceTripID)
+	if err != nil {
+		return err
+	}
+	expected, unknown := selectBoxes(onTrip, cmd.BoxIDs)
+	if len(unknown) > 0 {
+		return reject(fmt.Sprintf("boxes not on the source trip: %v", unknown))
+	}
+	if len(expected) == 0 {
+		return reject("no boxes to transfer")
+	}
+	load, err := carrierLoadTx(ctx, tx, cmd.TargetCarrierID)
+	if err != nil {
+		return err
+	}
+	if load+len(expected) > s.transship.VehicleCapacityBoxes {
+		return reject(fmt.Sprintf("target vehicle capacity exceeded: %d on board, %d to load, capacity %d", load, len(expected), s.transship.VehicleCapacityBoxes))
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO transshipments (id, source_trip_id, source_carrier_id, target_carrier_id, status, initiated_by, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, cmd.TransshipmentID, cmd.SourceTripID, source, cmd.TargetCarrierID, transshipmentInitiated, cmd.InitiatedBy, cmd.Reason, now); err != nil {
+		return err
+	}
+	boxIDs := make([]string, 0, len(expected))
+	for boxID, batchID := range expected {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO transshipment_boxes (transshipment_id, box_id, batch_id, expected)
+			VALUES ($1, $2, $3, true)
+		`, cmd.TransshipmentID, boxID, batchID); err != nil {
+			return err
+		}
+		boxIDs = append(boxIDs, boxID)
+	}
+	sort.Strings(boxIDs)
+	if err := s.publishTransshipmentTx(ctx, tx, transshipmentInitiatedEvent, cmd.TransshipmentID, cmd.SourceTripID, now, map[string]interface{}{
+		"transshipment_id":  cmd.TransshipmentID,
+		"source_trip_id":    cmd.SourceTripID,
+		"source_carrier_id": source,
+		"target_carrier_id": cmd.TargetCarrierID,
+		"box_ids":           boxIDs,
+		"full":              len(expected) == len(onTrip),
+		"initiated_by":      cmd.InitiatedBy,
+		"reason":            cmd.Reason,
+	}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("Transshipment initiated", "transshipment_id", cmd.TransshipmentID, "trip_id", cmd.SourceTripID, "target_carrier_id", cmd.TargetCarrierID, "boxes", len(expected))
+	return nil
+}
+
+// tripBoxesTx maps every box on the trip to its batch.
+func tripBoxesTx(ctx context.Context, tx pgx.Tx, tripID string) (map[string]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT bb.box_id, bb.batch_id
+		FROM batch_boxes bb
+		JOIN trip_batches tb ON tb.batch_id = bb.batch_id
+		WHERE tb.trip_id = $1
+	`, tripID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[string]string)
+	for rows.Next() {
+		var box, batch string
+		if err := rows.Scan(&box, &batch); err != nil {
+			return nil, err
+		}
+		res[box] = batch
+	}
+	return res, rows.Err()
+}
+
+// carrierLoadTx counts boxes already on the carrier's active trips.
+func carrierLoadTx(ctx context.Context, tx pgx.Tx, carrierID string) (int, error) {
+	var n int
+	err := tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM batch_boxes bb
+		JOIN trip_batches tb ON tb.batch_id = bb.batch_id
+		JOIN trips t ON t.id = tb.trip_id
+		WHERE t.carrier_id = $1 AND t.status IN ('ASSIGNED', 'IN_PROGRESS')
+	`, carrierID).Scan(&n)
+	return n, err
+}
+
+// selectBoxes returns the requested boxes with their batches, or the whole
+// trip when nothing was requested, plus the requested boxes not on the trip.
+func selectBoxes(onTrip map[string]string, requested []string) (map[string]string, []string) {
+	if len(requested) == 0 {
+		res := make(map[string]string, len(onTrip))
+		for box, batch := range onTrip {
+			res[box] = batch
+		}
+		return res, nil
+	}
+	res := make(map[string]string, len(requested))
+	var unknown []string
+	for _, box := range requested {
+		batch, ok := onTrip[box]
+		if !ok {
+			unknown = append(unknown, box)
+			continue
+		}
+		res[box] = batch
+	}
+	return res, unknown
+}
+
+// handleBoxScanned records a scan out of the source vehicle or into the
+// target one. Scans of boxes outside the plan are kept as extras.
+func (s *Service) handleBoxScanned(ctx context.Context, eventID, eventType string, scan boxScan) error {
+	if scan.TransshipmentID == "" || scan.BoxID == "" {
+		return nil
+	}
+	if scan.ScannedAt.IsZero() {
+		scan.ScannedAt = time.Now().UTC()
+	}
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if fresh, err := markProcessedTx(ctx, tx, eventID, eventType); err != nil || !fresh {
+		return err
+	}
+	var status, sourceTrip, sourceCarrier, targetCarrier string
+	if err := tx.QueryRow(ctx, `
+		SELECT status, source_trip_id, source_carrier_id, target_carrier_id FROM transshipments WHERE id=$1 FOR UPDATE
+	`, scan.TransshipmentID).Scan(&status, &sourceTrip, &sourceCarrier, &targetCarrier); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			slog.Warn("Scan for unknown transshipment", "transshipment_id", scan.TransshipmentID, "box_id", scan.BoxID)
+			return tx.Commit(ctx)
+		}
+		return err
+	}
+	if status != transshipmentInitiated {
+		slog.Warn("Scan for closed transshipment", "transshipment_id", scan.TransshipmentID, "status", status, "box_id", scan.BoxID)
+		return tx.Commit(ctx)
+	}
+	scanner := sourceCarrier
+	if scan.Direction == ScanIn {
+		scanner = targetCarrier
+	}
+	if (scan.Direction != ScanIn && scan.Direction != ScanOut) || (scan.CarrierID != "" && scan.CarrierID != scanner) {
+		slog.Warn("Scan rejected", "transshipment_id", scan.TransshipmentID, "box_id", scan.BoxID, "direction", scan.Direction, "carrier_id", scan.CarrierID)
+		return tx.Commit(ctx)
+	}
+
+	var expected bool
+	var scannedIn *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT expected, scanned_in_at FROM transshipment_boxes WHERE transshipment_id=$1 AND box_id=$2
+	`, scan.TransshipmentID, scan.BoxID).Scan(&expected, &scannedIn)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO transshipment_boxes (transshipment_id, box_id, expected) VALUES ($1, $2, false)
+		`, scan.TransshipmentID, scan.BoxID); err != nil {
+			return err
+		}
+	}
+	column := "scanned_out_at"
+	if scan.Direction == ScanIn {
+		column = "scanned_in_at"
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE transshipment_boxes SET `+column+` = COALESCE(`+column+`, $3)
+		WHERE transshipment_id=$1 AND box_id=$2
+	`, scan.TransshipmentID, scan.BoxID, scan.ScannedAt); err != nil {
+		return err
+	}
+	if scan.Direction == ScanIn && expected && scannedIn == nil {
+		if err := s.publishTransshipmentTx(ctx, tx, transshipmentBoxMovedEvent, scan.TransshipmentID+"/"+scan.BoxID, sourceTrip, scan.ScannedAt, map[string]interface{}{
+			"transshipment_id":  scan.TransshipmentID,
+			"box_id":            scan.BoxID,
+			"source_trip_id":    sourceTrip,
+			"source_carrier_id": sourceCarrier,
+			"target_carrier_id": targetCarrier,
+			"moved_at":          scan.ScannedAt,
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+type transshipmentBox struct {
+	BoxID      string
+	BatchID    string
+	Expected   bool
+	ScannedOut bool
+	ScannedIn  bool
+}
+
+// Reconciliation is the outcome of comparing the plan with the scans.
+type Reconciliation struct {
+	Moved   []string `json:"moved"`
+	Missing []string `json:"missing"`
+	Extra   []string `json:"extra"`
+}
+
+func (r Reconciliation) Clean() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0
+}
+
+// reconcile treats a planned box as moved once it is scanned into the target
+// vehicle. Planned boxes never scanned in stay on the source trip as missing;
+// scanned boxes outside the plan are reported as extra and not moved.
+func reconcile(boxes []transshipmentBox) Reconciliation {
+	r := Reconciliation{Moved: []string{}, Missing: []string{}, Extra: []string{}}
+	for _, b := range boxes {
+		switch {
+		case !b.Expected:
+			r.Extra = append(r.Extra, b.BoxID)
+		case b.ScannedIn:
+			r.Moved = append(r.Moved, b.BoxID)
+		default:
+			r.Missing = append(r.Missing, b.BoxID)
+		}
+	}
+	sort.Strings(r.Moved)
+	sort.Strings(r.Missing)
+	sort.Strings(r.Extra)
+	return r
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func loadTransshipmentBoxes(ctx context.Context, q querier, transshipmentID string) ([]transshipmentBox, error) {
+	rows, err := q.Query(ctx, `
+		SELECT box_id, COALESCE(batch_id, ''), expected, scanned_out_at IS NOT NULL, scanned_in_at IS NOT NULL
+		FROM transshipment_boxes WHERE transshipment_id=$1
+	`, transshipmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []transshipmentBox
+	for rows.Next() {
+		var b transshipmentBox
+		if err := rows.Scan(&b.BoxID, &b.BatchID, &b.Expected, &b.ScannedOut, &b.ScannedIn); err != nil {
+			return nil, err
+		}
+		res = append(res, b)
+	}
+	return res, rows.Err()
+}
+
+// handleTransshipmentComplete reconciles the scans and re-links the moved
+// boxes. Whole batches move to the target trip; partly moved batches are
+// split and the moved part gets a new batch. When nothing is left on the
+// source trip the vehicle is replaced entirely.
+func (s *Service) handleTransshipmentComplete(ctx context.Context, eventID, eventType string, cmd transshipmentCommand) error {
+	if cmd.TransshipmentID == "" {
+		return nil
+	}
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if fresh, err := markProcessedTx(ctx, tx, eventID, eventType); err != nil || !fresh {
+		return err
+	}
+	var status, sourceTrip, sourceCarrier, targetCarrier string
+	if err := tx.QueryRow(ctx, `
+		SELECT status, source_trip_id, source_carrier_id, target_carrier_id FROM transshipments WHERE id=$1 FOR UPDATE
+	`, cmd.TransshipmentID).Scan(&status, &sourceTrip, &sourceCarrier, &targetCarrier); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return tx.Commit(ctx)
+		}
+		return err
+	}
+	if status != transshipmentInitiated {
+		return tx.Commit(ctx)
+	}
+	var destLat, destLng, originLat, originLng float64
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(origin_lat, 0), COALESCE(origin_lng, 0), COALESCE(dest_lat, 0), COALESCE(dest_lng, 0)
+		FROM trips WHERE id=$1 FOR UPDATE
+	`, sourceTrip).Scan(&originLat, &originLng, &destLat, &destLng); err != nil {
+		return err
+	}
+	boxes, err := loadTransshipmentBoxes(ctx, tx, cmd.TransshipmentID)
+	if err != nil {
+		return err
+	}
+	rec := reconcile(boxes)
+	now := time.Now().UTC()
+
+	var targetTrip string
+	full := false
+	newBatches := map[string]string{}
+	if len(rec.Moved) > 0 {
+		// The transfer happens where the source vehicle stands.
+		var lat, lng float64
+		if err := tx.QueryRow(ctx, `SELECT latitude, longitude FROM carrier_positions WHERE carrier_id=$1`, sourceCarrier).Scan(&lat, &lng); err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+			lat, lng = originLat, originLng
+		}
+		dist := 0
+		var tLat, tLng float64
+		if err := tx.QueryRow(ctx, `SELECT latitude, longitude FROM carrier_positions WHERE carrier_id=$1`, targetCarrier).Scan(&tLat, &tLng); err == nil {
+			dist = int(math.Round(haversine(lat, lng, tLat, tLng)))
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		targetTrip, err = s.createTripTx(ctx, tx, newTrip{
+			CarrierID: targetCarrier, DistanceMeters: dist, OriginLat: lat, OriginLng: lng, DestLat: destLat, DestLng: destLng,
+			Actor: targetCarrier, Reason: "transshipment",
+		}, now)
+		if err != nil {
+			return err
+		}
+		onTrip, err := tripBoxesTx(ctx, tx, sourceTrip)
+		if err != nil {
+			return err
+		}
+		plan := planBatchMoves(onTrip, rec.Moved)
+		for _, batchID := range plan.whole {
+			if _, err := tx.Exec(ctx, `
+				UPDATE trip_batches SET trip_id=$3 WHERE trip_id=$1 AND batch_id=$2
+			`, sourceTrip, batchID, targetTrip); err != nil {
+				return err
+			}
+			if err := s.publishAssignedTx(ctx, tx, targetTrip, batchID, targetCarrier, dist, lat, lng, destLat, destLng, now); err != nil {
+				return err
+			}
+		}
+		for _, parent := range sortedKeys(plan.split) {
+			moved := plan.split[parent]
+			child := uuid.NewString()
+			newBatches[parent] = child
+			if _, err := tx.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, targetTrip, child); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE batch_boxes SET batch_id=$2 WHERE box_id = ANY($1)`, moved, child); err != nil {
+				return err
+			}
+			if err := s.publishBatchSplitTx(ctx, tx, parent, child, moved, sourceTrip, targetTrip, cmd.TransshipmentID, now); err != nil {
+				return err
+			}
+			if err := s.publishAssignedTx(ctx, tx, targetTrip, child, targetCarrier, dist, lat, lng, destLat, destLng, now); err != nil {
+				return err
+			}
+		}
+		var remaining int
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM trip_batches WHERE trip_id=$1`, sourceTrip).Scan(&remaining); err != nil {
+			return err
+		}
+		if remaining == 0 {
+			full = true
+			if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: sourceTrip, To: TripReassigned, Actor: cmd.CompletedBy, Reason: "transshipment"}, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	final := transshipmentCompleted
+	if !rec.Clean() {
+		final = transshipmentCompletedWithDiscrepancies
+	}
+	var target *string
+	if targetTrip != "" {
+		target = &targetTrip
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE transshipments
+		SET status=$2, target_trip_id=$3, completed_by=$4, completed_at=$5, moved_count=$6, missing_count=$7, extra_count=$8
+		WHERE id=$1
+	`, cmd.TransshipmentID, final, target, cmd.CompletedBy, now, len(rec.Moved), len(rec.Missing), len(rec.Extra)); err != nil {
+		return err
+	}
+	if err := s.publishTransshipmentTx(ctx, tx, transshipmentCompletedEvent, cmd.TransshipmentID, sourceTrip, now, map[string]interface{}{
+		"transshipment_id":  cmd.TransshipmentID,
+		"source_trip_id":    sourceTrip,
+		"target_trip_id":    targetTrip,
+		"source_carrier_id": sourceCarrier,
+		"target_carrier_id": targetCarrier,
+		"status":            final,
+		"full":              full,
+		"moved":             rec.Moved,
+		"missing":           rec.Missing,
+		"extra":             rec.Extra,
+		"split_batches":     newBatches,
+		"completed_by":      cmd.CompletedBy,
+	}); err != nil {
+		return err
+	}
+	if !rec.Clean() {
+		if err := s.publishTransshipmentAlertTx(ctx, tx, cmd.TransshipmentID, sourceTrip, sourceCarrier, rec, now); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("Transshipment completed", "transshipment_id", cmd.TransshipmentID, "source_trip_id", sourceTrip, "target_trip_id", targetTrip,
+		"moved", len(rec.Moved), "missing", len(rec.Missing), "extra", len(rec.Extra), "full", full)
+	return nil
+}
+
+type batchMovePlan struct {
+	whole []string
+	split map[string][]string
+}
+
+// planBatchMoves decides per batch whether it moves whole or has to be split.
+func planBatchMoves(onTrip map[string]string, moved []string) batchMovePlan {
+	total := make(map[string]int)
+	for _, batch := range onTrip {
+		total[batch]++
+	}
+	movedByBatch := make(map[string][]string)
+	for _, box := range moved {
+		if batch, ok := onTrip[box]; ok {
+			movedByBatch[batch] = append(movedByBatch[batch], box)
+		}
+	}
+	plan := batchMovePlan{split: map[string][]string{}}
+	for _, batch := range sortedKeys(movedByBatch) {
+		boxes := movedByBatch[batch]
+		if len(boxes) == total[batch] {
+			plan.whole = append(plan.whole, batch)
+			continue
+		}
+		sort.Strings(boxes)
+		plan.split[batch] = boxes
+	}
+	return plan
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Service) publishTransshipmentTx(ctx context.Context, tx pgx.Tx, eventType, correlationID, tripID string, now time.Time, data map[string]interface{}) error {
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     eventType,
+		"occurred_at":    now,
+		"correlation_id": correlationID,
+		"data":           data,
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     eventType,
+		CorrelationID: correlationID,
+		Topic:         s.transship.Topic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+// publishBatchSplitTx tells batching-service to carve the moved orders out of
+// the parent batch into the child batch.
+func (s *Service) publishBatchSplitTx(ctx context.Context, tx pgx.Tx, parentID, childID string, orderIDs []string, sourceTrip, targetTrip, transshipmentID string, now time.Time) error {
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     batchSplitEvent,
+		"occurred_at":    now,
+		"correlation_id": parentID,
+		"data": map[string]interface{}{
+			"parent_batch_id":  parentID,
+			"batch_id":         childID,
+			"order_ids":        orderIDs,
+			"source_trip_id":   sourceTrip,
+			"target_trip_id":   targetTrip,
+			"transshipment_id": transshipmentID,
+			"split_at":         now,
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     batchSplitEvent,
+		CorrelationID: parentID + "/" + childID,
+		Topic:         s.transship.SplitTopic,
+		PartitionKey:  parentID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+func (s *Service) publishTransshipmentAlertTx(ctx context.Context, tx pgx.Tx, transshipmentID, tripID, carrierID string, rec Reconciliation, now time.Time) error {
+	envelope := map[string]interface{}{
+		"event_id":       uuid.NewString(),
+		"event_type":     transshipmentAlertTopic,
+		"occurred_at":    now,
+		"correlation_id": transshipmentID,
+		"data": map[string]interface{}{
+			"transshipment_id": transshipmentID,
+			"trip_id":          tripID,
+			"carrier_id":       carrierID,
+			"missing":          rec.Missing,
+			"extra":            rec.Extra,
+			"message":          fmt.Sprintf("Перегрузка %s: недостача %d, лишних коробок %d", transshipmentID, len(rec.Missing), len(rec.Extra)),
+			"severity":         "warning",
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     transshipmentAlertTopic,
+		CorrelationID: transshipmentID,
+		Topic:         transshipmentAlertTopic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+// TransshipmentView is the state of a transfer with its reconciliation.
+type TransshipmentView struct {
+	ID              string         `json:"id"`
+	SourceTripID    string         `json:"source_trip_id"`
+	TargetTripID    *string        `json:"target_trip_id"`
+	SourceCarrierID string         `json:"source_carrier_id"`
+	TargetCarrierID string         `json:"target_carrier_id"`
+	Status          string         `json:"status"`
+	RejectionReason *string        `json:"rejection_reason,omitempty"`
+	InitiatedBy     string         `json:"initiated_by"`
+	Reason          string         `json:"reason"`
+	CreatedAt       time.Time      `json:"created_at"`
+	CompletedAt     *time.Time     `json:"completed_at"`
+	Expected        int            `json:"expected"`
+	ScannedOut      int            `json:"scanned_out"`
+	Reconciliation  Reconciliation `json:"reconciliation"`
+}
+
+var ErrTransshipmentNotFound = errors.New("transshipment not found")
+
+// GetTransshipment returns the transfer with a reconciliation of the scans so
+// far; for open transfers "missing" lists the boxes still to be loaded.
+func (s *Service) GetTransshipment(ctx context.Context, id string) (TransshipmentView, error) {
+	var v TransshipmentView
+	if err := s.tripDB.QueryRow(ctx, `
+		SELECT id::text, source_trip_id::text, target_trip_id::text, COALESCE(source_carrier_id, ''), target_carrier_id, status,
+		       rejection_reason, COALESCE(initiated_by, ''), COALESCE(reason, ''), created_at, completed_at
+		FROM transshipments WHERE id=$1
+	`, id).Scan(&v.ID, &v.SourceTripID, &v.TargetTripID, &v.SourceCarrierID, &v.TargetCarrierID, &v.Status,
+		&v.RejectionReason, &v.InitiatedBy, &v.Reason, &v.CreatedAt, &v.CompletedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return v, ErrTransshipmentNotFound
+		}
+		return v, err
+	}
+	boxes, err := loadTransshipmentBoxes(ctx, s.tripDB, id)
+	if err != nil {
+		return v, err
+	}
+	for _, b := range boxes {
+		if b.Expected {
+			v.Expected++
+		}
+		if b.ScannedOut {
+			v.ScannedOut++
+		}
+	}
+	v.Reconciliation = reconcile(boxes)
+	return v, nil
+}
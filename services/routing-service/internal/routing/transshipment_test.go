@@ -0,0 +1,157 @@
+package routing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSelectBoxes_WholeTripWhenNothingRequested(t *testing.T) {
+	onTrip := map[string]string{"o1": "b1", "o2": "b1", "o3": "b2"}
+	got, unknown := selectBoxes(onTrip, nil)
+	if len(got) != 3 || len(unknown) != 0 {
+		t.Fatalf("expected the whole trip, got %v unknown %v", got, unknown)
+	}
+	got, unknown = selectBoxes(onTrip, []string{"o3", "o9"})
+	if len(got) != 1 || got["o3"] != "b2" {
+		t.Fatalf("unexpected selection: %v", got)
+	}
+	if !reflect.DeepEqual(unknown, []string{"o9"}) {
+		t.Fatalf("expected o9 reported as unknown, got %v", unknown)
+	}
+}
+
+func TestReconcile_MissingAndExtraBoxes(t *testing.T) {
+	rec := reconcile([]transshipmentBox{
+		{BoxID: "o2", Expected: true, ScannedOut: true, ScannedIn: true},
+		{BoxID: "o1", Expected: true, ScannedOut: true, ScannedIn: true},
+		{BoxID: "o3", Expected: true, ScannedOut: true},
+		{BoxID: "x1", ScannedIn: true},
+	})
+	if !reflect.DeepEqual(rec.Moved, []string{"o1", "o2"}) || !reflect.DeepEqual(rec.Missing, []string{"o3"}) || !reflect.DeepEqual(rec.Extra, []string{"x1"}) {
+		t.Fatalf("unexpected reconciliation: %+v", rec)
+	}
+	if rec.Clean() {
+		t.Fatal("expected discrepancies")
+	}
+}
+
+func TestPlanBatchMoves_WholeAndSplit(t *testing.T) {
+	onTrip := map[string]string{"o1": "b1", "o2": "b1", "o3": "b2", "o4": "b2"}
+	plan := planBatchMoves(onTrip, []string{"o1", "o2", "o4"})
+	if !reflect.DeepEqual(plan.whole, []string{"b1"}) {
+		t.Fatalf("expected b1 to move whole, got %v", plan.whole)
+	}
+	if !reflect.DeepEqual(plan.split, map[string][]string{"b2": {"o4"}}) {
+		t.Fatalf("expected b2 to be split, got %v", plan.split)
+	}
+}
+
+func TestTransshipment_PartialTransferSplitsBatch(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips")
+
+	suffix := uuid.NewString()[:8]
+	source, target := "src-"+suffix, "dst-"+suffix
+	tripID := uuid.NewString()
+	if _, err := db.Exec(ctx, `INSERT INTO trips (id, carrier_id, status, assigned_at) VALUES ($1, $2, 'IN_PROGRESS', NOW())`, tripID, source); err != nil {
+		t.Fatal(err)
+	}
+	whole, split := "bw-"+suffix, "bs-"+suffix
+	boxes := map[string][]string{whole: {"w1-" + suffix}, split: {"s1-" + suffix, "s2-" + suffix}}
+	for batch, ids := range boxes {
+		if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := svc.recordBatchBoxes(ctx, batch, ids); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tsID := uuid.NewString()
+	cmd := transshipmentCommand{TransshipmentID: tsID, SourceTripID: tripID, TargetCarrierID: target, InitiatedBy: "op-1"}
+	if err := svc.handleTransshipmentInitiate(ctx, uuid.NewString(), "commands.transshipment.initiate", cmd); err != nil {
+		t.Fatal(err)
+	}
+	scans := []boxScan{
+		{BoxID: "w1-" + suffix, Direction: ScanOut, CarrierID: source},
+		{BoxID: "w1-" + suffix, Direction: ScanIn, CarrierID: target},
+		{BoxID: "s1-" + suffix, Direction: ScanOut, CarrierID: source},
+		{BoxID: "s1-" + suffix, Direction: ScanIn, CarrierID: target},
+		{BoxID: "stray-" + suffix, Direction: ScanIn, CarrierID: target},
+	}
+	for _, sc := range scans {
+		sc.TransshipmentID = tsID
+		if err := svc.handleBoxScanned(ctx, uuid.NewString(), "commands.transshipment.scan", sc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.handleTransshipmentComplete(ctx, uuid.NewString(), "commands.transshipment.complete", transshipmentCommand{TransshipmentID: tsID, CompletedBy: target}); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := svc.GetTransshipment(ctx, tsID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != transshipmentCompletedWithDiscrepancies || v.TargetTripID == nil {
+		t.Fatalf("unexpected transshipment: %+v", v)
+	}
+	if !reflect.DeepEqual(v.Reconciliation.Missing, []string{"s2-" + suffix}) || !reflect.DeepEqual(v.Reconciliation.Extra, []string{"stray-" + suffix}) {
+		t.Fatalf("unexpected reconciliation: %+v", v.Reconciliation)
+	}
+	var onTarget, onSource int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM trip_batches WHERE trip_id=$1`, *v.TargetTripID).Scan(&onTarget); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM trip_batches WHERE trip_id=$1`, tripID).Scan(&onSource); err != nil {
+		t.Fatal(err)
+	}
+	if onTarget != 2 || onSource != 1 {
+		t.Fatalf("expected the whole batch and a split child on the target, got %d target / %d source", onTarget, onSource)
+	}
+	assertTripCreated(t, db, *v.TargetTripID, TripAssigned)
+	var status string
+	if err := db.QueryRow(ctx, `SELECT status FROM trips WHERE id=$1`, tripID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != "IN_PROGRESS" {
+		t.Fatalf("partial transfer must keep the source trip running, got %s", status)
+	}
+}
+
+func TestTransshipment_RejectedWhenTargetFull(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips").WithTransshipmentPolicy(TransshipmentPolicy{VehicleCapacityBoxes: 1})
+
+	suffix := uuid.NewString()[:8]
+	tripID := uuid.NewString()
+	if _, err := db.Exec(ctx, `INSERT INTO trips (id, carrier_id, status, assigned_at) VALUES ($1, $2, 'ASSIGNED', NOW())`, tripID, "src-"+suffix); err != nil {
+		t.Fatal(err)
+	}
+	batch := "b-" + suffix
+	if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.recordBatchBoxes(ctx, batch, []string{"a-" + suffix, "b-" + suffix}); err != nil {
+		t.Fatal(err)
+	}
+	tsID := uuid.NewString()
+	cmd := transshipmentCommand{TransshipmentID: tsID, SourceTripID: tripID, TargetCarrierID: "dst-" + suffix}
+	if err := svc.handleTransshipmentInitiate(ctx, uuid.NewString(), "commands.transshipment.initiate", cmd); err != nil {
+		t.Fatal(err)
+	}
+	v, err := svc.GetTransshipment(ctx, tsID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != transshipmentRejected || v.RejectionReason == nil {
+		t.Fatalf("expected capacity rejection, got %+v", v)
+	}
+}
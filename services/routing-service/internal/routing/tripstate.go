@@ -0,0 +1,341 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"bel-parcel/services/routing-service/internal/outbox"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Trip statuses.
+const (
+	TripPending        = "PENDING"
+	TripOffered        = "OFFERED"
+	TripAssigned       = "ASSIGNED"
+	TripInProgress     = "IN_PROGRESS"
+	TripCompleted      = "COMPLETED"
+	TripReassigned     = "REASSIGNED"
+	TripRequiresManual = "REQUIRES_MANUAL_ASSIGNMENT"
+	TripIncident       = "INCIDENT"
+	TripOnHold         = "ON_HOLD"
+	TripCancelled      = "CANCELLED"
+)
+
+const (
+	tripStatusChanged = "trips.status_changed"
+	tripCancelCommand = "commands.trip.cancel"
+	tripHoldCommand   = "commands.trip.hold"
+	tripResumeCommand = "commands.trip.resume"
+	systemActor       = "system"
+)
+
+// tripTransitions lists the statuses a trip may move to from each status.
+// COMPLETED, REASSIGNED, INCIDENT and CANCELLED are final. A trip with cargo
+// on board cannot be cancelled; it has to be reassigned or transshipped.
+var tripTransitions = map[string][]string{
+	TripPending:        {TripOffered, TripAssigned, TripRequiresManual, TripOnHold, TripCancelled},
+	TripOffered:        {TripAssigned, TripPending, TripOnHold, TripCancelled},
+	TripAssigned:       {TripInProgress, TripReassigned, TripIncident, TripOnHold, TripCancelled},
+	TripInProgress:     {TripCompleted, TripReassigned, TripIncident, TripOnHold},
+	TripRequiresManual: {TripAssigned, TripPending, TripReassigned, TripOnHold, TripCancelled},
+	TripOnHold:         {TripPending, TripAssigned, TripInProgress, TripRequiresManual, TripCancelled},
+}
+
+var ErrInvalidTransition = errors.New("invalid trip status transition")
+
+// CanTransition reports whether a trip may move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, s := range tripTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// tripTransition is a single status change. From, when set, is the status the
+// caller expects; a trip in any other status is left alone.
+type tripTransition struct {
+	TripID string
+	From   string
+	To     string
+	Actor  string
+	Reason string
+}
+
+// transitionTripTx moves a trip to a new status if the state machine allows
+// it, records who did it and why, and publishes trips.status_changed. It
+// returns the previous status; a refused change wraps ErrInvalidTransition.
+func (s *Service) transitionTripTx(ctx context.Context, tx pgx.Tx, t tripTransition, now time.Time) (string, error) {
+	var from string
+	var carrierID *string
+	if err := tx.QueryRow(ctx, `SELECT status, carrier_id FROM trips WHERE id=$1 FOR UPDATE`, t.TripID).Scan(&from, &carrierID); err != nil {
+		return "", err
+	}
+	if (t.From != "" && from != t.From) || !CanTransition(from, t.To) {
+		return from, fmt.Errorf("%w: trip %s %s -> %s", ErrInvalidTransition, t.TripID, from, t.To)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE trips SET status=$2 WHERE id=$1`, t.TripID, t.To); err != nil {
+		return from, err
+	}
+	return from, s.recordTripStatusTx(ctx, tx, t.TripID, carrierID, from, t.To, t.Actor, t.Reason, now)
+}
+
+// newTrip is a trip to create. A trip with a carrier starts ASSIGNED and
+// one without starts in Status, PENDING or OFFERED.
+type newTrip struct {
+	Status         string
+	CarrierID      string
+	DistanceMeters int
+	OriginLat      float64
+	OriginLng      float64
+	DestLat        float64
+	DestLng        float64
+	Actor          string
+	Reason         string
+}
+
+// createTripTx inserts a trip and records its creation like a status change
+// from nothing, so it has a history from the start and the trip projections
+// learn about it from trips.status_changed.
+func (s *Service) createTripTx(ctx context.Context, tx pgx.Tx, t newTrip, now time.Time) (string, error) {
+	var carrierID, assignedAt any
+	if t.CarrierID != "" {
+		t.Status = TripAssigned
+		carrierID, assignedAt = t.CarrierID, now
+	}
+	if t.Status != TripPending && t.Status != TripOffered && t.Status != TripAssigned {
+		return "", fmt.Errorf("%w: new trip in %s", ErrInvalidTransition, t.Status)
+	}
+	var tripID string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO trips (id, carrier_id, status, assigned_at, assigned_distance_meters, origin_lat, origin_lng, dest_lat, dest_lng)
+		VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8) RETURNING id
+	`, carrierID, t.Status, assignedAt, t.DistanceMeters, t.OriginLat, t.OriginLng, t.DestLat, t.DestLng).Scan(&tripID); err != nil {
+		return "", err
+	}
+	var carrier *string
+	if t.CarrierID != "" {
+		carrier = &t.CarrierID
+	}
+	return tripID, s.recordTripStatusTx(ctx, tx, tripID, carrier, "", t.Status, t.Actor, t.Reason, now)
+}
+
+// recordTripStatusTx writes the history row of a status change and publishes
+// trips.status_changed. An empty from marks the creation of the trip.
+func (s *Service) recordTripStatusTx(ctx context.Context, tx pgx.Tx, tripID string, carrierID *string, from, to, actor, reason string, now time.Time) error {
+	if actor == "" {
+		actor = systemActor
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO trip_status_history (trip_id, from_status, to_status, actor, reason, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, tripID, from, to, actor, reason, now); err != nil {
+		return err
+	}
+	eventID := uuid.NewString()
+	envelope := map[string]interface{}{
+		"event_id":       eventID,
+		"event_type":     tripStatusChanged,
+		"occurred_at":    now,
+		"correlation_id": tripID,
+		"data": map[string]interface{}{
+			"trip_id":     tripID,
+			"carrier_id":  carrierID,
+			"from_status": from,
+			"to_status":   to,
+			"actor":       actor,
+			"reason":      reason,
+			"changed_at":  now,
+		},
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     tripStatusChanged,
+		CorrelationID: eventID,
+		Topic:         s.statusTopic,
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
+func (s *Service) WithStatusTopic(topic string) *Service {
+	if topic != "" {
+		s.statusTopic = topic
+	}
+	return s
+}
+
+type tripStatusCommand struct {
+	TripID     string `json:"trip_id"`
+	Reason     string `json:"reason"`
+	OperatorID string `json:"operator_id"`
+}
+
+// handleTripCommand applies an operator's cancel, hold or resume. A command
+// the state machine refuses is logged and dropped.
+func (s *Service) handleTripCommand(ctx context.Context, eventID, eventType string, cmd tripStatusCommand) error {
+	if cmd.TripID == "" {
+		return nil
+	}
+	tx, err := s.tripDB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if fresh, err := markProcessedTx(ctx, tx, eventID, eventType); err != nil || !fresh {
+		return err
+	}
+	now := time.Now().UTC()
+	switch eventType {
+	case tripCancelCommand:
+		err = s.cancelTripTx(ctx, tx, cmd, now)
+	case tripHoldCommand:
+		err = s.holdTripTx(ctx, tx, cmd, now)
+	case tripResumeCommand:
+		err = s.resumeTripTx(ctx, tx, cmd, now)
+	default:
+		return tx.Commit(ctx)
+	}
+	if errors.Is(err, ErrInvalidTransition) || errors.Is(err, pgx.ErrNoRows) {
+		slog.Warn("Trip command refused", "command", eventType, "trip_id", cmd.TripID, "operator_id", cmd.OperatorID, "error", err)
+		return tx.Commit(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("Trip command applied", "command", eventType, "trip_id", cmd.TripID, "operator_id", cmd.OperatorID)
+	return nil
+}
+
+// cancelTripTx cancels a trip that has not been picked up. Its batches still
+// have to be delivered, so they move to a new PENDING trip that looks for a
+// carrier like any other.
+func (s *Service) cancelTripTx(ctx context.Context, tx pgx.Tx, cmd tripStatusCommand, now time.Time) error {
+	if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: cmd.TripID, To: TripCancelled, Actor: cmd.OperatorID, Reason: cmd.Reason}, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM pending_assignments WHERE trip_id=$1`, cmd.TripID); err != nil {
+		return err
+	}
+	if err := s.withdrawOffersTx(ctx, tx, cmd.TripID, "cancelled", now); err != nil {
+		return err
+	}
+	var hasBatches bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM trip_batches WHERE trip_id=$1)`, cmd.TripID).Scan(&hasBatches); err != nil || !hasBatches {
+		return err
+	}
+	replan := newTrip{Status: TripPending, Actor: cmd.OperatorID, Reason: "trip_cancelled"}
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(origin_lat, 0), COALESCE(origin_lng, 0), COALESCE(dest_lat, 0), COALESCE(dest_lng, 0) FROM trips WHERE id=$1
+	`, cmd.TripID).Scan(&replan.OriginLat, &replan.OriginLng, &replan.DestLat, &replan.DestLng); err != nil {
+		return err
+	}
+	newTripID, err := s.createTripTx(ctx, tx, replan, now)
+	if err != nil {
+		return err
+	}
+	batches, err := moveBatchesTx(ctx, tx, cmd.TripID, newTripID)
+	if err != nil {
+		return err
+	}
+	slog.Info("Batches of a cancelled trip moved to a new trip", "trip_id", cmd.TripID, "new_trip_id", newTripID, "batches", batches)
+	return s.registerPendingTx(ctx, tx, newTripID, batches[0], now)
+}
+
+// holdTripTx freezes a trip. A trip that was still looking for a carrier
+// stops searching (the pending loop only picks PENDING trips, so its attempt
+// count is kept) and resumes as PENDING; any other trip resumes in the status
+// it was held in.
+func (s *Service) holdTripTx(ctx context.Context, tx pgx.Tx, cmd tripStatusCommand, now time.Time) error {
+	from, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: cmd.TripID, To: TripOnHold, Actor: cmd.OperatorID, Reason: cmd.Reason}, now)
+	if err != nil {
+		return err
+	}
+	if from == TripOffered {
+		from = TripPending
+		if err := s.withdrawOffersTx(ctx, tx, cmd.TripID, "on_hold", now); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(ctx, `UPDATE trips SET held_from_status=$2 WHERE id=$1`, cmd.TripID, from)
+	return err
+}
+
+func (s *Service) resumeTripTx(ctx context.Context, tx pgx.Tx, cmd tripStatusCommand, now time.Time) error {
+	var heldFrom *string
+	if err := tx.QueryRow(ctx, `SELECT held_from_status FROM trips WHERE id=$1`, cmd.TripID).Scan(&heldFrom); err != nil {
+		return err
+	}
+	to := TripPending
+	if heldFrom != nil && *heldFrom != "" {
+		to = *heldFrom
+	}
+	if _, err := s.transitionTripTx(ctx, tx, tripTransition{TripID: cmd.TripID, From: TripOnHold, To: to, Actor: cmd.OperatorID, Reason: cmd.Reason}, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE trips SET held_from_status=NULL WHERE id=$1`, cmd.TripID); err != nil {
+		return err
+	}
+	if to != TripPending {
+		return nil
+	}
+	ct, err := tx.Exec(ctx, `UPDATE pending_assignments SET timeout_at=$2 WHERE trip_id=$1`, cmd.TripID, now)
+	if err != nil || ct.RowsAffected() > 0 {
+		return err
+	}
+	var batchID string
+	if err := tx.QueryRow(ctx, `SELECT batch_id FROM trip_batches WHERE trip_id=$1 ORDER BY batch_id LIMIT 1`, cmd.TripID).Scan(&batchID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	return s.registerPendingTx(ctx, tx, cmd.TripID, batchID, now)
+}
+
+// TripStatusChange is one row of a trip's status history.
+type TripStatusChange struct {
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	Reason     string    `json:"reason"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// TripHistory returns the status changes of a trip, oldest first.
+func (s *Service) TripHistory(ctx context.Context, tripID string) ([]TripStatusChange, error) {
+	rows, err := s.tripDB.Query(ctx, `
+		SELECT from_status, to_status, actor, COALESCE(reason, ''), changed_at
+		FROM trip_status_history WHERE trip_id=$1
+		ORDER BY id
+	`, tripID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := []TripStatusChange{}
+	for rows.Next() {
+		var c TripStatusChange
+		if err := rows.Scan(&c.FromStatus, &c.ToStatus, &c.Actor, &c.Reason, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,148 @@
+package routing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{TripPending, TripAssigned, true},
+		{TripAssigned, TripInProgress, true},
+		{TripInProgress, TripCompleted, true},
+		{TripInProgress, TripCancelled, false},
+		{TripCompleted, TripReassigned, false},
+		{TripCancelled, TripPending, false},
+		{TripOnHold, TripAssigned, true},
+		{TripAssigned, TripPending, false},
+	}
+	for _, c := range cases {
+		if got := CanTransition(c.from, c.to); got != c.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestTripCommands_HoldResumeCancel(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, nil, "trips")
+
+	tripID := uuid.NewString()
+	batchID := "batch-" + tripID[:8]
+	if _, err := db.Exec(ctx, `INSERT INTO trips (id, carrier_id, status) VALUES ($1, NULL, 'PENDING')`, tripID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO trip_batches (trip_id, batch_id) VALUES ($1, $2)`, tripID, batchID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO pending_assignments (trip_id, batch_id, attempt_count, timeout_at) VALUES ($1, $2, 0, NOW())`, tripID, batchID); err != nil {
+		t.Fatal(err)
+	}
+	cmd := tripStatusCommand{TripID: tripID, Reason: "customer request", OperatorID: "op-1"}
+	status := func() string {
+		var s string
+		if err := db.QueryRow(ctx, `SELECT status FROM trips WHERE id=$1`, tripID).Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if err := svc.handleTripCommand(ctx, uuid.NewString(), tripHoldCommand, cmd); err != nil {
+		t.Fatal(err)
+	}
+	if status() != TripOnHold {
+		t.Fatalf("expected held trip, got %s", status())
+	}
+	if err := svc.processPendingAssignments(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var attempts int
+	_ = db.QueryRow(ctx, `SELECT attempt_count FROM pending_assignments WHERE trip_id=$1`, tripID).Scan(&attempts)
+	if status() != TripOnHold || attempts != 0 {
+		t.Fatalf("held trip must stop searching, got status %s after %d attempts", status(), attempts)
+	}
+
+	if err := svc.handleTripCommand(ctx, uuid.NewString(), tripResumeCommand, cmd); err != nil {
+		t.Fatal(err)
+	}
+	var pending int
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM pending_assignments WHERE trip_id=$1 AND timeout_at <= NOW()`, tripID).Scan(&pending)
+	if status() != TripPending || pending != 1 {
+		t.Fatalf("resumed trip must search again, got status %s and %d pending rows", status(), pending)
+	}
+
+	if err := svc.handleTripCommand(ctx, uuid.NewString(), tripCancelCommand, cmd); err != nil {
+		t.Fatal(err)
+	}
+	if status() != TripCancelled {
+		t.Fatalf("expected cancelled trip, got %s", status())
+	}
+	// The batch is not left on the cancelled trip but planned again.
+	var replanned string
+	if err := db.QueryRow(ctx, `SELECT trip_id::text FROM trip_batches WHERE batch_id=$1`, batchID).Scan(&replanned); err != nil {
+		t.Fatal(err)
+	}
+	if replanned == tripID {
+		t.Fatalf("batch must leave the cancelled trip")
+	}
+	assertTripCreated(t, db, replanned, TripPending)
+	var queued int
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM pending_assignments WHERE trip_id=$1`, replanned).Scan(&queued)
+	if queued != 1 {
+		t.Fatalf("expected the new trip registered for assignment, got %d rows", queued)
+	}
+	// A cancelled trip is final: resuming it is refused without an error.
+	if err := svc.handleTripCommand(ctx, uuid.NewString(), tripResumeCommand, cmd); err != nil {
+		t.Fatal(err)
+	}
+	if status() != TripCancelled {
+		t.Fatalf("cancelled trip must stay cancelled, got %s", status())
+	}
+
+	history, err := svc.TripHistory(ctx, tripID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].Actor != "op-1" || history[2].ToStatus != TripCancelled {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	var events int
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM outbox_events WHERE event_type=$1 AND partition_key=$2`, tripStatusChanged, tripID).Scan(&events)
+	if events != 3 {
+		t.Fatalf("expected one trips.status_changed per transition, got %d", events)
+	}
+}
+
+// assertTripCreated checks that a trip created by the service went through
+// createTripTx: its history starts with the creation and the projections got
+// a trips.status_changed for it.
+func assertTripCreated(t *testing.T, db *pgxpool.Pool, tripID, status string) {
+	t.Helper()
+	ctx := context.Background()
+	var from, to string
+	if err := db.QueryRow(ctx, `
+		SELECT from_status, to_status FROM trip_status_history WHERE trip_id=$1 ORDER BY id LIMIT 1
+	`, tripID).Scan(&from, &to); err != nil {
+		t.Fatalf("trip %s has no status history: %v", tripID, err)
+	}
+	if from != "" || to != status {
+		t.Fatalf("expected trip %s created as %s, history starts %q -> %q", tripID, status, from, to)
+	}
+	var events int
+	if err := db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM outbox_events WHERE event_type=$1 AND partition_key=$2
+	`, tripStatusChanged, tripID).Scan(&events); err != nil {
+		t.Fatal(err)
+	}
+	if events == 0 {
+		t.Fatalf("expected trips.status_changed for new trip %s", tripID)
+	}
+}
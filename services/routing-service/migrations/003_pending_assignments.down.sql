@@ -0,0 +1,6 @@
+-- Rollback for 003_pending_assignments.up.sql
+
+DROP INDEX IF EXISTS idx_pending_assignments_timeout_at;
+ALTER TABLE pending_assignments DROP COLUMN IF EXISTS created_at;
+ALTER TABLE pending_assignments DROP COLUMN IF EXISTS attempt_count;
+ALTER TABLE pending_assignments DROP COLUMN IF EXISTS batch_id;
@@ -0,0 +1,10 @@
+-- Очередь повторных попыток назначения PENDING-рейсов
+CREATE TABLE IF NOT EXISTS pending_assignments (
+    trip_id UUID PRIMARY KEY,
+    timeout_at TIMESTAMPTZ NOT NULL
+);
+ALTER TABLE pending_assignments ADD COLUMN IF NOT EXISTS batch_id TEXT;
+ALTER TABLE pending_assignments ADD COLUMN IF NOT EXISTS attempt_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE pending_assignments ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_pending_assignments_timeout_at ON pending_assignments(timeout_at);
@@ -0,0 +1,5 @@
+-- Rollback for 004_trip_offers.up.sql
+
+DROP INDEX IF EXISTS idx_trip_offers_pending_expiry;
+DROP INDEX IF EXISTS idx_trip_offers_trip;
+DROP TABLE IF EXISTS trip_offers;
@@ -0,0 +1,16 @@
+-- Предложения рейса нескольким перевозчикам (режим assignment.mode=offer)
+CREATE TABLE IF NOT EXISTS trip_offers (
+    offer_id UUID PRIMARY KEY,
+    trip_id UUID NOT NULL,
+    batch_id TEXT NOT NULL,
+    carrier_id TEXT NOT NULL,
+    rank INTEGER NOT NULL,
+    distance_meters INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL,
+    offered_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    responded_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_trip_offers_trip ON trip_offers(trip_id);
+CREATE INDEX IF NOT EXISTS idx_trip_offers_pending_expiry ON trip_offers(expires_at) WHERE status = 'pending';
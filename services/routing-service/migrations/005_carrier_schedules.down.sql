@@ -0,0 +1,3 @@
+-- Rollback for 005_carrier_schedules.up.sql
+
+DROP TABLE IF EXISTS carrier_schedules;
@@ -0,0 +1,6 @@
+-- Проекция расписания смен перевозчиков из reference-service
+CREATE TABLE IF NOT EXISTS carrier_schedules (
+    carrier_id TEXT PRIMARY KEY,
+    schedule JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
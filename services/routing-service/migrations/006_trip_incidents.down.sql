@@ -0,0 +1,3 @@
+-- Rollback for 006_trip_incidents.up.sql
+
+DROP TABLE IF EXISTS trip_incidents;
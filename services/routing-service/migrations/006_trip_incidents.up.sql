@@ -0,0 +1,19 @@
+-- Инциденты на рейсах (поломка, ДТП, задержка) и их разрешение
+CREATE TABLE IF NOT EXISTS trip_incidents (
+    incident_id UUID PRIMARY KEY,
+    trip_id UUID NOT NULL,
+    carrier_id TEXT,
+    incident_type TEXT NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+    longitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+    description TEXT,
+    status TEXT NOT NULL,
+    replacement_trip_id UUID,
+    reported_at TIMESTAMPTZ NOT NULL,
+    resolved_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_trip_incidents_trip ON trip_incidents(trip_id);
+-- Поиск инцидента, ожидающего замену, по новому рейсу
+CREATE INDEX IF NOT EXISTS idx_trip_incidents_replacement ON trip_incidents(replacement_trip_id) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_trip_incidents_reported ON trip_incidents(reported_at);
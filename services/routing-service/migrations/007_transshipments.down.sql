@@ -0,0 +1,5 @@
+-- Rollback for 007_transshipments.up.sql
+
+DROP TABLE IF EXISTS transshipment_boxes;
+DROP TABLE IF EXISTS transshipments;
+DROP TABLE IF EXISTS batch_boxes;
@@ -0,0 +1,39 @@
+-- Состав партий по коробкам (коробка = заказ) для поштучной сверки при перегрузке
+CREATE TABLE IF NOT EXISTS batch_boxes (
+    box_id TEXT PRIMARY KEY,
+    batch_id TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_batch_boxes_batch ON batch_boxes(batch_id);
+
+-- Перегрузка товара между машинами
+CREATE TABLE IF NOT EXISTS transshipments (
+    id UUID PRIMARY KEY,
+    source_trip_id UUID NOT NULL,
+    target_trip_id UUID,
+    source_carrier_id TEXT,
+    target_carrier_id TEXT NOT NULL,
+    status TEXT NOT NULL,
+    initiated_by TEXT,
+    completed_by TEXT,
+    reason TEXT,
+    rejection_reason TEXT,
+    moved_count INT NOT NULL DEFAULT 0,
+    missing_count INT NOT NULL DEFAULT 0,
+    extra_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_transshipments_source_trip ON transshipments(source_trip_id);
+
+-- План и сканы коробок: выемка из исходной машины и укладка в новую
+CREATE TABLE IF NOT EXISTS transshipment_boxes (
+    transshipment_id UUID NOT NULL,
+    box_id TEXT NOT NULL,
+    batch_id TEXT,
+    expected BOOLEAN NOT NULL,
+    scanned_out_at TIMESTAMPTZ,
+    scanned_in_at TIMESTAMPTZ,
+    PRIMARY KEY (transshipment_id, box_id)
+);
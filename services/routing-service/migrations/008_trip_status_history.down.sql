@@ -0,0 +1,4 @@
+-- Rollback for 008_trip_status_history.up.sql
+
+DROP TABLE IF EXISTS trip_status_history;
+ALTER TABLE trips DROP COLUMN IF EXISTS held_from_status;
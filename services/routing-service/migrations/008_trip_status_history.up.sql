@@ -0,0 +1,15 @@
+-- Машина состояний рейса: статус до постановки на удержание
+ALTER TABLE trips ADD COLUMN IF NOT EXISTS held_from_status TEXT;
+
+-- История смены статусов рейса: кто и почему
+CREATE TABLE IF NOT EXISTS trip_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    trip_id UUID NOT NULL,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    reason TEXT,
+    changed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_trip_status_history_trip ON trip_status_history(trip_id, id);
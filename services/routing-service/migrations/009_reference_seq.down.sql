@@ -0,0 +1,4 @@
+-- Rollback for 009_reference_seq.up.sql
+
+ALTER TABLE carrier_schedules DROP COLUMN IF EXISTS source_seq;
+ALTER TABLE carrier_activity_cache DROP COLUMN IF EXISTS source_seq;
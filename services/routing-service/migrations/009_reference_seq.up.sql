@@ -0,0 +1,3 @@
+-- Номер изменения записи справочника, из которой построена строка проекции
+ALTER TABLE carrier_activity_cache ADD COLUMN IF NOT EXISTS source_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE carrier_schedules ADD COLUMN IF NOT EXISTS source_seq BIGINT NOT NULL DEFAULT 0;
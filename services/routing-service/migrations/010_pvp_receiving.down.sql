@@ -0,0 +1,4 @@
+-- Rollback for 010_pvp_receiving.up.sql
+
+DROP TABLE IF EXISTS batch_destinations;
+DROP TABLE IF EXISTS ref_pickup_point_hours;
@@ -0,0 +1,13 @@
+-- Часы работы и окна приёмки ПВЗ из справочника
+CREATE TABLE IF NOT EXISTS ref_pickup_point_hours (
+    pvp_id TEXT PRIMARY KEY,
+    hours JSONB NOT NULL DEFAULT '{}',
+    source_seq BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- ПВЗ назначения каждой партии: по нему проверяется время прибытия рейса
+CREATE TABLE IF NOT EXISTS batch_destinations (
+    batch_id TEXT PRIMARY KEY,
+    pvp_id TEXT NOT NULL
+);
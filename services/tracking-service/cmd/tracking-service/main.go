@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"os"
@@ -16,9 +17,11 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"bel-parcel/pkg/leader"
 	"bel-parcel/services/tracking-service/internal/config"
 	"bel-parcel/services/tracking-service/internal/infra/db"
 	"bel-parcel/services/tracking-service/internal/infra/kafka"
+	"bel-parcel/services/tracking-service/internal/infra/reference"
 	"bel-parcel/services/tracking-service/internal/metrics"
 	"bel-parcel/services/tracking-service/internal/outbox"
 	tservice "bel-parcel/services/tracking-service/internal/tracking"
@@ -64,6 +67,10 @@ func main() {
 		slog.Error("failed to ensure outbox schema", "error", err)
 		os.Exit(1)
 	}
+	if err := leader.EnsureSchema(trackDB); err != nil {
+		slog.Error("failed to ensure leader schema", "error", err)
+		os.Exit(1)
+	}
 
 	consumer := kafka.NewConsumer(cfg.Kafka.Brokers, cfg.Kafka.GroupID, cfg.Kafka.ConsumeTopics, cfg.Kafka.Timeout)
 	cctx, ccancel := context.WithCancel(context.Background())
@@ -72,9 +79,14 @@ func main() {
 	// WebSocket Hub
 	hub := whub.NewHub(trackDB)
 	go hub.Run(cctx)
+	elector := leader.NewElector(trackDB, "tracking-session-cleanup", leader.InstanceID(), cfg.Leader.RenewInterval, metrics.SetLeader)
+	go elector.Run(cctx, hub.RunSessionCleanup)
 	go outbox.StartPublisher(cctx, trackDB, hub)
 
 	svc := tservice.NewService(trackDB, cfg.Tracking.DeviationThresholdMeters, cfg.Tracking.LateThresholdMinutes)
+	// PVP hours are filled from the snapshot before consuming; older events
+	// are then ignored by seq.
+	bootstrapReference(cctx, svc, reference.NewClient(cfg.Reference.URL, cfg.Reference.Token, cfg.Reference.Timeout))
 	consumer.Start(cctx, func(topic string, key, value []byte) error {
 		return svc.HandleEvent(cctx, topic, key, value)
 	})
@@ -91,8 +103,16 @@ func main() {
 			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 			return
 		}
+		lease, err := elector.Current(r.Context())
+		if err != nil {
+			slog.Warn("leader lookup failed", "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "OK",
+			"leader": lease,
+		})
 	})
 	mux.HandleFunc("GET /ws/alerts", func(w http.ResponseWriter, r *http.Request) {
 		if hub != nil {
@@ -127,6 +147,30 @@ func main() {
 	slog.Info("server stopped")
 }
 
+// bootstrapReference loads the reference snapshot with a few retries. If the
+// reference service stays unavailable the service starts on the projection it
+// already has and catches up from the event stream.
+func bootstrapReference(ctx context.Context, svc *tservice.Service, client *reference.Client) {
+	backoff := time.Second
+	for attempt := 1; attempt <= 5; attempt++ {
+		snap, err := client.Snapshot(ctx)
+		if err == nil {
+			err = svc.ApplyReferenceSnapshot(ctx, snap)
+		}
+		if err == nil {
+			return
+		}
+		slog.Warn("reference snapshot bootstrap failed", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	slog.Warn("starting without reference snapshot")
+}
+
 func setupLogger() {
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
@@ -25,6 +25,14 @@ type Config struct {
 		DeviationThresholdMeters float64
 		LateThresholdMinutes     int
 	}
+	Leader struct {
+		RenewInterval time.Duration
+	}
+	Reference struct {
+		URL     string
+		Token   string
+		Timeout time.Duration
+	}
 	OTLP struct {
 		Endpoint string
 	}
@@ -37,10 +45,14 @@ func Load() (*Config, error) {
 	v.SetDefault("db.trackingdsn", "")
 	v.SetDefault("kafka.brokers", []string{"redpanda:9092"})
 	v.SetDefault("kafka.groupid", "tracking-service")
-	v.SetDefault("kafka.consumetopics", []string{"events.carrier_location", "commands.trip.reassign"})
+	v.SetDefault("kafka.consumetopics", []string{"trips.assigned", "trips.confirmed", "events.carrier_location", "commands.trip.reassign", "alerts.trip_assignment_escalation", "alerts.trip_incident", "alerts.transshipment_discrepancy", "alerts.receipt_discrepancy", "batches.formed", "events.reference_updated"})
 	v.SetDefault("kafka.timeout", 5*time.Second)
 	v.SetDefault("tracking.deviation_threshold_meters", 500.0)
 	v.SetDefault("tracking.late_threshold_minutes", 15)
+	v.SetDefault("leader.renewinterval", 5*time.Second)
+	v.SetDefault("reference.url", "http://localhost:8084")
+	v.SetDefault("reference.token", "")
+	v.SetDefault("reference.timeout", 10*time.Second)
 	v.SetDefault("otlp.endpoint", "")
 
 	v.SetConfigName("config")
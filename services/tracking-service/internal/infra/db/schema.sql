@@ -82,3 +82,21 @@ CREATE TABLE IF NOT EXISTS dead_letter_queue (
 
 ALTER TABLE active_trips
   ADD COLUMN IF NOT EXISTS total_route_distance_meters DOUBLE PRECISION;
+
+ALTER TABLE active_trips
+  ADD COLUMN IF NOT EXISTS last_eta_published TIMESTAMPTZ;
+
+ALTER TABLE active_trips
+  ADD COLUMN IF NOT EXISTS batch_id TEXT;
+
+CREATE TABLE IF NOT EXISTS batch_destinations (
+  batch_id TEXT PRIMARY KEY,
+  pvp_id TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pvp_receiving (
+  pvp_id TEXT PRIMARY KEY,
+  hours JSONB NOT NULL DEFAULT '{}',
+  source_seq BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,28 @@
+package reference
+
+import (
+	"time"
+
+	"bel-parcel/pkg/refsnapshot"
+	"bel-parcel/pkg/schedule"
+)
+
+// Snapshot is the part of the reference-service snapshot tracking keeps.
+type Snapshot struct {
+	Seq          int64         `json:"seq"`
+	PickupPoints []PickupPoint `json:"pickup_points"`
+}
+
+// PickupPoint carries the PVP hours ETAs are checked against.
+type PickupPoint struct {
+	ID string `json:"id"`
+	schedule.Hours
+	Seq int64 `json:"seq"`
+}
+
+// Client reads the reference snapshot into Snapshot.
+type Client = refsnapshot.Client[Snapshot]
+
+func NewClient(baseURL, token string, timeout time.Duration) *Client {
+	return refsnapshot.NewClient[Snapshot](baseURL, token, timeout)
+}
@@ -59,6 +59,13 @@ var (
 			Help: "Dead letter queue size",
 		},
 	)
+	LeaderIsLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tracking_leader_is_leader",
+			Help: "Whether this tracking-service instance leads a singleton job (1) or not (0)",
+		},
+		[]string{"job"},
+	)
 )
 
 func Init(mux *http.ServeMux) {
@@ -71,10 +78,19 @@ func Init(mux *http.ServeMux) {
 		GPSUpdatesTotal,
 		OutboxQueueSize,
 		DLQSize,
+		LeaderIsLeader,
 	)
 	mux.Handle("/metrics", promhttp.Handler())
 }
 
+func SetLeader(job string, leader bool) {
+	v := 0.0
+	if leader {
+		v = 1
+	}
+	LeaderIsLeader.WithLabelValues(job).Set(v)
+}
+
 func StartGauges(ctx context.Context, db *pgxpool.Pool) {
 	go func() {
 		t := time.NewTicker(10 * time.Second)
@@ -1,7 +1,10 @@
 package tracking
 
 import (
+	"fmt"
 	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -52,10 +55,37 @@ func haversine(lat1, lng1, lat2, lng2 float64) float64 {
 	return R * c
 }
 
+// parseDuration accepts Go durations ("30m") and PostgreSQL interval text
+// ("00:30:00", "1 day 02:00:00") as read back from active_trips.
 func parseDuration(s string) time.Duration {
-	d, err := time.ParseDuration(s)
-	if err != nil {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d
+	}
+	var days int
+	if i := strings.Index(s, " day"); i > 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0
+		}
+		days = n
+		s = strings.TrimSpace(s[i+len(" day"):])
+		s = strings.TrimSpace(strings.TrimPrefix(s, "s"))
+	}
+	d := time.Duration(days) * 24 * time.Hour
+	if s == "" {
+		return d
+	}
+	var h, m int
+	var sec float64
+	if _, err := fmt.Sscanf(s, "%d:%d:%g", &h, &m, &sec); err != nil {
 		return 0
 	}
-	return d
+	return d + time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second))
+}
+
+// defaultTripSpeedKmh estimates the trip duration when the assignment carries none.
+const defaultTripSpeedKmh = 40.0
+
+func estimateDuration(distanceMeters float64) time.Duration {
+	return time.Duration(distanceMeters / (defaultTripSpeedKmh * 1000) * float64(time.Hour)).Round(time.Minute)
 }
@@ -47,3 +47,24 @@ func TestCalculateEstimatedArrival(t *testing.T) {
 		t.Fatalf("eta should be in future")
 	}
 }
+
+func TestParseDuration_PostgresInterval(t *testing.T) {
+	cases := map[string]time.Duration{
+		"00:30:00":        30 * time.Minute,
+		"02:15:30":        2*time.Hour + 15*time.Minute + 30*time.Second,
+		"1 day 02:00:00":  26 * time.Hour,
+		"3 days 00:00:00": 72 * time.Hour,
+		"1 day":           24 * time.Hour,
+	}
+	for in, want := range cases {
+		if got := parseDuration(in); got != want {
+			t.Fatalf("parseDuration(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestEstimateDuration(t *testing.T) {
+	if got := estimateDuration(20000); got != 30*time.Minute {
+		t.Fatalf("expected 30m for 20km, got %s", got)
+	}
+}
@@ -0,0 +1,126 @@
+package tracking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"bel-parcel/pkg/schedule"
+	"bel-parcel/services/tracking-service/internal/infra/reference"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Operators are warned when a trip is expected at its PVP outside the
+// receiving windows. tracking keeps the destination PVP of every batch from
+// batches.formed and the PVP hours from reference-service.
+
+func (s *Service) handleBatchFormed(ctx context.Context, value []byte) error {
+	var envelope struct {
+		Data struct {
+			BatchID         string `json:"batch_id"`
+			DestinationType string `json:"destination_type"`
+			DestinationID   string `json:"destination_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	d := envelope.Data
+	if d.BatchID == "" || d.DestinationID == "" || d.DestinationType != "pvp" {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO batch_destinations (batch_id, pvp_id) VALUES ($1, $2)
+		ON CONFLICT (batch_id) DO NOTHING
+	`, d.BatchID, d.DestinationID)
+	return err
+}
+
+// handleReferenceUpdated keeps the hours of pickup points. Rows carry the
+// change number of the record, so replays and events older than the
+// snapshot are ignored.
+func (s *Service) handleReferenceUpdated(ctx context.Context, value []byte) error {
+	var envelope struct {
+		Data struct {
+			UpdateType  string `json:"update_type"`
+			Seq         int64  `json:"seq"`
+			PickupPoint struct {
+				ID string `json:"pvp_id"`
+				schedule.Hours
+			} `json:"pickup_point"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return err
+	}
+	d := envelope.Data
+	if d.UpdateType != "pickup_point" || d.PickupPoint.ID == "" {
+		return nil
+	}
+	return upsertPVPReceiving(ctx, s.db, d.PickupPoint.ID, d.PickupPoint.Hours, d.Seq)
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func upsertPVPReceiving(ctx context.Context, ex execer, pvpID string, hours schedule.Hours, seq int64) error {
+	_, err := ex.Exec(ctx, `
+		INSERT INTO pvp_receiving (pvp_id, hours, source_seq, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (pvp_id) DO UPDATE
+		SET hours = EXCLUDED.hours, source_seq = EXCLUDED.source_seq, updated_at = NOW()
+		WHERE pvp_receiving.source_seq <= EXCLUDED.source_seq
+	`, pvpID, hours, seq)
+	return err
+}
+
+// ApplyReferenceSnapshot fills the PVP hours from a reference-service
+// snapshot.
+func (s *Service) ApplyReferenceSnapshot(ctx context.Context, snap *reference.Snapshot) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	for _, p := range snap.PickupPoints {
+		if err := upsertPVPReceiving(ctx, tx, p.ID, p.Hours, p.Seq); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("reference snapshot applied", "seq", snap.Seq, "pickup_points", len(snap.PickupPoints))
+	return nil
+}
+
+// checkReceivingWindowTx raises an alert when eta falls outside the
+// receiving windows of the trip's PVP. Trips without a known PVP or hours
+// are not checked.
+func checkReceivingWindowTx(ctx context.Context, tx pgx.Tx, tripID, carrierID string, eta time.Time) error {
+	var pvpID string
+	var hours schedule.Hours
+	err := tx.QueryRow(ctx, `
+		SELECT d.pvp_id, r.hours
+		FROM active_trips t
+		JOIN batch_destinations d ON d.batch_id = t.batch_id
+		JOIN pvp_receiving r ON r.pvp_id = d.pvp_id
+		WHERE t.trip_id = $1
+	`, tripID).Scan(&pvpID, &hours)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if hours.ReceivesAt(eta) {
+		return nil
+	}
+	return createAlertTx(ctx, tx, "eta_outside_receiving_window", tripID, carrierID,
+		"Рейс прибудет на ПВЗ "+pvpID+" вне окна приёмки", "warning")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"bel-parcel/services/tracking-service/internal/outbox"
@@ -25,16 +26,28 @@ func NewService(db *pgxpool.Pool, deviationThresholdMeters float64, lateThreshol
 
 func (s *Service) HandleEvent(ctx context.Context, topic string, key, value []byte) error {
 	switch topic {
-	case "трипы.назначены":
+	case "trips.assigned", "трипы.назначены":
 		return s.handleTripAssigned(ctx, value)
-	case "трипы.подтверждены":
+	case "trips.confirmed", "трипы.подтверждены":
 		return s.handleTripConfirmed(ctx, value)
-	case "местоположение.перевозчика":
+	case "events.carrier_location", "местоположение.перевозчика":
 		return s.handleCarrierLocation(ctx, value)
 	case "алерты.требуется_ручное_назначение":
 		return s.handleManualAssignmentAlert(ctx, value)
-	case "команды.переназначить":
+	case "alerts.trip_assignment_escalation":
+		return s.handleAlertEvent(ctx, value, "assignment_escalation", "Рейс скоро потребует ручного назначения")
+	case "alerts.trip_incident":
+		return s.handleAlertEvent(ctx, value, "trip_incident", "Инцидент на рейсе")
+	case "alerts.transshipment_discrepancy":
+		return s.handleAlertEvent(ctx, value, "transshipment_discrepancy", "Расхождение при перегрузке")
+	case "alerts.receipt_discrepancy":
+		return s.handleAlertEvent(ctx, value, "receipt_discrepancy", "Расхождение при приёмке на ПВЗ")
+	case "commands.trip.reassign", "команды.переназначить":
 		return s.handleReassignCommand(ctx, value)
+	case "batches.formed":
+		return s.handleBatchFormed(ctx, value)
+	case "events.reference_updated":
+		return s.handleReferenceUpdated(ctx, value)
 	default:
 		return nil
 	}
@@ -51,6 +64,7 @@ func (s *Service) handleTripAssigned(ctx context.Context, value []byte) error {
 	}
 	var data struct {
 		TripID            string    `json:"trip_id"`
+		BatchID           string    `json:"batch_id"`
 		CarrierID         string    `json:"carrier_id"`
 		OriginLat         float64   `json:"origin_lat"`
 		OriginLng         float64   `json:"origin_lng"`
@@ -80,11 +94,15 @@ func (s *Service) handleTripAssigned(ctx context.Context, value []byte) error {
 		return nil
 	}
 	totalRouteDist := haversine(data.OriginLat, data.OriginLng, data.DestinationLat, data.DestinationLng)
+	if data.EstimatedDuration == "" {
+		// routing-service does not send a duration
+		data.EstimatedDuration = fmt.Sprintf("%d seconds", int64(estimateDuration(totalRouteDist).Seconds()))
+	}
 	_, err = tx.Exec(ctx, `
-		INSERT INTO active_trips(trip_id, carrier_id, origin_lat, origin_lng, destination_lat, destination_lng, assigned_at, estimated_duration, status, total_route_distance_meters)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8::interval,'assigned',$9)
-		ON CONFLICT (trip_id) DO UPDATE SET carrier_id=EXCLUDED.carrier_id, origin_lat=EXCLUDED.origin_lat, origin_lng=EXCLUDED.origin_lng, destination_lat=EXCLUDED.destination_lat, destination_lng=EXCLUDED.destination_lng, assigned_at=EXCLUDED.assigned_at, estimated_duration=EXCLUDED.estimated_duration, status='assigned', total_route_distance_meters=EXCLUDED.total_route_distance_meters
-	`, data.TripID, data.CarrierID, data.OriginLat, data.OriginLng, data.DestinationLat, data.DestinationLng, data.AssignedAt, data.EstimatedDuration, totalRouteDist)
+		INSERT INTO active_trips(trip_id, carrier_id, origin_lat, origin_lng, destination_lat, destination_lng, assigned_at, estimated_duration, status, total_route_distance_meters, batch_id)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8::interval,'assigned',$9,NULLIF($10,''))
+		ON CONFLICT (trip_id) DO UPDATE SET carrier_id=EXCLUDED.carrier_id, batch_id=COALESCE(EXCLUDED.batch_id, active_trips.batch_id), origin_lat=EXCLUDED.origin_lat, origin_lng=EXCLUDED.origin_lng, destination_lat=EXCLUDED.destination_lat, destination_lng=EXCLUDED.destination_lng, assigned_at=EXCLUDED.assigned_at, estimated_duration=EXCLUDED.estimated_duration, status='assigned', total_route_distance_meters=EXCLUDED.total_route_distance_meters
+	`, data.TripID, data.CarrierID, data.OriginLat, data.OriginLng, data.DestinationLat, data.DestinationLng, data.AssignedAt, data.EstimatedDuration, totalRouteDist, data.BatchID)
 	if err != nil {
 		return err
 	}
@@ -170,10 +188,11 @@ func (s *Service) handleCarrierLocation(ctx context.Context, value []byte) error
 	var assignedAt time.Time
 	var estDur string
 	var totalRouteDist float64
+	var lastETA *time.Time
 	if err := tx.QueryRow(ctx, `
-		SELECT origin_lat, origin_lng, destination_lat, destination_lng, assigned_at, estimated_duration::text, total_route_distance_meters
+		SELECT origin_lat, origin_lng, destination_lat, destination_lng, assigned_at, estimated_duration::text, total_route_distance_meters, last_eta_published
 		FROM active_trips WHERE trip_id=$1
-	`, data.TripID).Scan(&originLat, &originLng, &destLat, &destLng, &assignedAt, &estDur, &totalRouteDist); err != nil {
+	`, data.TripID).Scan(&originLat, &originLng, &destLat, &destLng, &assignedAt, &estDur, &totalRouteDist, &lastETA); err != nil {
 		return err
 	}
 	deviation := calculateDeviation(data.Lat, data.Lng, originLat, originLng, destLat, destLng)
@@ -191,10 +210,66 @@ func (s *Service) handleCarrierLocation(ctx context.Context, value []byte) error
 	if delay > parseDuration(estDur)+time.Duration(s.lateThresholdMinutes)*time.Minute {
 		_ = createAlertTx(ctx, tx, "driver_late", data.TripID, data.CarrierID, "Опоздание >15 минут", "warning")
 	}
+	if etaShifted(lastETA, eta) {
+		if err := publishETATx(ctx, tx, data.TripID, eta); err != nil {
+			return err
+		}
+		// checked only when the ETA moved, so a late trip is not re-alerted on every ping
+		if err := checkReceivingWindowTx(ctx, tx, data.TripID, data.CarrierID, eta); err != nil {
+			return err
+		}
+	}
 	return tx.Commit(ctx)
 }
 
+// etaShiftThreshold throttles customer ETA notifications: a new ETA is
+// published only when it moved at least this much from the last one.
+const etaShiftThreshold = 15 * time.Minute
+
+func etaShifted(last *time.Time, eta time.Time) bool {
+	if last == nil {
+		return true
+	}
+	d := eta.Sub(*last)
+	if d < 0 {
+		d = -d
+	}
+	return d >= etaShiftThreshold
+}
+
+func publishETATx(ctx context.Context, tx pgx.Tx, tripID string, eta time.Time) error {
+	if _, err := tx.Exec(ctx, `UPDATE active_trips SET last_eta_published=$2 WHERE trip_id=$1`, tripID, eta); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	envelope := map[string]interface{}{
+		"event_id":    uuid.NewString(),
+		"event_type":  "events.trip_eta_updated",
+		"occurred_at": now,
+		"data": map[string]interface{}{
+			"trip_id":           tripID,
+			"estimated_arrival": eta.UTC(),
+		},
+	}
+	payload, _ := json.Marshal(envelope)
+	return outbox.EnqueueTx(ctx, tx, outbox.Event{
+		ID:            uuid.NewString(),
+		EventType:     "events.trip_eta_updated",
+		CorrelationID: tripID + "/" + eta.UTC().Format(time.RFC3339),
+		Topic:         "events.trip_eta_updated",
+		PartitionKey:  tripID,
+		Payload:       payload,
+		OccurredAt:    now,
+	})
+}
+
 func (s *Service) handleManualAssignmentAlert(ctx context.Context, value []byte) error {
+	return s.handleAlertEvent(ctx, value, "manual_assignment", "Требуется ручное назначение")
+}
+
+// handleAlertEvent turns an alert event from another service into an active
+// alert for operators. The sender may set the severity, warning otherwise.
+func (s *Service) handleAlertEvent(ctx context.Context, value []byte, alertType, defaultMessage string) error {
 	var envelope struct {
 		EventID    string          `json:"event_id"`
 		OccurredAt time.Time       `json:"occurred_at"`
@@ -207,6 +282,7 @@ func (s *Service) handleManualAssignmentAlert(ctx context.Context, value []byte)
 		TripID    string `json:"trip_id"`
 		CarrierID string `json:"carrier_id"`
 		Message   string `json:"message"`
+		Severity  string `json:"severity"`
 	}
 	if err := json.Unmarshal(envelope.Data, &data); err != nil {
 		return err
@@ -229,9 +305,12 @@ func (s *Service) handleManualAssignmentAlert(ctx context.Context, value []byte)
 		return nil
 	}
 	if data.Message == "" {
-		data.Message = "Требуется ручное назначение"
+		data.Message = defaultMessage
+	}
+	if data.Severity == "" {
+		data.Severity = "warning"
 	}
-	if err := createAlertTx(ctx, tx, "manual_assignment", data.TripID, data.CarrierID, data.Message, "warning"); err != nil {
+	if err := createAlertTx(ctx, tx, alertType, data.TripID, data.CarrierID, data.Message, data.Severity); err != nil {
 		return err
 	}
 	return tx.Commit(ctx)
@@ -279,12 +358,15 @@ func (s *Service) handleReassignCommand(ctx context.Context, value []byte) error
 	return tx.Commit(ctx)
 }
 
+// createAlertTx stores the alert and notifies operators. The outbox row is
+// keyed by the alert id so that several alerts on one trip are all delivered.
 func createAlertTx(ctx context.Context, tx pgx.Tx, alertType, tripID, carrierID, message, severity string) error {
 	now := time.Now().UTC()
+	alertID := uuid.NewString()
 	if _, err := tx.Exec(ctx, `
 		INSERT INTO active_alerts(alert_id, alert_type, trip_id, carrier_id, message, created_at, severity)
 		VALUES ($1,$2,$3,$4,$5,$6,$7)
-	`, uuid.NewString(), alertType, tripID, carrierID, message, now, severity); err != nil {
+	`, alertID, alertType, tripID, carrierID, message, now, severity); err != nil {
 		return err
 	}
 	envelope := map[string]interface{}{
@@ -293,6 +375,7 @@ func createAlertTx(ctx context.Context, tx pgx.Tx, alertType, tripID, carrierID,
 		"occurred_at":    now,
 		"correlation_id": tripID,
 		"data": map[string]interface{}{
+			"alert_id":   alertID,
 			"alert_type": alertType,
 			"trip_id":    tripID,
 			"carrier_id": carrierID,
@@ -304,7 +387,7 @@ func createAlertTx(ctx context.Context, tx pgx.Tx, alertType, tripID, carrierID,
 	return outbox.EnqueueTx(ctx, tx, outbox.Event{
 		ID:            uuid.NewString(),
 		EventType:     "operator.alert",
-		CorrelationID: tripID,
+		CorrelationID: alertID,
 		Topic:         "operator.alerts",
 		PartitionKey:  tripID,
 		Payload:       payload,
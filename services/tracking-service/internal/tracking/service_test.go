@@ -277,3 +277,89 @@ func TestRestoreAlertsOnReconnect(t *testing.T) {
 		t.Fatal("expected non-empty alert payload")
 	}
 }
+
+func TestTripIncidentAlertKeepsSeverity(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	svc := NewService(db, 500, 15)
+	for i, severity := range []string{"critical", "warning"} {
+		env := map[string]interface{}{
+			"event_id":    "evt-incident-" + severity,
+			"occurred_at": time.Now().UTC(),
+			"data": map[string]interface{}{
+				"trip_id":    "trip-incident-1",
+				"carrier_id": "carrier-9",
+				"message":    "breakdown",
+				"severity":   severity,
+			},
+		}
+		b, _ := json.Marshal(env)
+		if err := svc.HandleEvent(context.Background(), "alerts.trip_incident", nil, b); err != nil {
+			t.Fatal(err)
+		}
+		var cnt int
+		_ = db.QueryRow(context.Background(), `SELECT COUNT(*) FROM outbox_events WHERE event_type='operator.alert' AND partition_key='trip-incident-1'`).Scan(&cnt)
+		if cnt < i+1 {
+			t.Fatalf("expected an operator notification per alert, got %d", cnt)
+		}
+	}
+	var cnt int
+	_ = db.QueryRow(context.Background(), `SELECT COUNT(*) FROM active_alerts WHERE alert_type='trip_incident' AND trip_id='trip-incident-1' AND severity='critical'`).Scan(&cnt)
+	if cnt == 0 {
+		t.Fatal("expected critical trip_incident alert")
+	}
+}
+
+func TestETAOutsideReceivingWindowAlert(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	ctx := context.Background()
+	svc := NewService(db, 500, 15)
+	now := time.Now().UTC()
+	events := []struct {
+		handle func(context.Context, []byte) error
+		data   map[string]interface{}
+	}{
+		{svc.handleBatchFormed, map[string]interface{}{"batch_id": "batch-rw", "destination_type": "pvp", "destination_id": "pvp-rw"}},
+		{svc.handleReferenceUpdated, map[string]interface{}{"update_type": "pickup_point", "seq": 1, "pickup_point": map[string]interface{}{
+			"pvp_id":   "pvp-rw",
+			"holidays": []map[string]interface{}{{"kind": "off", "starts_at": now.Add(-time.Hour), "ends_at": now.Add(48 * time.Hour)}},
+		}}},
+		{svc.handleTripAssigned, map[string]interface{}{
+			"trip_id": "trip-rw", "batch_id": "batch-rw", "carrier_id": "carrier-rw",
+			"origin_lat": 53.9, "origin_lng": 27.56, "destination_lat": 53.7, "destination_lng": 27.3,
+			"assigned_at": now.Add(-10 * time.Minute),
+		}},
+		{svc.handleCarrierLocation, map[string]interface{}{"trip_id": "trip-rw", "carrier_id": "carrier-rw", "lat": 53.8, "lng": 27.4, "timestamp": now}},
+	}
+	for i, e := range events {
+		b, _ := json.Marshal(map[string]interface{}{"event_id": "evt-rw-" + string(rune('a'+i)), "occurred_at": now, "data": e.data})
+		if err := e.handle(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cnt int
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM active_alerts WHERE alert_type='eta_outside_receiving_window' AND trip_id='trip-rw'`).Scan(&cnt)
+	if cnt == 0 {
+		t.Fatal("expected eta_outside_receiving_window alert")
+	}
+}
+
+func TestETAShifted(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if !etaShifted(nil, base) {
+		t.Fatal("first ETA must be published")
+	}
+	if etaShifted(&base, base.Add(14*time.Minute)) {
+		t.Fatal("shift below threshold must not be published")
+	}
+	if !etaShifted(&base, base.Add(-15*time.Minute)) {
+		t.Fatal("earlier arrival by threshold must be published")
+	}
+}
@@ -36,8 +36,6 @@ func NewHub(db *pgxpool.Pool) *Hub {
 }
 
 func (h *Hub) Run(ctx context.Context) {
-	t := time.NewTicker(5 * time.Minute)
-	defer t.Stop()
 	for {
 		select {
 		case <-ctx.Done():
@@ -69,6 +67,19 @@ func (h *Hub) Run(ctx context.Context) {
 					}
 				}()
 			}
+		}
+	}
+}
+
+// RunSessionCleanup removes stale websocket sessions. The table is shared by
+// all replicas, so main runs it under leader election.
+func (h *Hub) RunSessionCleanup(ctx context.Context) {
+	t := time.NewTicker(5 * time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
 		case <-t.C:
 			h.cleanupSessions(ctx)
 		}
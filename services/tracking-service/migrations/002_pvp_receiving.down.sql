@@ -0,0 +1,5 @@
+-- Rollback for 002_pvp_receiving.up.sql
+
+DROP TABLE IF EXISTS pvp_receiving;
+DROP TABLE IF EXISTS batch_destinations;
+ALTER TABLE active_trips DROP COLUMN IF EXISTS batch_id;
@@ -0,0 +1,16 @@
+-- Партия рейса: по ней находится ПВЗ назначения
+ALTER TABLE active_trips ADD COLUMN IF NOT EXISTS batch_id TEXT;
+
+-- ПВЗ назначения каждой партии
+CREATE TABLE IF NOT EXISTS batch_destinations (
+    batch_id TEXT PRIMARY KEY,
+    pvp_id TEXT NOT NULL
+);
+
+-- Часы работы и окна приёмки ПВЗ из справочника
+CREATE TABLE IF NOT EXISTS pvp_receiving (
+    pvp_id TEXT PRIMARY KEY,
+    hours JSONB NOT NULL DEFAULT '{}',
+    source_seq BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);